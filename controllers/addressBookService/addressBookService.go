@@ -1,7 +1,6 @@
 package addressBookService
 
 import (
-	"addressBookServer/gates/psg"
 	"addressBookServer/models/dto"
 	"addressBookServer/pkg"
 	"encoding/json"
@@ -10,24 +9,43 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 )
 
 type AddressBookService struct {
 	server http.Server
-	db     *psg.Psg
+	db     Storage
+	done   chan struct{} // Закрывается при остановке сервера (останавливает фоновые задачи)
+	closed sync.Once     // Защищает done от повторного закрытия
 }
 
-func NewAddressBookService(addr string, p *psg.Psg) (abs *AddressBookService) {
+func NewAddressBookService(addr string, db Storage) (abs *AddressBookService) {
 	abs = new(AddressBookService)
 	abs.server = http.Server{}
+	abs.done = make(chan struct{})
 	router := http.NewServeMux()
 	router.HandleFunc("/create", abs.createRecordHandler)
 	router.HandleFunc("/get", abs.getRecordsHandler)
 	router.HandleFunc("/update", abs.updateRecordHandler)
 	router.HandleFunc("/delete", abs.deleteRecordByPhoneHandler)
-	abs.server.Handler = router
+	router.HandleFunc("/batch", abs.batchHandler)
+	router.HandleFunc("/export", abs.exportHandler)
+	router.HandleFunc(recordsV2Path, abs.recordsV2Handler)
+	router.HandleFunc(recordsV2Path+"/", abs.recordV2Handler)
+	router.HandleFunc(customFieldsV2Path, abs.customFieldsV2Handler)
+	router.HandleFunc(customFieldsV2Path+"/", abs.customFieldV2Handler)
+	router.HandleFunc(tagsV2Path, abs.tagsV2Handler)
+	router.HandleFunc(tagsV2Path+"/", abs.tagV2Handler)
+	router.HandleFunc(auditV2Path, abs.auditV2Handler)
+	router.HandleFunc(trashV2Path, abs.trashV2Handler)
+	router.HandleFunc(trashV2Path+"/", abs.trashRecordV2Handler)
+	router.HandleFunc(importV2Path, abs.importV2Handler)
+	router.HandleFunc(cardDAVPath, abs.cardDAVHandler)
+	router.HandleFunc(wellKnownCardDAVPath, abs.wellKnownCardDAVHandler)
+	abs.server.Handler = withChangeMeta(router)
 	abs.server.Addr = addr
-	abs.db = p
+	abs.db = db
 	return abs
 }
 
@@ -43,7 +61,9 @@ func (abs *AddressBookService) Start() {
 	return
 }
 
+// Close останавливает сервер и фоновые задачи; повторный вызов безопасен.
 func (abs *AddressBookService) Close() error {
+	abs.closed.Do(func() { close(abs.done) })
 	return abs.server.Close()
 }
 
@@ -52,6 +72,25 @@ func (abs *AddressBookService) Close() error {
 Запрос должен быть с методом POST и с содержимым в формате JSON следующего вида (все поля обязательны для заполнения):
   {"name": "Имя", "last_name": "Фамилия", "middle_name": "Отчество", "address": "Адрес", "phone": "Телефон"}
 
+Вместо phone (или вместе с ним) можно передать несколько номеров с типами (mobile, work, home, fax, other)
+и признаком основного номера. Если основной номер не указан, им становится первый:
+  {"name": "Имя", "last_name": "Фамилия", "address": "Адрес",
+   "phones": [{"number": "Телефон", "type": "mobile", "primary": true}, {"number": "Телефон", "type": "work"}]}
+
+Адрес в свободной форме address разбирается на части (страна, регион, город, улица, дом, квартира, индекс),
+которые возвращаются в addresses. Вместо address (или вместе с ним) можно передать несколько адресов с метками,
+первый из них основной:
+  {"name": "Имя", "last_name": "Фамилия", "phone": "Телефон",
+   "addresses": [{"label": "дом", "city": "Москва", "street": "ул. Ленина", "house": "5", "apartment": "10", "postal_index": "123456"}]}
+
+Необязательные поля: адреса электронной почты emails, дата рождения birthday (ГГГГ-ММ-ДД),
+организация organization, должность job_title и заметки notes:
+  {"name": "Имя", "last_name": "Фамилия", "address": "Адрес", "phone": "Телефон",
+   "emails": ["ivan@example.com"], "birthday": "1990-05-17", "organization": "ООО Ромашка", "job_title": "Инженер", "notes": "Текст"}
+
+Дополнительные поля, объявленные в /v2/custom-fields, передаются в объекте custom; обязательные поля нужно указать:
+  {"name": "Имя", "last_name": "Фамилия", "address": "Адрес", "phone": "Телефон", "custom": {"department": "ИТ", "badge_id": 1042}}
+
 Возвращает клиенту ответ с содержимым в формате JSON следующего вида:
   {"result": "OK", "data": null, "error": ""}
 
@@ -92,7 +131,8 @@ func (abs *AddressBookService) createRecordHandler(w http.ResponseWriter, req *h
 	}
 
 	// Проверка наличия необходимых данных в запросе
-	if record.Name == "" || record.LastName == "" || record.Address == "" || record.Phone == "" {
+	if record.Name == "" || record.LastName == "" || (record.Address == "" && len(record.Addresses) == 0) ||
+		(record.Phone == "" && len(record.Phones) == 0) {
 		err = errors.New("required data is missing")
 		resp.Update("ERROR", nil, err.Error())
 		wErr.LogMsg(fmt.Sprintf("%s: {name: '%s', lastName: '%s', address: '%s', phone: '%s'}",
@@ -100,21 +140,43 @@ func (abs *AddressBookService) createRecordHandler(w http.ResponseWriter, req *h
 		return
 	}
 
-	// Нормализация номера телефона
-	record.Phone, err = pkg.NormalizePhoneNumber(record.Phone)
+	// Разбор адреса и проверка структурированных адресов
+	err = prepareRecordAddresses(&record)
 	if err != nil {
-		err = errors.New("wrong Phone")
 		resp.Update("ERROR", nil, err.Error())
-		wErr.Specify(err, "pkg.NormalizePhoneNumber(record.Phone)").LogError()
+		wErr.Specify(err, "prepareRecordAddresses(&record)").LogError()
 		return
 	}
 
-	// Сохранение записи
-	err = abs.db.SaveRecord(record)
+	// Проверка адресов электронной почты, даты рождения и других дополнительных полей
+	err = prepareRecordDetails(&record)
+	if err != nil {
+		resp.Update("ERROR", nil, err.Error())
+		wErr.Specify(err, "prepareRecordDetails(&record)").LogError()
+		return
+	}
+
+	// Проверка дополнительных полей по их объявлениям
+	err = abs.prepareCustom(req.Context(), &record)
+	if err != nil {
+		resp.Update("ERROR", nil, storageErrorText(err, err.Error()))
+		wErr.Specify(err, "abs.prepareCustom(req.Context(), &record)").LogError()
+		return
+	}
+
+	// Нормализация и проверка номеров телефона
+	err = prepareRecordPhones(&record)
 	if err != nil {
-		err = errors.New("cannot save record")
 		resp.Update("ERROR", nil, err.Error())
-		wErr.Specify(err, "abs.db.SaveRecord(record)").LogError()
+		wErr.Specify(err, "prepareRecordPhones(&record)").LogError()
+		return
+	}
+
+	// Сохранение записи
+	_, err = abs.db.SaveRecord(req.Context(), record)
+	if err != nil {
+		resp.Update("ERROR", nil, storageErrorText(err, "cannot save record"))
+		wErr.Specify(err, "abs.db.SaveRecord(req.Context(), record)").LogError()
 		return
 	}
 
@@ -123,9 +185,30 @@ func (abs *AddressBookService) createRecordHandler(w http.ResponseWriter, req *h
 
 // updateRecordHandler обрабатывает запрос на обновление записи
 /*
-Запрос должен быть с методом POST и с содержимым в формате JSON следующего вида (обязательно нужен номер и данные для обновления, т.е. номер нельзя изменить):
+Запрос должен быть с методом POST и с содержимым в формате JSON одного из следующих видов.
+
+С идентификатором записи (id возвращается в /get): можно изменить любые поля, в том числе номер телефона,
+обязательно хотя бы одно поле для обновления. Новый номер нормализуется и проверяется на уникальность:
+  {"id": 1, "name": "Имя", "last_name": "Фамилия", "middle_name": "Отчество", "address": "Адрес", "phone": "Новый телефон"}
+
+Без идентификатора запись ищется по любому из ее номеров телефона (обязательно нужен номер и данные для обновления,
+т.е. phone изменить нельзя, но можно заменить список номеров phones):
   {"name": "Имя", "last_name": "Фамилия", "middle_name": "Отчество", "address": "Адрес", "phone": "Телефон"}
 
+Поле phone в запросе с идентификатором меняет основной номер, поле phones заменяет все номера записи
+(в одном запросе можно указать только одно из них):
+  {"id": 1, "phones": [{"number": "Телефон", "type": "mobile", "primary": true}, {"number": "Телефон", "type": "home"}]}
+
+Тело обрабатывается по правилам JSON Merge Patch (RFC 7396): отсутствующие поля не изменяются,
+null очищает поле, а пустая строка, как и раньше, оставляет его без изменений. Очистить нельзя только name,
+last_name и phone; очистка address удаляет и все структурированные адреса.
+Новый address заменяет основной адрес записи, список addresses заменяет все адреса, список emails - все адреса почты.
+Объект custom объединяется с текущими дополнительными полями, null в значении поля удаляет его (кроме обязательных).
+
+Необязательное поле version - версия записи из /get: если запись успели изменить, обновление не выполняется
+и возвращается ошибка "record version mismatch" с текущим состоянием записи в data:
+  {"id": 1, "version": 3, "address": "Новый адрес"}
+
 Возвращает клиенту ответ с содержимым в формате JSON следующего вида:
   {"result": "OK", "data": null, "error": ""}
 
@@ -150,45 +233,114 @@ func (abs *AddressBookService) updateRecordHandler(w http.ResponseWriter, req *h
 		return
 	}
 
-	// Парсинг запроса
-	record := dto.Record{}
+	// Парсинг запроса (тело - JSON Merge Patch с ключом записи)
+	update := updateRequest{}
 	byteReq, err := io.ReadAll(req.Body)
 	if err != nil {
 		resp.Update("ERROR", nil, err.Error())
 		wErr.Specify(err, "io.ReadAll(req.Body)").LogError()
 		return
 	}
-	err = json.Unmarshal(byteReq, &record)
+	err = json.Unmarshal(byteReq, &update)
 	if err != nil {
 		resp.Update("ERROR", nil, err.Error())
-		wErr.Specify(err, "json.Unmarshal(byteReq, &record)").LogError()
+		wErr.Specify(err, "json.Unmarshal(byteReq, &update)").LogError()
 		return
 	}
+	update.SkipEmptyStrings()
 
-	// Проверка наличия необходимых данных в запросе
-	if (record.Name == "" && record.LastName == "" && record.MiddleName == "" && record.Address == "") || record.Phone == "" {
-		err = errors.New("required data is missing")
+	// Обновление по идентификатору (можно изменить и номер телефона)
+	if update.ID != 0 {
+		abs.updateRecordByID(req, update, resp, wErr)
+		return
+	}
+
+	// Номер телефона - ключ записи, а не обновляемое поле
+	if !update.Phone.Set || update.Phone.Cleared() {
+		err = errors.New("phone data is missing")
 		resp.Update("ERROR", nil, err.Error())
-		wErr.LogMsg(fmt.Sprintf("%s: {name: '%s', lastName: '%s', middleName: '%s', address: '%s', phone: '%s'}",
-			err.Error(), record.Name, record.LastName, record.MiddleName, record.Address, record.Phone))
+		wErr.LogMsg(err.Error())
+		return
+	}
+	phone := update.Phone.Value
+	update.Phone = dto.PatchString{}
+
+	// Проверка наличия данных для обновления
+	err = preparePatch(&update.RecordPatch)
+	if err != nil {
+		resp.Update("ERROR", nil, err.Error())
+		wErr.LogMsg(fmt.Sprintf("%s: {phone: '%s'}", err.Error(), phone))
+		return
+	}
+	err = abs.prepareCustomPatch(req.Context(), &update.RecordPatch)
+	if err != nil {
+		resp.Update("ERROR", nil, storageErrorText(err, err.Error()))
+		wErr.Specify(err, "abs.prepareCustomPatch(req.Context(), &update.RecordPatch)").LogError()
 		return
 	}
 
 	// Нормализация номера телефона
-	record.Phone, err = pkg.NormalizePhoneNumber(record.Phone)
+	phone, err = pkg.NormalizePhoneNumber(phone)
 	if err != nil {
 		err = errors.New("wrong Phone")
 		resp.Update("ERROR", nil, err.Error())
-		wErr.Specify(err, "pkg.NormalizePhoneNumber(record.Phone)").LogError()
+		wErr.Specify(err, "pkg.NormalizePhoneNumber(phone)").LogError()
 		return
 	}
 
 	// Обновление записи
-	err = abs.db.UpdateRecord(record)
+	err = abs.db.UpdateRecord(req.Context(), phone, update.RecordPatch)
+	if errors.Is(err, dto.ErrVersionMismatch) {
+		resp.Update("ERROR", abs.currentRecordJSON(req, dto.Record{Phone: phone}, wErr), err.Error())
+		wErr.LogMsg(fmt.Sprintf("%s: {phone: '%s', version: %d}", err.Error(), phone, update.Version))
+		return
+	}
+	if err != nil {
+		resp.Update("ERROR", nil, storageErrorText(err, "cannot update record"))
+		wErr.Specify(err, "abs.db.UpdateRecord(req.Context(), phone, update.RecordPatch)").LogError()
+		return
+	}
+
+	resp.Update("OK", nil, "")
+}
+
+// updateRequest - тело запроса updateRecordHandler: идентификатор записи (необязательный) и JSON Merge Patch.
+type updateRequest struct {
+	ID int64 `json:"id"`
+	dto.RecordPatch
+}
+
+// updateRecordByID обновляет запись по идентификатору для updateRecordHandler.
+// Ошибки занятого номера и отсутствия записи сообщаются клиенту как есть, чтобы их можно было отличить.
+func (abs *AddressBookService) updateRecordByID(req *http.Request, update updateRequest, resp *dto.Response, wErr *pkg.WrappedError) {
+	// Проверка наличия данных для обновления и нормализация номера телефона, если указан
+	err := preparePatch(&update.RecordPatch)
 	if err != nil {
-		err = errors.New("cannot update record")
 		resp.Update("ERROR", nil, err.Error())
-		wErr.Specify(err, "abs.db.UpdateRecord(record)").LogError()
+		wErr.LogMsg(fmt.Sprintf("%s: {id: %d}", err.Error(), update.ID))
+		return
+	}
+	err = abs.prepareCustomPatch(req.Context(), &update.RecordPatch)
+	if err != nil {
+		resp.Update("ERROR", nil, storageErrorText(err, err.Error()))
+		wErr.Specify(err, "abs.prepareCustomPatch(req.Context(), &update.RecordPatch)").LogError()
+		return
+	}
+
+	// Обновление записи
+	err = abs.db.UpdateRecordByID(req.Context(), update.ID, update.RecordPatch)
+	if errors.Is(err, dto.ErrVersionMismatch) {
+		resp.Update("ERROR", abs.currentRecordJSON(req, dto.Record{ID: update.ID}, wErr), err.Error())
+		wErr.LogMsg(fmt.Sprintf("%s: {id: %d, version: %d}", err.Error(), update.ID, update.Version))
+		return
+	}
+	if err != nil {
+		msg := storageErrorText(err, "cannot update record")
+		if errors.Is(err, dto.ErrPhoneInUse) || errors.Is(err, dto.ErrRecordNotFound) {
+			msg = err.Error()
+		}
+		resp.Update("ERROR", nil, msg)
+		wErr.Specify(err, "abs.db.UpdateRecordByID(req.Context(), update.ID, update.RecordPatch)").LogError()
 		return
 	}
 
@@ -197,9 +349,14 @@ func (abs *AddressBookService) updateRecordHandler(w http.ResponseWriter, req *h
 
 // deleteRecordByPhoneHandler обрабатывает запрос на удаление записи по номеру телефона
 /*
+Удаляется запись, у которой есть указанный номер (основной или дополнительный). Запись перемещается в корзину,
+откуда ее можно восстановить (см. /v2/trash), а ее номера освобождаются.
 Запрос должен быть с методом POST и с содержимым в формате JSON следующего вида:
   {"phone": "89995554422"}
 
+Необязательное поле version - версия записи из /get, как в /update: если запись успели изменить, она не удаляется
+и возвращается ошибка "record version mismatch" с текущим состоянием записи в data.
+
 Возвращает клиенту ответ с содержимым в формате JSON следующего вида:
   {"result": "OK", "data": null, "error": ""}
 
@@ -257,11 +414,15 @@ func (abs *AddressBookService) deleteRecordByPhoneHandler(w http.ResponseWriter,
 	}
 
 	// Удаление записи
-	err = abs.db.DeleteRecordByPhone(record.Phone)
+	err = abs.db.DeleteRecordByPhone(req.Context(), record.Phone, record.Version)
+	if errors.Is(err, dto.ErrVersionMismatch) {
+		resp.Update("ERROR", abs.currentRecordJSON(req, dto.Record{Phone: record.Phone}, wErr), err.Error())
+		wErr.LogMsg(fmt.Sprintf("%s: {phone: '%s', version: %d}", err.Error(), record.Phone, record.Version))
+		return
+	}
 	if err != nil {
-		err = errors.New("cannot delete record")
-		resp.Update("ERROR", nil, err.Error())
-		wErr.Specify(err, "abs.db.DeleteRecordByPhone(record.Phone)").LogError()
+		resp.Update("ERROR", nil, storageErrorText(err, "cannot delete record"))
+		wErr.Specify(err, "abs.db.DeleteRecordByPhone(req.Context(), record.Phone, record.Version)").LogError()
 		return
 	}
 
@@ -270,11 +431,54 @@ func (abs *AddressBookService) deleteRecordByPhoneHandler(w http.ResponseWriter,
 
 // getRecordsHandler обрабатывает запрос на получение записей
 /*
-Запрос должен быть с методом POST и с содержимым в формате JSON следующего вида:
+Запрос должен быть с методом POST и с содержимым в формате JSON следующего вида (поля задают условия на точное совпадение):
   {"phone": "Телефон", "name": "Имя", "last_name": "Фамилия", "middle_name": "Отчество", "address": "Адрес"}
-
-Возвращает клиенту ответ с содержимым в формате JSON следующего вида:
-  {"result": "OK", "data": [ <массив записей> ], "error": ""}
+Так же можно указать emails, birthday, organization, job_title, notes (для emails у записи должны быть все указанные адреса)
+и объект custom (значения дополнительных полей).
+
+Теги (группы, см. /v2/tags): tags - у записи должны быть все указанные теги (AND), tags_any - хотя бы один из них (OR).
+В filter поле tag, как и phone, выполняется, если условию удовлетворяет любой из тегов записи:
+  {"tags": ["Бухгалтерия"], "tags_any": ["Москва", "Казань"]}
+
+Время создания и последнего изменения записи (created_at, updated_at в ответе) устанавливает сервер.
+Условия на них: created_after, created_before, updated_after, updated_before (границы не включаются),
+время в формате RFC 3339 или дата ГГГГ-ММ-ДД; в filter поля created_at и updated_at сравниваются как время.
+Например, записи, измененные после прошлой синхронизации:
+  {"updated_after": "2024-05-01T03:00:00Z", "limit": 500}
+
+Параметр as_of (RFC 3339 или ГГГГ-ММ-ДД) возвращает записи в том виде, в каком они были в этот момент,
+по истории изменений (см. /v2/audit), в том числе удаленные позже записи; условия, поиск и сортировка
+применяются к состоянию на этот момент:
+  {"last_name": "Иванов", "as_of": "2024-04-01T00:00:00Z"}
+
+Условие на phone (в том числе в filter) выполняется, если ему удовлетворяет любой из номеров записи.
+В filter можно использовать части структурированных адресов (country, region, city, street, house, apartment,
+postal_index, label): условие выполняется, если ему удовлетворяет любой из адресов записи, и поле email -
+любой из адресов электронной почты. Дата рождения birthday сравнивается как дата (ГГГГ-ММ-ДД), без prefix и contains.
+Дополнительные поля указываются как custom.{key} и сравниваются по своему типу (string, int, date, enum):
+  {"filter": {"and": [{"field": "custom.department", "op": "eq", "value": "ИТ"}, {"field": "custom.badge_id", "op": "gte", "value": 1000}]}}
+В ответе phone - основной номер записи, phones - все номера, tags - имена тегов записи.
+
+Вместо полей или вместе с ними (условия объединяются через AND) можно указать структурированное условие filter
+(группы and/or, not, операции eq, ne, in, prefix, contains, gt, gte, lt, lte, ignore_case - см. dto.Filter):
+  {"filter": {"or": [{"field": "last_name", "op": "prefix", "value": "ив", "ignore_case": true},
+                     {"field": "id", "op": "in", "values": [1, 2, 3]}]}}
+
+Поиск по строке q - полнотекстовый (с учетом морфологии русского языка и по началу слов) и нечеткий
+(с опечатками) поиск по фамилии, имени, отчеству, адресу, организации, должности и заметкам, а также
+по началу адресов электронной почты; объединяется с остальными условиями через AND,
+по умолчанию записи упорядочиваются по релевантности (sort "relevance"):
+  {"q": "иван петр", "limit": 20}
+
+Постраничная выборка: limit - количество записей на странице (не больше 1000, по умолчанию все записи),
+sort - поле сортировки id, name, last_name, city или postal_index (части основного адреса; с префиксом "-" - по убыванию, по умолчанию id) или relevance (только с q),
+cursor - токен next_cursor из ответа на предыдущую страницу (с теми же условиями и сортировкой),
+with_total - посчитать общее количество записей, удовлетворяющих условиям:
+  {"last_name": "Иванов", "sort": "name", "limit": 50, "cursor": "eyJzIjoi...", "with_total": true}
+
+Возвращает клиенту ответ с содержимым в формате JSON следующего вида
+(next_cursor - только если есть следующая страница, total - только при with_total):
+  {"result": "OK", "data": [ <массив записей> ], "error": "", "next_cursor": "eyJzIjoi...", "total": 120}
 
 В случае ошибки:
 {"result": "ERROR", "data": null, "error": "error description"}
@@ -298,47 +502,121 @@ func (abs *AddressBookService) getRecordsHandler(w http.ResponseWriter, req *htt
 	}
 
 	// Парсинг запроса
-	record := dto.Record{}
+	get := getRequest{}
 	byteReq, err := io.ReadAll(req.Body)
 	if err != nil {
 		resp.Update("ERROR", nil, err.Error())
 		wErr.Specify(err, "io.ReadAll(req.Body)").LogError()
 		return
 	}
-	err = json.Unmarshal(byteReq, &record)
+	err = json.Unmarshal(byteReq, &get)
 	if err != nil {
 		resp.Update("ERROR", nil, err.Error())
-		wErr.Specify(err, "json.Marshal(req)").LogError()
+		wErr.Specify(err, "json.Unmarshal(byteReq, &get)").LogError()
 		return
 	}
 
-	// Нормализация номера телефона, если указан
-	if record.Phone != "" {
-		record.Phone, err = pkg.NormalizePhoneNumber(record.Phone)
-		if err != nil {
-			resp.Update("ERROR", nil, err.Error())
-			wErr.Specify(err, "pkg.NormalizePhoneNumber(record.Phone)").LogError()
-			return
-		}
-	}
-
-	// Получение записей
-	records, err := abs.db.GetRecords(record)
+	// Нормализация условий и получение записей
+	query, err := get.query()
 	if err != nil {
 		resp.Update("ERROR", nil, err.Error())
-		wErr.Specify(err, "abs.db.GetRecords(record)").LogError()
+		wErr.Specify(err, "get.query()").LogError()
+		return
+	}
+	page, err := abs.db.GetRecords(req.Context(), query)
+	if err != nil {
+		resp.Update("ERROR", nil, storageErrorText(err, err.Error()))
+		wErr.Specify(err, "abs.db.GetRecords(req.Context(), query)").LogError()
 		return
 	}
 
 	// Преобразование записей в формат JSON
-	recordsJSON, err := json.Marshal(records)
+	recordsJSON, err := json.Marshal(page.Records)
 	if err != nil {
 		resp.Update("ERROR", nil, err.Error())
-		wErr.Specify(err, "json.Marshal(records)").LogError()
+		wErr.Specify(err, "json.Marshal(page.Records)").LogError()
 		return
 	}
 
 	resp.Update("OK", recordsJSON, "")
+	resp.NextCursor = page.NextCursor
+	resp.Total = page.Total
+}
+
+// getRequest - тело запроса getRecordsHandler: условия на точное совпадение полей, на время создания и изменения,
+// структурированное условие, строка поиска, параметры постраничной выборки и момент времени as_of.
+type getRequest struct {
+	dto.Record
+	dto.TimeRange
+	TagsAny   []string    `json:"tags_any"`
+	Filter    *dto.Filter `json:"filter"`
+	Q         string      `json:"q"`
+	Sort      string      `json:"sort"`
+	Limit     int         `json:"limit"`
+	Cursor    string      `json:"cursor"`
+	WithTotal bool        `json:"with_total"`
+	AsOf      string      `json:"as_of"`
+}
+
+// query нормализует условия запроса (номер телефона, адреса электронной почты, filter)
+// и возвращает параметры выборки записей.
+func (get *getRequest) query() (q dto.Query, err error) {
+	if get.Phone != "" {
+		get.Phone, err = pkg.NormalizePhoneNumber(get.Phone)
+		if err != nil {
+			return q, err
+		}
+	}
+	for i := range get.Emails {
+		get.Emails[i] = strings.ToLower(strings.TrimSpace(get.Emails[i]))
+	}
+	if get.Filter != nil {
+		if err = normalizeFilter(get.Filter); err != nil {
+			return q, err
+		}
+	}
+	asOf, err := dto.ParseAsOf(get.AsOf)
+	if err != nil {
+		return q, err
+	}
+
+	return dto.Query{
+		Filter:    dto.AndFilters(dto.RecordFilter(get.Record), dto.AnyTagFilter(get.TagsAny), get.TimeRange.Filter(), get.Filter),
+		Search:    get.Q,
+		Sort:      get.Sort,
+		Limit:     get.Limit,
+		Cursor:    get.Cursor,
+		WithTotal: get.WithTotal,
+		AsOf:      asOf,
+	}, nil
+}
+
+// currentRecordJSON возвращает текущее состояние записи, удовлетворяющей cond (идентификатор или номер телефона),
+// для ответа на изменение с устаревшей версией или nil, если запись не удалось прочитать.
+func (abs *AddressBookService) currentRecordJSON(req *http.Request, cond dto.Record, wErr *pkg.WrappedError) json.RawMessage {
+	page, err := abs.db.GetRecords(req.Context(), dto.Query{Filter: dto.RecordFilter(cond)})
+	if err != nil || len(page.Records) == 0 {
+		if err != nil {
+			wErr.Specify(err, "abs.db.GetRecords(req.Context(), query)").LogError()
+		}
+		return nil
+	}
+	recordJSON, err := json.Marshal(page.Records[0])
+	if err != nil {
+		wErr.Specify(err, "json.Marshal(page.Records[0])").LogError()
+		return nil
+	}
+	return recordJSON
+}
+
+// storageErrorText возвращает текст ошибки хранилища для клиента.
+// Истечение времени выполнения сообщается отдельной ошибкой dto.ErrTimeout,
+// в остальных случаях возвращается fallback.
+func storageErrorText(err error, fallback string) string {
+	if errors.Is(err, dto.ErrTimeout) {
+		return dto.ErrTimeout.Error()
+	}
+	return fallback
 }
 
 func setHttpHeaders(w http.ResponseWriter) {
@@ -0,0 +1,286 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// maxBatchOps - наибольшее количество операций в одном запросе /batch.
+const maxBatchOps = 1000
+
+// Режимы выполнения пакета /batch (см. dto.BatchOps)
+const (
+	batchAtomic     = "atomic"      // Все операции или ни одной (по умолчанию)
+	batchBestEffort = "best_effort" // Каждая операция отдельно
+)
+
+// Результаты операций пакета в ответе /batch
+const (
+	batchOK         = "OK"
+	batchError      = "ERROR"
+	batchRolledBack = "ROLLED_BACK" // Операция не выполнена из-за ошибки другой операции атомарного пакета
+)
+
+// batchRequest - тело запроса batchHandler.
+type batchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchOperation - операция пакета: действие и данные в формате соответствующего одиночного обработчика.
+type batchOperation struct {
+	Op   string          `json:"op"`
+	Data json.RawMessage `json:"data"`
+}
+
+// batchItem - результат операции пакета в ответе batchHandler.
+type batchItem struct {
+	Op     string `json:"op"`
+	Result string `json:"result"`
+	ID     int64  `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchHandler обрабатывает запрос на пакетное изменение записей
+/*
+Запрос должен быть с методом POST и с содержимым в формате JSON следующего вида (не больше 1000 операций):
+  {"mode": "atomic", "operations": [
+    {"op": "create", "data": {"name": "Имя", "last_name": "Фамилия", "address": "Адрес", "phone": "Телефон"}},
+    {"op": "update", "data": {"id": 1, "version": 3, "address": "Новый адрес"}},
+    {"op": "update", "data": {"phone": "Телефон", "notes": null}},
+    {"op": "delete", "data": {"phone": "Телефон"}},
+    {"op": "delete", "data": {"id": 2, "version": 5}}
+  ]}
+
+Данные операций те же, что у /create, /update и /delete (удалять можно и по id), и проверяются и нормализуются
+так же; поле version необязательно, как в /update и /delete. Операции выполняются по порядку в одной транзакции.
+Режим mode: atomic (по умолчанию) - если одна из операций не проходит проверку или завершается ошибкой,
+не выполняется ни одна; best_effort - ошибка операции отменяет только ее, остальные выполняются.
+
+Возвращает клиенту ответ с результатом каждой операции в порядке запроса (id - идентификатор записи,
+result - OK, ERROR или ROLLED_BACK - операция отменена из-за ошибки другой операции атомарного пакета):
+  {"result": "OK", "data": [{"op": "create", "result": "OK", "id": 7}, {"op": "update", "result": "OK", "id": 1}], "error": ""}
+
+Если хотя бы одна операция завершилась ошибкой:
+  {"result": "ERROR", "data": [{"op": "create", "result": "ROLLED_BACK"},
+    {"op": "update", "result": "ERROR", "id": 1, "error": "record version mismatch"}], "error": "batch rolled back: operation 1 failed"}
+
+В случае ошибки запроса (data - null):
+  {"result": "ERROR", "data": null, "error": "error description"}
+*/
+func (abs *AddressBookService) batchHandler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) batchHandler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) batchHandler: NewWrappedErrorWithFile()", err)
+	}
+
+	// Создание ответа
+	resp := &dto.Response{}
+	defer writeResponseContent(w, resp, wErr)
+
+	// Проверка метода
+	if req.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Парсинг запроса
+	batch := batchRequest{}
+	byteReq, err := io.ReadAll(req.Body)
+	if err != nil {
+		resp.Update("ERROR", nil, err.Error())
+		wErr.Specify(err, "io.ReadAll(req.Body)").LogError()
+		return
+	}
+	err = json.Unmarshal(byteReq, &batch)
+	if err != nil {
+		resp.Update("ERROR", nil, err.Error())
+		wErr.Specify(err, "json.Unmarshal(byteReq, &batch)").LogError()
+		return
+	}
+
+	// Проверка режима и количества операций
+	switch {
+	case batch.Mode != "" && batch.Mode != batchAtomic && batch.Mode != batchBestEffort:
+		err = errors.New("mode must be atomic or best_effort")
+	case len(batch.Operations) == 0:
+		err = errors.New("operations are missing")
+	case len(batch.Operations) > maxBatchOps:
+		err = fmt.Errorf("too many operations (max %d)", maxBatchOps)
+	}
+	if err != nil {
+		resp.Update("ERROR", nil, err.Error())
+		wErr.LogMsg(err.Error())
+		return
+	}
+	atomic := batch.Mode != batchBestEffort
+
+	// Объявления дополнительных полей загружаются один раз для всех операций
+	defs, err := abs.db.CustomFields(req.Context())
+	if err != nil {
+		resp.Update("ERROR", nil, storageErrorText(err, "cannot execute batch"))
+		wErr.Specify(err, "abs.db.CustomFields(req.Context())").LogError()
+		return
+	}
+	customDefs := dto.NewCustomFieldDefs(defs)
+
+	// Проверка и нормализация операций; в хранилище передаются только прошедшие проверку
+	items := make([]batchItem, len(batch.Operations))
+	ops := make([]dto.BatchOp, 0, len(batch.Operations))
+	positions := make([]int, 0, len(batch.Operations))
+	for i, op := range batch.Operations {
+		items[i].Op = op.Op
+		batchOp, err := prepareBatchOp(op, customDefs)
+		if err != nil {
+			items[i].Result, items[i].Error = batchError, err.Error()
+			wErr.LogMsg(fmt.Sprintf("operation %d (%s): %s", i, op.Op, err.Error()))
+			continue
+		}
+		ops = append(ops, batchOp)
+		positions = append(positions, i)
+	}
+
+	// Выполнение операций (атомарный пакет с ошибками проверки не выполняется)
+	if atomic && len(ops) < len(items) {
+		for _, i := range positions {
+			items[i].Result = batchRolledBack
+		}
+	} else {
+		outcomes, err := abs.db.Batch(req.Context(), dto.BatchOps{Ops: ops, Atomic: atomic})
+		if err != nil {
+			resp.Update("ERROR", nil, storageErrorText(err, "cannot execute batch"))
+			wErr.Specify(err, "abs.db.Batch(req.Context(), ops)").LogError()
+			return
+		}
+		for j, outcome := range outcomes {
+			item := &items[positions[j]]
+			switch {
+			case outcome.Err == nil:
+				item.Result, item.ID = batchOK, outcome.ID
+			case errors.Is(outcome.Err, dto.ErrBatchRolledBack):
+				item.Result = batchRolledBack
+			default:
+				item.Result, item.ID, item.Error = batchError, outcome.ID, batchErrorText(outcome.Err)
+			}
+		}
+	}
+
+	// Преобразование результатов в формат JSON
+	itemsJSON, err := json.Marshal(items)
+	if err != nil {
+		resp.Update("ERROR", nil, err.Error())
+		wErr.Specify(err, "json.Marshal(items)").LogError()
+		return
+	}
+
+	failed := -1
+	var failures int
+	for i := range items {
+		if items[i].Result == batchError {
+			failures++
+			if failed == -1 {
+				failed = i
+			}
+		}
+	}
+	switch {
+	case failures == 0:
+		resp.Update("OK", itemsJSON, "")
+	case atomic:
+		resp.Update("ERROR", itemsJSON, fmt.Sprintf("batch rolled back: operation %d failed", failed))
+	default:
+		resp.Update("ERROR", itemsJSON, fmt.Sprintf("%d of %d operations failed", failures, len(items)))
+	}
+}
+
+// prepareBatchOp проверяет и нормализует операцию пакета так же, как /create, /update и /delete
+// (дополнительные поля - по объявлениям defs), и возвращает ее в виде операции хранилища.
+func prepareBatchOp(op batchOperation, defs dto.CustomFieldDefs) (dto.BatchOp, error) {
+	if len(op.Data) == 0 {
+		return dto.BatchOp{}, errors.New("data is missing")
+	}
+
+	switch op.Op {
+	case dto.ActionCreate:
+		record := dto.Record{}
+		if err := json.Unmarshal(op.Data, &record); err != nil {
+			return dto.BatchOp{}, err
+		}
+		if err := prepareNewRecord(&record); err != nil {
+			return dto.BatchOp{}, err
+		}
+		if err := defs.ValidateCustom(record.Custom, true); err != nil {
+			return dto.BatchOp{}, err
+		}
+		record.ID = 0
+		return dto.BatchOp{Action: op.Op, Record: record}, nil
+
+	case dto.ActionUpdate:
+		update := updateRequest{}
+		if err := json.Unmarshal(op.Data, &update); err != nil {
+			return dto.BatchOp{}, err
+		}
+		update.SkipEmptyStrings()
+		// Без идентификатора номер телефона - ключ записи, а не обновляемое поле
+		var phone string
+		if update.ID == 0 {
+			if !update.Phone.Set || update.Phone.Cleared() {
+				return dto.BatchOp{}, errors.New("phone data is missing")
+			}
+			phone = update.Phone.Value
+			update.Phone = dto.PatchString{}
+		}
+		if err := preparePatch(&update.RecordPatch); err != nil {
+			return dto.BatchOp{}, err
+		}
+		if update.Custom.Set {
+			if err := defs.ValidatePatch(&update.Custom); err != nil {
+				return dto.BatchOp{}, err
+			}
+		}
+		if update.ID != 0 {
+			return dto.BatchOp{Action: op.Op, ID: update.ID, Patch: update.RecordPatch}, nil
+		}
+		phone, err := pkg.NormalizePhoneNumber(phone)
+		if err != nil {
+			return dto.BatchOp{}, errors.New("wrong Phone")
+		}
+		return dto.BatchOp{Action: op.Op, Phone: phone, Patch: update.RecordPatch}, nil
+
+	case dto.ActionDelete:
+		record := dto.Record{}
+		if err := json.Unmarshal(op.Data, &record); err != nil {
+			return dto.BatchOp{}, err
+		}
+		if record.ID != 0 {
+			return dto.BatchOp{Action: op.Op, ID: record.ID, Version: record.Version}, nil
+		}
+		if record.Phone == "" {
+			return dto.BatchOp{}, errors.New("phone data is missing")
+		}
+		phone, err := pkg.NormalizePhoneNumber(record.Phone)
+		if err != nil {
+			return dto.BatchOp{}, errors.New("wrong Phone")
+		}
+		return dto.BatchOp{Action: op.Op, Phone: phone, Version: record.Version}, nil
+	}
+
+	return dto.BatchOp{}, errors.New("op must be create, update or delete")
+}
+
+// batchErrorText возвращает текст ошибки операции пакета: ошибки хранилища, которые клиент может исправить
+// (занятый номер, отсутствие записи, несовпадение версии), сообщаются как есть, внутренние - общим текстом.
+func batchErrorText(err error) string {
+	if statusForError(err) == http.StatusInternalServerError {
+		return "internal server error"
+	}
+	return err.Error()
+}
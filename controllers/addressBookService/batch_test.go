@@ -0,0 +1,151 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// postBatch отправляет пакет операций ops в режиме mode и возвращает ответ и результаты операций.
+func postBatch(t *testing.T, h http.Handler, mode string, ops ...string) (dto.Response, []batchItem) {
+	t.Helper()
+	body := fmt.Sprintf(`{"mode": %q, "operations": [`, mode)
+	for i, op := range ops {
+		if i > 0 {
+			body += ", "
+		}
+		body += op
+	}
+	resp := postV1(t, h, "/batch", body+"]}")
+
+	var items []batchItem
+	if resp.Data != nil {
+		if err := json.Unmarshal(resp.Data, &items); err != nil {
+			t.Fatalf("/batch data %s: %v", resp.Data, err)
+		}
+	}
+	return resp, items
+}
+
+// wantBatchResults проверяет результаты операций пакета (OK, ERROR, ROLLED_BACK) по порядку.
+func wantBatchResults(t *testing.T, items []batchItem, results ...string) {
+	t.Helper()
+	got := make([]string, len(items))
+	for i, item := range items {
+		got[i] = item.Result
+	}
+	if !reflect.DeepEqual(got, results) {
+		t.Fatalf("batch results = %+v, want %v", items, results)
+	}
+}
+
+// batchCreate возвращает операцию create записи с номером phone.
+func batchCreate(phone string) string {
+	return fmt.Sprintf(`{"op": "create", "data": {"name": "Иван", "last_name": "Петров", "address": "Москва", "phone": %q}}`, phone)
+}
+
+func TestBatchMixedOperations(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			existing, created, temporary := testPhone(31), testPhone(32), testPhone(33)
+			wantV1(t, postV1(t, h, "/create", fmt.Sprintf(`{"name": "Петр", "last_name": "Иванов", "address": "Тверь", "phone": %q}`, existing)), "")
+
+			// Операции видят результаты предыдущих операций пакета
+			resp, items := postBatch(t, h, batchAtomic,
+				batchCreate(created),
+				fmt.Sprintf(`{"op": "update", "data": {"phone": %q, "version": 1, "notes": "Перезвонить"}}`, existing),
+				batchCreate(temporary),
+				fmt.Sprintf(`{"op": "delete", "data": {"phone": %q}}`, temporary),
+			)
+			wantV1(t, resp, "")
+			wantBatchResults(t, items, batchOK, batchOK, batchOK, batchOK)
+			if items[0].ID == 0 || items[2].ID == 0 || items[2].ID != items[3].ID {
+				t.Fatalf("batch ids = %+v", items)
+			}
+
+			if records := getByPhone(t, h, created); len(records) != 1 || records[0].ID != items[0].ID {
+				t.Fatalf("/get created record = %+v", records)
+			}
+			if records := getByPhone(t, h, existing); len(records) != 1 || records[0].Notes != "Перезвонить" || records[0].Version != 2 {
+				t.Fatalf("/get updated record = %+v", records)
+			}
+			if records := getByPhone(t, h, temporary); len(records) != 0 {
+				t.Fatalf("/get deleted record = %+v", records)
+			}
+		})
+	}
+}
+
+func TestBatchDuplicatePhone(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			existing := testPhone(34)
+			wantV1(t, postV1(t, h, "/create", fmt.Sprintf(`{"name": "Петр", "last_name": "Иванов", "address": "Тверь", "phone": %q}`, existing)), "")
+
+			batch := func(mode, created string) (dto.Response, []batchItem) {
+				return postBatch(t, h, mode,
+					batchCreate(created),
+					fmt.Sprintf(`{"op": "update", "data": {"phone": %q, "notes": %q}}`, existing, mode),
+					batchCreate(existing),
+				)
+			}
+
+			// Атомарный пакет: ошибка одной операции отменяет все
+			created := testPhone(35)
+			resp, items := batch(batchAtomic, created)
+			wantV1(t, resp, "batch rolled back: operation 2 failed")
+			wantBatchResults(t, items, batchRolledBack, batchRolledBack, batchError)
+			if items[2].Error != dto.ErrPhoneInUse.Error() {
+				t.Fatalf("duplicate phone error %q", items[2].Error)
+			}
+			if records := getByPhone(t, h, created); len(records) != 0 {
+				t.Fatalf("/get rolled back record = %+v", records)
+			}
+			if records := getByPhone(t, h, existing); len(records) != 1 || records[0].Notes != "" || records[0].Version != 1 {
+				t.Fatalf("/get rolled back update = %+v", records)
+			}
+
+			// Пакет best_effort: отменяется только ошибочная операция
+			created = testPhone(36)
+			resp, items = batch(batchBestEffort, created)
+			wantV1(t, resp, "1 of 3 operations failed")
+			wantBatchResults(t, items, batchOK, batchOK, batchError)
+			if items[2].Error != dto.ErrPhoneInUse.Error() {
+				t.Fatalf("duplicate phone error %q", items[2].Error)
+			}
+			if records := getByPhone(t, h, created); len(records) != 1 {
+				t.Fatalf("/get created record = %+v", records)
+			}
+			if records := getByPhone(t, h, existing); len(records) != 1 || records[0].Notes != batchBestEffort || records[0].Version != 2 {
+				t.Fatalf("/get updated record = %+v", records)
+			}
+		})
+	}
+}
+
+func TestBatchValidation(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			created := testPhone(37)
+
+			// Операция, не прошедшая проверку, отменяет атомарный пакет до обращения к хранилищу
+			resp, items := postBatch(t, h, batchAtomic, batchCreate(created), `{"op": "create", "data": {"name": "Иван"}}`)
+			wantV1(t, resp, "batch rolled back: operation 1 failed")
+			wantBatchResults(t, items, batchRolledBack, batchError)
+			if records := getByPhone(t, h, created); len(records) != 0 {
+				t.Fatalf("/get rolled back record = %+v", records)
+			}
+
+			resp, _ = postBatch(t, h, "parallel", batchCreate(created))
+			wantV1(t, resp, "mode must be atomic or best_effort")
+			resp, _ = postBatch(t, h, batchAtomic)
+			wantV1(t, resp, "operations are missing")
+		})
+	}
+}
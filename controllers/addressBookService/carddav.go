@@ -0,0 +1,583 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Адресная книга по протоколу CardDAV (RFC 6352) для встроенных приложений контактов iOS, macOS и Android
+// (DAVx5): принципал, домашняя коллекция и одна общая адресная книга компании, карточки которой - записи
+// адресной книги. Сервер не проверяет пароли: аутентификацию и TLS выполняет обратный прокси, а автором
+// изменений (см. withChangeMeta) без заголовка X-Actor становится имя пользователя из Basic-авторизации.
+//
+//	/.well-known/carddav                   - перенаправление на /carddav/ (RFC 6764)
+//	/carddav/principal/                    - принципал
+//	/carddav/addressbooks/                 - домашняя коллекция (addressbook-home-set)
+//	/carddav/addressbooks/company/         - адресная книга
+//	/carddav/addressbooks/company/{name}   - карточка vCard записи; ETag - версия записи, как в REST API v2
+//
+// Карточки записей называются abs-record-{id}.vcf, карточки, созданные клиентом, сохраняют выбранное им имя.
+const (
+	cardDAVPath            = "/carddav/"
+	cardDAVPrincipalPath   = cardDAVPath + "principal/"
+	cardDAVHomePath        = cardDAVPath + "addressbooks/"
+	cardDAVAddressBookPath = cardDAVHomePath + "company/"
+	wellKnownCardDAVPath   = "/.well-known/carddav"
+)
+
+// Описание адресной книги CardDAV
+const (
+	cardDAVDisplayName = "Адресная книга компании"
+	cardDAVDescription = "Общая адресная книга компании"
+)
+
+// cardDAVExtension - расширение имен карточек.
+const cardDAVExtension = ".vcf"
+
+// cardDAVMaxResourceSize - наибольший размер карточки в запросе PUT.
+const cardDAVMaxResourceSize = 1 << 20
+
+// cardDAVSyncTokenPrefix - начало sync-token: urn:x-abs:commit:{отметка синхронизации хранилища, см. Storage.SyncToken}.
+// Токены прежнего вида urn:x-abs:sync:{txid} (до миграции 0016) отклоняются, и клиенты синхронизируются заново.
+const cardDAVSyncTokenPrefix = "urn:x-abs:commit:"
+
+// cardDAVMethods - методы, которые принимает сервер CardDAV.
+const cardDAVMethods = "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT"
+
+// cardDAVKind - вид ресурса CardDAV.
+type cardDAVKind int
+
+const (
+	cardDAVRoot cardDAVKind = iota
+	cardDAVPrincipal
+	cardDAVHome
+	cardDAVAddressBook
+	cardDAVObject
+)
+
+// cardDAVResource определяет ресурс по пути запроса path; для карточки возвращает ее имя.
+func cardDAVResource(path string) (kind cardDAVKind, name string, ok bool) {
+	if name, ok = strings.CutPrefix(path, cardDAVAddressBookPath); ok && name != "" {
+		return cardDAVObject, name, !strings.Contains(name, "/")
+	}
+	switch strings.TrimSuffix(path, "/") + "/" {
+	case cardDAVPath:
+		return cardDAVRoot, "", true
+	case cardDAVPrincipalPath:
+		return cardDAVPrincipal, "", true
+	case cardDAVHomePath:
+		return cardDAVHome, "", true
+	case cardDAVAddressBookPath:
+		return cardDAVAddressBook, "", true
+	}
+	return 0, "", false
+}
+
+// wellKnownCardDAVHandler перенаправляет клиентов, которые ищут сервер по адресу /.well-known/carddav, на /carddav/.
+func (abs *AddressBookService) wellKnownCardDAVHandler(w http.ResponseWriter, req *http.Request) {
+	http.Redirect(w, req, cardDAVPath, http.StatusMovedPermanently)
+}
+
+// cardDAVHandler обрабатывает запросы CardDAV
+/*
+OPTIONS - поддерживаемые методы (Allow) и возможности сервера (DAV: 1, 3, addressbook).
+
+PROPFIND - свойства ресурса (Depth: 0) или ресурса и его членов (Depth: 1; Depth: infinity обрабатывается как 1).
+Тело - allprop (или пустое), propname или prop со списком свойств. Ответ - 207 Multi-Status.
+
+REPORT к адресной книге - addressbook-multiget, addressbook-query и sync-collection (см. cardDAVReport).
+
+GET, HEAD /carddav/addressbooks/company/{name} - карточка vCard 3.0 (ETag - версия записи).
+
+PUT /carddav/addressbooks/company/{name} - создание (201) или замена (204) записи по карточке vCard
+(поля сопоставляются, как при импорте vCard). Заголовки If-Match ("3") и If-None-Match: * проверяются (412).
+Теги и дополнительные поля записи при замене сохраняются, адрес, совпадающий с прежним, не изменяется.
+
+DELETE /carddav/addressbooks/company/{name} - удаление записи в корзину (204).
+*/
+func (abs *AddressBookService) cardDAVHandler(w http.ResponseWriter, req *http.Request) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) cardDAVHandler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) cardDAVHandler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	w.Header().Set("DAV", "1, 3, addressbook")
+	req = withCardDAVActor(req)
+
+	kind, name, ok := cardDAVResource(req.URL.Path)
+	if !ok {
+		writeDAVError(w, &davError{status: http.StatusNotFound, msg: "resource not found"}, wErr)
+		return
+	}
+
+	switch {
+	case req.Method == http.MethodOptions:
+		w.Header().Set("Allow", cardDAVMethods)
+		w.WriteHeader(http.StatusOK)
+	case req.Method == "PROPFIND":
+		abs.cardDAVPropfind(w, req, kind, name, wErr)
+	case req.Method == "REPORT" && kind == cardDAVAddressBook:
+		abs.cardDAVReport(w, req, wErr)
+	case req.Method == "REPORT":
+		writeDAVError(w, &davError{status: http.StatusForbidden, condition: "<d:supported-report/>",
+			msg: "reports are supported by the address book collection only"}, wErr)
+	case kind != cardDAVObject:
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT")
+		writeDAVError(w, &davError{status: http.StatusMethodNotAllowed, msg: "method not allowed for a collection"}, wErr)
+	case req.Method == http.MethodGet, req.Method == http.MethodHead:
+		abs.cardDAVGet(w, req, name, wErr)
+	case req.Method == http.MethodPut:
+		abs.cardDAVPut(w, req, name, wErr)
+	case req.Method == http.MethodDelete:
+		abs.cardDAVDelete(w, req, name, wErr)
+	default:
+		w.Header().Set("Allow", cardDAVMethods)
+		writeDAVError(w, &davError{status: http.StatusMethodNotAllowed, msg: "invalid request method"}, wErr)
+	}
+}
+
+// withCardDAVActor добавляет в контекст запроса автора изменений - имя пользователя из Basic-авторизации,
+// если автор не передан в заголовке X-Actor. Пароль не проверяется, поэтому автор, как и X-Actor, известен только
+// со слов клиента и заслуживает доверия, лишь если прокси проверяет пароль и сам задает X-Actor.
+func withCardDAVActor(req *http.Request) *http.Request {
+	meta := dto.ChangeMetaFrom(req.Context())
+	if meta.Actor != "" {
+		return req
+	}
+	user, _, ok := req.BasicAuth()
+	if !ok || user == "" {
+		return req
+	}
+	meta.Actor = user
+	return req.WithContext(dto.WithChangeMeta(req.Context(), meta))
+}
+
+// cardDAVNames - имена карточек записей в адресной книге CardDAV.
+type cardDAVNames struct {
+	byID   map[int64]string
+	byName map[string]int64
+}
+
+// cardDAVNames загружает имена карточек, выбранные клиентами CardDAV.
+func (abs *AddressBookService) cardDAVNames(ctx context.Context) (cardDAVNames, error) {
+	byID, err := abs.db.CardDAVNames(ctx)
+	if err != nil {
+		return cardDAVNames{}, err
+	}
+	names := cardDAVNames{byID: byID, byName: make(map[string]int64, len(byID))}
+	for id, name := range byID {
+		names.byName[name] = id
+	}
+	return names, nil
+}
+
+// name возвращает имя карточки записи id: выбранное клиентом или abs-record-{id}.vcf.
+func (n cardDAVNames) name(id int64) string {
+	if name, ok := n.byID[id]; ok {
+		return name
+	}
+	return vcardUIDPrefix + strconv.FormatInt(id, 10) + cardDAVExtension
+}
+
+// id возвращает идентификатор записи с карточкой name. Запись может быть удалена.
+func (n cardDAVNames) id(name string) (int64, bool) {
+	if id, ok := n.byName[name]; ok {
+		return id, true
+	}
+	digits, ok := strings.CutPrefix(strings.TrimSuffix(name, cardDAVExtension), vcardUIDPrefix)
+	if !ok || !strings.HasSuffix(name, cardDAVExtension) {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || id <= 0 || strconv.FormatInt(id, 10) != digits {
+		return 0, false
+	}
+	if _, custom := n.byID[id]; custom {
+		return 0, false // Карточка записи доступна только под выбранным клиентом именем
+	}
+	return id, true
+}
+
+// href возвращает путь карточки записи id.
+func (n cardDAVNames) href(id int64) string {
+	return cardDAVAddressBookPath + url.PathEscape(n.name(id))
+}
+
+// cardDAVCard возвращает карточку записи r с именем name в версии vCard version. UID карточки - имя
+// без расширения, чтобы карточка, созданная клиентом, сохраняла его UID.
+func cardDAVCard(r dto.Record, name, version string) pkg.VCard {
+	card := recordToVCard(r, version)
+	if uid := card.Get("UID"); uid != nil {
+		uid.Value = pkg.VCardText(strings.TrimSuffix(name, cardDAVExtension))
+	}
+	return card
+}
+
+// cardDAVRecord возвращает запись с карточкой name или dto.ErrRecordNotFound, если ее нет (или запись удалена).
+func (abs *AddressBookService) cardDAVRecord(req *http.Request, names cardDAVNames, name string) (dto.Record, error) {
+	id, ok := names.id(name)
+	if !ok {
+		return dto.Record{}, dto.ErrRecordNotFound
+	}
+	return abs.recordByID(req, id)
+}
+
+// cardDAVSyncToken возвращает sync-token адресной книги: отметку синхронизации хранилища. Отметка меняется
+// с каждым изменением записей, поэтому служит и getctag.
+func (abs *AddressBookService) cardDAVSyncToken(ctx context.Context) (int64, error) {
+	return abs.db.SyncToken(ctx)
+}
+
+// formatSyncToken возвращает sync-token для отметки синхронизации token.
+func formatSyncToken(token int64) string {
+	return cardDAVSyncTokenPrefix + strconv.FormatInt(token, 10)
+}
+
+// collectionProps возвращает свойства коллекции kind.
+func (abs *AddressBookService) collectionProps(ctx context.Context, kind cardDAVKind) (davProps, error) {
+	props := davProps{
+		propResourceType:         "<d:collection/>",
+		propCurrentUserPrincipal: davHref(cardDAVPrincipalPath),
+	}
+	switch kind {
+	case cardDAVRoot:
+		props[propDisplayName] = "CardDAV"
+		props[propAddressBookHomeSet] = davHref(cardDAVHomePath)
+	case cardDAVPrincipal:
+		props[propResourceType] = "<d:collection/><d:principal/>"
+		props[propDisplayName] = davEscape(cardDAVDisplayName)
+		props[propPrincipalURL] = davHref(cardDAVPrincipalPath)
+		props[propAddressBookHomeSet] = davHref(cardDAVHomePath)
+	case cardDAVHome:
+		props[propDisplayName] = davEscape("Адресные книги")
+	case cardDAVAddressBook:
+		token, err := abs.cardDAVSyncToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		props[propResourceType] = "<d:collection/><card:addressbook/>"
+		props[propDisplayName] = davEscape(cardDAVDisplayName)
+		props[propOwner] = davHref(cardDAVPrincipalPath)
+		props[propAddressBookDescription] = davEscape(cardDAVDescription)
+		props[propSupportedAddressData] = `<card:address-data-type content-type="text/vcard" version="3.0"/>` +
+			`<card:address-data-type content-type="text/vcard" version="4.0"/>`
+		props[propMaxResourceSize] = strconv.Itoa(cardDAVMaxResourceSize)
+		props[propSupportedReportSet] = "<d:supported-report><d:report><card:addressbook-multiget/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><card:addressbook-query/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><d:sync-collection/></d:report></d:supported-report>"
+		props[propSyncToken] = davEscape(formatSyncToken(token))
+		props[propGetCTag] = davEscape(formatSyncToken(token))
+		props[propCurrentUserPrivileges] = cardDAVPrivileges(true)
+		return props, nil
+	}
+	props[propCurrentUserPrivileges] = cardDAVPrivileges(false)
+	return props, nil
+}
+
+// cardDAVPrivileges возвращает значение current-user-privilege-set: чтение, а для адресной книги - и изменение.
+func cardDAVPrivileges(write bool) string {
+	privileges := []string{"read"}
+	if write {
+		privileges = append(privileges, "write", "write-content", "bind", "unbind")
+	}
+	var b strings.Builder
+	for _, p := range privileges {
+		b.WriteString("<d:privilege><d:" + p + "/></d:privilege>")
+	}
+	return b.String()
+}
+
+// cardProps возвращает свойства карточки записи r с именем name. card:address-data заполняется, только если
+// оно запрошено явно (RFC 6352, 8.7), в версии и с набором свойств карточки из запроса.
+func cardProps(r dto.Record, name string, pr davPropRequest) davProps {
+	var body bytes.Buffer
+	_ = pkg.WriteVCard(&body, cardDAVCard(r, name, vcardV3))
+	props := davProps{
+		propResourceType:          "",
+		propGetETag:               davEscape(etagV2(r.Version)),
+		propGetContentType:        davEscape(vcardContentType),
+		propGetContentLength:      strconv.Itoa(body.Len()),
+		propCurrentUserPrivileges: cardDAVPrivileges(true),
+	}
+	if !r.UpdatedAt.IsZero() {
+		props[propGetLastModified] = r.UpdatedAt.UTC().Format(http.TimeFormat)
+	}
+	if !pr.wants(propAddressData) {
+		return props
+	}
+
+	card := cardDAVCard(r, name, pr.addressData.version)
+	if len(pr.addressData.props) > 0 {
+		card.Props = slices.DeleteFunc(card.Props, func(p pkg.VCardProp) bool {
+			return p.Name != "VERSION" && !slices.Contains(pr.addressData.props, p.Name)
+		})
+	}
+	body.Reset()
+	_ = pkg.WriteVCard(&body, card)
+	props[propAddressData] = davEscape(body.String())
+	return props
+}
+
+// cardDAVPropfind обрабатывает PROPFIND к ресурсу kind (для карточки - с именем name).
+func (abs *AddressBookService) cardDAVPropfind(w http.ResponseWriter, req *http.Request, kind cardDAVKind, name string, wErr *pkg.WrappedError) {
+	pr, err := parsePropfind(req)
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+	depth1 := req.Header.Get("Depth") != "0"
+	ctx := req.Context()
+	ms := newDAVMultistatus(w)
+
+	if kind == cardDAVObject {
+		names, err := abs.cardDAVNames(ctx)
+		if err != nil {
+			writeDAVError(w, err, wErr)
+			return
+		}
+		record, err := abs.cardDAVRecord(req, names, name)
+		if err != nil {
+			writeDAVError(w, err, wErr)
+			return
+		}
+		ms.propstat(names.href(record.ID), cardProps(record, name, pr), pr)
+		ms.send(wErr)
+		return
+	}
+
+	props, err := abs.collectionProps(ctx, kind)
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+	ms.propstat(map[cardDAVKind]string{cardDAVRoot: cardDAVPath, cardDAVPrincipal: cardDAVPrincipalPath,
+		cardDAVHome: cardDAVHomePath, cardDAVAddressBook: cardDAVAddressBookPath}[kind], props, pr)
+
+	if depth1 {
+		switch kind {
+		case cardDAVRoot:
+			for _, member := range []struct {
+				kind cardDAVKind
+				path string
+			}{{cardDAVPrincipal, cardDAVPrincipalPath}, {cardDAVHome, cardDAVHomePath}} {
+				if props, err = abs.collectionProps(ctx, member.kind); err != nil {
+					writeDAVError(w, err, wErr)
+					return
+				}
+				ms.propstat(member.path, props, pr)
+			}
+		case cardDAVHome:
+			if props, err = abs.collectionProps(ctx, cardDAVAddressBook); err != nil {
+				writeDAVError(w, err, wErr)
+				return
+			}
+			ms.propstat(cardDAVAddressBookPath, props, pr)
+		case cardDAVAddressBook:
+			names, err := abs.cardDAVNames(ctx)
+			if err == nil {
+				err = abs.db.ExportRecords(ctx, dto.Query{}, func(r dto.Record) error {
+					ms.propstat(names.href(r.ID), cardProps(r, names.name(r.ID), pr), pr)
+					return ms.err
+				})
+			}
+			if err != nil {
+				writeDAVError(w, ms.fail(err, wErr), wErr)
+				return
+			}
+		}
+	}
+
+	ms.send(wErr)
+}
+
+// cardDAVGet отправляет карточку name в формате vCard 3.0 (на HEAD - только заголовки).
+func (abs *AddressBookService) cardDAVGet(w http.ResponseWriter, req *http.Request, name string, wErr *pkg.WrappedError) {
+	names, err := abs.cardDAVNames(req.Context())
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+	record, err := abs.cardDAVRecord(req, names, name)
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+
+	var body bytes.Buffer
+	_ = pkg.WriteVCard(&body, cardDAVCard(record, name, vcardV3))
+	w.Header().Set("Content-Type", vcardContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.Header().Set("ETag", etagV2(record.Version))
+	if !record.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", record.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err = w.Write(body.Bytes()); err != nil {
+		wErr.Specify(err, "w.Write()").LogError()
+	}
+}
+
+// cardDAVPut создает или заменяет запись по карточке vCard из тела запроса. ETag в ответе не возвращается:
+// сохраненная карточка отличается от присланной (RFC 6352, 6.3.2.3), и клиент перечитывает ее.
+func (abs *AddressBookService) cardDAVPut(w http.ResponseWriter, req *http.Request, name string, wErr *pkg.WrappedError) {
+	ctx := req.Context()
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, cardDAVMaxResourceSize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			err = &davError{status: http.StatusRequestEntityTooLarge, condition: "<card:max-resource-size/>", msg: "vCard is too large"}
+		}
+		writeDAVError(w, err, wErr)
+		return
+	}
+	cards, err := pkg.ParseVCards(bytes.NewReader(body))
+	if err == nil && len(cards) != 1 {
+		err = &dto.ValidationError{Msg: "request body must contain exactly one vCard"}
+	}
+	var record dto.Record
+	if err == nil {
+		record, err = recordFromVCard(cards[0])
+	}
+	if err != nil {
+		writeDAVError(w, &davError{status: http.StatusForbidden, condition: "<card:valid-address-data/>", msg: err.Error()}, wErr)
+		return
+	}
+
+	names, err := abs.cardDAVNames(ctx)
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+	current, err := abs.cardDAVRecord(req, names, name)
+	switch {
+	case errors.Is(err, dto.ErrRecordNotFound):
+		abs.cardDAVCreate(w, req, name, record, wErr)
+		return
+	case err != nil:
+		writeDAVError(w, err, wErr)
+		return
+	case strings.TrimSpace(req.Header.Get("If-None-Match")) == "*":
+		writeDAVError(w, &davError{status: http.StatusPreconditionFailed, msg: "resource already exists"}, wErr)
+		return
+	}
+
+	pre, err := preconditionV2(req, 0)
+	switch {
+	case errors.Is(err, errVersionRequired):
+		// Без If-Match карточка заменяется без проверки версии
+	case err != nil:
+		writeDAVError(w, &davError{status: http.StatusPreconditionFailed, msg: err.Error()}, wErr)
+		return
+	}
+
+	record.ID = current.ID
+	record.Version = pre.version
+	record.Custom = current.Custom
+	keepAddresses(&record, current)
+	err = abs.db.ReplaceRecord(ctx, record)
+	if errors.Is(err, dto.ErrVersionMismatch) {
+		err = &davError{status: http.StatusPreconditionFailed, msg: err.Error()}
+	}
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cardDAVCreate создает запись record с карточкой name.
+func (abs *AddressBookService) cardDAVCreate(w http.ResponseWriter, req *http.Request, name string, record dto.Record, wErr *pkg.WrappedError) {
+	switch {
+	case req.Header.Get("If-Match") != "":
+		writeDAVError(w, &davError{status: http.StatusPreconditionFailed, msg: "resource does not exist"}, wErr)
+		return
+	case strings.HasPrefix(name, vcardUIDPrefix):
+		writeDAVError(w, &davError{status: http.StatusForbidden, msg: fmt.Sprintf("resource names starting with %q are reserved", vcardUIDPrefix)}, wErr)
+		return
+	}
+
+	err := abs.prepareCustom(req.Context(), &record)
+	if err != nil {
+		var validationErr *dto.ValidationError
+		if errors.As(err, &validationErr) {
+			err = &davError{status: http.StatusForbidden, condition: "<card:valid-address-data/>", msg: err.Error()}
+		}
+		writeDAVError(w, err, wErr)
+		return
+	}
+	_, err = abs.db.SaveCardDAVRecord(req.Context(), record, name)
+	if errors.Is(err, dto.ErrCardDAVNameInUse) {
+		err = &davError{status: http.StatusPreconditionFailed, msg: err.Error()}
+	}
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// keepAddresses заменяет адреса записи record, совпадающие в vCard с адресами записи current, прежними:
+// в карточке нет меток адресов, кроме home и work, и без этого каждое изменение контакта на телефоне
+// стирало бы остальные метки.
+func keepAddresses(record *dto.Record, current dto.Record) {
+	for i, a := range record.Addresses {
+		for _, c := range current.Addresses {
+			value, types := vcardADR(c)
+			if vcardAddress(pkg.VCardProp{Value: value}, types) == a {
+				record.Addresses[i] = c
+				break
+			}
+		}
+	}
+	if len(record.Addresses) > 0 {
+		record.Address = record.Addresses[0].String()
+	}
+}
+
+// cardDAVDelete удаляет запись с карточкой name в корзину.
+func (abs *AddressBookService) cardDAVDelete(w http.ResponseWriter, req *http.Request, name string, wErr *pkg.WrappedError) {
+	names, err := abs.cardDAVNames(req.Context())
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+	record, err := abs.cardDAVRecord(req, names, name)
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+
+	pre, err := preconditionV2(req, 0)
+	if errors.Is(err, errVersionRequired) {
+		err = nil
+	}
+	if err == nil {
+		err = abs.db.DeleteRecordByID(req.Context(), record.ID, pre.version)
+	}
+	if errors.Is(err, dto.ErrVersionMismatch) {
+		err = &davError{status: http.StatusPreconditionFailed, msg: err.Error()}
+	}
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
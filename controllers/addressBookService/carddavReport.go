@@ -0,0 +1,464 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Отчеты REPORT адресной книги CardDAV
+var (
+	reportMultiget = xml.Name{Space: nsCardDAV, Local: "addressbook-multiget"}
+	reportQuery    = xml.Name{Space: nsCardDAV, Local: "addressbook-query"}
+	reportSync     = xml.Name{Space: nsDAV, Local: "sync-collection"}
+)
+
+// cardDAVReport обрабатывает REPORT к адресной книге
+/*
+addressbook-multiget (RFC 6352, 8.7) - свойства карточек по списку DAV:href; для неизвестных карточек - 404.
+
+addressbook-query (RFC 6352, 8.6) - свойства карточек, удовлетворяющих card:filter: prop-filter по свойствам
+vCard (is-not-defined, text-match, param-filter; test="anyof" по умолчанию или "allof"). text-match сравнивает
+без учета регистра (i;unicode-casemap по умолчанию, i;ascii-casemap) или точно (i;octet), match-type - equals,
+contains (по умолчанию), starts-with или ends-with, negate-condition="yes" обращает условие. Если карточек больше
+card:limit/card:nresults, выдаются первые из них и ответ 507 для адресной книги.
+
+sync-collection (RFC 6578) - карточки, измененные после sync-token из запроса (пустой токен - все карточки),
+и 404 для удаленных; новый sync-token - в конце ответа.
+*/
+func (abs *AddressBookService) cardDAVReport(w http.ResponseWriter, req *http.Request, wErr *pkg.WrappedError) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, cardDAVMaxRequestSize))
+	if err != nil {
+		writeDAVError(w, err, wErr)
+		return
+	}
+
+	var root xml.StartElement
+	for decoder := xml.NewDecoder(bytes.NewReader(body)); ; {
+		tok, err := decoder.Token()
+		if err != nil {
+			writeDAVError(w, badDAVRequest(err), wErr)
+			return
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			root = start
+			break
+		}
+	}
+
+	switch root.Name {
+	case reportMultiget:
+		err = abs.cardDAVMultiget(w, req, body, wErr)
+	case reportQuery:
+		err = abs.cardDAVQuery(w, req, body, wErr)
+	case reportSync:
+		err = abs.cardDAVSync(w, req, body, wErr)
+	default:
+		err = &davError{status: http.StatusForbidden, condition: "<d:supported-report/>",
+			msg: "unsupported report " + root.Name.Local}
+	}
+	if err != nil {
+		writeDAVError(w, err, wErr)
+	}
+}
+
+// cardReportProps - свойства, запрошенные в отчете: prop, allprop или propname.
+type cardReportProps struct {
+	AllProp  *struct{}       `xml:"DAV: allprop"`
+	PropName *struct{}       `xml:"DAV: propname"`
+	Prop     *davPropRequest `xml:"DAV: prop"`
+}
+
+// request возвращает запрошенные свойства (без перечня - allprop).
+func (p cardReportProps) request() davPropRequest {
+	switch {
+	case p.Prop != nil:
+		return *p.Prop
+	case p.PropName != nil:
+		return davPropRequest{propName: true}
+	}
+	return davPropRequest{allProp: true}
+}
+
+// cardDAVMultiget отправляет свойства карточек, перечисленных в отчете addressbook-multiget.
+func (abs *AddressBookService) cardDAVMultiget(w http.ResponseWriter, req *http.Request, body []byte, wErr *pkg.WrappedError) error {
+	var report struct {
+		cardReportProps
+		Hrefs []string `xml:"DAV: href"`
+	}
+	if err := xml.Unmarshal(body, &report); err != nil {
+		return badDAVRequest(err)
+	}
+	pr := report.request()
+
+	names, err := abs.cardDAVNames(req.Context())
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, len(report.Hrefs))
+	var found []int64
+	for i, href := range report.Hrefs {
+		u, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			continue
+		}
+		if name, ok := strings.CutPrefix(u.Path, cardDAVAddressBookPath); ok {
+			ids[i], _ = names.id(name)
+		}
+		if ids[i] != 0 {
+			found = append(found, ids[i])
+		}
+	}
+
+	records, err := abs.cardDAVRecords(req.Context(), found)
+	if err != nil {
+		return err
+	}
+
+	ms := newDAVMultistatus(w)
+	for i, href := range report.Hrefs {
+		r, ok := records[ids[i]]
+		if !ok {
+			ms.status(strings.TrimSpace(href), http.StatusNotFound)
+			continue
+		}
+		ms.propstat(strings.TrimSpace(href), cardProps(r, names.name(r.ID), pr), pr)
+	}
+	ms.send(wErr)
+	return nil
+}
+
+// cardFilter - условие отчета addressbook-query (RFC 6352, 10.5).
+type cardFilter struct {
+	Test  string           `xml:"test,attr"`
+	Props []cardPropFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+}
+
+// cardPropFilter - условие на свойство карточки.
+type cardPropFilter struct {
+	Name         string            `xml:"name,attr"`
+	Test         string            `xml:"test,attr"`
+	IsNotDefined *struct{}         `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatches  []cardTextMatch   `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+	Params       []cardParamFilter `xml:"urn:ietf:params:xml:ns:carddav param-filter"`
+}
+
+// cardParamFilter - условие на параметр свойства карточки.
+type cardParamFilter struct {
+	Name         string         `xml:"name,attr"`
+	IsNotDefined *struct{}      `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatch    *cardTextMatch `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+}
+
+// cardTextMatch - сравнение значения свойства или параметра с текстом.
+type cardTextMatch struct {
+	Collation string `xml:"collation,attr"`
+	Negate    string `xml:"negate-condition,attr"`
+	MatchType string `xml:"match-type,attr"`
+	Text      string `xml:",chardata"`
+}
+
+// validate проверяет сравнения и типы условий фильтра.
+func (f cardFilter) validate() error {
+	tests := []string{f.Test}
+	var matches []cardTextMatch
+	for _, p := range f.Props {
+		tests = append(tests, p.Test)
+		matches = append(matches, p.TextMatches...)
+		for _, param := range p.Params {
+			if param.TextMatch != nil {
+				matches = append(matches, *param.TextMatch)
+			}
+		}
+	}
+	for _, test := range tests {
+		if test != "" && test != "anyof" && test != "allof" {
+			return &davError{status: http.StatusBadRequest, msg: "test must be anyof or allof"}
+		}
+	}
+	for _, m := range matches {
+		switch m.Collation {
+		case "", "i;unicode-casemap", "i;ascii-casemap", "i;octet":
+		default:
+			return &davError{status: http.StatusForbidden, condition: "<card:supported-collation/>",
+				msg: "unsupported collation " + m.Collation}
+		}
+		switch m.MatchType {
+		case "", "equals", "contains", "starts-with", "ends-with":
+		default:
+			return &davError{status: http.StatusBadRequest, msg: "unsupported match-type " + m.MatchType}
+		}
+	}
+	return nil
+}
+
+// match сообщает, что карточка card удовлетворяет фильтру. Фильтр без условий принимает все карточки.
+func (f cardFilter) match(card pkg.VCard) bool {
+	results := make([]bool, len(f.Props))
+	for i, p := range f.Props {
+		results[i] = p.match(card)
+	}
+	return combineTest(f.Test, results)
+}
+
+// match сообщает, что карточка card удовлетворяет условию на свойство.
+func (p cardPropFilter) match(card pkg.VCard) bool {
+	props := card.All(strings.ToUpper(p.Name))
+	if p.IsNotDefined != nil {
+		return len(props) == 0
+	}
+	for _, prop := range props {
+		var results []bool
+		for _, m := range p.TextMatches {
+			results = append(results, m.match(prop.Text()))
+		}
+		for _, param := range p.Params {
+			results = append(results, param.match(prop))
+		}
+		if len(results) == 0 || combineTest(p.Test, results) {
+			return true
+		}
+	}
+	return false
+}
+
+// match сообщает, что свойство prop удовлетворяет условию на параметр.
+func (p cardParamFilter) match(prop pkg.VCardProp) bool {
+	values, defined := prop.Params[strings.ToUpper(p.Name)]
+	switch {
+	case p.IsNotDefined != nil:
+		return !defined
+	case p.TextMatch == nil:
+		return defined
+	}
+	for _, v := range values {
+		if p.TextMatch.match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// match сравнивает значение value с текстом условия.
+func (m cardTextMatch) match(value string) bool {
+	text := m.Text
+	switch m.Collation {
+	case "i;octet":
+	case "i;ascii-casemap":
+		value, text = asciiLower(value), asciiLower(text)
+	default:
+		value, text = strings.ToLower(value), strings.ToLower(text)
+	}
+
+	var ok bool
+	switch m.MatchType {
+	case "equals":
+		ok = value == text
+	case "starts-with":
+		ok = strings.HasPrefix(value, text)
+	case "ends-with":
+		ok = strings.HasSuffix(value, text)
+	default:
+		ok = strings.Contains(value, text)
+	}
+	return ok != (m.Negate == "yes")
+}
+
+// asciiLower приводит к нижнему регистру только латинские буквы.
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// combineTest объединяет результаты условий: allof - все выполнены, anyof (по умолчанию) - хотя бы одно.
+// Пустой список условий выполнен.
+func combineTest(test string, results []bool) bool {
+	if len(results) == 0 {
+		return true
+	}
+	for _, ok := range results {
+		if ok != (test == "allof") {
+			return ok
+		}
+	}
+	return test == "allof"
+}
+
+// cardDAVQuery отправляет свойства карточек, удовлетворяющих условию отчета addressbook-query.
+func (abs *AddressBookService) cardDAVQuery(w http.ResponseWriter, req *http.Request, body []byte, wErr *pkg.WrappedError) error {
+	var report struct {
+		cardReportProps
+		Filter cardFilter `xml:"urn:ietf:params:xml:ns:carddav filter"`
+		Limit  *struct {
+			NResults int `xml:"urn:ietf:params:xml:ns:carddav nresults"`
+		} `xml:"urn:ietf:params:xml:ns:carddav limit"`
+	}
+	if err := xml.Unmarshal(body, &report); err != nil {
+		return badDAVRequest(err)
+	}
+	if err := report.Filter.validate(); err != nil {
+		return err
+	}
+	pr := report.request()
+	limit := 0
+	if report.Limit != nil {
+		if report.Limit.NResults <= 0 {
+			return &davError{status: http.StatusBadRequest, msg: "nresults must be positive"}
+		}
+		limit = report.Limit.NResults
+	}
+
+	names, err := abs.cardDAVNames(req.Context())
+	if err != nil {
+		return err
+	}
+	ms := newDAVMultistatus(w)
+	count, truncated := 0, false
+	err = abs.db.ExportRecords(req.Context(), dto.Query{}, func(r dto.Record) error {
+		name := names.name(r.ID)
+		if truncated || !report.Filter.match(cardDAVCard(r, name, vcardV3)) {
+			return nil
+		}
+		if limit > 0 && count == limit {
+			truncated = true
+			return nil
+		}
+		count++
+		ms.propstat(names.href(r.ID), cardProps(r, name, pr), pr)
+		return ms.err
+	})
+	if err != nil {
+		return ms.fail(err, wErr)
+	}
+	if truncated {
+		ms.status(cardDAVAddressBookPath, http.StatusInsufficientStorage)
+	}
+	ms.send(wErr)
+	return nil
+}
+
+// cardDAVSync отправляет изменения карточек после sync-token из отчета sync-collection. Измененные карточки
+// определяются по истории изменений с отметкой синхронизации не меньше токена (см. Storage.SyncToken), поэтому
+// изменения, зафиксированные после выдачи токена, не теряются, а уже полученные клиентом могут прийти повторно.
+// Записи, которых больше нет (удалены в корзину или окончательно), - 404.
+func (abs *AddressBookService) cardDAVSync(w http.ResponseWriter, req *http.Request, body []byte, wErr *pkg.WrappedError) error {
+	var report struct {
+		cardReportProps
+		SyncToken string `xml:"DAV: sync-token"`
+		SyncLevel string `xml:"DAV: sync-level"`
+	}
+	if err := xml.Unmarshal(body, &report); err != nil {
+		return badDAVRequest(err)
+	}
+	pr := report.request()
+	ctx := req.Context()
+
+	// Токен читается до записей: изменения, сделанные во время ответа, войдут в следующую синхронизацию
+	token, err := abs.cardDAVSyncToken(ctx)
+	if err != nil {
+		return err
+	}
+	since := int64(-1)
+	if s := strings.TrimSpace(report.SyncToken); s != "" {
+		digits, ok := strings.CutPrefix(s, cardDAVSyncTokenPrefix)
+		since, err = strconv.ParseInt(digits, 10, 64)
+		if !ok || err != nil || since < 0 || since > token {
+			return &davError{status: http.StatusForbidden, condition: "<d:valid-sync-token/>", msg: "invalid sync token"}
+		}
+	}
+
+	names, err := abs.cardDAVNames(ctx)
+	if err != nil {
+		return err
+	}
+	ms := newDAVMultistatus(w)
+
+	if since < 0 {
+		err = abs.db.ExportRecords(ctx, dto.Query{}, func(r dto.Record) error {
+			ms.propstat(names.href(r.ID), cardProps(r, names.name(r.ID), pr), pr)
+			return ms.err
+		})
+		if err != nil {
+			return ms.fail(err, wErr)
+		}
+		ms.syncToken(formatSyncToken(token))
+		ms.send(wErr)
+		return nil
+	}
+
+	// История читается страницами: в памяти остаются только идентификаторы измененных записей
+	var changed []int64
+	seen := map[int64]bool{}
+	q := dto.HistoryQuery{SyncFrom: since, Limit: dto.MaxLimit}
+	for {
+		history, err := abs.db.History(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, e := range history.Entries {
+			if !seen[e.RecordID] {
+				seen[e.RecordID] = true
+				changed = append(changed, e.RecordID)
+			}
+		}
+		if history.NextCursor == "" {
+			break
+		}
+		q.Cursor = history.NextCursor
+	}
+
+	// Записи загружаются и отправляются частями по dto.MaxLimit
+	for len(changed) > 0 && ms.err == nil {
+		ids := changed[:min(len(changed), dto.MaxLimit)]
+		changed = changed[len(ids):]
+
+		records, err := abs.cardDAVRecords(ctx, ids)
+		if err != nil {
+			return ms.fail(err, wErr)
+		}
+		for _, id := range ids {
+			if r, ok := records[id]; ok {
+				ms.propstat(names.href(id), cardProps(r, names.name(id), pr), pr)
+			} else {
+				ms.status(names.href(id), http.StatusNotFound)
+			}
+		}
+	}
+	ms.syncToken(formatSyncToken(token))
+	ms.send(wErr)
+	return nil
+}
+
+// cardDAVRecords загружает записи ids (кроме записей в корзине) по идентификаторам частями по dto.MaxLimit,
+// чтобы размер каждого запроса к хранилищу был ограничен при любом числе карточек в отчете.
+func (abs *AddressBookService) cardDAVRecords(ctx context.Context, ids []int64) (map[int64]dto.Record, error) {
+	records := map[int64]dto.Record{}
+	for len(ids) > 0 {
+		n := min(len(ids), dto.MaxLimit)
+		values := make([]any, n)
+		for i, id := range ids[:n] {
+			values[i] = id
+		}
+		ids = ids[n:]
+
+		page, err := abs.db.GetRecords(ctx, dto.Query{Filter: &dto.Filter{Field: "id", Op: dto.OpIn, Values: values}, Limit: n})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page.Records {
+			records[r.ID] = r
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,343 @@
+package addressBookService
+
+import (
+	"addressBookServer/pkg"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Пространства имен XML WebDAV, CardDAV и расширений Apple Calendar Server (getctag)
+const (
+	nsDAV     = "DAV:"
+	nsCardDAV = "urn:ietf:params:xml:ns:carddav"
+	nsCS      = "http://calendarserver.org/ns/"
+)
+
+// davPrefixes - префиксы пространств имен в ответах multistatus.
+var davPrefixes = map[string]string{nsDAV: "d", nsCardDAV: "card", nsCS: "cs"}
+
+// cardDAVMaxRequestSize - наибольший размер тела запросов PROPFIND и REPORT.
+const cardDAVMaxRequestSize = 1 << 20
+
+// Свойства WebDAV и CardDAV, которые поддерживает сервер
+var (
+	propResourceType           = xml.Name{Space: nsDAV, Local: "resourcetype"}
+	propDisplayName            = xml.Name{Space: nsDAV, Local: "displayname"}
+	propCurrentUserPrincipal   = xml.Name{Space: nsDAV, Local: "current-user-principal"}
+	propPrincipalURL           = xml.Name{Space: nsDAV, Local: "principal-URL"}
+	propOwner                  = xml.Name{Space: nsDAV, Local: "owner"}
+	propCurrentUserPrivileges  = xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}
+	propSupportedReportSet     = xml.Name{Space: nsDAV, Local: "supported-report-set"}
+	propSyncToken              = xml.Name{Space: nsDAV, Local: "sync-token"}
+	propGetETag                = xml.Name{Space: nsDAV, Local: "getetag"}
+	propGetContentType         = xml.Name{Space: nsDAV, Local: "getcontenttype"}
+	propGetContentLength       = xml.Name{Space: nsDAV, Local: "getcontentlength"}
+	propGetLastModified        = xml.Name{Space: nsDAV, Local: "getlastmodified"}
+	propAddressBookHomeSet     = xml.Name{Space: nsCardDAV, Local: "addressbook-home-set"}
+	propAddressBookDescription = xml.Name{Space: nsCardDAV, Local: "addressbook-description"}
+	propSupportedAddressData   = xml.Name{Space: nsCardDAV, Local: "supported-address-data"}
+	propMaxResourceSize        = xml.Name{Space: nsCardDAV, Local: "max-resource-size"}
+	propAddressData            = xml.Name{Space: nsCardDAV, Local: "address-data"}
+	propGetCTag                = xml.Name{Space: nsCS, Local: "getctag"}
+)
+
+// davProps - свойства ресурса: внутренний XML значения по имени свойства.
+type davProps map[xml.Name]string
+
+// davPropRequest - свойства, запрошенные в PROPFIND или REPORT: все (allprop), только имена (propname)
+// или перечисленные в DAV:prop.
+type davPropRequest struct {
+	allProp     bool
+	propName    bool
+	names       []xml.Name
+	addressData davAddressData // Параметры card:address-data, если оно запрошено
+}
+
+// davAddressData - параметры card:address-data (RFC 6352, 10.4): версия vCard и свойства карточки
+// для частичной выдачи (пусто - все свойства).
+type davAddressData struct {
+	version string
+	props   []string
+}
+
+// wants сообщает, что свойство name запрошено явно (allprop не включает дорогие свойства, например, address-data).
+func (pr davPropRequest) wants(name xml.Name) bool {
+	for _, n := range pr.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalXML разбирает элемент DAV:prop: имена свойств и параметры card:address-data.
+func (pr *davPropRequest) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	pr.addressData.version = vcardV3
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			pr.names = append(pr.names, t.Name)
+			if t.Name != propAddressData {
+				if err = d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			var spec struct {
+				ContentType string `xml:"content-type,attr"`
+				Version     string `xml:"version,attr"`
+				Props       []struct {
+					Name string `xml:"name,attr"`
+				} `xml:"urn:ietf:params:xml:ns:carddav prop"`
+			}
+			if err = d.DecodeElement(&spec, &t); err != nil {
+				return err
+			}
+			if spec.ContentType != "" && !strings.EqualFold(spec.ContentType, "text/vcard") {
+				return errUnsupportedAddressData
+			}
+			if pr.addressData.version, err = parseVCardVersion(spec.Version); err != nil {
+				return errUnsupportedAddressData
+			}
+			for _, p := range spec.Props {
+				pr.addressData.props = append(pr.addressData.props, strings.ToUpper(p.Name))
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// davPropfind - тело запроса PROPFIND (RFC 4918, 14.20).
+type davPropfind struct {
+	XMLName  xml.Name        `xml:"DAV: propfind"`
+	AllProp  *struct{}       `xml:"DAV: allprop"`
+	PropName *struct{}       `xml:"DAV: propname"`
+	Prop     *davPropRequest `xml:"DAV: prop"`
+}
+
+// parsePropfind читает тело запроса PROPFIND. Пустое тело означает allprop.
+func parsePropfind(req *http.Request) (davPropRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, cardDAVMaxRequestSize))
+	if err != nil {
+		return davPropRequest{}, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return davPropRequest{allProp: true}, nil
+	}
+
+	var propfind davPropfind
+	if err = xml.Unmarshal(body, &propfind); err != nil {
+		return davPropRequest{}, badDAVRequest(err)
+	}
+	switch {
+	case propfind.Prop != nil:
+		return *propfind.Prop, nil
+	case propfind.PropName != nil:
+		return davPropRequest{propName: true}, nil
+	}
+	return davPropRequest{allProp: true}, nil
+}
+
+// davError - ошибка запроса WebDAV с кодом ответа status. Если задано нарушенное предусловие condition
+// (внутренний XML, например "<card:valid-address-data/>"), ответ содержит элемент DAV:error (RFC 4918, 16).
+type davError struct {
+	status    int
+	condition string
+	msg       string
+}
+
+func (e *davError) Error() string {
+	return e.msg
+}
+
+// errUnsupportedAddressData - запрошен формат карточек, отличный от text/vcard версий 3.0 и 4.0.
+var errUnsupportedAddressData = &davError{status: http.StatusForbidden, condition: "<card:supported-address-data/>",
+	msg: "address data must be text/vcard version 3.0 or 4.0"}
+
+// badDAVRequest возвращает ошибку разбора тела запроса WebDAV.
+func badDAVRequest(err error) error {
+	var dErr *davError
+	if errors.As(err, &dErr) {
+		return err
+	}
+	return &davError{status: http.StatusBadRequest, msg: "invalid XML body: " + err.Error()}
+}
+
+// writeDAVError отправляет клиенту ошибку запроса WebDAV. Ошибки хранилища переводятся в коды, как в REST API v2
+// (см. statusForError); текст внутренних ошибок клиенту не раскрывается.
+func writeDAVError(w http.ResponseWriter, err error, wErr *pkg.WrappedError) {
+	var dErr *davError
+	if !errors.As(err, &dErr) {
+		dErr = &davError{status: statusForError(err), msg: err.Error()}
+	}
+	if dErr.status == http.StatusInternalServerError {
+		wErr.Specify(err, "storage").LogError()
+		http.Error(w, "internal server error", dErr.status)
+		return
+	}
+	wErr.LogMsg(fmt.Sprintf("%d: %s", dErr.status, dErr.msg))
+
+	if dErr.condition == "" {
+		http.Error(w, dErr.msg, dErr.status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(dErr.status)
+	_, _ = fmt.Fprintf(w, `%s<d:error xmlns:d="%s" xmlns:card="%s">%s</d:error>`, xml.Header, nsDAV, nsCardDAV, dErr.condition)
+}
+
+// davFlushSize - объем накопленного ответа 207, после которого он отправляется клиенту: ответ со всей
+// адресной книгой не собирается в памяти целиком.
+const davFlushSize = 64 << 10
+
+// davMultistatus собирает ответ 207 Multi-Status (RFC 4918, 13) и отправляет его клиенту частями по davFlushSize.
+// Заголовки ответа отправляются с первой частью, поэтому до нее об ошибке можно сообщить обычным ответом (см. fail).
+type davMultistatus struct {
+	w       http.ResponseWriter
+	buf     bytes.Buffer
+	started bool  // Заголовки и часть ответа уже отправлены
+	err     error // Ошибка отправки ответа (например, клиент разорвал соединение)
+}
+
+func newDAVMultistatus(w http.ResponseWriter) *davMultistatus {
+	m := &davMultistatus{w: w}
+	m.buf.WriteString(xml.Header + `<d:multistatus`)
+	for _, ns := range []string{nsDAV, nsCardDAV, nsCS} {
+		fmt.Fprintf(&m.buf, ` xmlns:%s="%s"`, davPrefixes[ns], ns)
+	}
+	m.buf.WriteString(">\n")
+	return m
+}
+
+// propstat добавляет ответ для ресурса href со свойствами props по запросу pr: найденные свойства -
+// в propstat с кодом 200, запрошенные, но отсутствующие у ресурса, - с кодом 404.
+func (m *davMultistatus) propstat(href string, props davProps, pr davPropRequest) {
+	var found, missing []xml.Name
+	switch {
+	case pr.allProp, pr.propName:
+		for name := range props {
+			found = append(found, name)
+		}
+		sortDAVNames(found)
+	default:
+		for _, name := range pr.names {
+			if _, ok := props[name]; ok {
+				found = append(found, name)
+			} else {
+				missing = append(missing, name)
+			}
+		}
+	}
+
+	m.buf.WriteString("<d:response><d:href>" + davEscape(href) + "</d:href>")
+	for _, group := range []struct {
+		names  []xml.Name
+		status int
+	}{{found, http.StatusOK}, {missing, http.StatusNotFound}} {
+		if len(group.names) == 0 {
+			continue
+		}
+		m.buf.WriteString("<d:propstat><d:prop>")
+		for _, name := range group.names {
+			open, end := davElement(name)
+			value := props[name]
+			if pr.propName {
+				value = ""
+			}
+			m.buf.WriteString(open + value + end)
+		}
+		fmt.Fprintf(&m.buf, "</d:prop><d:status>HTTP/1.1 %d %s</d:status></d:propstat>", group.status, http.StatusText(group.status))
+	}
+	m.buf.WriteString("</d:response>\n")
+	if m.buf.Len() >= davFlushSize {
+		m.flush()
+	}
+}
+
+// status добавляет ответ для ресурса href без свойств: например, 404 для удаленной карточки.
+func (m *davMultistatus) status(href string, status int) {
+	fmt.Fprintf(&m.buf, "<d:response><d:href>%s</d:href><d:status>HTTP/1.1 %d %s</d:status></d:response>\n",
+		davEscape(href), status, http.StatusText(status))
+	if m.buf.Len() >= davFlushSize {
+		m.flush()
+	}
+}
+
+// syncToken добавляет новый sync-token в ответ на sync-collection.
+func (m *davMultistatus) syncToken(token string) {
+	m.buf.WriteString("<d:sync-token>" + davEscape(token) + "</d:sync-token>\n")
+}
+
+// flush отправляет клиенту накопленную часть ответа (с первой частью - заголовки). После ошибки отправки
+// ответ больше не отправляется, а ошибка сохраняется в m.err.
+func (m *davMultistatus) flush() {
+	if !m.started {
+		m.started = true
+		m.w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		m.w.WriteHeader(http.StatusMultiStatus)
+	}
+	if m.err == nil {
+		_, m.err = m.w.Write(m.buf.Bytes())
+	}
+	m.buf.Reset()
+}
+
+// send завершает и отправляет ответ клиенту.
+func (m *davMultistatus) send(wErr *pkg.WrappedError) {
+	m.buf.WriteString("</d:multistatus>\n")
+	m.flush()
+	if m.err != nil {
+		wErr.Specify(m.err, "w.Write()").LogError()
+	}
+}
+
+// fail возвращает ошибку err, возникшую при составлении ответа, если клиенту еще ничего не отправлено,
+// чтобы о ней сообщил writeDAVError. Если часть ответа уже отправлена, ошибка журналируется, а соединение
+// разрывается: клиент не должен принять неполный ответ за всю адресную книгу.
+func (m *davMultistatus) fail(err error, wErr *pkg.WrappedError) error {
+	if !m.started {
+		return err
+	}
+	wErr.Specify(err, "davMultistatus").LogError()
+	panic(http.ErrAbortHandler)
+}
+
+// davElement возвращает открывающий и закрывающий теги свойства name. Для неизвестных пространств имен
+// пространство объявляется в самом элементе.
+func davElement(name xml.Name) (open, end string) {
+	if prefix, ok := davPrefixes[name.Space]; ok {
+		return "<" + prefix + ":" + name.Local + ">", "</" + prefix + ":" + name.Local + ">"
+	}
+	return `<x:` + name.Local + ` xmlns:x="` + davEscape(name.Space) + `">`, "</x:" + name.Local + ">"
+}
+
+// sortDAVNames упорядочивает имена свойств по пространству имен и имени.
+func sortDAVNames(names []xml.Name) {
+	for i := 1; i < len(names); i++ {
+		for j := i; j > 0 && (names[j].Space+" "+names[j].Local) < (names[j-1].Space+" "+names[j-1].Local); j-- {
+			names[j], names[j-1] = names[j-1], names[j]
+		}
+	}
+}
+
+// davEscape экранирует текст для XML.
+func davEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// davHref возвращает значение свойства со ссылкой на ресурс path.
+func davHref(path string) string {
+	return "<d:href>" + davEscape(path) + "</d:href>"
+}
@@ -0,0 +1,239 @@
+package addressBookService
+
+import (
+	"addressBookServer/gates/memory"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// davResponse - ответ для одного ресурса в 207 Multi-Status.
+type davResponse struct {
+	Href     string `xml:"DAV: href"`
+	Status   string `xml:"DAV: status"`
+	Propstat []struct {
+		Prop struct {
+			ETag        string `xml:"DAV: getetag"`
+			CTag        string `xml:"http://calendarserver.org/ns/ getctag"`
+			SyncToken   string `xml:"DAV: sync-token"`
+			AddressData string `xml:"urn:ietf:params:xml:ns:carddav address-data"`
+		} `xml:"DAV: prop"`
+		Status string `xml:"DAV: status"`
+	} `xml:"DAV: propstat"`
+}
+
+// davMultistatusResponse - разобранный ответ 207 Multi-Status.
+type davMultistatusResponse struct {
+	Responses []davResponse `xml:"DAV: response"`
+	SyncToken string        `xml:"DAV: sync-token"`
+}
+
+// find возвращает ответ для ресурса href.
+func (m davMultistatusResponse) find(t *testing.T, href string) davResponse {
+	t.Helper()
+	for _, r := range m.Responses {
+		if r.Href == href {
+			return r
+		}
+	}
+	t.Fatalf("no response for %s", href)
+	return davResponse{}
+}
+
+// doDAV отправляет обработчику h запрос CardDAV с заголовками headers (имя, значение, ...) и телом body.
+func doDAV(t *testing.T, h http.Handler, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// wantMultistatus проверяет, что ответ - 207, и разбирает его.
+func wantMultistatus(t *testing.T, rec *httptest.ResponseRecorder) davMultistatusResponse {
+	t.Helper()
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status %d (%s), want 207", rec.Code, rec.Body.String())
+	}
+	var ms davMultistatusResponse
+	if err := xml.Unmarshal(rec.Body.Bytes(), &ms); err != nil {
+		t.Fatalf("multistatus %s: %v", rec.Body.String(), err)
+	}
+	return ms
+}
+
+// syncCollection отправляет отчет sync-collection с токеном token.
+func syncCollection(t *testing.T, h http.Handler, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := fmt.Sprintf(`<d:sync-collection xmlns:d="DAV:"><d:sync-token>%s</d:sync-token><d:sync-level>1</d:sync-level>`+
+		`<d:prop><d:getetag/></d:prop></d:sync-collection>`, token)
+	return doDAV(t, h, "REPORT", cardDAVAddressBookPath, body)
+}
+
+func TestCardDAVPropfindAndReports(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			rec := createV2(t, h, testPhone(21))
+			href := fmt.Sprintf("%s%s%d%s", cardDAVAddressBookPath, vcardUIDPrefix, rec.ID, cardDAVExtension)
+
+			// PROPFIND адресной книги с ее карточками
+			propfind := `<d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/">` +
+				`<d:prop><d:getetag/><cs:getctag/><d:sync-token/></d:prop></d:propfind>`
+			ms := wantMultistatus(t, doDAV(t, h, "PROPFIND", cardDAVAddressBookPath, propfind, "Depth", "1"))
+			book := ms.find(t, cardDAVAddressBookPath).Propstat[0].Prop
+			if book.CTag == "" || book.CTag != book.SyncToken {
+				t.Fatalf("getctag %q, sync-token %q", book.CTag, book.SyncToken)
+			}
+			if card := ms.find(t, href); card.Propstat[0].Prop.ETag != `"1"` {
+				t.Fatalf("card %s: %+v", href, card)
+			}
+
+			// Depth: 0 - только сама адресная книга
+			ms = wantMultistatus(t, doDAV(t, h, "PROPFIND", cardDAVAddressBookPath, propfind, "Depth", "0"))
+			if len(ms.Responses) != 1 {
+				t.Fatalf("PROPFIND Depth 0 returned %d responses", len(ms.Responses))
+			}
+
+			// addressbook-multiget: известная карточка с vCard и 404 для неизвестной
+			missing := cardDAVAddressBookPath + "missing.vcf"
+			multiget := fmt.Sprintf(`<card:addressbook-multiget xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">`+
+				`<d:prop><d:getetag/><card:address-data/></d:prop><d:href>%s</d:href><d:href>%s</d:href></card:addressbook-multiget>`, href, missing)
+			ms = wantMultistatus(t, doDAV(t, h, "REPORT", cardDAVAddressBookPath, multiget))
+			card := ms.find(t, href).Propstat[0].Prop
+			if card.ETag != `"1"` || !strings.Contains(card.AddressData, "N:Петров;Иван") || !strings.Contains(card.AddressData, rec.Phone[1:]) {
+				t.Fatalf("multiget card = %+v", card)
+			}
+			if status := ms.find(t, missing).Status; !strings.Contains(status, "404") {
+				t.Fatalf("multiget missing card status %q", status)
+			}
+
+			// sync-collection: сначала все карточки, затем только измененные и удаленные
+			ms = wantMultistatus(t, syncCollection(t, h, ""))
+			ms.find(t, href)
+			if ms.SyncToken != book.SyncToken {
+				t.Fatalf("sync-token %q, want %q", ms.SyncToken, book.SyncToken)
+			}
+			token := ms.SyncToken
+			ms = wantMultistatus(t, syncCollection(t, h, token))
+			for _, r := range ms.Responses {
+				if r.Href == href {
+					t.Fatalf("unchanged card %s returned by sync", href)
+				}
+			}
+
+			path := fmt.Sprintf("%s/%d", recordsV2Path, rec.ID)
+			wantV2(t, doV2(t, h, http.MethodPatch, path, `"1"`, `{"notes": "x"}`), http.StatusOK, `"2"`)
+			ms = wantMultistatus(t, syncCollection(t, h, token))
+			if etag := ms.find(t, href).Propstat[0].Prop.ETag; etag != `"2"` {
+				t.Fatalf("sync changed card ETag %q", etag)
+			}
+			if ms.SyncToken == token {
+				t.Fatalf("sync-token %q did not change", token)
+			}
+
+			token = ms.SyncToken
+			wantV2(t, doV2(t, h, http.MethodDelete, path, `"2"`, ""), http.StatusNoContent, "")
+			ms = wantMultistatus(t, syncCollection(t, h, token))
+			if status := ms.find(t, href).Status; !strings.Contains(status, "404") {
+				t.Fatalf("sync deleted card status %q", status)
+			}
+		})
+	}
+}
+
+func TestCardDAVInvalidSyncToken(t *testing.T) {
+	h := NewAddressBookService("", memory.NewMemory()).server.Handler
+	ms := wantMultistatus(t, syncCollection(t, h, ""))
+
+	for _, token := range []string{
+		"urn:x-abs:sync:1", // Токен до миграции 0016
+		"garbage",
+		cardDAVSyncTokenPrefix + "-1",
+		cardDAVSyncTokenPrefix + "1000000", // Больше текущего
+	} {
+		resp := syncCollection(t, h, token)
+		if resp.Code != http.StatusForbidden || !strings.Contains(resp.Body.String(), "valid-sync-token") {
+			t.Errorf("sync-token %q: status %d (%s), want 403 valid-sync-token", token, resp.Code, resp.Body.String())
+		}
+	}
+	if resp := syncCollection(t, h, ms.SyncToken); resp.Code != http.StatusMultiStatus {
+		t.Errorf("current sync-token: status %d", resp.Code)
+	}
+}
+
+func TestCardDAVPutDelete(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			phone := testPhone(22)
+			href := fmt.Sprintf("%sphone-%s%s", cardDAVAddressBookPath, phone, cardDAVExtension)
+			card := func(note string) string {
+				return "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Петров;Иван;;;\r\nFN:Иван Петров\r\nTEL;TYPE=CELL:" + phone +
+					"\r\nADR;TYPE=HOME:;;ул. Ленина, д. 5;Москва;;;Россия\r\nNOTE:" + note + "\r\nEND:VCARD\r\n"
+			}
+			want := func(rec *httptest.ResponseRecorder, status int) {
+				t.Helper()
+				if rec.Code != status {
+					t.Fatalf("status %d (%s), want %d", rec.Code, rec.Body.String(), status)
+				}
+			}
+
+			// Создание: If-Match к несуществующей карточке - 412, повторное создание с If-None-Match: * - 412
+			want(doDAV(t, h, http.MethodPut, href, card("1"), "If-Match", `"1"`), http.StatusPreconditionFailed)
+			want(doDAV(t, h, http.MethodPut, href, card("1"), "If-None-Match", "*"), http.StatusCreated)
+			want(doDAV(t, h, http.MethodPut, href, card("1"), "If-None-Match", "*"), http.StatusPreconditionFailed)
+			want(doDAV(t, h, http.MethodPut, href, "BEGIN:VCARD\r\nEND:VCARD\r\n"), http.StatusForbidden)
+
+			resp := doDAV(t, h, http.MethodGet, href, "")
+			want(resp, http.StatusOK)
+			if resp.Header().Get("ETag") != `"1"` || !strings.Contains(resp.Body.String(), "NOTE:1") {
+				t.Fatalf("GET %s: ETag %s, %s", href, resp.Header().Get("ETag"), resp.Body.String())
+			}
+
+			// Замена: устаревший ETag - 412, текущий - 204 и новая версия
+			want(doDAV(t, h, http.MethodPut, href, card("2"), "If-Match", `"5"`), http.StatusPreconditionFailed)
+			want(doDAV(t, h, http.MethodPut, href, card("2"), "If-Match", `"1"`), http.StatusNoContent)
+			records := getByPhone(t, h, phone)
+			if len(records) != 1 || records[0].Notes != "2" || records[0].Version != 2 {
+				t.Fatalf("/get after PUT = %+v", records)
+			}
+
+			// Удаление: устаревший ETag - 412, текущий - 204, затем карточки нет
+			want(doDAV(t, h, http.MethodDelete, href, "", "If-Match", `"1"`), http.StatusPreconditionFailed)
+			want(doDAV(t, h, http.MethodDelete, href, "", "If-Match", `"2"`), http.StatusNoContent)
+			want(doDAV(t, h, http.MethodGet, href, ""), http.StatusNotFound)
+			want(doDAV(t, h, http.MethodDelete, href, ""), http.StatusNotFound)
+			if got := getByPhone(t, h, phone); len(got) != 0 {
+				t.Fatalf("/get after DELETE returned %d records", len(got))
+			}
+		})
+	}
+}
+
+func TestCardDAVLargeMultistatus(t *testing.T) {
+	h := NewAddressBookService("", memory.NewMemory()).server.Handler
+
+	// Ответ больше davFlushSize отправляется частями и остается цельным документом
+	const n = 300
+	notes := strings.Repeat("Примечание ", 30)
+	for i := 0; i < n; i++ {
+		wantV1(t, postV1(t, h, "/create", fmt.Sprintf(`{"name": "Иван", "last_name": "Петров", "address": "Москва", "phone": "8900%07d", "notes": %q}`, i, notes)), "")
+	}
+	body := `<d:sync-collection xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav"><d:sync-token/>` +
+		`<d:sync-level>1</d:sync-level><d:prop><d:getetag/><card:address-data/></d:prop></d:sync-collection>`
+	resp := doDAV(t, h, "REPORT", cardDAVAddressBookPath, body)
+	if resp.Body.Len() < 2*davFlushSize {
+		t.Fatalf("response size %d is too small for the test", resp.Body.Len())
+	}
+	ms := wantMultistatus(t, resp)
+	if len(ms.Responses) != n || ms.SyncToken == "" {
+		t.Fatalf("sync returned %d responses, sync-token %q", len(ms.Responses), ms.SyncToken)
+	}
+}
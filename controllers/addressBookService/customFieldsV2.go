@@ -0,0 +1,171 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// customFieldsV2Path - путь к объявлениям дополнительных полей записей.
+// Набор полей (номер клиента, отдел, номер пропуска и т.п.) задается администратором развертывания,
+// значения полей передаются в записях в объекте custom и проверяются по объявлениям.
+// Коды ответов те же, что у /v2/records; 409 - поле с таким именем уже объявлено.
+const customFieldsV2Path = "/v2/custom-fields"
+
+// customFieldsV2Handler обрабатывает запросы к списку объявлений дополнительных полей
+/*
+GET /v2/custom-fields - возвращает 200 и объявления полей в порядке имен:
+  [{"key": "department", "type": "enum", "required": true, "values": ["ИТ", "Продажи"], "label": "Отдел"}]
+
+POST /v2/custom-fields - объявление нового поля. Тип type - string, int, date (ГГГГ-ММ-ДД) или enum
+(тогда обязателен список допустимых значений values), имя key - латинские строчные буквы, цифры и "_":
+  {"key": "badge_id", "type": "int", "required": false, "label": "Номер пропуска"}
+Возвращает 201, заголовок Location: /v2/custom-fields/{key} и объявление.
+Обязательность нового поля проверяется для записей при их следующем создании или полной замене.
+*/
+func (abs *AddressBookService) customFieldsV2Handler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) customFieldsV2Handler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) customFieldsV2Handler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	switch req.Method {
+	case http.MethodGet:
+		defs, err := abs.db.CustomFields(req.Context())
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		if defs == nil {
+			defs = []dto.CustomFieldDef{}
+		}
+		writeJSONV2(w, http.StatusOK, defs, wErr)
+	case http.MethodPost:
+		def := dto.CustomFieldDef{}
+		err = decodeJSONV2(req, &def)
+		if err == nil {
+			err = def.Validate()
+		}
+		if err != nil {
+			writeErrorV2(w, http.StatusBadRequest, err, wErr)
+			return
+		}
+		err = abs.db.CreateCustomField(req.Context(), def)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		w.Header().Set("Location", customFieldsV2Path+"/"+def.Key)
+		writeJSONV2(w, http.StatusCreated, def, wErr)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// customFieldV2Handler обрабатывает запросы к объявлению дополнительного поля /v2/custom-fields/{key}
+/*
+GET /v2/custom-fields/{key} - возвращает 200 и объявление поля.
+
+PUT /v2/custom-fields/{key} - замена объявления (тело как при создании, key можно не указывать).
+Тип поля изменить нельзя: для этого поле нужно удалить и объявить заново. Возвращает 200 и объявление.
+
+DELETE /v2/custom-fields/{key} - удаление объявления и значений поля во всех записях. Возвращает 204 без тела.
+*/
+func (abs *AddressBookService) customFieldV2Handler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) customFieldV2Handler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) customFieldV2Handler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	key := strings.TrimPrefix(req.URL.Path, customFieldsV2Path+"/")
+
+	switch req.Method {
+	case http.MethodGet:
+		def, err := abs.customField(req.Context(), key)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		writeJSONV2(w, http.StatusOK, def, wErr)
+	case http.MethodPut:
+		def := dto.CustomFieldDef{}
+		err = decodeJSONV2(req, &def)
+		if err == nil && def.Key != "" && def.Key != key {
+			err = &dto.ValidationError{Msg: "key cannot be changed"}
+		}
+		def.Key = key
+		if err == nil {
+			err = def.Validate()
+		}
+		if err != nil {
+			writeErrorV2(w, http.StatusBadRequest, err, wErr)
+			return
+		}
+		err = abs.db.UpdateCustomField(req.Context(), def)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		writeJSONV2(w, http.StatusOK, def, wErr)
+	case http.MethodDelete:
+		err = abs.db.DeleteCustomField(req.Context(), key)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// customField возвращает объявление дополнительного поля key или dto.ErrCustomFieldNotFound.
+func (abs *AddressBookService) customField(ctx context.Context, key string) (dto.CustomFieldDef, error) {
+	defs, err := abs.db.CustomFields(ctx)
+	if err != nil {
+		return dto.CustomFieldDef{}, err
+	}
+	def, ok := dto.NewCustomFieldDefs(defs)[key]
+	if !ok {
+		return dto.CustomFieldDef{}, dto.ErrCustomFieldNotFound
+	}
+	return def, nil
+}
+
+// prepareCustom проверяет дополнительные поля новой (или полностью заменяемой) записи по объявлениям
+// из хранилища: все обязательные поля заполнены, значения соответствуют типам (см. dto.CustomFieldDefs.ValidateCustom).
+func (abs *AddressBookService) prepareCustom(ctx context.Context, rec *dto.Record) error {
+	defs, err := abs.db.CustomFields(ctx)
+	if err != nil {
+		return err
+	}
+	return dto.NewCustomFieldDefs(defs).ValidateCustom(rec.Custom, true)
+}
+
+// prepareCustomPatch проверяет изменение дополнительных полей в частичном обновлении, если оно есть.
+func (abs *AddressBookService) prepareCustomPatch(ctx context.Context, patch *dto.RecordPatch) error {
+	if !patch.Custom.Set {
+		return nil
+	}
+	defs, err := abs.db.CustomFields(ctx)
+	if err != nil {
+		return err
+	}
+	return dto.NewCustomFieldDefs(defs).ValidatePatch(&patch.Custom)
+}
@@ -0,0 +1,85 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// listV2 возвращает записи из GET /v2/records с параметрами params.
+func listV2(t *testing.T, h http.Handler, params url.Values) []dto.Record {
+	t.Helper()
+	resp := doV2(t, h, http.MethodGet, recordsV2Path+"?"+params.Encode(), "", "")
+	wantV2(t, resp, http.StatusOK, "")
+
+	var records []dto.Record
+	if err := json.Unmarshal(resp.Body.Bytes(), &records); err != nil {
+		t.Fatalf("GET %s?%s: %v", recordsV2Path, params.Encode(), err)
+	}
+	return records
+}
+
+func TestCustomFields(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			// Объявления общие для всей базы, поэтому имена полей уникальны для каждого запуска
+			floor, dept := "floor_"+testPhone(41), "dept_"+testPhone(42)
+
+			// Проверка объявлений
+			wantV2(t, doV2(t, h, http.MethodPost, customFieldsV2Path, "", `{"key": "Bad-Key", "type": "string"}`), http.StatusBadRequest, "")
+			wantV2(t, doV2(t, h, http.MethodPost, customFieldsV2Path, "", fmt.Sprintf(`{"key": %q, "type": "enum"}`, dept)), http.StatusBadRequest, "")
+			wantV2(t, doV2(t, h, http.MethodPost, customFieldsV2Path, "", fmt.Sprintf(`{"key": %q, "type": "int"}`, floor)), http.StatusCreated, "")
+			wantV2(t, doV2(t, h, http.MethodPost, customFieldsV2Path, "", fmt.Sprintf(`{"key": %q, "type": "int"}`, floor)), http.StatusConflict, "")
+			wantV2(t, doV2(t, h, http.MethodPost, customFieldsV2Path, "",
+				fmt.Sprintf(`{"key": %q, "type": "enum", "values": ["ИТ", "Продажи"]}`, dept)), http.StatusCreated, "")
+
+			// Проверка значений в записях
+			record := func(n int, custom string) string {
+				return fmt.Sprintf(`{"name": "Иван", "last_name": "Петров", "address": "Москва", "phone": %q, "custom": %s}`, testPhone(n), custom)
+			}
+			for _, custom := range []string{
+				fmt.Sprintf(`{%q: "третий"}`, floor),
+				fmt.Sprintf(`{%q: "Склад"}`, dept),
+				`{"undeclared_field": 1}`,
+			} {
+				wantV2(t, doV2(t, h, http.MethodPost, recordsV2Path, "", record(43, custom)), http.StatusBadRequest, "")
+			}
+			resp := doV2(t, h, http.MethodPost, recordsV2Path, "", record(44, fmt.Sprintf(`{%q: 3, %q: "ИТ"}`, floor, dept)))
+			wantV2(t, resp, http.StatusCreated, "")
+			var a dto.Record
+			if err := json.Unmarshal(resp.Body.Bytes(), &a); err != nil {
+				t.Fatalf("POST %s: %v", recordsV2Path, err)
+			}
+			wantV2(t, doV2(t, h, http.MethodPost, recordsV2Path, "", record(45, fmt.Sprintf(`{%q: 10}`, floor))), http.StatusCreated, "")
+
+			// Условия на дополнительные поля
+			if got := listV2(t, h, url.Values{dto.CustomFieldPrefix + dept: {"ИТ"}}); len(got) != 1 || got[0].ID != a.ID {
+				t.Fatalf("custom.%s=ИТ returned %+v", dept, got)
+			}
+			filter := fmt.Sprintf(`{"field": "custom.%s", "op": "gte", "value": 5}`, floor)
+			if got := listV2(t, h, url.Values{"filter": {filter}}); len(got) != 1 || got[0].Custom[floor] != float64(10) {
+				t.Fatalf("filter %s returned %+v", filter, got)
+			}
+			filter = fmt.Sprintf(`{"field": "custom.%s", "op": "gte", "value": "пять"}`, floor)
+			wantV2(t, doV2(t, h, http.MethodGet, recordsV2Path+"?"+url.Values{"filter": {filter}}.Encode(), "", ""), http.StatusBadRequest, "")
+
+			// Удаление поля убирает значение и у записей в корзине, с записью в историю
+			wantV2(t, doV2(t, h, http.MethodDelete, fmt.Sprintf("%s/%d", recordsV2Path, a.ID), "*", ""), http.StatusNoContent, "")
+			wantV2(t, doV2(t, h, http.MethodDelete, customFieldsV2Path+"/"+dept, "", ""), http.StatusNoContent, "")
+			page, err := db.History(context.Background(), dto.HistoryQuery{RecordID: a.ID, Limit: 1})
+			if err != nil || len(page.Entries) != 1 || page.Entries[0].Action != dto.ActionUpdate || page.Entries[0].After.Custom[dept] != nil {
+				t.Fatalf("History() after field delete = %+v, %v", page.Entries, err)
+			}
+			wantV2(t, doV2(t, h, http.MethodPost, fmt.Sprintf("%s/%d/restore", trashV2Path, a.ID), "", ""), http.StatusOK, "")
+			if rec := getRecordV2(t, h, a.ID); rec.Custom[dept] != nil || rec.Custom[floor] != float64(3) {
+				t.Fatalf("restored record custom = %v", rec.Custom)
+			}
+			wantV2(t, doV2(t, h, http.MethodDelete, customFieldsV2Path+"/"+floor, "", ""), http.StatusNoContent, "")
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Форматы выгрузки /export
+const (
+	exportCSV    = "csv"    // CSV с заголовком (по умолчанию)
+	exportNDJSON = "ndjson" // JSON Lines: запись в формате JSON на каждой строке
+	exportVCard  = "vcard"  // Карточки vCard одним файлом .vcf
+)
+
+// exportColumns - столбцы выгрузки CSV до дополнительных полей; после них идут custom.{key} по алфавиту
+// и exportTimeColumns. Имена столбцов совпадают с полями импорта (см. ImportCSV), кроме phones и emails.
+var exportColumns = []string{"id", "name", "last_name", "middle_name", "address", "phone", "phones", "emails",
+	"birthday", "organization", "job_title", "notes", "tags"}
+
+// exportTimeColumns - последние столбцы выгрузки CSV.
+var exportTimeColumns = []string{"created_at", "updated_at", "version"}
+
+// exportListSeparator - разделитель значений в столбцах phones, emails и tags выгрузки CSV.
+const exportListSeparator = "; "
+
+// exportRequest - тело запроса exportHandler: условия выборки, как у getRecordsHandler, и формат выгрузки.
+type exportRequest struct {
+	getRequest
+	Format       string `json:"format"`
+	VCardVersion string `json:"vcard_version"` // Версия vCard для формата vcard: 3.0 (по умолчанию) или 4.0
+}
+
+// exportHandler обрабатывает запрос на выгрузку записей в файл
+/*
+Запрос должен быть с методом POST и с содержимым в формате JSON: условия выборки и сортировка те же, что у /get
+(name, phone, filter, q, sort, as_of и т.д.), а format - формат выгрузки csv (по умолчанию), ndjson или vcard:
+  {"format": "csv", "last_name": "Иванов", "sort": "name"}
+
+Выгружаются все записи, удовлетворяющие условиям (limit, cursor и with_total не учитываются). Записи передаются
+клиенту по мере чтения из базы данных, поэтому расход памяти сервера не зависит от их количества.
+Ответ - файл (заголовок Content-Disposition: attachment; filename="address_book_20240501_120000.csv"):
+
+csv - первая строка - заголовок: id, name, last_name, middle_name, address, phone (основной номер),
+phones (остальные номера вида тип:номер), emails, birthday, organization, job_title, notes, tags,
+custom.{key} для каждого объявленного дополнительного поля, created_at, updated_at, version.
+Значения списков phones, emails и tags разделяются "; ".
+
+ndjson (Content-Type: application/x-ndjson) - каждая запись в формате JSON, как в ответе /get, на отдельной строке.
+
+vcard (Content-Type: text/vcard, файл .vcf) - карточка vCard на каждую запись (см. recordToVCard), версия
+задается полем vcard_version: 3.0 (по умолчанию) или 4.0. Такой файл принимают контакты Android и iOS.
+
+В случае ошибки до начала выгрузки:
+  {"result": "ERROR", "data": null, "error": "error description"}
+Если ошибка произошла во время выгрузки, соединение разрывается, и клиент получает неполный ответ.
+*/
+func (abs *AddressBookService) exportHandler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) exportHandler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) exportHandler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	// Ответ с ошибкой отправляется, только если выгрузка еще не началась
+	resp := &dto.Response{}
+	fail := func(err error, msg, operation string) {
+		resp.Update("ERROR", nil, msg)
+		wErr.Specify(err, operation).LogError()
+		if err = json.NewEncoder(w).Encode(resp); err != nil {
+			wErr.Specify(err, "json.NewEncoder(w).Encode(resp)").LogError()
+		}
+	}
+
+	// Проверка метода
+	if req.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Парсинг запроса
+	export := exportRequest{}
+	byteReq, err := io.ReadAll(req.Body)
+	if err != nil {
+		fail(err, err.Error(), "io.ReadAll(req.Body)")
+		return
+	}
+	err = json.Unmarshal(byteReq, &export)
+	if err != nil {
+		fail(err, err.Error(), "json.Unmarshal(byteReq, &export)")
+		return
+	}
+	if export.Format == "" {
+		export.Format = exportCSV
+	}
+	if export.Format != exportCSV && export.Format != exportNDJSON && export.Format != exportVCard {
+		err = errors.New("format must be csv, ndjson or vcard")
+		fail(err, err.Error(), "export.Format")
+		return
+	}
+	vcardVersion, err := parseVCardVersion(export.VCardVersion)
+	if err != nil {
+		fail(err, err.Error(), "parseVCardVersion(export.VCardVersion)")
+		return
+	}
+	query, err := export.query()
+	if err != nil {
+		fail(err, err.Error(), "export.query()")
+		return
+	}
+
+	var out exportWriter
+	extension := export.Format
+	switch export.Format {
+	case exportNDJSON:
+		out = &ndjsonExportWriter{w: w}
+	case exportVCard:
+		out = &vcardExportWriter{w: w, version: vcardVersion}
+		extension = "vcf"
+	default:
+		defs, err := abs.db.CustomFields(req.Context())
+		if err != nil {
+			fail(err, storageErrorText(err, "cannot export records"), "abs.db.CustomFields(req.Context())")
+			return
+		}
+		out = newCSVExportWriter(w, defs)
+	}
+
+	// Заголовки ответа отправляются с первой записью, чтобы до нее можно было сообщить об ошибке
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", out.contentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="address_book_%s.%s"`,
+			time.Now().UTC().Format("20060102_150405"), extension))
+		w.WriteHeader(http.StatusOK)
+		return out.begin()
+	}
+
+	var count int
+	err = abs.db.ExportRecords(req.Context(), query, func(r dto.Record) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		count++
+		return out.write(r)
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = out.end()
+	}
+	if err != nil && !started {
+		fail(err, storageErrorText(err, err.Error()), "abs.db.ExportRecords()")
+		return
+	}
+	if err != nil {
+		// Клиент уже получил часть файла: разрыв соединения не дает принять ее за полную выгрузку
+		wErr.Specify(err, "abs.db.ExportRecords()").LogError()
+		panic(http.ErrAbortHandler)
+	}
+	wErr.LogMsg(fmt.Sprintf("exported %d records (%s)", count, export.Format))
+}
+
+// exportWriter записывает выгружаемые записи в ответ в одном из форматов /export.
+type exportWriter interface {
+	contentType() string
+	begin() error // Начало файла (заголовок CSV)
+	write(r dto.Record) error
+	end() error // Отправка буферизованных данных
+}
+
+// ndjsonExportWriter записывает записи в формате JSON Lines.
+type ndjsonExportWriter struct {
+	w io.Writer
+}
+
+func (e *ndjsonExportWriter) contentType() string {
+	return "application/x-ndjson"
+}
+
+func (e *ndjsonExportWriter) begin() error {
+	return nil
+}
+
+func (e *ndjsonExportWriter) write(r dto.Record) error {
+	// Encode добавляет перевод строки после каждой записи
+	return json.NewEncoder(e.w).Encode(r)
+}
+
+func (e *ndjsonExportWriter) end() error {
+	return nil
+}
+
+// csvExportWriter записывает записи в формате CSV (см. exportColumns).
+type csvExportWriter struct {
+	w      *csv.Writer
+	custom []string // Имена дополнительных полей по алфавиту
+	row    []string // Буфер строки, используется повторно
+}
+
+// newCSVExportWriter возвращает csvExportWriter со столбцами для дополнительных полей defs.
+func newCSVExportWriter(w io.Writer, defs []dto.CustomFieldDef) *csvExportWriter {
+	e := &csvExportWriter{w: csv.NewWriter(w)}
+	for _, d := range defs {
+		e.custom = append(e.custom, d.Key)
+	}
+	slices.Sort(e.custom)
+	return e
+}
+
+func (e *csvExportWriter) contentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (e *csvExportWriter) begin() error {
+	header := slices.Clone(exportColumns)
+	for _, key := range e.custom {
+		header = append(header, "custom."+key)
+	}
+	return e.w.Write(append(header, exportTimeColumns...))
+}
+
+func (e *csvExportWriter) write(r dto.Record) error {
+	var phones []string
+	for _, p := range r.Phones {
+		if !p.Primary {
+			phones = append(phones, p.Type+":"+p.Number)
+		}
+	}
+
+	e.row = append(e.row[:0], strconv.FormatInt(r.ID, 10), r.Name, r.LastName, r.MiddleName, r.Address, r.Phone,
+		strings.Join(phones, exportListSeparator), strings.Join(r.Emails, exportListSeparator), r.Birthday,
+		r.Organization, r.JobTitle, r.Notes, strings.Join(r.Tags, exportListSeparator))
+	for _, key := range e.custom {
+		e.row = append(e.row, customValueText(r.Custom[key]))
+	}
+	e.row = append(e.row, r.CreatedAt.Format(time.RFC3339), r.UpdatedAt.Format(time.RFC3339), strconv.FormatInt(r.Version, 10))
+	return e.w.Write(e.row)
+}
+
+func (e *csvExportWriter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// customValueText возвращает значение дополнительного поля для столбца CSV ("" - поле не заполнено).
+// Целые числа из JSON (float64) записываются без экспоненты.
+func customValueText(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
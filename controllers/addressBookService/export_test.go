@@ -0,0 +1,146 @@
+package addressBookService
+
+import (
+	"addressBookServer/gates/memory"
+	"addressBookServer/models/dto"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// exportFile отправляет запрос /export с телом body и проверяет заголовки файла с расширением extension.
+func exportFile(t *testing.T, h http.Handler, body, contentType, extension string) []byte {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/export", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/export %s: status %d (%s)", body, rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != contentType {
+		t.Fatalf("/export %s: Content-Type %q, want %q", body, got, contentType)
+	}
+	disposition := regexp.MustCompile(`^attachment; filename="address_book_\d{8}_\d{6}\.` + extension + `"$`)
+	if got := rec.Header().Get("Content-Disposition"); !disposition.MatchString(got) {
+		t.Fatalf("/export %s: Content-Disposition %q", body, got)
+	}
+	return rec.Body.Bytes()
+}
+
+// readExportNDJSON возвращает записи из выгрузки /export в формате ndjson с условиями conditions (поля JSON).
+func readExportNDJSON(t *testing.T, h http.Handler, conditions string) []dto.Record {
+	t.Helper()
+	body := exportFile(t, h, `{"format": "ndjson"`+conditions+`}`, "application/x-ndjson", "ndjson")
+
+	var records []dto.Record
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var r dto.Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("/export ndjson line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// recordIDs возвращает идентификаторы записей records по порядку.
+func recordIDs(records []dto.Record) []int64 {
+	ids := make([]int64, len(records))
+	for i, r := range records {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+func TestExportFormats(t *testing.T) {
+	h := NewAddressBookService("", memory.NewMemory()).server.Handler
+	wantV1(t, postV1(t, h, "/create", `{"name": "Иван", "last_name": "Петров", "address": "Москва",
+		"phones": [{"number": "89001112233", "type": "mobile", "primary": true}, {"number": "89004445566", "type": "work"}], "emails": ["ivan@example.com"], "notes": "Текст, с запятой"}`), "")
+	wantV1(t, postV1(t, h, "/create", `{"name": "Анна", "last_name": "Смирнова", "address": "Казань", "phone": "89007778899"}`), "")
+
+	// CSV: заголовок и строка на каждую запись; значения с разделителем экранируются
+	rows, err := csv.NewReader(bytes.NewReader(exportFile(t, h, `{"sort": "name"}`, "text/csv; charset=utf-8", "csv"))).ReadAll()
+	if err != nil {
+		t.Fatalf("/export csv: %v", err)
+	}
+	wantHeader := append(append([]string{}, exportColumns...), exportTimeColumns...)
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], wantHeader) {
+		t.Fatalf("/export csv = %q", rows)
+	}
+	column := func(row []string, name string) string {
+		return row[slices.Index(wantHeader, name)]
+	}
+	anna, ivan := rows[1], rows[2]
+	if column(anna, "name") != "Анна" || column(ivan, "name") != "Иван" {
+		t.Fatalf("/export csv sort: %q", rows[1:])
+	}
+	if column(ivan, "phone") != "89001112233" || column(ivan, "phones") != "work:89004445566" ||
+		column(ivan, "emails") != "ivan@example.com" || column(ivan, "notes") != "Текст, с запятой" || column(ivan, "version") != "1" {
+		t.Fatalf("/export csv row = %q", ivan)
+	}
+
+	// NDJSON: записи в том же виде, что и в ответе /get
+	records := readExportNDJSON(t, h, `, "sort": "name"`)
+	if len(records) != 2 || records[0].Name != "Анна" || records[1].Name != "Иван" || len(records[1].Phones) != 2 {
+		t.Fatalf("/export ndjson = %+v", records)
+	}
+
+	// vCard: карточка на каждую запись
+	cards := exportFile(t, h, `{"format": "vcard", "vcard_version": "4.0"}`, vcardContentType, "vcf")
+	if n := bytes.Count(cards, []byte("BEGIN:VCARD")); n != 2 || !bytes.Contains(cards, []byte("VERSION:4.0")) {
+		t.Fatalf("/export vcard: %d cards\n%s", n, cards)
+	}
+
+	// Ошибки до начала выгрузки сообщаются ответом API v1
+	for body, wantErr := range map[string]string{
+		`{"format": "xml"}`:                                           "format must be csv, ndjson or vcard",
+		`{"format": "vcard", "vcard_version": "2.1"}`:                 "vCard version must be 3.0 or 4.0",
+		`{"filter": {"field": "password", "op": "eq", "value": "x"}}`: `filter: unknown field "password"`,
+	} {
+		resp := postV1(t, h, "/export", body)
+		if resp.Result != "ERROR" || resp.Error != wantErr {
+			t.Errorf("/export %s = %+v, want ERROR %q", body, resp, wantErr)
+		}
+	}
+}
+
+func TestExportMatchesGet(t *testing.T) {
+	h := NewAddressBookService("", memory.NewMemory()).server.Handler
+	for i, name := range []string{"Иван", "Анна", "Петр", "Мария", "Олег"} {
+		lastName := "Петров"
+		if i%2 == 1 {
+			lastName = "Иванова"
+		}
+		wantV1(t, postV1(t, h, "/create", fmt.Sprintf(`{"name": %q, "last_name": %q, "address": "Москва", "phone": "8901000000%d"}`,
+			name, lastName, i)), "")
+	}
+
+	// Выгрузка выбирает те же записи в том же порядке, что и /get с теми же условиями, но без limit
+	for _, conditions := range []string{
+		`"last_name": "Петров"`,
+		`"sort": "-name"`,
+		`"filter": {"field": "name", "op": "prefix", "value": "м", "ignore_case": true}`,
+		`"q": "Иванова", "sort": "name"`,
+		`"sort": "name", "limit": 2`,
+	} {
+		resp := postV1(t, h, "/get", "{"+strings.Replace(conditions, `"limit": 2`, `"limit": 100`, 1)+"}")
+		wantV1(t, resp, "")
+		var want []dto.Record
+		if err := json.Unmarshal(resp.Data, &want); err != nil {
+			t.Fatalf("/get %s: %v", conditions, err)
+		}
+		got := readExportNDJSON(t, h, ", "+conditions)
+		if !reflect.DeepEqual(recordIDs(got), recordIDs(want)) {
+			t.Errorf("/export %s = %v, /get = %v", conditions, recordIDs(got), recordIDs(want))
+		}
+	}
+}
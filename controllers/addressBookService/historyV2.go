@@ -0,0 +1,162 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// auditV2Path - путь к общей ленте изменений записей (журналу аудита).
+// Хранилище записывает каждое создание, изменение и удаление записи в историю вместе с состоянием записи
+// до и после изменения, временем, автором (заголовок X-Actor запроса) и идентификатором запроса (X-Request-ID).
+// Сервер не аутентифицирует клиентов, поэтому автор указывается самим клиентом и не подтверждает, кто сделал изменение.
+const auditV2Path = "/v2/audit"
+
+// Заголовки запроса с автором изменения и идентификатором запроса (см. withChangeMeta).
+// Значение X-Actor не проверяется: пока у сервера нет аутентификации, автор - то, что сообщил о себе клиент.
+const (
+	actorHeader     = "X-Actor"
+	requestIDHeader = "X-Request-ID"
+)
+
+// withChangeMeta передает в контексте запроса автора и идентификатор запроса для истории изменений.
+// Если клиент не передал X-Request-ID, идентификатор генерируется; в обоих случаях он возвращается в ответе.
+func withChangeMeta(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		meta := dto.ChangeMeta{
+			Actor:     req.Header.Get(actorHeader),
+			RequestID: req.Header.Get(requestIDHeader),
+		}
+		if meta.RequestID == "" {
+			meta.RequestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, meta.RequestID)
+		next.ServeHTTP(w, req.WithContext(dto.WithChangeMeta(req.Context(), meta)))
+	})
+}
+
+// newRequestID возвращает случайный идентификатор запроса из 16 шестнадцатеричных цифр.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("newRequestID(): rand.Read()", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// auditV2Handler обрабатывает запросы к ленте изменений
+/*
+GET /v2/audit - изменения всех записей от новых к старым. Параметры: actor - автор изменения, action - действие
+(create, update, delete, restore или purge), since и until (RFC 3339 или ГГГГ-ММ-ДД) - изменения не раньше since и раньше until.
+Поле actor - значение заголовка X-Actor запроса, сделавшего изменение: клиент указывает его сам, сервер его не проверяет.
+Постраничная выборка: limit (по умолчанию 100, не больше 1000) и cursor (токен следующей страницы), токен
+возвращается в заголовках X-Next-Cursor и Link (rel="next"). Возвращает 200 и массив изменений:
+  [{"id": 7, "record_id": 1, "action": "update", "before": {...}, "after": {...},
+    "changed_at": "2024-03-01T10:00:00Z", "actor": "ivanov", "request_id": "5f0c3a9e1b2d4c68"}]
+*/
+func (abs *AddressBookService) auditV2Handler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) auditV2Handler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) auditV2Handler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	switch req.Method {
+	case http.MethodGet:
+		abs.listHistoryV2(w, req, 0, wErr)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// recordHistoryV2 обрабатывает запросы к истории изменений записи /v2/records/{id}/history
+/*
+GET /v2/records/{id}/history - изменения записи от новых к старым, в том числе после ее удаления.
+Параметры и ответ те же, что у GET /v2/audit. Возвращает 404, если у записи нет истории.
+*/
+func (abs *AddressBookService) recordHistoryV2(w http.ResponseWriter, req *http.Request, id int64, wErr *pkg.WrappedError) {
+	switch req.Method {
+	case http.MethodGet:
+		abs.listHistoryV2(w, req, id, wErr)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// listHistoryV2 отправляет страницу истории изменений по параметрам URL; recordID, если не 0, ограничивает
+// историю одной записью.
+func (abs *AddressBookService) listHistoryV2(w http.ResponseWriter, req *http.Request, recordID int64, wErr *pkg.WrappedError) {
+	q, err := historyQueryFromURLV2(req.URL.Query())
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, err, wErr)
+		return
+	}
+	q.RecordID = recordID
+	if q.Limit == 0 {
+		q.Limit = defaultLimitV2
+	}
+
+	page, err := abs.db.History(req.Context(), q)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+	if recordID != 0 && q.Cursor == "" && len(page.Entries) == 0 {
+		writeErrorV2(w, http.StatusNotFound, dto.ErrRecordNotFound, wErr)
+		return
+	}
+	if page.Entries == nil {
+		page.Entries = []dto.HistoryEntry{}
+	}
+
+	if page.NextCursor != "" {
+		next := req.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, req.URL.Path, next.Encode()))
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+
+	writeJSONV2(w, http.StatusOK, page.Entries, wErr)
+}
+
+// historyQueryFromURLV2 строит параметры выборки истории изменений по параметрам URL.
+func historyQueryFromURLV2(values url.Values) (q dto.HistoryQuery, err error) {
+	q = dto.HistoryQuery{
+		Actor:  values.Get("actor"),
+		Action: values.Get("action"),
+		Cursor: values.Get("cursor"),
+	}
+	bounds := map[string]*time.Time{"since": &q.Since, "until": &q.Until}
+	for _, param := range []string{"since", "until"} {
+		if values.Get(param) == "" {
+			continue
+		}
+		*bounds[param], err = dto.ParseTimestamp(values.Get(param))
+		if err != nil {
+			return q, &dto.ValidationError{Msg: param + " must be a RFC 3339 timestamp or a date"}
+		}
+	}
+	if values.Get("limit") != "" {
+		q.Limit, err = strconv.Atoi(values.Get("limit"))
+		if err != nil {
+			return q, &dto.ValidationError{Msg: "limit must be a number"}
+		}
+	}
+	return q, q.Validate()
+}
@@ -0,0 +1,471 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"golang.org/x/text/encoding/charmap"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// importV2Path - путь загрузки файла CSV или vCard с записями (см. ImportCSV и ImportVCard).
+const importV2Path = "/v2/import"
+
+// Форматы файлов импорта
+const (
+	importCSV   = "csv"   // CSV с заголовком (см. ImportCSV)
+	importVCard = "vcard" // Карточки vCard (см. ImportVCard)
+)
+
+// maxImportSize - наибольший размер файла импорта, принимаемого importV2Handler.
+const maxImportSize = 64 << 20
+
+// Кодировки файлов импорта
+const (
+	encodingUTF8        = "utf-8"
+	encodingWindows1251 = "windows-1251" // Кодировка старых выгрузок
+)
+
+// importIgnore - поле, сопоставление с которым исключает столбец из импорта.
+const importIgnore = "-"
+
+// importScalarFields - поля записи, которые можно сопоставить только одному столбцу.
+var importScalarFields = map[string]bool{"name": true, "last_name": true, "middle_name": true, "address": true,
+	"birthday": true, "organization": true, "job_title": true, "notes": true}
+
+// utf8BOM - метка порядка байтов, которую добавляют в начало файлов UTF-8 некоторые программы (например, Excel).
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// ImportOptions - параметры импорта записей из файла CSV (см. NewImportOptions).
+type ImportOptions struct {
+	Delimiter rune              // Разделитель полей
+	Encoding  string            // Кодировка файла: encodingUTF8 или encodingWindows1251
+	Mapping   map[string]string // Поля записи по заголовкам столбцов (см. ImportCSV)
+	DryRun    bool              // Только проверить строки, не сохраняя записи
+}
+
+// NewImportOptions проверяет и возвращает параметры импорта. delimiter - один символ или tab
+// (по умолчанию запятая), encoding - utf-8 (по умолчанию) или windows-1251 (cp1251),
+// mapping - сопоставления вида "Заголовок:поле" (см. ImportCSV). Ошибки возвращаются как *dto.ValidationError.
+func NewImportOptions(delimiter, encoding string, mapping []string, dryRun bool) (opts ImportOptions, err error) {
+	opts.DryRun = dryRun
+
+	switch delimiter {
+	case "":
+		opts.Delimiter = ','
+	case "tab", `\t`:
+		opts.Delimiter = '\t'
+	default:
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+			return opts, &dto.ValidationError{Msg: "delimiter must be a single character"}
+		}
+		opts.Delimiter = r
+	}
+
+	switch strings.ToLower(encoding) {
+	case "", encodingUTF8, "utf8":
+		opts.Encoding = encodingUTF8
+	case encodingWindows1251, "cp1251":
+		opts.Encoding = encodingWindows1251
+	default:
+		return opts, &dto.ValidationError{Msg: "encoding must be utf-8 or windows-1251"}
+	}
+
+	opts.Mapping = make(map[string]string, len(mapping))
+	for _, m := range mapping {
+		i := strings.LastIndex(m, ":")
+		if i == -1 {
+			return opts, &dto.ValidationError{Msg: fmt.Sprintf("mapping %q must be in format Header:field", m)}
+		}
+		header, field := strings.TrimSpace(m[:i]), strings.TrimSpace(m[i+1:])
+		if _, ok := opts.Mapping[header]; ok {
+			return opts, &dto.ValidationError{Msg: fmt.Sprintf("column %q is mapped twice", header)}
+		}
+		opts.Mapping[header] = field
+	}
+	return opts, nil
+}
+
+// importColumn - поле записи, которому сопоставлен столбец файла импорта.
+type importColumn struct {
+	field string // Поле записи; phone, email и custom - см. ImportCSV
+	key   string // Тип номера (phone.<type>) или имя дополнительного поля (custom.<key>)
+}
+
+// parseImportField возвращает поле записи по его имени в сопоставлении.
+func parseImportField(name string, defs dto.CustomFieldDefs) (importColumn, error) {
+	field, key, _ := strings.Cut(name, ".")
+	switch {
+	case importScalarFields[name], name == "phone", name == "email":
+		return importColumn{field: name}, nil
+	case field == "phone" && dto.PhoneTypes[key]:
+		return importColumn{field: field, key: key}, nil
+	case field == "custom":
+		if _, ok := defs[key]; !ok {
+			return importColumn{}, &dto.ValidationError{Msg: fmt.Sprintf("unknown custom field %q", key)}
+		}
+		return importColumn{field: field, key: key}, nil
+	}
+	return importColumn{}, &dto.ValidationError{Msg: fmt.Sprintf("unknown field %q", name)}
+}
+
+// importColumns сопоставляет столбцы файла с заголовком header полям записи: сначала по mapping,
+// затем по совпадению заголовка с именем поля без учета регистра. Несопоставленные столбцы (nil)
+// возвращаются в ignored. Все обязательные поля записи должны быть сопоставлены.
+func importColumns(header []string, mapping map[string]string, defs dto.CustomFieldDefs) (columns []*importColumn, ignored []string, err error) {
+	for h := range mapping {
+		if !slices.Contains(header, h) {
+			return nil, nil, &dto.ValidationError{Msg: fmt.Sprintf("column %q not found", h)}
+		}
+	}
+
+	mapped := map[string]bool{} // Сопоставленные имена полей (phone.mobile, custom.department)
+	fields := map[string]bool{} // Сопоставленные поля записи (phone, custom)
+	columns = make([]*importColumn, len(header))
+	for i, h := range header {
+		name, explicit := mapping[h]
+		if !explicit {
+			name = strings.ToLower(h)
+		}
+		if name == importIgnore || name == "" {
+			ignored = append(ignored, h)
+			continue
+		}
+		column, err := parseImportField(name, defs)
+		if err != nil {
+			if explicit {
+				return nil, nil, err
+			}
+			ignored = append(ignored, h)
+			continue
+		}
+		if (importScalarFields[name] || name == "phone" || column.field == "custom") && mapped[name] {
+			return nil, nil, &dto.ValidationError{Msg: fmt.Sprintf("field %q is mapped to several columns", name)}
+		}
+		mapped[name], fields[column.field] = true, true
+		columns[i] = &column
+	}
+
+	for _, field := range []string{"name", "last_name", "address", "phone"} {
+		if !fields[field] {
+			return nil, nil, &dto.ValidationError{Msg: fmt.Sprintf("no column for required field %q", field)}
+		}
+	}
+	return columns, ignored, nil
+}
+
+// importRecord возвращает запись из строки файла row по сопоставлению столбцов columns. Пустые значения
+// пропускаются. Номер столбца phone - основной; если его нет, основным становится первый из номеров.
+func importRecord(columns []*importColumn, row []string) (rec dto.Record) {
+	for i, c := range columns {
+		value := strings.TrimSpace(row[i])
+		if c == nil || value == "" {
+			continue
+		}
+		switch c.field {
+		case "name":
+			rec.Name = value
+		case "last_name":
+			rec.LastName = value
+		case "middle_name":
+			rec.MiddleName = value
+		case "address":
+			rec.Address = value
+		case "birthday":
+			rec.Birthday = value
+		case "organization":
+			rec.Organization = value
+		case "job_title":
+			rec.JobTitle = value
+		case "notes":
+			rec.Notes = value
+		case "phone":
+			rec.Phones = append(rec.Phones, dto.Phone{Number: value, Type: c.key, Primary: c.key == ""})
+		case "email":
+			rec.Emails = append(rec.Emails, value)
+		case "custom":
+			if rec.Custom == nil {
+				rec.Custom = map[string]any{}
+			}
+			rec.Custom[c.key] = value
+		}
+	}
+	return rec
+}
+
+// importReader возвращает текст файла r в UTF-8: файл в windows-1251 перекодируется,
+// а у файла в UTF-8 отбрасывается метка порядка байтов.
+func importReader(r io.Reader, encoding string) io.Reader {
+	if encoding == encodingWindows1251 {
+		return charmap.Windows1251.NewDecoder().Reader(r)
+	}
+	br := bufio.NewReader(r)
+	if bom, err := br.Peek(len(utf8BOM)); err == nil && bytes.Equal(bom, utf8BOM) {
+		_, _ = br.Discard(len(utf8BOM))
+	}
+	return br
+}
+
+// ImportCSV импортирует записи из файла CSV r в хранилище db и возвращает отчет с отклоненными строками.
+// Первая строка файла - заголовок. Столбцы сопоставляются полям записи по opts.Mapping, а столбцы
+// без сопоставления - по совпадению заголовка с именем поля; остальные столбцы пропускаются.
+// Поля: name, last_name, middle_name, address, birthday, organization, job_title, notes,
+// phone (основной номер), phone.<type> (номер типа mobile, work, home, fax или other),
+// email и custom.<key> (дополнительное поле); номера и почту можно сопоставить нескольким столбцам.
+// Обязательны столбцы name, last_name, address и хотя бы один столбец номера.
+//
+// Каждая строка проверяется и нормализуется так же, как запись в /create (номера - pkg.NormalizePhoneNumber),
+// строки с ошибками и номерами, занятыми другими записями или предыдущими строками файла, отклоняются,
+// а остальные записи сохраняются одной транзакцией (см. Storage.ImportRecords). Ошибки заголовка
+// и сопоставления возвращаются как *dto.ValidationError, и тогда не сохраняется ни одна запись.
+func ImportCSV(ctx context.Context, db Storage, r io.Reader, opts ImportOptions) (report dto.ImportReport, err error) {
+	batch := newImportBatch(opts.DryRun)
+	report = batch.report
+
+	defs, err := db.CustomFields(ctx)
+	if err != nil {
+		return report, err
+	}
+	customDefs := dto.NewCustomFieldDefs(defs)
+
+	reader := csv.NewReader(importReader(r, opts.Encoding))
+	reader.Comma = opts.Delimiter
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return report, &dto.ValidationError{Msg: "file is empty"}
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return report, &dto.ValidationError{Msg: "header: " + err.Error()}
+	}
+	if err != nil {
+		return report, err
+	}
+	for i := range header {
+		if !utf8.ValidString(header[i]) {
+			return report, &dto.ValidationError{Msg: "header is not valid UTF-8 (check encoding)"}
+		}
+		header[i] = strings.TrimSpace(header[i])
+	}
+	columns, ignored, err := importColumns(header, opts.Mapping, customDefs)
+	if err != nil {
+		return report, err
+	}
+	batch.report.IgnoredColumns = ignored
+
+	// Проверка строк; в хранилище передаются только прошедшие проверку
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.As(err, &parseErr) {
+			batch.reject(parseErr.StartLine, "", parseErr.Err)
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		if len(row) != len(header) {
+			batch.reject(line, "", fmt.Errorf("wrong number of fields: %d instead of %d", len(row), len(header)))
+			continue
+		}
+		if !validUTF8(row) {
+			batch.reject(line, "", errors.New("invalid UTF-8 (check encoding)"))
+			continue
+		}
+		rec := importRecord(columns, row)
+		if err = prepareNewRecord(&rec); err == nil {
+			err = customDefs.ValidateCustom(rec.Custom, true)
+		}
+		if err != nil {
+			batch.reject(line, "", err)
+			continue
+		}
+		batch.add(line, "", rec)
+	}
+
+	return batch.save(ctx, db)
+}
+
+// importBatch собирает записи файла импорта, прошедшие проверку, и отчет об импорте.
+type importBatch struct {
+	report  dto.ImportReport
+	records []dto.Record
+	sources []dto.ImportReject // Строка файла и имя каждой записи records (для отчета)
+}
+
+func newImportBatch(dryRun bool) *importBatch {
+	return &importBatch{report: dto.ImportReport{DryRun: dryRun, Rejected: []dto.ImportReject{}}}
+}
+
+// add добавляет проверенную запись rec из строки line файла (name - имя в отчете, если есть).
+func (b *importBatch) add(line int, name string, rec dto.Record) {
+	b.report.Total++
+	b.records = append(b.records, rec)
+	b.sources = append(b.sources, dto.ImportReject{Line: line, Name: name})
+}
+
+// reject отмечает в отчете строку line файла, не прошедшую проверку с ошибкой err.
+func (b *importBatch) reject(line int, name string, err error) {
+	b.report.Total++
+	b.report.Rejected = append(b.report.Rejected, dto.ImportReject{Line: line, Name: name, Reason: err.Error()})
+}
+
+// save сохраняет проверенные записи (см. Storage.ImportRecords) и возвращает отчет: записи, номера которых
+// уже заняты, добавляются к отклоненным, и отклоненные строки упорядочиваются по номеру строки.
+func (b *importBatch) save(ctx context.Context, db Storage) (dto.ImportReport, error) {
+	errs, err := db.ImportRecords(ctx, b.records, b.report.DryRun)
+	if err != nil {
+		return b.report, err
+	}
+	for i, recErr := range errs {
+		if recErr != nil {
+			reject := b.sources[i]
+			reject.Reason = batchErrorText(recErr)
+			b.report.Rejected = append(b.report.Rejected, reject)
+			continue
+		}
+		b.report.Imported++
+	}
+	slices.SortStableFunc(b.report.Rejected, func(a, b dto.ImportReject) int { return a.Line - b.Line })
+
+	return b.report, nil
+}
+
+// validUTF8 сообщает, что все поля строки - корректный текст в UTF-8.
+func validUTF8(row []string) bool {
+	for _, field := range row {
+		if !utf8.ValidString(field) {
+			return false
+		}
+	}
+	return true
+}
+
+// importV2Handler обрабатывает загрузку файла CSV или vCard с записями
+/*
+POST /v2/import - импорт записей из файла CSV или vCard (не больше 64 МБ). Файл передается телом запроса
+(Content-Type: text/csv или text/vcard) или полем file формы multipart/form-data. Первая строка файла CSV - заголовок.
+Параметры запроса:
+  format - формат файла: csv или vcard (по умолчанию vcard для Content-Type text/vcard, text/x-vcard
+  и файлов формы .vcf, иначе csv);
+  delimiter - разделитель полей CSV: один символ или tab (по умолчанию запятая);
+  encoding - кодировка файла: utf-8 (по умолчанию) или windows-1251;
+  map - сопоставление столбца полю записи вида Заголовок:поле (можно повторять), поле "-" исключает столбец;
+  dry_run=true - только проверить файл, не сохраняя записи.
+Поля: name, last_name, middle_name, address, birthday, organization, job_title, notes, phone (основной номер),
+phone.mobile, phone.work, phone.home, phone.fax, phone.other, email и custom.{key}. Столбцы с заголовком,
+совпадающим с именем поля, сопоставляются без параметра map. Обязательны name, last_name, address и номер.
+
+Карточки vCard (в том числе выгрузки контактов Android и iOS с несколькими карточками) сопоставляются
+записям так, как описано у recordFromVCard; параметры delimiter, encoding и map для них не учитываются.
+
+Строки и карточки проверяются так же, как в /create. Строки с ошибками и с номерами, которые уже заняты,
+отклоняются, остальные записи сохраняются одной транзакцией. Возвращает 200 и отчет (line - номер строки
+в файле, для vCard - строка BEGIN:VCARD, name - имя контакта из карточки):
+  {"dry_run": false, "total": 3, "imported": 2, "rejected": [{"line": 3, "reason": "wrong Phone"}],
+   "ignored_columns": ["Комментарий"]}
+  {"dry_run": false, "total": 2, "imported": 1,
+   "rejected": [{"line": 9, "name": "John Smith", "reason": "wrong Phone \"+1 555 0100\" (TEL)"}]}
+
+400 - ошибка в параметрах, заголовке файла или сопоставлении столбцов, 413 - файл слишком большой.
+*/
+func (abs *AddressBookService) importV2Handler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) importV2Handler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) importV2Handler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	switch req.Method {
+	case http.MethodPost:
+		abs.importFileV2(w, req, wErr)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "POST, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+func (abs *AddressBookService) importFileV2(w http.ResponseWriter, req *http.Request, wErr *pkg.WrappedError) {
+	values := req.URL.Query()
+	format := values.Get("format")
+	if format != "" && format != importCSV && format != importVCard {
+		writeErrorV2(w, http.StatusBadRequest, errors.New("format must be csv or vcard"), wErr)
+		return
+	}
+	dryRun, err := strconv.ParseBool(values.Get("dry_run"))
+	if err != nil && values.Get("dry_run") != "" {
+		writeErrorV2(w, http.StatusBadRequest, errors.New("dry_run must be true or false"), wErr)
+		return
+	}
+	opts, err := NewImportOptions(values.Get("delimiter"), values.Get("encoding"), values["map"], dryRun)
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, err, wErr)
+		return
+	}
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxImportSize)
+	var file io.Reader = req.Body
+	contentType, name := req.Header.Get("Content-Type"), ""
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		f, header, err := req.FormFile("file")
+		if err != nil {
+			status := importStatus(err)
+			if status == http.StatusInternalServerError {
+				status = http.StatusBadRequest // Ошибка разбора формы
+			}
+			writeErrorV2(w, status, err, wErr)
+			return
+		}
+		defer f.Close()
+		file = f
+		contentType, name = header.Header.Get("Content-Type"), header.Filename
+	}
+	if format == "" {
+		format = importFormat(contentType, name)
+	}
+
+	var report dto.ImportReport
+	if format == importVCard {
+		report, err = ImportVCard(req.Context(), abs.db, file, dryRun)
+	} else {
+		report, err = ImportCSV(req.Context(), abs.db, file, opts)
+	}
+	if err != nil {
+		writeErrorV2(w, importStatus(err), err, wErr)
+		return
+	}
+	wErr.LogMsg(fmt.Sprintf("import %s: %d rows, %d imported, %d rejected (dry run: %t)",
+		format, report.Total, report.Imported, len(report.Rejected), report.DryRun))
+	writeJSONV2(w, http.StatusOK, report, wErr)
+}
+
+// importStatus возвращает код HTTP ошибки импорта: превышение размера файла - 413, остальные - как statusForError.
+func importStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return statusForError(err)
+}
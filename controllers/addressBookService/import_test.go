@@ -0,0 +1,146 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"bytes"
+	"context"
+	"fmt"
+	"golang.org/x/text/encoding/charmap"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// importFileCSV импортирует в db файл data с разделителем ";" и параметрами encoding, mapping и dryRun (см. NewImportOptions).
+func importFileCSV(t *testing.T, db Storage, data []byte, encoding string, mapping []string, dryRun bool) dto.ImportReport {
+	t.Helper()
+	opts, err := NewImportOptions(";", encoding, mapping, dryRun)
+	if err != nil {
+		t.Fatalf("NewImportOptions(): %v", err)
+	}
+	report, err := ImportCSV(context.Background(), db, bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("ImportCSV(): %v", err)
+	}
+	return report
+}
+
+func TestImportCSV(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			first, second, inUse := testPhone(11), testPhone(12), testPhone(13)
+			wantV1(t, postV1(t, h, "/create", fmt.Sprintf(`{"name": "Петр", "last_name": "Иванов", "address": "Тверь", "phone": %q}`, inUse)), "")
+
+			// Файл начинается с метки порядка байтов; строка 3 повторяет номер строки 2, номер строки 4 занят, в строке 5 нет фамилии
+			data := []byte("\uFEFFИмя;Фамилия;Адрес;Телефон;Комментарий\n" +
+				"Иван;Петров;Москва;" + first + ";первый\n" +
+				"Иван;Сидоров;Москва;" + first + ";повтор\n" +
+				"Анна;Смирнова;Казань;" + inUse + ";занят\n" +
+				"Олег;;Казань;" + second + ";без фамилии\n" +
+				"Мария;Кузнецова;Тверь;" + second + ";второй\n")
+			mapping := []string{"Имя:name", "Фамилия:last_name", "Адрес:address", "Телефон:phone"}
+
+			wantRejected := []dto.ImportReject{
+				{Line: 3, Reason: dto.ErrPhoneInUse.Error()},
+				{Line: 4, Reason: dto.ErrPhoneInUse.Error()},
+				{Line: 5, Reason: "required data is missing"},
+			}
+			check := func(report dto.ImportReport, dryRun bool) {
+				t.Helper()
+				if report.DryRun != dryRun || report.Total != 5 || report.Imported != 2 {
+					t.Fatalf("report = %+v", report)
+				}
+				if !reflect.DeepEqual(report.Rejected, wantRejected) {
+					t.Fatalf("rejected = %+v, want %+v", report.Rejected, wantRejected)
+				}
+				if !reflect.DeepEqual(report.IgnoredColumns, []string{"Комментарий"}) {
+					t.Fatalf("ignored columns = %q", report.IgnoredColumns)
+				}
+			}
+
+			// Пробный импорт ничего не сохраняет
+			check(importFileCSV(t, db, data, "", mapping, true), true)
+			if got := getByPhone(t, h, first); len(got) != 0 {
+				t.Fatalf("/get after dry run returned %d records", len(got))
+			}
+
+			check(importFileCSV(t, db, data, "", mapping, false), false)
+			records := getByPhone(t, h, first)
+			if len(records) != 1 || records[0].Name != "Иван" || records[0].LastName != "Петров" || records[0].Version != 1 {
+				t.Fatalf("/get imported record = %+v", records)
+			}
+			if records = getByPhone(t, h, second); len(records) != 1 || records[0].LastName != "Кузнецова" {
+				t.Fatalf("/get imported record = %+v", records)
+			}
+
+			// Повторный импорт отклоняет все строки: их номера уже заняты
+			if report := importFileCSV(t, db, data, "", mapping, false); report.Imported != 0 || len(report.Rejected) != 5 {
+				t.Fatalf("second import report = %+v", report)
+			}
+		})
+	}
+}
+
+func TestImportCSVWindows1251(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			phone := testPhone(14)
+
+			// Столбцы с заголовками, совпадающими с именами полей, сопоставляются без map
+			text := "name;last_name;address;phone;Отдел\nИван;Петров;Москва;" + phone + ";Продажи\n"
+			data, err := charmap.Windows1251.NewEncoder().Bytes([]byte(text))
+			if err != nil {
+				t.Fatalf("Windows1251 encoder: %v", err)
+			}
+
+			// Без кодировки текст в windows-1251 не читается как UTF-8
+			opts, err := NewImportOptions(";", "", nil, false)
+			if err != nil {
+				t.Fatalf("NewImportOptions(): %v", err)
+			}
+			if _, err = ImportCSV(context.Background(), db, bytes.NewReader(data), opts); err == nil || !strings.Contains(err.Error(), "UTF-8") {
+				t.Fatalf("ImportCSV() without encoding error = %v", err)
+			}
+
+			report := importFileCSV(t, db, data, "cp1251", []string{"Отдел:-"}, false)
+			if report.Total != 1 || report.Imported != 1 || len(report.Rejected) != 0 {
+				t.Fatalf("report = %+v", report)
+			}
+			if !reflect.DeepEqual(report.IgnoredColumns, []string{"Отдел"}) {
+				t.Fatalf("ignored columns = %q", report.IgnoredColumns)
+			}
+			records := getByPhone(t, h, phone)
+			if len(records) != 1 || records[0].Name != "Иван" || records[0].LastName != "Петров" {
+				t.Fatalf("/get imported record = %+v", records)
+			}
+		})
+	}
+}
+
+func TestImportOptions(t *testing.T) {
+	tests := []struct {
+		name                string
+		delimiter, encoding string
+		mapping             []string
+		wantErr             bool
+	}{
+		{"defaults", "", "", nil, false},
+		{"tab and cp1251", "tab", "CP1251", []string{"Имя:name"}, false},
+		{"long delimiter", ";;", "", nil, true},
+		{"quote delimiter", `"`, "", nil, true},
+		{"unknown encoding", "", "koi8-r", nil, true},
+		{"mapping without field", "", "", []string{"Имя"}, true},
+		{"column mapped twice", "", "", []string{"Имя:name", "Имя:last_name"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewImportOptions(tt.delimiter, tt.encoding, tt.mapping, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewImportOptions() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
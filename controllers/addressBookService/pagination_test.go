@@ -0,0 +1,95 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// getPage запрашивает в /get страницу записей с условиями conditions (поля JSON) и токеном cursor.
+func getPage(t *testing.T, h http.Handler, conditions, cursor string) ([]dto.Record, dto.Response) {
+	t.Helper()
+	body := "{" + conditions
+	if cursor != "" {
+		body += fmt.Sprintf(`, "cursor": %q`, cursor)
+	}
+	resp := postV1(t, h, "/get", body+"}")
+	if resp.Result != "OK" {
+		return nil, resp
+	}
+	var records []dto.Record
+	if err := json.Unmarshal(resp.Data, &records); err != nil {
+		t.Fatalf("/get data %s: %v", resp.Data, err)
+	}
+	return records, resp
+}
+
+func TestPagination(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			// Фамилия отделяет записи этого запуска от записей, оставшихся в базе psg
+			lastName := fmt.Sprintf("Страница%d", time.Now().UnixNano())
+			names := []string{"анна", "Борис", "Анна", "Ёлка", "Zoe", "ann", "Анна", "яков"}
+			for i, n := range names {
+				wantV1(t, postV1(t, h, "/create", fmt.Sprintf(`{"name": %q, "last_name": %q, "address": "Москва", "phone": %q}`,
+					n, lastName, testPhone(40+i))), "")
+			}
+
+			// Порядок побайтный в обоих хранилищах: латиница, Ё, прописные, строчные; равные имена - по id
+			want := []string{"Zoe", "ann", "Ёлка", "Анна", "Анна", "Борис", "анна", "яков"}
+			for _, sort := range []string{"name", "-name"} {
+				conditions := fmt.Sprintf(`"last_name": %q, "sort": %q, "limit": 3`, lastName, sort)
+				var got []string
+				var ids []int64
+				cursor := ""
+				for page := 0; ; page++ {
+					records, resp := getPage(t, h, conditions, cursor)
+					wantV1(t, resp, "")
+					for _, r := range records {
+						got = append(got, r.Name)
+						ids = append(ids, r.ID)
+					}
+					if resp.NextCursor == "" {
+						break
+					}
+					if page == len(names) {
+						t.Fatalf("sort %s: too many pages", sort)
+					}
+					cursor = resp.NextCursor
+				}
+
+				wantNames := want
+				if sort == "-name" {
+					wantNames = make([]string, len(want))
+					for i := range want {
+						wantNames[i] = want[len(want)-1-i]
+					}
+				}
+				if !reflect.DeepEqual(got, wantNames) {
+					t.Fatalf("sort %s: names %q, want %q", sort, got, wantNames)
+				}
+				// Два имени "Анна" - четвертое и пятое в обоих направлениях
+				if (sort == "name") != (ids[3] < ids[4]) {
+					t.Fatalf("sort %s: equal names are not ordered by id: %v", sort, ids)
+				}
+			}
+
+			// Токен действует только для тех же условий и сортировки
+			conditions := fmt.Sprintf(`"last_name": %q, "sort": "name", "limit": 3`, lastName)
+			_, resp := getPage(t, h, conditions, "")
+			wantV1(t, resp, "")
+			cursor := resp.NextCursor
+			_, resp = getPage(t, h, fmt.Sprintf(`"last_name": %q, "name": "Анна", "sort": "name", "limit": 3`, lastName), cursor)
+			wantV1(t, resp, "cursor was issued for another filter")
+			_, resp = getPage(t, h, fmt.Sprintf(`"last_name": %q, "sort": "-name", "limit": 3`, lastName), cursor)
+			wantV1(t, resp, "cursor was issued for another sort order")
+			_, resp = getPage(t, h, conditions, "garbage")
+			wantV1(t, resp, "invalid cursor")
+		})
+	}
+}
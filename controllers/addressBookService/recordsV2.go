@@ -0,0 +1,470 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// recordsV2Path - путь к коллекции записей REST API v2.
+// В отличие от обработчиков /create, /get, /update, /delete, API v2 адресует записи по идентификатору,
+// возвращает ресурсы без обертки dto.Response и сообщает результат кодом HTTP:
+//
+//	200 OK, 201 Created, 204 No Content - успех
+//	400 Bad Request - ошибка в данных запроса
+//	404 Not Found - запись не найдена
+//	409 Conflict - номер телефона уже используется или версия записи не совпадает (см. versionV2.go)
+//	412 Precondition Failed, 428 Precondition Required - версия записи в If-Match не совпадает или не передана
+//	500 Internal Server Error - внутренняя ошибка
+//	504 Gateway Timeout - истекло время выполнения запроса к хранилищу
+//
+// Тело ответа с ошибкой: {"error": "error description"}
+const recordsV2Path = "/v2/records"
+
+// defaultLimitV2 - количество записей на странице GET /v2/records, если limit не указан.
+const defaultLimitV2 = 100
+
+// recordsV2Handler обрабатывает запросы к коллекции записей
+/*
+GET /v2/records - список записей. Необязательные параметры запроса name, last_name, middle_name, address, phone,
+email (можно повторять), birthday, organization, job_title, notes и custom.{key} (дополнительные поля)
+задают условия выборки (точное совпадение). Параметр tag (можно повторять) оставляет записи со всеми указанными
+тегами, any_tag (можно повторять) - хотя бы с одним из них. Параметры created_after, created_before, updated_after
+и updated_before (RFC 3339) ограничивают время создания и изменения записи, как в /get, as_of - состояние записей на момент времени (как в /get). Параметр filter - структурированное условие в формате JSON
+(как в /get, см. dto.Filter), параметр q - строка полнотекстового и нечеткого поиска по всем полям записи
+(как в /get). Постраничная выборка: limit (по умолчанию 100, не больше 1000),
+sort (id, name, last_name, city, postal_index, с префиксом "-" - по убыванию, или relevance при поиске), cursor (токен следующей страницы), with_total=true.
+Токен следующей страницы возвращается в заголовках X-Next-Cursor и Link (rel="next"),
+общее количество записей - в заголовке X-Total-Count. Возвращает 200 и массив записей:
+  [{"id": 1, "name": "Имя", "last_name": "Фамилия", "middle_name": "Отчество", "address": "Адрес", "phone": "Телефон",
+    "phones": [{"number": "Телефон", "type": "mobile", "primary": true}]}]
+
+POST /v2/records - создание записи. Тело запроса (обязательны все поля, кроме middle_name):
+  {"name": "Имя", "last_name": "Фамилия", "middle_name": "Отчество", "address": "Адрес", "phone": "Телефон"}
+Вместо phone можно передать список номеров phones, вместо address - список адресов addresses (как в /create),
+необязательные поля emails, birthday, organization, job_title, notes и custom - как в /create.
+
+Возвращает 201, заголовок Location: /v2/records/{id}, заголовок ETag и созданную запись.
+*/
+func (abs *AddressBookService) recordsV2Handler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) recordsV2Handler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) recordsV2Handler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	switch req.Method {
+	case http.MethodGet:
+		abs.listRecordsV2(w, req, nil, false, wErr)
+	case http.MethodPost:
+		abs.createRecordV2(w, req, wErr)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// recordV2Handler обрабатывает запросы к отдельной записи /v2/records/{id}
+/*
+GET /v2/records/{id} - возвращает 200, запись и ее версию в заголовке ETag.
+
+PUT, PATCH и DELETE требуют версию записи, прочитанную клиентом: заголовок If-Match со значением ETag
+или поле version в теле (см. versionV2.go). Если запись успели изменить, возвращают 412 (If-Match) или 409 (version)
+и текущее состояние записи, если версия не передана - 428.
+
+PUT /v2/records/{id} - полная замена записи, включая номер телефона. Тело как при создании. Возвращает 200 и запись.
+
+PATCH /v2/records/{id} - изменение записи по правилам JSON Merge Patch (RFC 7396), Content-Type application/merge-patch+json
+или application/json. Отсутствующие поля не изменяются, null очищает поле (нельзя очистить name, last_name и phone),
+хотя бы одно поле обязательно. Можно изменить и номер телефона. Объект custom объединяется с текущими
+дополнительными полями (null в значении поля удаляет его). Возвращает 200 и запись после изменения:
+  {"address": "Новый адрес", "middle_name": null}
+
+DELETE /v2/records/{id} - удаление записи в корзину (см. /v2/trash). Версию можно передать в теле {"version": 3}.
+Возвращает 204 без тела.
+
+PUT и DELETE /v2/records/{id}/tags/{tag_id} - добавление записи в тег и исключение из него (см. recordTagV2).
+
+GET /v2/records/{id}/history - история изменений записи (см. recordHistoryV2).
+
+GET /v2/records/{id}/vcard - карточка записи в формате vCard (см. recordVCardV2).
+*/
+func (abs *AddressBookService) recordV2Handler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) recordV2Handler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) recordV2Handler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	rest := strings.TrimPrefix(req.URL.Path, recordsV2Path+"/")
+	rest, sub, isSub := strings.Cut(rest, "/")
+	tagID, isTag := strings.CutPrefix(sub, "tags/")
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || id <= 0 || (isSub && !isTag && sub != "history" && sub != "vcard") {
+		writeErrorV2(w, http.StatusNotFound, dto.ErrRecordNotFound, wErr)
+		return
+	}
+	switch {
+	case isTag:
+		abs.recordTagV2(w, req, id, tagID, wErr)
+		return
+	case sub == "vcard":
+		abs.recordVCardV2(w, req, id, wErr)
+		return
+	case isSub:
+		abs.recordHistoryV2(w, req, id, wErr)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		abs.getRecordV2(w, req, id, wErr)
+	case http.MethodPut:
+		abs.replaceRecordV2(w, req, id, wErr)
+	case http.MethodPatch:
+		abs.patchRecordV2(w, req, id, wErr)
+	case http.MethodDelete:
+		abs.deleteRecordV2(w, req, id, wErr)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, PATCH, DELETE, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// listRecordsV2 отправляет страницу записей по параметрам URL; условие extra (если не nil) добавляется через AND.
+// При trash выбираются записи из корзины.
+func (abs *AddressBookService) listRecordsV2(w http.ResponseWriter, req *http.Request, extra *dto.Filter, trash bool, wErr *pkg.WrappedError) {
+	q, err := queryFromURLV2(req.URL.Query())
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, err, wErr)
+		return
+	}
+	q.Filter = dto.AndFilters(extra, q.Filter)
+	q.Trash = trash
+	if q.Limit == 0 {
+		q.Limit = defaultLimitV2
+	}
+
+	page, err := abs.db.GetRecords(req.Context(), q)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+	if page.Records == nil {
+		page.Records = []dto.Record{}
+	}
+
+	if page.NextCursor != "" {
+		next := req.URL.Query()
+		next.Set("cursor", page.NextCursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, req.URL.Path, next.Encode()))
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	if page.Total != nil {
+		w.Header().Set("X-Total-Count", strconv.FormatInt(*page.Total, 10))
+	}
+
+	writeJSONV2(w, http.StatusOK, page.Records, wErr)
+}
+
+// queryFromURLV2 строит параметры выборки по параметрам URL: условия на точное совпадение полей,
+// структурированное условие filter (JSON) и параметры страницы sort, limit, cursor, with_total.
+func queryFromURLV2(values url.Values) (q dto.Query, err error) {
+	cond := dto.Record{
+		Name:         values.Get("name"),
+		LastName:     values.Get("last_name"),
+		MiddleName:   values.Get("middle_name"),
+		Address:      values.Get("address"),
+		Phone:        values.Get("phone"),
+		Birthday:     values.Get("birthday"),
+		Organization: values.Get("organization"),
+		JobTitle:     values.Get("job_title"),
+		Notes:        values.Get("notes"),
+	}
+	for _, email := range values["email"] {
+		cond.Emails = append(cond.Emails, strings.ToLower(strings.TrimSpace(email)))
+	}
+	cond.Tags = values["tag"]
+	for param := range values {
+		if key, ok := strings.CutPrefix(param, dto.CustomFieldPrefix); ok {
+			if cond.Custom == nil {
+				cond.Custom = map[string]any{}
+			}
+			cond.Custom[key] = values.Get(param)
+		}
+	}
+	if cond.Phone != "" {
+		if err = normalizeRecordPhone(&cond); err != nil {
+			return q, err
+		}
+	}
+
+	var filter *dto.Filter
+	if values.Get("filter") != "" {
+		filter = &dto.Filter{}
+		err = json.Unmarshal([]byte(values.Get("filter")), filter)
+		if err == nil {
+			err = normalizeFilter(filter)
+		}
+		if err != nil {
+			return q, &dto.ValidationError{Msg: "filter: " + err.Error()}
+		}
+	}
+
+	if values.Get("limit") != "" {
+		q.Limit, err = strconv.Atoi(values.Get("limit"))
+		if err != nil {
+			return q, &dto.ValidationError{Msg: "limit must be a number"}
+		}
+	}
+	if values.Get("with_total") != "" {
+		q.WithTotal, err = strconv.ParseBool(values.Get("with_total"))
+		if err != nil {
+			return q, &dto.ValidationError{Msg: "with_total must be a boolean"}
+		}
+	}
+	changed := dto.TimeRange{
+		CreatedAfter:  values.Get("created_after"),
+		CreatedBefore: values.Get("created_before"),
+		UpdatedAfter:  values.Get("updated_after"),
+		UpdatedBefore: values.Get("updated_before"),
+	}
+	q.Filter = dto.AndFilters(dto.RecordFilter(cond), dto.AnyTagFilter(values["any_tag"]), changed.Filter(), filter)
+	q.AsOf, err = dto.ParseAsOf(values.Get("as_of"))
+	if err != nil {
+		return q, err
+	}
+	q.Search = values.Get("q")
+	q.Sort = values.Get("sort")
+	q.Cursor = values.Get("cursor")
+
+	return q, nil
+}
+
+func (abs *AddressBookService) createRecordV2(w http.ResponseWriter, req *http.Request, wErr *pkg.WrappedError) {
+	record, err := decodeRecordV2(req)
+	if err == nil {
+		err = prepareNewRecord(&record)
+	}
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, err, wErr)
+		return
+	}
+	err = abs.prepareCustom(req.Context(), &record)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+	record.ID = 0
+
+	record.ID, err = abs.db.SaveRecord(req.Context(), record)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+
+	// Время создания устанавливает хранилище, поэтому запись перечитывается
+	record, err = abs.recordByID(req, record.ID)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", recordsV2Path, record.ID))
+	writeRecordV2(w, http.StatusCreated, record, wErr)
+}
+
+func (abs *AddressBookService) getRecordV2(w http.ResponseWriter, req *http.Request, id int64, wErr *pkg.WrappedError) {
+	record, err := abs.recordByID(req, id)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+
+	writeRecordV2(w, http.StatusOK, record, wErr)
+}
+
+func (abs *AddressBookService) replaceRecordV2(w http.ResponseWriter, req *http.Request, id int64, wErr *pkg.WrappedError) {
+	record, err := decodeRecordV2(req)
+	if err == nil {
+		err = prepareNewRecord(&record)
+	}
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, err, wErr)
+		return
+	}
+	err = abs.prepareCustom(req.Context(), &record)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+	record.ID = id
+
+	pre, err := preconditionV2(req, record.Version)
+	if err == nil {
+		record.Version = pre.version
+		err = abs.db.ReplaceRecord(req.Context(), record)
+	}
+	if err != nil {
+		abs.writeChangeErrorV2(w, req, id, pre, err, wErr)
+		return
+	}
+
+	// Теги, время создания и версия при замене устанавливаются хранилищем, поэтому запись перечитывается
+	record, err = abs.recordByID(req, id)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+
+	writeRecordV2(w, http.StatusOK, record, wErr)
+}
+
+func (abs *AddressBookService) patchRecordV2(w http.ResponseWriter, req *http.Request, id int64, wErr *pkg.WrappedError) {
+	patch := dto.RecordPatch{}
+	err := decodeJSONV2(req, &patch)
+	if err == nil {
+		err = preparePatch(&patch)
+	}
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, err, wErr)
+		return
+	}
+	err = abs.prepareCustomPatch(req.Context(), &patch)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+
+	pre, err := preconditionV2(req, patch.Version)
+	if err == nil {
+		patch.Version = pre.version
+		err = abs.db.UpdateRecordByID(req.Context(), id, patch)
+	}
+	if err != nil {
+		abs.writeChangeErrorV2(w, req, id, pre, err, wErr)
+		return
+	}
+
+	record, err := abs.recordByID(req, id)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+
+	writeRecordV2(w, http.StatusOK, record, wErr)
+}
+
+func (abs *AddressBookService) deleteRecordV2(w http.ResponseWriter, req *http.Request, id int64, wErr *pkg.WrappedError) {
+	// Тело необязательно: версию можно передать в If-Match
+	var body struct {
+		Version int64 `json:"version"`
+	}
+	if req.ContentLength != 0 {
+		if err := decodeJSONV2(req, &body); err != nil {
+			writeErrorV2(w, http.StatusBadRequest, err, wErr)
+			return
+		}
+	}
+
+	pre, err := preconditionV2(req, body.Version)
+	if err == nil {
+		err = abs.db.DeleteRecordByID(req.Context(), id, pre.version)
+	}
+	if err != nil {
+		abs.writeChangeErrorV2(w, req, id, pre, err, wErr)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recordByID возвращает запись с идентификатором id или dto.ErrRecordNotFound.
+func (abs *AddressBookService) recordByID(req *http.Request, id int64) (dto.Record, error) {
+	page, err := abs.db.GetRecords(req.Context(), dto.Query{Filter: dto.RecordFilter(dto.Record{ID: id})})
+	if err != nil {
+		return dto.Record{}, err
+	}
+	if len(page.Records) == 0 {
+		return dto.Record{}, dto.ErrRecordNotFound
+	}
+	return page.Records[0], nil
+}
+
+// decodeRecordV2 читает запись из тела запроса в формате JSON.
+func decodeRecordV2(req *http.Request) (record dto.Record, err error) {
+	err = decodeJSONV2(req, &record)
+	return record, err
+}
+
+// decodeJSONV2 читает тело запроса в формате JSON в v.
+func decodeJSONV2(req *http.Request, v any) error {
+	byteReq, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(byteReq, v)
+	if err != nil {
+		return &dto.ValidationError{Msg: err.Error()}
+	}
+	return nil
+}
+
+// statusForError возвращает код HTTP, соответствующий ошибке хранилища или проверки данных.
+func statusForError(err error) int {
+	var validationErr *dto.ValidationError
+	switch {
+	case errors.As(err, &validationErr), errors.Is(err, dto.ErrNothingToUpdate):
+		return http.StatusBadRequest
+	case errors.Is(err, dto.ErrRecordNotFound), errors.Is(err, dto.ErrPhoneNotFound), errors.Is(err, dto.ErrCustomFieldNotFound),
+		errors.Is(err, dto.ErrTagNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, dto.ErrPhoneInUse), errors.Is(err, dto.ErrCustomFieldExists), errors.Is(err, dto.ErrTagExists),
+		errors.Is(err, dto.ErrVersionMismatch):
+		return http.StatusConflict
+	case errors.Is(err, errVersionRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, dto.ErrTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeErrorV2 отправляет клиенту ошибку с кодом status. Текст внутренних ошибок клиенту не раскрывается.
+func writeErrorV2(w http.ResponseWriter, status int, err error, wErr *pkg.WrappedError) {
+	msg := err.Error()
+	if status == http.StatusInternalServerError {
+		wErr.Specify(err, "storage").LogError()
+		msg = "internal server error"
+	} else {
+		wErr.LogMsg(fmt.Sprintf("%d: %s", status, msg))
+	}
+
+	writeJSONV2(w, status, dto.ErrorResponse{Error: msg}, wErr)
+}
+
+// writeJSONV2 отправляет клиенту v в формате JSON с кодом status.
+func writeJSONV2(w http.ResponseWriter, status int, v any, wErr *pkg.WrappedError) {
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		wErr.Specify(err, "json.NewEncoder(w).Encode(v)").LogError()
+	}
+}
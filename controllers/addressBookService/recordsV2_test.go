@@ -0,0 +1,147 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// doV2 отправляет обработчику h запрос API v2 с заголовком If-Match (если ifMatch не пуст) и телом body.
+func doV2(t *testing.T, h http.Handler, method, path, ifMatch, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// wantV2 проверяет код ответа и заголовок ETag (если etag не пуст) ответа API v2.
+func wantV2(t *testing.T, rec *httptest.ResponseRecorder, status int, etag string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status %d (%s), want %d", rec.Code, rec.Body.String(), status)
+	}
+	if etag != "" && rec.Header().Get("ETag") != etag {
+		t.Fatalf("ETag %s, want %s", rec.Header().Get("ETag"), etag)
+	}
+}
+
+func TestRecordV2Preconditions(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+
+			resp := doV2(t, h, http.MethodPost, recordsV2Path, "",
+				fmt.Sprintf(`{"name": "Иван", "last_name": "Петров", "address": "Москва", "phone": %q}`, testPhone(11)))
+			wantV2(t, resp, http.StatusCreated, `"1"`)
+			var created dto.Record
+			if err := json.Unmarshal(resp.Body.Bytes(), &created); err != nil {
+				t.Fatalf("POST %s: %v", recordsV2Path, err)
+			}
+			path := fmt.Sprintf("%s/%d", recordsV2Path, created.ID)
+			if resp.Header().Get("Location") != path {
+				t.Fatalf("Location %q, want %q", resp.Header().Get("Location"), path)
+			}
+			wantV2(t, doV2(t, h, http.MethodGet, path, "", ""), http.StatusOK, `"1"`)
+
+			// Без версии - 428, с текущей версией - изменение и новый ETag
+			wantV2(t, doV2(t, h, http.MethodPatch, path, "", `{"notes": "x"}`), http.StatusPreconditionRequired, "")
+			wantV2(t, doV2(t, h, http.MethodPatch, path, `"1"`, `{"notes": "x"}`), http.StatusOK, `"2"`)
+
+			// Устаревшая версия: 412 для If-Match, 409 для поля version, в ответе текущая запись
+			resp = doV2(t, h, http.MethodPatch, path, `"1"`, `{"notes": "y"}`)
+			wantV2(t, resp, http.StatusPreconditionFailed, `"2"`)
+			var conflict dto.ErrorResponse
+			if err := json.Unmarshal(resp.Body.Bytes(), &conflict); err != nil || conflict.Current == nil ||
+				conflict.Current.Version != 2 || conflict.Current.Notes != "x" {
+				t.Fatalf("412 body %s: %v", resp.Body.String(), err)
+			}
+			wantV2(t, doV2(t, h, http.MethodPatch, path, "", `{"version": 1, "notes": "y"}`), http.StatusConflict, `"2"`)
+			wantV2(t, doV2(t, h, http.MethodPatch, path, "2", `{"notes": "y"}`), http.StatusPreconditionFailed, `"2"`)
+			wantV2(t, doV2(t, h, http.MethodPut, path, `"1"`,
+				fmt.Sprintf(`{"name": "Иван", "last_name": "Петров", "address": "Москва", "phone": %q}`, created.Phone)),
+				http.StatusPreconditionFailed, `"2"`)
+
+			// If-Match: * - без проверки версии
+			wantV2(t, doV2(t, h, http.MethodPatch, path, "*", `{"notes": "z"}`), http.StatusOK, `"3"`)
+
+			wantV2(t, doV2(t, h, http.MethodDelete, path, "", ""), http.StatusPreconditionRequired, "")
+			wantV2(t, doV2(t, h, http.MethodDelete, path, `"2"`, ""), http.StatusPreconditionFailed, `"3"`)
+			wantV2(t, doV2(t, h, http.MethodDelete, path, `"3"`, ""), http.StatusNoContent, "")
+			wantV2(t, doV2(t, h, http.MethodGet, path, "", ""), http.StatusNotFound, "")
+		})
+	}
+}
+
+func TestRecordsAsOf(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+
+			// Паузы разводят время изменений, чтобы моменты между ними были однозначны
+			a := createV2(t, h, testPhone(12))
+			path := fmt.Sprintf("%s/%d", recordsV2Path, a.ID)
+			time.Sleep(time.Millisecond)
+			resp := doV2(t, h, http.MethodPatch, path, "*", `{"notes": "Перезвонить"}`)
+			wantV2(t, resp, http.StatusOK, `"2"`)
+			var changed dto.Record
+			if err := json.Unmarshal(resp.Body.Bytes(), &changed); err != nil {
+				t.Fatalf("PATCH %s: %v", path, err)
+			}
+			time.Sleep(time.Millisecond)
+			b := createV2(t, h, testPhone(13))
+			time.Sleep(time.Millisecond)
+			wantV2(t, doV2(t, h, http.MethodDelete, fmt.Sprintf("%s/%d", recordsV2Path, b.ID), "*", ""), http.StatusNoContent, "")
+			time.Sleep(time.Millisecond)
+			deleted := time.Now()
+			time.Sleep(time.Millisecond)
+			wantV2(t, doV2(t, h, http.MethodDelete, fmt.Sprintf("%s/%d", trashV2Path, b.ID), "", ""), http.StatusNoContent, "")
+
+			// asOf возвращает записи a и b на момент at: номер версии a (0 - записи нет) и есть ли b
+			asOf := func(at time.Time) (int64, bool) {
+				t.Helper()
+				filter := fmt.Sprintf(`{"field": "id", "op": "in", "values": [%d, %d]}`, a.ID, b.ID)
+				var version int64
+				var hasB bool
+				for _, rec := range listV2(t, h, url.Values{"as_of": {at.Format(time.RFC3339Nano)}, "filter": {filter}}) {
+					switch rec.ID {
+					case a.ID:
+						version = rec.Version
+						if (version == 1) != (rec.Notes == "") {
+							t.Fatalf("as_of %s: record %+v", at, rec)
+						}
+					case b.ID:
+						hasB = true
+					}
+				}
+				return version, hasB
+			}
+
+			for _, tt := range []struct {
+				at          time.Time
+				wantVersion int64
+				wantB       bool
+			}{
+				{a.CreatedAt.Add(-time.Microsecond), 0, false},
+				{a.CreatedAt, 1, false},
+				{changed.UpdatedAt, 2, false},
+				{b.CreatedAt, 2, true},
+				{deleted, 2, false},
+				{time.Now(), 2, false},
+			} {
+				if version, hasB := asOf(tt.at); version != tt.wantVersion || hasB != tt.wantB {
+					t.Errorf("as_of %s: version %d, b %t, want %d, %t", tt.at.Format(time.RFC3339Nano), version, hasB, tt.wantVersion, tt.wantB)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"context"
+	"time"
+)
+
+// Storage описывает хранилище записей адресной книги, с которым работает AddressBookService.
+// Реализации: psg.Psg (PostgreSQL) и memory.Memory (в памяти, для демо и тестов без базы данных).
+type Storage interface {
+	// SaveRecord сохраняет новую запись и возвращает ее идентификатор; занятый номер - dto.ErrPhoneInUse.
+	// Каждое создание, изменение и удаление записи (в том числе через теги и удаление дополнительного поля)
+	// записывается в историю изменений в той же транзакции, с автором и запросом из dto.ChangeMetaFrom(ctx).
+	// Все методы прерываются отменой ctx, а истечение дедлайна возвращают как dto.ErrTimeout.
+	SaveRecord(ctx context.Context, rec dto.Record) (id int64, err error)
+	// GetRecords возвращает страницу записей по условиям q; записи из корзины - только при q.Trash.
+	// Некорректные условие, сортировка или токен страницы возвращаются как *dto.ValidationError,
+	// объявления дополнительных полей для условий на них (q.CustomFields) загружаются самим методом.
+	GetRecords(ctx context.Context, q dto.Query) (dto.RecordsPage, error)
+	// ExportRecords передает fn по одной все записи по условиям q (без постраничной выборки) в порядке q.Sort,
+	// не загружая их все в память; ошибка fn прерывает выгрузку и возвращается.
+	ExportRecords(ctx context.Context, q dto.Query, fn func(dto.Record) error) error
+	// UpdateRecord изменяет запись с номером phone; нет такого номера - dto.ErrPhoneNotFound, новый номер занят -
+	// dto.ErrPhoneInUse, patch.Version не совпадает с версией записи - dto.ErrVersionMismatch.
+	UpdateRecord(ctx context.Context, phone string, patch dto.RecordPatch) error
+	// DeleteRecordByPhone перемещает в корзину запись с номером phone (dto.ErrPhoneNotFound, dto.ErrVersionMismatch).
+	DeleteRecordByPhone(ctx context.Context, phone string, version int64) error
+	// PhoneExists возвращает dto.ErrPhoneInUse, если номер phone есть у записи не из корзины.
+	PhoneExists(ctx context.Context, phone string) error
+
+	// ReplaceRecord заменяет запись rec.ID целиком (dto.ErrRecordNotFound, dto.ErrPhoneInUse).
+	// Версия записи растет при каждом ее изменении, включая теги, удаление и восстановление. Изменение и удаление
+	// принимают ожидаемую версию (rec.Version, patch.Version, version): если она не 0 и не совпадает с текущей,
+	// запись не изменяется и возвращается dto.ErrVersionMismatch.
+	ReplaceRecord(ctx context.Context, rec dto.Record) error
+	// UpdateRecordByID изменяет запись id (ошибки те же, что у ReplaceRecord).
+	UpdateRecordByID(ctx context.Context, id int64, patch dto.RecordPatch) error
+	// DeleteRecordByID перемещает запись id в корзину (dto.ErrRecordNotFound, dto.ErrVersionMismatch).
+	DeleteRecordByID(ctx context.Context, id, version int64) error
+
+	// Batch выполняет пакет операций создания, изменения и удаления в одной транзакции и возвращает результат
+	// каждой операции с той же ошибкой, что и одиночный метод; ошибка самого Batch - не выполнена ни одна операция.
+	Batch(ctx context.Context, batch dto.BatchOps) ([]dto.BatchOutcome, error)
+	// ImportRecords сохраняет проверенные записи импорта одной транзакцией и возвращает ошибку каждой записи:
+	// dto.ErrPhoneInUse, если номер занят другой записью или более ранней записью импорта (такие записи пропускаются).
+	// При dryRun записи только проверяются; ошибка самого ImportRecords - не сохранена ни одна запись.
+	ImportRecords(ctx context.Context, recs []dto.Record, dryRun bool) ([]error, error)
+
+	// CustomFields возвращает объявления дополнительных полей.
+	CustomFields(ctx context.Context) ([]dto.CustomFieldDef, error)
+	// CreateCustomField объявляет поле; поле уже объявлено - dto.ErrCustomFieldExists.
+	CreateCustomField(ctx context.Context, def dto.CustomFieldDef) error
+	// UpdateCustomField изменяет объявление; поля нет - dto.ErrCustomFieldNotFound.
+	UpdateCustomField(ctx context.Context, def dto.CustomFieldDef) error
+	// DeleteCustomField удаляет объявление и значения поля во всех записях (dto.ErrCustomFieldNotFound).
+	DeleteCustomField(ctx context.Context, key string) error
+
+	// Tags возвращает теги с количеством записей.
+	Tags(ctx context.Context) ([]dto.Tag, error)
+	// CreateTag создает тег; имя занято без учета регистра - dto.ErrTagExists.
+	CreateTag(ctx context.Context, name string) (dto.Tag, error)
+	// RenameTag переименовывает тег (dto.ErrTagNotFound, dto.ErrTagExists).
+	RenameTag(ctx context.Context, id int64, name string) error
+	// DeleteTag удаляет тег (dto.ErrTagNotFound).
+	DeleteTag(ctx context.Context, id int64) error
+	// AttachTag добавляет запись в тег (dto.ErrTagNotFound, dto.ErrRecordNotFound).
+	AttachTag(ctx context.Context, recordID, tagID int64) error
+	// DetachTag убирает запись из тега (dto.ErrTagNotFound, dto.ErrRecordNotFound).
+	DetachTag(ctx context.Context, recordID, tagID int64) error
+
+	// History возвращает историю изменений по условиям q от новых изменений к старым.
+	History(ctx context.Context, q dto.HistoryQuery) (dto.HistoryPage, error)
+	// SyncToken возвращает отметку синхронизации: изменения, которых не видно на момент вызова, попадут в историю
+	// с отметкой не меньше возвращенной (выборка по q.SyncFrom), даже если зафиксированы в другом порядке.
+	SyncToken(ctx context.Context) (int64, error)
+
+	// CardDAVNames возвращает имена ресурсов CardDAV, выбранные клиентами, всех записей, включая удаленные.
+	CardDAVNames(ctx context.Context) (map[int64]string, error)
+	// SaveCardDAVRecord сохраняет запись, созданную клиентом CardDAV, вместе с именем ресурса name: имя удаленной
+	// записи переходит новой записи, имя другой записи - dto.ErrCardDAVNameInUse.
+	SaveCardDAVRecord(ctx context.Context, rec dto.Record, name string) (id int64, err error)
+
+	// RestoreRecord восстанавливает запись из корзины; записи нет в корзине - dto.ErrRecordNotFound,
+	// номер уже занят - dto.ErrPhoneInUse. Номера записей в корзине могут быть заняты другими записями.
+	RestoreRecord(ctx context.Context, id int64) error
+	// PurgeRecord окончательно удаляет запись из корзины (dto.ErrRecordNotFound).
+	PurgeRecord(ctx context.Context, id int64) error
+	// PurgeTrash окончательно удаляет записи, перемещенные в корзину раньше before, и возвращает их количество.
+	PurgeTrash(ctx context.Context, before time.Time) (n int64, err error)
+}
@@ -0,0 +1,215 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// tagsV2Path - путь к тегам (группам) записей: отделам, проектам и т.п.
+// Запись может входить в любое количество тегов, имена тегов уникальны без учета регистра.
+// Коды ответов те же, что у /v2/records; 404 - тег не найден, 409 - тег с таким именем уже есть.
+const tagsV2Path = "/v2/tags"
+
+// tagsV2Handler обрабатывает запросы к списку тегов
+/*
+GET /v2/tags - возвращает 200 и теги в порядке имен с количеством записей count:
+  [{"id": 1, "name": "Бухгалтерия", "count": 12}]
+
+POST /v2/tags - создание тега:
+  {"name": "Бухгалтерия"}
+Возвращает 201, заголовок Location: /v2/tags/{id} и тег.
+*/
+func (abs *AddressBookService) tagsV2Handler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) tagsV2Handler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) tagsV2Handler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	switch req.Method {
+	case http.MethodGet:
+		tags, err := abs.db.Tags(req.Context())
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		if tags == nil {
+			tags = []dto.Tag{}
+		}
+		writeJSONV2(w, http.StatusOK, tags, wErr)
+	case http.MethodPost:
+		name, err := decodeTagNameV2(req)
+		if err != nil {
+			writeErrorV2(w, http.StatusBadRequest, err, wErr)
+			return
+		}
+		tag, err := abs.db.CreateTag(req.Context(), name)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("%s/%d", tagsV2Path, tag.ID))
+		writeJSONV2(w, http.StatusCreated, tag, wErr)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// tagV2Handler обрабатывает запросы к тегу /v2/tags/{id} и к списку его записей /v2/tags/{id}/records
+/*
+GET /v2/tags/{id} - возвращает 200 и тег.
+
+PATCH /v2/tags/{id} - переименование тега (записи остаются в теге). Тело как при создании. Возвращает 200 и тег.
+
+DELETE /v2/tags/{id} - удаление тега; записи не удаляются, а только исключаются из него. Возвращает 204 без тела.
+
+GET /v2/tags/{id}/records - записи с тегом. Параметры запроса и ответ те же, что у GET /v2/records.
+*/
+func (abs *AddressBookService) tagV2Handler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) tagV2Handler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) tagV2Handler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	rest, sub, isSub := strings.Cut(strings.TrimPrefix(req.URL.Path, tagsV2Path+"/"), "/")
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || id <= 0 || (isSub && sub != "records") {
+		writeErrorV2(w, http.StatusNotFound, dto.ErrTagNotFound, wErr)
+		return
+	}
+
+	if isSub {
+		abs.tagRecordsV2(w, req, id, wErr)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		tag, err := abs.tag(req.Context(), id)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		writeJSONV2(w, http.StatusOK, tag, wErr)
+	case http.MethodPatch:
+		name, err := decodeTagNameV2(req)
+		if err != nil {
+			writeErrorV2(w, http.StatusBadRequest, err, wErr)
+			return
+		}
+		err = abs.db.RenameTag(req.Context(), id, name)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		tag, err := abs.tag(req.Context(), id)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		writeJSONV2(w, http.StatusOK, tag, wErr)
+	case http.MethodDelete:
+		err = abs.db.DeleteTag(req.Context(), id)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PATCH, DELETE, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// tagRecordsV2 отправляет записи с тегом id (GET /v2/tags/{id}/records).
+func (abs *AddressBookService) tagRecordsV2(w http.ResponseWriter, req *http.Request, id int64, wErr *pkg.WrappedError) {
+	switch req.Method {
+	case http.MethodGet:
+		tag, err := abs.tag(req.Context(), id)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		abs.listRecordsV2(w, req, &dto.Filter{Field: dto.TagFilterField, Op: dto.OpEq, Value: tag.Name}, false, wErr)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// recordTagV2 обрабатывает запросы к принадлежности записи тегу /v2/records/{id}/tags/{tag_id}
+/*
+PUT /v2/records/{id}/tags/{tag_id} - добавление записи в тег (повторное добавление не считается ошибкой).
+
+DELETE /v2/records/{id}/tags/{tag_id} - исключение записи из тега.
+
+Возвращают 204 без тела, 404 - если нет записи или тега.
+*/
+func (abs *AddressBookService) recordTagV2(w http.ResponseWriter, req *http.Request, id int64, tagID string, wErr *pkg.WrappedError) {
+	tag, err := strconv.ParseInt(tagID, 10, 64)
+	if err != nil || tag <= 0 {
+		writeErrorV2(w, http.StatusNotFound, dto.ErrTagNotFound, wErr)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPut:
+		err = abs.db.AttachTag(req.Context(), id, tag)
+	case http.MethodDelete:
+		err = abs.db.DetachTag(req.Context(), id, tag)
+	case http.MethodOptions:
+	default:
+		w.Header().Set("Allow", "PUT, DELETE, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+		return
+	}
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tag возвращает тег id или dto.ErrTagNotFound.
+func (abs *AddressBookService) tag(ctx context.Context, id int64) (dto.Tag, error) {
+	tags, err := abs.db.Tags(ctx)
+	if err != nil {
+		return dto.Tag{}, err
+	}
+	for _, t := range tags {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return dto.Tag{}, dto.ErrTagNotFound
+}
+
+// decodeTagNameV2 читает из тела запроса имя тега {"name": "..."} и нормализует его (dto.NormalizeTagName).
+func decodeTagNameV2(req *http.Request) (string, error) {
+	body := struct {
+		Name string `json:"name"`
+	}{}
+	if err := decodeJSONV2(req, &body); err != nil {
+		return "", err
+	}
+	return dto.NormalizeTagName(body.Name)
+}
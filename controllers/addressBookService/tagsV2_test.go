@@ -0,0 +1,85 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// getRecordV2 возвращает запись id из GET /v2/records/{id}.
+func getRecordV2(t *testing.T, h http.Handler, id int64) dto.Record {
+	t.Helper()
+	resp := doV2(t, h, http.MethodGet, fmt.Sprintf("%s/%d", recordsV2Path, id), "", "")
+	wantV2(t, resp, http.StatusOK, "")
+
+	var rec dto.Record
+	if err := json.Unmarshal(resp.Body.Bytes(), &rec); err != nil {
+		t.Fatalf("GET record %d: %v", id, err)
+	}
+	return rec
+}
+
+func TestTags(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			tagName := "Отдел " + testPhone(31)
+
+			resp := doV2(t, h, http.MethodPost, tagsV2Path, "", fmt.Sprintf(`{"name": %q}`, tagName))
+			wantV2(t, resp, http.StatusCreated, "")
+			var tag dto.Tag
+			if err := json.Unmarshal(resp.Body.Bytes(), &tag); err != nil {
+				t.Fatalf("POST %s: %v", tagsV2Path, err)
+			}
+			tagPath := fmt.Sprintf("%s/%d", tagsV2Path, tag.ID)
+			wantV2(t, doV2(t, h, http.MethodPost, tagsV2Path, "", fmt.Sprintf(`{"name": %q}`, "отдел "+tagName[len("Отдел "):])),
+				http.StatusConflict, "")
+
+			// Добавление в тег изменяет версию записи, повторное добавление ничего не меняет
+			a, b := createV2(t, h, testPhone(32)), createV2(t, h, testPhone(33))
+			for _, rec := range []dto.Record{a, b, a} {
+				wantV2(t, doV2(t, h, http.MethodPut, fmt.Sprintf("%s/%d/tags/%d", recordsV2Path, rec.ID, tag.ID), "", ""),
+					http.StatusNoContent, "")
+			}
+			wantV2(t, doV2(t, h, http.MethodPut, fmt.Sprintf("%s/%d/tags/%d", recordsV2Path, a.ID, tag.ID+1_000_000), "", ""),
+				http.StatusNotFound, "")
+			if rec := getRecordV2(t, h, a.ID); rec.Version != 2 || !reflect.DeepEqual(rec.Tags, []string{tagName}) {
+				t.Fatalf("record after attach = %+v", rec)
+			}
+
+			resp = doV2(t, h, http.MethodGet, tagPath+"/records", "", "")
+			wantV2(t, resp, http.StatusOK, "")
+			var members []dto.Record
+			if err := json.Unmarshal(resp.Body.Bytes(), &members); err != nil || len(members) != 2 {
+				t.Fatalf("GET %s/records = %s, %v", tagPath, resp.Body.String(), err)
+			}
+
+			// Переименование тега - изменение всех его записей с историей
+			renamed := tagName + " (архив)"
+			wantV2(t, doV2(t, h, http.MethodPatch, tagPath, "", fmt.Sprintf(`{"name": %q}`, renamed)), http.StatusOK, "")
+			for _, id := range []int64{a.ID, b.ID} {
+				if rec := getRecordV2(t, h, id); rec.Version != 3 || !reflect.DeepEqual(rec.Tags, []string{renamed}) {
+					t.Fatalf("record after rename = %+v", rec)
+				}
+			}
+			page, err := db.History(context.Background(), dto.HistoryQuery{RecordID: b.ID})
+			if err != nil || len(page.Entries) == 0 || page.Entries[0].Action != dto.ActionUpdate ||
+				!reflect.DeepEqual(page.Entries[0].Before.Tags, []string{tagName}) || !reflect.DeepEqual(page.Entries[0].After.Tags, []string{renamed}) {
+				t.Fatalf("History() after rename = %+v, %v", page.Entries, err)
+			}
+
+			// Удаление тега исключает из него записи
+			wantV2(t, doV2(t, h, http.MethodDelete, tagPath, "", ""), http.StatusNoContent, "")
+			if rec := getRecordV2(t, h, a.ID); rec.Version != 4 || len(rec.Tags) != 0 {
+				t.Fatalf("record after tag delete = %+v", rec)
+			}
+			wantV2(t, doV2(t, h, http.MethodGet, tagPath, "", ""), http.StatusNotFound, "")
+			wantV2(t, doV2(t, h, http.MethodPatch, tagPath, "", `{"name": "x"}`), http.StatusNotFound, "")
+			wantV2(t, doV2(t, h, http.MethodDelete, tagPath, "", ""), http.StatusNotFound, "")
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trashV2Path - путь к корзине: удаленным записям, которые можно восстановить или удалить окончательно.
+// Записи попадают в корзину при удалении через /delete и DELETE /v2/records/{id} и не видны остальным запросам,
+// а их номера телефонов освобождаются. Записи старше срока хранения удаляются фоновой задачей (см. PurgeTrash).
+const trashV2Path = "/v2/trash"
+
+// trashPurgeActor - автор окончательного удаления записей фоновой задачей в истории изменений.
+const trashPurgeActor = "trash-purge"
+
+// trashV2Handler обрабатывает запросы к списку записей в корзине
+/*
+GET /v2/trash - записи в корзине с временем удаления deleted_at и автором удаления deleted_by.
+Параметры запроса (кроме as_of) и ответ те же, что у GET /v2/records.
+*/
+func (abs *AddressBookService) trashV2Handler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) trashV2Handler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) trashV2Handler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	switch req.Method {
+	case http.MethodGet:
+		abs.listRecordsV2(w, req, nil, true, wErr)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// trashRecordV2Handler обрабатывает запросы к записи в корзине /v2/trash/{id}
+/*
+GET /v2/trash/{id} - возвращает 200 и запись из корзины.
+
+DELETE /v2/trash/{id} - окончательное удаление записи. Возвращает 204 без тела.
+
+POST /v2/trash/{id}/restore - восстановление записи. Возвращает 200 и восстановленную запись;
+409 - один из номеров записи уже занят другой записью (его нужно освободить или изменить у другой записи).
+
+404 - записи нет в корзине.
+*/
+func (abs *AddressBookService) trashRecordV2Handler(w http.ResponseWriter, req *http.Request) {
+	setHttpHeaders(w)
+
+	wErr, err := pkg.NewWrappedErrorWithFile("(abs *AddressBookService) trashRecordV2Handler()")
+	if err != nil {
+		log.Println("(abs *AddressBookService) trashRecordV2Handler: NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	rest, sub, isSub := strings.Cut(strings.TrimPrefix(req.URL.Path, trashV2Path+"/"), "/")
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || id <= 0 || (isSub && sub != "restore") {
+		writeErrorV2(w, http.StatusNotFound, dto.ErrRecordNotFound, wErr)
+		return
+	}
+
+	if isSub {
+		abs.restoreRecordV2(w, req, id, wErr)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		page, err := abs.db.GetRecords(req.Context(), dto.Query{Filter: dto.RecordFilter(dto.Record{ID: id}), Trash: true})
+		if err == nil && len(page.Records) == 0 {
+			err = dto.ErrRecordNotFound
+		}
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		writeJSONV2(w, http.StatusOK, page.Records[0], wErr)
+	case http.MethodDelete:
+		err = abs.db.PurgeRecord(req.Context(), id)
+		if err != nil {
+			writeErrorV2(w, statusForError(err), err, wErr)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+	}
+}
+
+// restoreRecordV2 обрабатывает запрос на восстановление записи из корзины (см. trashRecordV2Handler).
+func (abs *AddressBookService) restoreRecordV2(w http.ResponseWriter, req *http.Request, id int64, wErr *pkg.WrappedError) {
+	switch req.Method {
+	case http.MethodPost:
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "POST, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+		return
+	}
+
+	err := abs.db.RestoreRecord(req.Context(), id)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+	record, err := abs.recordByID(req, id)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+	writeJSONV2(w, http.StatusOK, record, wErr)
+}
+
+// PurgeTrash раз в interval окончательно удаляет записи, находящиеся в корзине дольше retention,
+// пока сервер не остановлен (см. Close). Запускается в отдельной горутине.
+func (abs *AddressBookService) PurgeTrash(retention, interval time.Duration) {
+	wErr := pkg.NewWrappedError("(abs *AddressBookService) PurgeTrash()")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ctx := dto.WithChangeMeta(context.Background(), dto.ChangeMeta{Actor: trashPurgeActor, RequestID: newRequestID()})
+		n, err := abs.db.PurgeTrash(ctx, time.Now().Add(-retention))
+		if err != nil {
+			wErr.Specify(err, "abs.db.PurgeTrash()").LogError()
+		} else if n > 0 {
+			wErr.LogMsg("purged " + strconv.FormatInt(n, 10) + " records from trash")
+		}
+
+		select {
+		case <-abs.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
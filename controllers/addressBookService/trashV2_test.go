@@ -0,0 +1,92 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// createV2 создает запись с номером phone через POST /v2/records и возвращает ее.
+func createV2(t *testing.T, h http.Handler, phone string) dto.Record {
+	t.Helper()
+	resp := doV2(t, h, http.MethodPost, recordsV2Path, "",
+		fmt.Sprintf(`{"name": "Иван", "last_name": "Петров", "address": "Москва", "phone": %q}`, phone))
+	wantV2(t, resp, http.StatusCreated, "")
+
+	var rec dto.Record
+	if err := json.Unmarshal(resp.Body.Bytes(), &rec); err != nil {
+		t.Fatalf("POST %s: %v", recordsV2Path, err)
+	}
+	return rec
+}
+
+func TestTrashRestore(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			phone := testPhone(21)
+
+			rec := createV2(t, h, phone)
+			path, trashPath := fmt.Sprintf("%s/%d", recordsV2Path, rec.ID), fmt.Sprintf("%s/%d", trashV2Path, rec.ID)
+			wantV2(t, doV2(t, h, http.MethodDelete, path, "*", ""), http.StatusNoContent, "")
+			wantV2(t, doV2(t, h, http.MethodGet, trashPath, "", ""), http.StatusOK, "")
+
+			// Номер записи в корзине свободен, а при восстановлении снова проверяется
+			other := createV2(t, h, phone)
+			wantV2(t, doV2(t, h, http.MethodPost, trashPath+"/restore", "", ""), http.StatusConflict, "")
+			wantV2(t, doV2(t, h, http.MethodGet, path, "", ""), http.StatusNotFound, "")
+
+			wantV2(t, doV2(t, h, http.MethodDelete, fmt.Sprintf("%s/%d", recordsV2Path, other.ID), "*", ""), http.StatusNoContent, "")
+			wantV2(t, doV2(t, h, http.MethodPost, trashPath+"/restore", "", ""), http.StatusOK, "")
+			wantV2(t, doV2(t, h, http.MethodGet, path, "", ""), http.StatusOK, `"3"`)
+			wantV2(t, doV2(t, h, http.MethodGet, trashPath, "", ""), http.StatusNotFound, "")
+			wantV2(t, doV2(t, h, http.MethodPost, trashPath+"/restore", "", ""), http.StatusNotFound, "")
+		})
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewAddressBookService("", db).server.Handler
+			ctx := context.Background()
+
+			// Окончательное удаление одной записи
+			rec := createV2(t, h, testPhone(22))
+			trashPath := fmt.Sprintf("%s/%d", trashV2Path, rec.ID)
+			wantV2(t, doV2(t, h, http.MethodDelete, trashPath, "", ""), http.StatusNotFound, "")
+			wantV2(t, doV2(t, h, http.MethodDelete, fmt.Sprintf("%s/%d", recordsV2Path, rec.ID), "*", ""), http.StatusNoContent, "")
+			wantV2(t, doV2(t, h, http.MethodDelete, trashPath, "", ""), http.StatusNoContent, "")
+			wantV2(t, doV2(t, h, http.MethodGet, trashPath, "", ""), http.StatusNotFound, "")
+
+			page, err := db.History(ctx, dto.HistoryQuery{RecordID: rec.ID})
+			if err != nil || len(page.Entries) == 0 || page.Entries[0].Action != dto.ActionPurge || page.Entries[0].Before == nil {
+				t.Fatalf("History() = %+v, %v, want purge first", page.Entries, err)
+			}
+
+			// Очистка корзины удаляет только записи, удаленные раньше before
+			var ids []int64
+			for i := 0; i < 3; i++ {
+				rec = createV2(t, h, testPhone(23+i))
+				wantV2(t, doV2(t, h, http.MethodDelete, fmt.Sprintf("%s/%d", recordsV2Path, rec.ID), "*", ""), http.StatusNoContent, "")
+				ids = append(ids, rec.ID)
+			}
+			if _, err = db.PurgeTrash(ctx, time.Now().Add(-time.Hour)); err != nil {
+				t.Fatalf("PurgeTrash(): %v", err)
+			}
+			wantV2(t, doV2(t, h, http.MethodGet, fmt.Sprintf("%s/%d", trashV2Path, ids[0]), "", ""), http.StatusOK, "")
+
+			n, err := db.PurgeTrash(ctx, time.Now().Add(time.Hour))
+			if err != nil || n < int64(len(ids)) {
+				t.Fatalf("PurgeTrash() = %d, %v, want at least %d", n, err, len(ids))
+			}
+			for _, id := range ids {
+				wantV2(t, doV2(t, h, http.MethodGet, fmt.Sprintf("%s/%d", trashV2Path, id), "", ""), http.StatusNotFound, "")
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// prepareNewRecord проверяет наличие обязательных полей новой (или полностью заменяемой) записи
+// и нормализует номера телефонов (см. prepareRecordPhones). Обязательны ФИО (кроме отчества), адрес и телефон,
+// номер телефона можно указать полем phone (старый формат) или списком phones.
+// Дополнительные поля (почта, дата рождения, организация) проверяются функцией prepareRecordDetails.
+func prepareNewRecord(rec *dto.Record) error {
+	if rec.Name == "" || rec.LastName == "" || (rec.Address == "" && len(rec.Addresses) == 0) ||
+		(rec.Phone == "" && len(rec.Phones) == 0) {
+		return &dto.ValidationError{Msg: "required data is missing"}
+	}
+	if err := prepareRecordAddresses(rec); err != nil {
+		return err
+	}
+	if err := prepareRecordDetails(rec); err != nil {
+		return err
+	}
+	return prepareRecordPhones(rec)
+}
+
+// prepareRecordDetails нормализует необязательные поля записи: адреса электронной почты (см. prepareEmails),
+// дату рождения (см. prepareBirthday), организацию и должность (без лишних пробелов).
+// Теги записи изменяются только через /v2/tags, поэтому поле tags в теле запроса не учитывается.
+func prepareRecordDetails(rec *dto.Record) (err error) {
+	rec.Tags = nil
+	if err = prepareEmails(rec.Emails); err != nil {
+		return err
+	}
+	if rec.Birthday, err = prepareBirthday(rec.Birthday); err != nil {
+		return err
+	}
+	rec.Organization = strings.TrimSpace(rec.Organization)
+	rec.JobTitle = strings.TrimSpace(rec.JobTitle)
+	return nil
+}
+
+// prepareEmails нормализует адреса электронной почты (pkg.NormalizeEmail) и проверяет, что они не повторяются.
+func prepareEmails(emails []string) (err error) {
+	seen := map[string]bool{}
+	for i := range emails {
+		emails[i], err = pkg.NormalizeEmail(emails[i])
+		if err != nil {
+			return &dto.ValidationError{Msg: "wrong Email"}
+		}
+		if seen[emails[i]] {
+			return &dto.ValidationError{Msg: fmt.Sprintf("duplicate email %s", emails[i])}
+		}
+		seen[emails[i]] = true
+	}
+	return nil
+}
+
+// prepareBirthday проверяет дату рождения: дата в формате dto.DateLayout и не в будущем. Пустая строка допустима.
+func prepareBirthday(birthday string) (string, error) {
+	birthday = strings.TrimSpace(birthday)
+	if birthday == "" {
+		return "", nil
+	}
+	date, err := time.Parse(dto.DateLayout, birthday)
+	if err != nil {
+		return "", &dto.ValidationError{Msg: fmt.Sprintf("birthday must be a date in format %s", dto.DateLayout)}
+	}
+	if date.After(time.Now()) {
+		return "", &dto.ValidationError{Msg: "birthday cannot be in the future"}
+	}
+	return birthday, nil
+}
+
+// prepareRecordAddresses согласует адрес в свободной форме address и структурированные адреса addresses:
+// если указан только address, он разбирается (pkg.ParseAddress) в единственный адрес, если только addresses -
+// address составляется из основного (первого) адреса. Если указаны оба поля, они сохраняются как есть.
+func prepareRecordAddresses(rec *dto.Record) error {
+	if err := prepareAddresses(rec.Addresses); err != nil {
+		return err
+	}
+	switch {
+	case len(rec.Addresses) == 0 && rec.Address != "":
+		rec.Addresses = []dto.PostalAddress{pkg.ParseAddress(rec.Address)}
+	case len(rec.Addresses) > 0 && rec.Address == "":
+		rec.Address = rec.Addresses[0].String()
+	}
+	return nil
+}
+
+// prepareAddresses убирает лишние пробелы в частях адресов и проверяет, что ни один адрес не пустой.
+func prepareAddresses(addresses []dto.PostalAddress) error {
+	for i := range addresses {
+		a := &addresses[i]
+		for _, part := range []*string{&a.Label, &a.Country, &a.Region, &a.City, &a.Street, &a.House, &a.Apartment, &a.PostalIndex} {
+			*part = strings.TrimSpace(*part)
+		}
+		if a.IsEmpty() {
+			return &dto.ValidationError{Msg: "address cannot be empty"}
+		}
+	}
+	return nil
+}
+
+// prepareRecordPhones приводит номера записи к единому виду: если указан только phone, он становится
+// единственным (основным) номером; номера списка phones нормализуются и проверяются (см. preparePhones).
+// Если указаны оба поля, phone должен совпадать с основным номером списка.
+func prepareRecordPhones(rec *dto.Record) (err error) {
+	if rec.Phone != "" {
+		if err = normalizeRecordPhone(rec); err != nil {
+			return err
+		}
+	}
+	if len(rec.Phones) == 0 {
+		rec.Phones = []dto.Phone{{Number: rec.Phone, Primary: true}}
+	}
+	if err = preparePhones(rec.Phones); err != nil {
+		return err
+	}
+
+	primary := dto.PrimaryPhone(rec.Phones)
+	if rec.Phone != "" && rec.Phone != primary {
+		return &dto.ValidationError{Msg: "phone must be the primary number of phones"}
+	}
+	rec.Phone = primary
+	return nil
+}
+
+// preparePhones нормализует номера и проверяет список: номера не повторяются, тип из dto.PhoneTypes
+// (пустой тип - dto.PhoneOther), основной номер не больше одного (если его нет, основным становится первый).
+func preparePhones(phones []dto.Phone) (err error) {
+	if len(phones) == 0 {
+		return &dto.ValidationError{Msg: "phones cannot be empty"}
+	}
+
+	seen := map[string]bool{}
+	primary := 0
+	for i := range phones {
+		phone := &phones[i]
+		phone.Number, err = pkg.NormalizePhoneNumber(phone.Number)
+		if err != nil {
+			return &dto.ValidationError{Msg: "wrong Phone"}
+		}
+		if seen[phone.Number] {
+			return &dto.ValidationError{Msg: fmt.Sprintf("duplicate phone %s", phone.Number)}
+		}
+		seen[phone.Number] = true
+
+		if phone.Type == "" {
+			phone.Type = dto.PhoneOther
+		}
+		if !dto.PhoneTypes[phone.Type] {
+			return &dto.ValidationError{Msg: fmt.Sprintf("unknown phone type %q", phone.Type)}
+		}
+		if phone.Primary {
+			primary++
+		}
+	}
+
+	switch primary {
+	case 0:
+		phones[0].Primary = true
+	case 1:
+	default:
+		return &dto.ValidationError{Msg: "only one phone can be primary"}
+	}
+	return nil
+}
+
+// prepareAddressPatch согласует address и addresses в частичном обновлении так же, как prepareRecordAddresses:
+// новый address заменяет основной адрес (разобранный адрес записывается в patch.PrimaryAddress),
+// новый список addresses заменяет address строкой основного адреса, а очистка address удаляет все адреса.
+func prepareAddressPatch(patch *dto.RecordPatch) error {
+	if err := prepareAddresses(patch.Addresses.Value); err != nil {
+		return err
+	}
+	switch {
+	case patch.Addresses.Set && !patch.Address.Set:
+		patch.Address = dto.PatchString{Set: true, Null: len(patch.Addresses.Value) == 0}
+		if len(patch.Addresses.Value) > 0 {
+			patch.Address.Value = patch.Addresses.Value[0].String()
+		}
+	case patch.Address.Empty() && !patch.Addresses.Set:
+		patch.Addresses = dto.PatchAddresses{Set: true}
+	case patch.Address.Set && !patch.Addresses.Set:
+		parsed := pkg.ParseAddress(patch.Address.Value)
+		patch.PrimaryAddress = &parsed
+	}
+	return nil
+}
+
+// preparePatch проверяет частичное обновление (JSON Merge Patch): в нем должно быть хотя бы одно поле,
+// обязательные поля (имя, фамилия, номер телефона) нельзя очистить. Номер телефона, если указан, нормализуется,
+// список номеров проверяется функцией preparePhones. Основной номер и список номеров нельзя менять одновременно.
+// Адреса электронной почты и дата рождения проверяются так же, как при создании записи.
+func preparePatch(patch *dto.RecordPatch) (err error) {
+	if patch.IsEmpty() {
+		return &dto.ValidationError{Msg: dto.ErrNothingToUpdate.Error()}
+	}
+	switch {
+	case patch.Name.Empty():
+		return &dto.ValidationError{Msg: "name cannot be empty"}
+	case patch.LastName.Empty():
+		return &dto.ValidationError{Msg: "last_name cannot be empty"}
+	case patch.Phone.Empty():
+		return &dto.ValidationError{Msg: "phone cannot be empty"}
+	case patch.Phone.Set && patch.Phones.Set:
+		return &dto.ValidationError{Msg: "phone and phones cannot be changed together"}
+	}
+	if err = prepareAddressPatch(patch); err != nil {
+		return err
+	}
+	if err = prepareEmails(patch.Emails.Value); err != nil {
+		return err
+	}
+	if patch.Birthday.Value, err = prepareBirthday(patch.Birthday.Value); err != nil {
+		return err
+	}
+	if patch.Phones.Set {
+		return preparePhones(patch.Phones.Value)
+	}
+	if !patch.Phone.Set {
+		return nil
+	}
+	patch.Phone.Value, err = pkg.NormalizePhoneNumber(patch.Phone.Value)
+	if err != nil {
+		return &dto.ValidationError{Msg: "wrong Phone"}
+	}
+	return nil
+}
+
+// normalizeRecordPhone приводит номер телефона записи к формату 8XXXXXXXXXX.
+func normalizeRecordPhone(rec *dto.Record) (err error) {
+	rec.Phone, err = pkg.NormalizePhoneNumber(rec.Phone)
+	if err != nil {
+		return &dto.ValidationError{Msg: "wrong Phone"}
+	}
+	return nil
+}
+
+// normalizeFilter нормализует номера телефонов в условиях точного сравнения (eq, ne, in) поля phone.
+// Условия prefix и contains не изменяются: в них указывается часть номера в формате 8XXXXXXXXXX.
+// Значения условий на поле email приводятся к нижнему регистру, как и хранимые адреса.
+func normalizeFilter(filter *dto.Filter) (err error) {
+	normalize := func(v any) any {
+		phone, ok := v.(string)
+		if !ok || err != nil {
+			return v
+		}
+		phone, normErr := pkg.NormalizePhoneNumber(phone)
+		if normErr != nil {
+			err = &dto.ValidationError{Msg: "wrong Phone"}
+			return v
+		}
+		return phone
+	}
+
+	filter.Walk(func(leaf *dto.Filter) {
+		if leaf.Field == dto.EmailFilterField {
+			leaf.Value = lowerValue(leaf.Value)
+			for i := range leaf.Values {
+				leaf.Values[i] = lowerValue(leaf.Values[i])
+			}
+			return
+		}
+		if leaf.Field != "phone" {
+			return
+		}
+		switch leaf.Op {
+		case dto.OpEq, dto.OpNe:
+			leaf.Value = normalize(leaf.Value)
+		case dto.OpIn:
+			for i := range leaf.Values {
+				leaf.Values[i] = normalize(leaf.Values[i])
+			}
+		}
+	})
+
+	return err
+}
+
+// lowerValue приводит строковое значение условия к нижнему регистру, остальные значения не изменяются.
+func lowerValue(v any) any {
+	if s, ok := v.(string); ok {
+		return strings.ToLower(s)
+	}
+	return v
+}
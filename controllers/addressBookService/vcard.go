@@ -0,0 +1,493 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Версии vCard, в которых выгружаются записи
+const (
+	vcardV3 = "3.0" // RFC 2426 (по умолчанию): понимают все телефоны и почтовые программы
+	vcardV4 = "4.0" // RFC 6350
+)
+
+// vcardContentType - тип содержимого файлов vCard.
+const vcardContentType = "text/vcard; charset=utf-8"
+
+// vcardUIDPrefix - начало UID карточки записи: abs-record-{id}.
+const vcardUIDPrefix = "abs-record-"
+
+// Метки адресов записи, соответствующие типам адресов vCard home и work
+const (
+	addressLabelHome = "дом"
+	addressLabelWork = "работа"
+)
+
+// parseVCardVersion проверяет версию vCard из запроса ("" - vcardV3).
+func parseVCardVersion(version string) (string, error) {
+	switch version {
+	case "":
+		return vcardV3, nil
+	case vcardV3, vcardV4:
+		return version, nil
+	}
+	return "", &dto.ValidationError{Msg: "vCard version must be 3.0 or 4.0"}
+}
+
+// ImportVCard загружает в хранилище db записи из файла vCard r: одной или нескольких карточек, например,
+// выгрузки контактов Android (vCard 2.1) или iOS (vCard 3.0). Свойства карточки сопоставляются полям записи
+// (см. recordFromVCard), номера нормализуются pkg.NormalizePhoneNumber. Карточки, которые не удалось
+// разобрать или сопоставить, и карточки с номерами, занятыми другими записями или предыдущими карточками,
+// отклоняются (в отчете - строка BEGIN:VCARD, имя контакта и причина), остальные записи сохраняются
+// одной транзакцией (см. Storage.ImportRecords). При dryRun записи только проверяются.
+func ImportVCard(ctx context.Context, db Storage, r io.Reader, dryRun bool) (dto.ImportReport, error) {
+	batch := newImportBatch(dryRun)
+
+	defs, err := db.CustomFields(ctx)
+	if err != nil {
+		return batch.report, err
+	}
+	customDefs := dto.NewCustomFieldDefs(defs)
+
+	cards, err := pkg.ParseVCards(r)
+	if err != nil {
+		return batch.report, err
+	}
+	if len(cards) == 0 {
+		return batch.report, &dto.ValidationError{Msg: "file contains no vCard (BEGIN:VCARD)"}
+	}
+
+	for _, card := range cards {
+		name := vcardName(card)
+		rec, err := recordFromVCard(card)
+		if err == nil {
+			err = customDefs.ValidateCustom(rec.Custom, true)
+		}
+		if err != nil {
+			batch.reject(card.Line, name, err)
+			continue
+		}
+		batch.add(card.Line, name, rec)
+	}
+
+	return batch.save(ctx, db)
+}
+
+// vcardName возвращает имя контакта для отчета об импорте: FN или части N.
+func vcardName(card pkg.VCard) string {
+	if fn := card.Get("FN"); fn != nil && strings.TrimSpace(fn.Text()) != "" {
+		return strings.TrimSpace(fn.Text())
+	}
+	if n := card.Get("N"); n != nil {
+		return strings.Join(strings.Fields(strings.Join(n.Components(), " ")), " ")
+	}
+	return ""
+}
+
+// recordFromVCard сопоставляет карточку vCard новой записи:
+//
+//	N    - фамилия, имя, отчество (без N имя и фамилия берутся из FN: "Имя [Отчество] Фамилия");
+//	TEL  - номера (тип cell - mobile, work, home, fax, остальные - other; предпочтительный номер - основной);
+//	ADR  - адреса (тип home - метка "дом", work - "работа"; предпочтительный адрес - основной);
+//	EMAIL, BDAY (дата без года не переносится), ORG (первая часть), TITLE и NOTE.
+//
+// Остальные свойства не переносятся. Запись проверяется так же, как в /create (см. prepareNewRecord).
+func recordFromVCard(card pkg.VCard) (rec dto.Record, err error) {
+	if card.Err != nil {
+		return rec, &dto.ValidationError{Msg: card.Err.Error()}
+	}
+
+	if n := card.Get("N"); n != nil {
+		parts := append(n.Components(), "", "")
+		rec.LastName, rec.Name, rec.MiddleName = strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2])
+	}
+	if fn := card.Get("FN"); fn != nil && rec.Name == "" && rec.LastName == "" {
+		if words := strings.Fields(fn.Text()); len(words) > 0 {
+			rec.Name = words[0]
+			if len(words) > 1 {
+				rec.LastName = words[len(words)-1]
+				rec.MiddleName = strings.Join(words[1:len(words)-1], " ")
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, tel := range card.All("TEL") {
+		value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tel.Text()), "tel:"))
+		if value == "" {
+			continue
+		}
+		number, err := pkg.NormalizePhoneNumber(value)
+		if err != nil {
+			return rec, &dto.ValidationError{Msg: fmt.Sprintf("wrong Phone %q (TEL)", value)}
+		}
+		if seen[number] {
+			continue // Телефоны нередко повторяют номер с разными типами
+		}
+		seen[number] = true
+		phone := dto.Phone{Number: number, Type: vcardPhoneType(vcardTypes(card, tel))}
+		phone.Primary = tel.Preferred() && dto.PrimaryPhone(rec.Phones) == ""
+		rec.Phones = append(rec.Phones, phone)
+	}
+
+	preferred := false // Первый предпочтительный адрес становится основным
+	for _, adr := range card.All("ADR") {
+		address := vcardAddress(adr, vcardTypes(card, adr))
+		if address.IsEmpty() {
+			continue
+		}
+		if adr.Preferred() && !preferred {
+			preferred = true
+			rec.Addresses = append([]dto.PostalAddress{address}, rec.Addresses...)
+			continue
+		}
+		rec.Addresses = append(rec.Addresses, address)
+	}
+
+	emails := map[string]bool{}
+	for _, email := range card.All("EMAIL") {
+		value := strings.TrimSpace(email.Text())
+		if value == "" || emails[strings.ToLower(value)] {
+			continue
+		}
+		emails[strings.ToLower(value)] = true
+		rec.Emails = append(rec.Emails, value)
+	}
+
+	if bday := card.Get("BDAY"); bday != nil && len(bday.Params["X-APPLE-OMIT-YEAR"]) == 0 {
+		if rec.Birthday, err = vcardBirthday(bday.Text()); err != nil {
+			return rec, err
+		}
+	}
+	if org := card.Get("ORG"); org != nil {
+		rec.Organization = org.Components()[0]
+	}
+	if title := card.Get("TITLE"); title != nil {
+		rec.JobTitle = title.Text()
+	}
+	if note := card.Get("NOTE"); note != nil {
+		rec.Notes = strings.TrimSpace(strings.ReplaceAll(note.Text(), "\r\n", "\n"))
+	}
+
+	var missing []string
+	for _, required := range []struct {
+		empty bool
+		name  string
+	}{
+		{rec.LastName == "", "family name (N)"},
+		{rec.Name == "", "given name (N)"},
+		{len(rec.Addresses) == 0, "address (ADR)"},
+		{len(rec.Phones) == 0, "phone (TEL)"},
+	} {
+		if required.empty {
+			missing = append(missing, required.name)
+		}
+	}
+	if len(missing) > 0 {
+		return rec, &dto.ValidationError{Msg: "required data is missing: " + strings.Join(missing, ", ")}
+	}
+
+	return rec, prepareNewRecord(&rec)
+}
+
+// vcardTypes возвращает типы свойства prop карточки card. Для свойств в группе (item1.TEL) добавляется
+// метка X-ABLabel той же группы, которую iOS записывает вместо типа: "_$!<Mobile>!$_" - mobile.
+func vcardTypes(card pkg.VCard, prop pkg.VCardProp) []string {
+	types := prop.Types()
+	if prop.Group == "" {
+		return types
+	}
+	for _, label := range card.All("X-ABLABEL") {
+		if label.Group == prop.Group {
+			text := strings.TrimSuffix(strings.TrimPrefix(label.Text(), "_$!<"), ">!$_")
+			types = append(types, strings.ToLower(text))
+		}
+	}
+	return types
+}
+
+// vcardPhoneType возвращает тип номера записи по типам TEL: fax, затем cell, work и home, остальные - other.
+func vcardPhoneType(types []string) string {
+	switch {
+	case slices.Contains(types, "fax"):
+		return dto.PhoneFax
+	case slices.Contains(types, "cell"), slices.Contains(types, "mobile"), slices.Contains(types, "iphone"):
+		return dto.PhoneMobile
+	case slices.Contains(types, "work"):
+		return dto.PhoneWork
+	case slices.Contains(types, "home"):
+		return dto.PhoneHome
+	}
+	return dto.PhoneOther
+}
+
+// vcardAddress сопоставляет ADR (абонентский ящик; доп. адрес; улица; город; регион; индекс; страна) с типами types
+// адресу записи. Дополнительный адрес считается квартирой ("кв. 10" - "10"), строки улицы соединяются через запятую,
+// а дом в конце улицы ("ул. Ленина, д. 5", как его записывает vcardADR) отделяется от нее (см. vcardStreetHouse).
+func vcardAddress(adr pkg.VCardProp, types []string) dto.PostalAddress {
+	parts := append(adr.Components(), make([]string, 7)...)
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	address := dto.PostalAddress{
+		Apartment:   parts[1],
+		Street:      strings.Join(strings.FieldsFunc(parts[2], func(r rune) bool { return r == '\n' || r == '\r' }), ", "),
+		City:        parts[3],
+		Region:      parts[4],
+		PostalIndex: parts[5],
+		Country:     parts[6],
+	}
+	address.Street, address.House = vcardStreetHouse(address.Street)
+	if flat := pkg.ParseAddress(address.Apartment).Apartment; flat != "" {
+		address.Apartment = flat
+	}
+	if address.Street == "" && parts[0] != "" {
+		address.Street = "а/я " + parts[0]
+	}
+	if !address.IsEmpty() {
+		switch {
+		case slices.Contains(types, "home"):
+			address.Label = addressLabelHome
+		case slices.Contains(types, "work"):
+			address.Label = addressLabelWork
+		}
+	}
+	return address
+}
+
+// vcardStreetHouse отделяет от улицы street дом, записанный последними частями через запятую: "ул. Ленина, д. 5, корп. 2" -
+// улица "ул. Ленина", дом "5 к. 2". Части разбираются pkg.ParseAddress; улица без дома возвращается как есть.
+func vcardStreetHouse(street string) (string, string) {
+	parts := strings.Split(street, ",")
+	for i := range parts {
+		tail := pkg.ParseAddress(strings.Join(parts[i:], ","))
+		if tail.House != "" && tail == (dto.PostalAddress{House: tail.House}) {
+			return strings.TrimSpace(strings.Join(parts[:i], ",")), tail.House
+		}
+	}
+	return street, ""
+}
+
+// vcardBirthday переводит BDAY в формат dto.DateLayout: принимаются даты 1990-05-17 и 19900517,
+// в том числе со временем (1990-05-17T00:00:00Z). Дата без года (--0517) не переносится.
+func vcardBirthday(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.HasPrefix(value, "--") {
+		return "", nil
+	}
+	date, _, _ := strings.Cut(value, "T")
+	for _, layout := range []string{dto.DateLayout, "20060102"} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.Format(dto.DateLayout), nil
+		}
+	}
+	return "", &dto.ValidationError{Msg: fmt.Sprintf("wrong birthday %q (BDAY)", value)}
+}
+
+// recordToVCard возвращает карточку записи r в версии vCard version (vcardV3 или vcardV4): UID, FN, N, TEL
+// (в международном формате +7...), ADR, EMAIL, BDAY, ORG, TITLE, NOTE, CATEGORIES (теги) и REV.
+// Основной номер и основной адрес отмечаются как предпочтительные.
+func recordToVCard(r dto.Record, version string) pkg.VCard {
+	v4 := version == vcardV4
+	card := pkg.VCard{}
+	card.Add("VERSION", version)
+	if v4 {
+		card.Add("UID", vcardUIDPrefix+strconv.FormatInt(r.ID, 10), "VALUE=text")
+	} else {
+		card.Add("UID", vcardUIDPrefix+strconv.FormatInt(r.ID, 10))
+	}
+	card.Add("FN", pkg.VCardText(strings.Join(strings.Fields(r.Name+" "+r.MiddleName+" "+r.LastName), " ")))
+	card.Add("N", pkg.VCardComponents(r.LastName, r.Name, r.MiddleName, "", ""))
+
+	// pref - предпочтительный элемент: в vCard 3.0 - тип, в vCard 4.0 - параметр PREF
+	typeParams := func(primary bool, types ...string) []string {
+		switch {
+		case primary && v4:
+			return []string{"TYPE=" + strings.Join(types, ","), "PREF=1"}
+		case primary:
+			types = append(types, "pref")
+		}
+		return []string{"TYPE=" + strings.Join(types, ",")}
+	}
+
+	for _, p := range r.Phones {
+		number := vcardPhoneNumber(p.Number)
+		if v4 {
+			card.Add("TEL", "tel:"+number, append(typeParams(p.Primary, vcardTelType(p.Type)), "VALUE=uri")...)
+		} else {
+			card.Add("TEL", number, typeParams(p.Primary, vcardTelType(p.Type))...)
+		}
+	}
+	if len(r.Phones) == 0 && r.Phone != "" {
+		card.Add("TEL", vcardPhoneNumber(r.Phone))
+	}
+
+	for i, a := range r.Addresses {
+		value, types := vcardADR(a)
+		switch {
+		case len(types) > 0 || (i == 0 && !v4):
+			card.Add("ADR", value, typeParams(i == 0, types...)...)
+		case i == 0:
+			card.Add("ADR", value, "PREF=1")
+		default:
+			card.Add("ADR", value)
+		}
+	}
+	if len(r.Addresses) == 0 && r.Address != "" {
+		card.Add("ADR", pkg.VCardComponents("", "", r.Address, "", "", "", ""))
+	}
+
+	for _, email := range r.Emails {
+		if v4 {
+			card.Add("EMAIL", pkg.VCardText(email))
+		} else {
+			card.Add("EMAIL", pkg.VCardText(email), "TYPE=internet")
+		}
+	}
+	if r.Birthday != "" {
+		if v4 {
+			card.Add("BDAY", strings.ReplaceAll(r.Birthday, "-", ""))
+		} else {
+			card.Add("BDAY", r.Birthday)
+		}
+	}
+	if r.Organization != "" {
+		card.Add("ORG", pkg.VCardComponents(r.Organization))
+	}
+	if r.JobTitle != "" {
+		card.Add("TITLE", pkg.VCardText(r.JobTitle))
+	}
+	if r.Notes != "" {
+		card.Add("NOTE", pkg.VCardText(r.Notes))
+	}
+	if len(r.Tags) > 0 {
+		tags := make([]string, len(r.Tags))
+		for i, tag := range r.Tags {
+			tags[i] = pkg.VCardText(tag)
+		}
+		card.Add("CATEGORIES", strings.Join(tags, ","))
+	}
+	if !r.UpdatedAt.IsZero() {
+		card.Add("REV", r.UpdatedAt.UTC().Format("20060102T150405Z"))
+	}
+	return card
+}
+
+// vcardADR возвращает значение ADR для адреса a (дом записывается в составе улицы: "ул. Ленина, д. 5",
+// vcardAddress отделяет его обратно) и тип адреса по метке: home, work или ни одного.
+func vcardADR(a dto.PostalAddress) (value string, types []string) {
+	street := a.Street
+	if a.House != "" {
+		street = strings.TrimPrefix(street+", д. "+a.House, ", ")
+	}
+	value = pkg.VCardComponents("", a.Apartment, street, a.City, a.Region, a.PostalIndex, a.Country)
+	switch strings.ToLower(a.Label) {
+	case addressLabelHome, "home":
+		types = append(types, "home")
+	case addressLabelWork, "work":
+		types = append(types, "work")
+	}
+	return value, types
+}
+
+// vcardPhoneNumber возвращает номер в формате записи (8XXXXXXXXXX) в международном формате +7XXXXXXXXXX.
+func vcardPhoneNumber(number string) string {
+	if len(number) == 11 && number[0] == '8' {
+		return "+7" + number[1:]
+	}
+	return number
+}
+
+// vcardTelType возвращает тип TEL для типа номера записи.
+func vcardTelType(phoneType string) string {
+	switch phoneType {
+	case dto.PhoneMobile:
+		return "cell"
+	case dto.PhoneWork, dto.PhoneHome, dto.PhoneFax:
+		return phoneType
+	}
+	return "voice"
+}
+
+// importFormat определяет формат загружаемого файла по типу содержимого contentType и имени файла name:
+// text/vcard, text/x-vcard и файлы .vcf - importVCard, остальные - importCSV.
+func importFormat(contentType, name string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "text/vcard", mediaType == "text/x-vcard", mediaType == "text/directory":
+		return importVCard
+	case strings.EqualFold(filepath.Ext(name), ".vcf"), strings.EqualFold(filepath.Ext(name), ".vcard"):
+		return importVCard
+	}
+	return importCSV
+}
+
+// recordVCardV2 обрабатывает запросы к /v2/records/{id}/vcard
+/*
+GET /v2/records/{id}/vcard - карточка записи в формате vCard (файл contact_{id}.vcf, версия записи - в ETag).
+Параметр version - версия vCard: 3.0 (по умолчанию) или 4.0.
+*/
+func (abs *AddressBookService) recordVCardV2(w http.ResponseWriter, req *http.Request, id int64, wErr *pkg.WrappedError) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "GET, OPTIONS")
+		writeErrorV2(w, http.StatusMethodNotAllowed, errors.New("invalid request method"), wErr)
+		return
+	}
+
+	version, err := parseVCardVersion(req.URL.Query().Get("version"))
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, err, wErr)
+		return
+	}
+	record, err := abs.recordByID(req, id)
+	if err != nil {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+
+	w.Header().Set("Content-Type", vcardContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="contact_%d.vcf"`, id))
+	w.Header().Set("ETag", etagV2(record.Version))
+	w.WriteHeader(http.StatusOK)
+	if err = pkg.WriteVCard(w, recordToVCard(record, version)); err != nil {
+		wErr.Specify(err, "pkg.WriteVCard()").LogError()
+	}
+}
+
+// vcardExportWriter записывает записи карточками vCard одним файлом.
+type vcardExportWriter struct {
+	w       io.Writer
+	version string
+}
+
+func (e *vcardExportWriter) contentType() string {
+	return vcardContentType
+}
+
+func (e *vcardExportWriter) begin() error {
+	return nil
+}
+
+func (e *vcardExportWriter) write(r dto.Record) error {
+	return pkg.WriteVCard(e.w, recordToVCard(r, e.version))
+}
+
+func (e *vcardExportWriter) end() error {
+	return nil
+}
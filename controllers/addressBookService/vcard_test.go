@@ -0,0 +1,71 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestVCardAddressRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		address dto.PostalAddress
+	}{
+		{"full", dto.PostalAddress{Label: addressLabelHome, Country: "Россия", Region: "Московская обл.", City: "Москва",
+			Street: "ул. Ленина", House: "5", Apartment: "10", PostalIndex: "123456"}},
+		{"house with building", dto.PostalAddress{Label: addressLabelWork, City: "Москва", Street: "пр-т Мира", House: "12 к. 2"}},
+		{"house with structure", dto.PostalAddress{City: "Казань", Street: "ул. Баумана", House: "3 стр. 1"}},
+		{"house without street", dto.PostalAddress{City: "Тверь", House: "7"}},
+		{"street without house", dto.PostalAddress{City: "Тверь", Street: "ул. Советская"}},
+		{"street with comma", dto.PostalAddress{City: "Тверь", Street: "мкр. Южный, ул. Строителей", House: "4а"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, version := range []string{vcardV3, vcardV4} {
+				rec := dto.Record{ID: 1, Name: "Иван", LastName: "Петров", Addresses: []dto.PostalAddress{tt.address},
+					Phones: []dto.Phone{{Number: "89995554422", Type: dto.PhoneMobile, Primary: true}}}
+
+				var buf bytes.Buffer
+				if err := pkg.WriteVCard(&buf, recordToVCard(rec, version)); err != nil {
+					t.Fatalf("WriteVCard(): %v", err)
+				}
+				cards, err := pkg.ParseVCards(&buf)
+				if err != nil || len(cards) != 1 {
+					t.Fatalf("ParseVCards() = %d cards, %v", len(cards), err)
+				}
+				got, err := recordFromVCard(cards[0])
+				if err != nil {
+					t.Fatalf("recordFromVCard(): %v", err)
+				}
+				if !reflect.DeepEqual(got.Addresses, rec.Addresses) {
+					t.Errorf("vCard %s: addresses = %+v, want %+v", version, got.Addresses, rec.Addresses)
+				}
+			}
+		})
+	}
+}
+
+func TestVCardStreetHouse(t *testing.T) {
+	tests := []struct {
+		street, wantStreet, wantHouse string
+	}{
+		{"ул. Ленина, д. 5", "ул. Ленина", "5"},
+		{"ул. Ленина, д. 5 к. 2", "ул. Ленина", "5 к. 2"},
+		{"ул. Ленина, д. 5, корп. 2", "ул. Ленина", "5 к. 2"},
+		{"д. 5", "", "5"},
+		{"ул. Ленина", "ул. Ленина", ""},
+		{"ул. Ленина 5", "ул. Ленина 5", ""},
+		{"Ленина, 5", "Ленина, 5", ""},
+		{"", "", ""},
+	}
+
+	for _, tt := range tests {
+		street, house := vcardStreetHouse(tt.street)
+		if street != tt.wantStreet || house != tt.wantHouse {
+			t.Errorf("vcardStreetHouse(%q) = %q, %q, want %q, %q", tt.street, street, house, tt.wantStreet, tt.wantHouse)
+		}
+	}
+}
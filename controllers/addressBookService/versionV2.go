@@ -0,0 +1,89 @@
+package addressBookService
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Оптимистичная блокировка записей в REST API v2. Версия записи (поле version) растет при каждом ее изменении
+// и возвращается в заголовке ETag ответов с записью: ETag: "3". PUT, PATCH и DELETE /v2/records/{id}
+// требуют версию, которую клиент прочитал: в заголовке If-Match ("3" или * - любая версия) или в поле version тела.
+//
+//	412 Precondition Failed - версия из If-Match не совпадает с текущей
+//	409 Conflict - версия из тела не совпадает с текущей
+//	428 Precondition Required - версия не передана
+//
+// При несовпадении версий запись не изменяется, а в ответе возвращаются текущее состояние записи и ее ETag,
+// чтобы клиент мог объединить свои изменения с ним и повторить запрос с новой версией:
+//
+//	{"error": "record version mismatch", "current": {"id": 1, "name": "Имя", "version": 4, ...}}
+
+// errVersionRequired - запрос на изменение записи без ожидаемой версии.
+var errVersionRequired = errors.New("If-Match header or version is required")
+
+// precondition - ожидаемая клиентом версия записи.
+type precondition struct {
+	version int64 // 0 - If-Match: *, версия не проверяется
+	ifMatch bool  // Версия передана в заголовке If-Match, а не в теле запроса
+}
+
+// conflictStatus возвращает код ответа при несовпадении версий.
+func (p precondition) conflictStatus() int {
+	if p.ifMatch {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusConflict
+}
+
+// preconditionV2 возвращает ожидаемую версию записи из заголовка If-Match или, если его нет, из тела запроса
+// (bodyVersion). Если версии нет, возвращает errVersionRequired, а если If-Match не содержит ETag
+// записи вида "3" - dto.ErrVersionMismatch (такой ETag не совпадает ни с одной версией).
+func preconditionV2(req *http.Request, bodyVersion int64) (precondition, error) {
+	ifMatch := strings.TrimSpace(req.Header.Get("If-Match"))
+	switch {
+	case ifMatch == "*":
+		return precondition{ifMatch: true}, nil
+	case ifMatch != "":
+		version, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+		if err != nil || version <= 0 || ifMatch != etagV2(version) {
+			return precondition{ifMatch: true}, dto.ErrVersionMismatch
+		}
+		return precondition{version: version, ifMatch: true}, nil
+	case bodyVersion > 0:
+		return precondition{version: bodyVersion}, nil
+	}
+	return precondition{}, errVersionRequired
+}
+
+// etagV2 возвращает ETag записи с версией version.
+func etagV2(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// writeRecordV2 отправляет клиенту запись с кодом status и ее версией в заголовке ETag.
+func writeRecordV2(w http.ResponseWriter, status int, record dto.Record, wErr *pkg.WrappedError) {
+	w.Header().Set("ETag", etagV2(record.Version))
+	writeJSONV2(w, status, record, wErr)
+}
+
+// writeChangeErrorV2 отправляет клиенту ошибку изменения или удаления записи id. При несовпадении версий
+// код ответа зависит от источника версии (см. precondition), а в ответ добавляется текущее состояние записи.
+func (abs *AddressBookService) writeChangeErrorV2(w http.ResponseWriter, req *http.Request, id int64, pre precondition, err error, wErr *pkg.WrappedError) {
+	if !errors.Is(err, dto.ErrVersionMismatch) {
+		writeErrorV2(w, statusForError(err), err, wErr)
+		return
+	}
+
+	current, cErr := abs.recordByID(req, id)
+	if cErr != nil {
+		writeErrorV2(w, statusForError(cErr), cErr, wErr)
+		return
+	}
+	wErr.LogMsg(strconv.Itoa(pre.conflictStatus()) + ": " + err.Error())
+	w.Header().Set("ETag", etagV2(current.Version))
+	writeJSONV2(w, pre.conflictStatus(), dto.ErrorResponse{Error: err.Error(), Current: &current}, wErr)
+}
@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"fmt"
+	"log"
+)
+
+// Batch выполняет операции batch.Ops по порядку под одной блокировкой и возвращает результат каждой операции
+// (см. psg.Psg.Batch). Атомарный пакет после первой ошибки операции возвращается к состоянию до пакета.
+func (m *Memory) Batch(ctx context.Context, batch dto.BatchOps) (outcomes []dto.BatchOutcome, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) Batch()")
+	if err != nil {
+		log.Println("(m *Memory) Batch(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return nil, err
+	}
+
+	var saved state
+	if batch.Atomic {
+		saved = m.saveState()
+	}
+
+	outcomes = make([]dto.BatchOutcome, len(batch.Ops))
+	for i, op := range batch.Ops {
+		id, err := m.batchOp(ctx, op)
+		outcomes[i] = dto.BatchOutcome{ID: id, Err: err}
+		if err == nil {
+			continue
+		}
+		wErr.LogMsg(fmt.Sprintf("operation %d (%s): %s", i, op.Action, err.Error()))
+		if batch.Atomic {
+			m.restoreState(saved)
+			dto.Rollback(outcomes, i)
+			break
+		}
+	}
+
+	return outcomes, nil
+}
+
+// batchOp выполняет операцию пакета op и возвращает идентификатор записи. Вызывающий должен удерживать m.mu.
+func (m *Memory) batchOp(ctx context.Context, op dto.BatchOp) (id int64, err error) {
+	if op.Action == dto.ActionCreate {
+		return m.saveRecord(ctx, op.Record)
+	}
+
+	id = op.ID
+	if id == 0 {
+		i := m.indexByPhone(op.Phone)
+		if i == -1 {
+			return 0, dto.ErrPhoneNotFound
+		}
+		id = m.records[i].ID
+	}
+
+	switch {
+	case op.Action == dto.ActionUpdate && op.ID == 0:
+		return id, m.updateRecord(ctx, op.Phone, op.Patch)
+	case op.Action == dto.ActionUpdate:
+		return id, m.updateRecordByID(ctx, id, op.Patch)
+	case op.Action == dto.ActionDelete:
+		return id, m.deleteRecordByID(ctx, id, op.Version)
+	}
+	return id, fmt.Errorf("unknown batch action %q", op.Action)
+}
+
+// state - копия записей, корзины и истории для отката атомарного пакета.
+// Операции пакета не изменяют теги и дополнительные поля, поэтому они не копируются, а идентификаторы
+// записей и истории, как последовательности в PostgreSQL, после отката не используются повторно.
+type state struct {
+	records []dto.Record
+	trash   []dto.Record
+	history int // Длина истории (история только дополняется)
+}
+
+// saveState возвращает копию состояния хранилища. Вызывающий должен удерживать m.mu.
+func (m *Memory) saveState() state {
+	s := state{history: len(m.history)}
+	for _, r := range m.records {
+		s.records = append(s.records, cloneRecord(r))
+	}
+	for _, r := range m.trash {
+		s.trash = append(s.trash, cloneRecord(r))
+	}
+	return s
+}
+
+// restoreState возвращает хранилище к состоянию s. Вызывающий должен удерживать m.mu.
+func (m *Memory) restoreState(s state) {
+	m.records, m.trash = s.records, s.trash
+	m.history = m.history[:s.history]
+}
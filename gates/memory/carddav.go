@@ -0,0 +1,57 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"log"
+	"maps"
+)
+
+// CardDAVNames возвращает имена ресурсов CardDAV записей, созданных клиентами CardDAV (см. psg.Psg.CardDAVNames).
+func (m *Memory) CardDAVNames(ctx context.Context) (map[int64]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+
+	return maps.Clone(m.cardDAVNames), nil
+}
+
+// SaveCardDAVRecord сохраняет запись вместе с именем ресурса CardDAV name (см. psg.Psg.SaveCardDAVRecord).
+func (m *Memory) SaveCardDAVRecord(ctx context.Context, rec dto.Record, name string) (id int64, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) SaveCardDAVRecord()")
+	if err != nil {
+		log.Println("(m *Memory) SaveCardDAVRecord(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return 0, err
+	}
+
+	owner := int64(0)
+	for recordID, n := range m.cardDAVNames {
+		if n == name {
+			owner = recordID
+		}
+	}
+	if owner != 0 && m.indexByID(owner) != -1 {
+		wErr.LogMsg(dto.ErrCardDAVNameInUse.Error())
+		return 0, dto.ErrCardDAVNameInUse
+	}
+
+	id, err = m.saveRecord(ctx, rec)
+	if err != nil {
+		wErr.LogMsg(err.Error())
+		return 0, err
+	}
+	delete(m.cardDAVNames, owner) // Имя удаленной записи переходит новой записи
+	m.cardDAVNames[id] = name
+	return id, nil
+}
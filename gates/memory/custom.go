@@ -0,0 +1,151 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// CustomFields возвращает объявления дополнительных полей в порядке имен.
+func (m *Memory) CustomFields(ctx context.Context) ([]dto.CustomFieldDef, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+
+	return m.customFieldList(), nil
+}
+
+// CreateCustomField добавляет объявление дополнительного поля def.
+// Если поле с таким именем уже объявлено, возвращает ошибку dto.ErrCustomFieldExists.
+func (m *Memory) CreateCustomField(ctx context.Context, def dto.CustomFieldDef) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) CreateCustomField()")
+	if err != nil {
+		log.Println("(m *Memory) CreateCustomField(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := m.customFields[def.Key]; ok {
+		wErr.LogMsg(dto.ErrCustomFieldExists.Error())
+		return dto.ErrCustomFieldExists
+	}
+	m.customFields[def.Key] = def
+
+	return nil
+}
+
+// UpdateCustomField заменяет объявление дополнительного поля def.Key (тип поля изменить нельзя, как в psg.Psg).
+// Если поле не объявлено, возвращает ошибку dto.ErrCustomFieldNotFound.
+func (m *Memory) UpdateCustomField(ctx context.Context, def dto.CustomFieldDef) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) UpdateCustomField()")
+	if err != nil {
+		log.Println("(m *Memory) UpdateCustomField(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	current, ok := m.customFields[def.Key]
+	if !ok {
+		wErr.LogMsg(dto.ErrCustomFieldNotFound.Error())
+		return dto.ErrCustomFieldNotFound
+	}
+	if current.Type != def.Type {
+		err = &dto.ValidationError{Msg: fmt.Sprintf("type of custom field %q cannot be changed", def.Key)}
+		wErr.LogMsg(err.Error())
+		return err
+	}
+	m.customFields[def.Key] = def
+
+	return nil
+}
+
+// DeleteCustomField удаляет объявление дополнительного поля key и значения этого поля во всех записях.
+// Если поле не объявлено, возвращает ошибку dto.ErrCustomFieldNotFound.
+func (m *Memory) DeleteCustomField(ctx context.Context, key string) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) DeleteCustomField()")
+	if err != nil {
+		log.Println("(m *Memory) DeleteCustomField(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := m.customFields[key]; !ok {
+		wErr.LogMsg(dto.ErrCustomFieldNotFound.Error())
+		return dto.ErrCustomFieldNotFound
+	}
+	var ids []int64
+	for _, r := range m.records {
+		if _, ok := r.Custom[key]; ok {
+			ids = append(ids, r.ID)
+		}
+	}
+	m.trackUpdate(ctx, ids, func() bool {
+		delete(m.customFields, key)
+		for i := range m.records {
+			if _, ok := m.records[i].Custom[key]; ok {
+				m.records[i] = cloneRecord(m.records[i])
+				delete(m.records[i].Custom, key)
+			}
+		}
+		return true
+	})
+
+	// Значение удаляется и у записей в корзине, чтобы восстановленная запись не содержала необъявленное поле
+	for i := range m.trash {
+		if _, ok := m.trash[i].Custom[key]; !ok {
+			continue
+		}
+		before := cloneRecord(m.withTags(m.trash[i]))
+		m.trash[i] = cloneRecord(m.trash[i])
+		delete(m.trash[i].Custom, key)
+		m.trash[i].UpdatedAt = timestamp()
+		m.trash[i].Version++
+		after := cloneRecord(m.withTags(m.trash[i]))
+		m.addHistory(ctx, dto.ActionUpdate, after.ID, &before, &after)
+	}
+
+	return nil
+}
+
+// customFieldList возвращает объявления дополнительных полей, отсортированные по имени.
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) customFieldList() []dto.CustomFieldDef {
+	defs := make([]dto.CustomFieldDef, 0, len(m.customFields))
+	for _, d := range m.customFields {
+		defs = append(defs, d)
+	}
+	slices.SortFunc(defs, func(a, b dto.CustomFieldDef) int { return cmp.Compare(a.Key, b.Key) })
+	return defs
+}
+
+// customValue возвращает значение дополнительного поля записи по имени поля в условии ("custom.key").
+func customValue(r dto.Record, field string) (any, bool) {
+	value, ok := r.Custom[strings.TrimPrefix(field, dto.CustomFieldPrefix)]
+	return value, ok
+}
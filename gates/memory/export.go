@@ -0,0 +1,27 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"context"
+)
+
+// ExportRecords передает функции fn по одной все записи, удовлетворяющие условиям q, в порядке q.Sort
+// (см. psg.Psg.ExportRecords). Записи выбираются так же, как GetRecords без постраничной выборки,
+// а fn вызывается после снятия блокировки. Ошибка fn прерывает выгрузку и возвращается.
+func (m *Memory) ExportRecords(ctx context.Context, q dto.Query, fn func(dto.Record) error) error {
+	q.Limit, q.Cursor, q.WithTotal = 0, "", false
+	page, err := m.GetRecords(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range page.Records {
+		if err = ctxErr(ctx); err != nil {
+			return err
+		}
+		if err = fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"cmp"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// recordFieldIndex - номера полей dto.Record по именам из тега sql.field.
+var recordFieldIndex = func() map[string]int {
+	index := map[string]int{}
+	rt := reflect.TypeOf(dto.Record{})
+	for i := 0; i < rt.NumField(); i++ {
+		name, _, _ := strings.Cut(rt.Field(i).Tag.Get("sql.field"), ",")
+		if name != "" && name != "-" {
+			index[name] = i
+		}
+	}
+	return index
+}()
+
+// matchFilter проверяет, что запись r удовлетворяет условию f.
+// Семантика совпадает с условием WHERE, которое строит psg.SelectRecord.
+// Условие f должно быть проверено методом Validate.
+func matchFilter(r dto.Record, f *dto.Filter) bool {
+	switch {
+	case len(f.And) > 0:
+		for i := range f.And {
+			if !matchFilter(r, &f.And[i]) {
+				return false
+			}
+		}
+		return true
+	case len(f.Or) > 0:
+		for i := range f.Or {
+			if matchFilter(r, &f.Or[i]) {
+				return true
+			}
+		}
+		return false
+	case f.Not != nil:
+		return !matchFilter(r, f.Not)
+	}
+
+	// Условие на номер телефона проверяется для каждого номера записи, как EXISTS в psg
+	if f.Field == "phone" {
+		if f.Op == dto.OpNe {
+			return !r.HasPhone(f.Value.(string))
+		}
+		for _, p := range r.Phones {
+			if matchValue(p.Number, f) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Условие на адрес электронной почты - для каждого адреса записи
+	if f.Field == dto.EmailFilterField {
+		if f.Op == dto.OpNe {
+			return !r.HasEmail(f.Value.(string))
+		}
+		for _, e := range r.Emails {
+			if matchValue(e, f) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Условие на тег - для каждого тега записи (теги заполняются до проверки, см. Memory.withTags)
+	if f.Field == dto.TagFilterField {
+		for _, t := range r.Tags {
+			if f.Op == dto.OpNe && compare(t, f.Value, f.IgnoreCase) == 0 {
+				return false
+			}
+			if f.Op != dto.OpNe && matchValue(t, f) {
+				return true
+			}
+		}
+		return f.Op == dto.OpNe
+	}
+
+	// Условие на дополнительное поле; отсутствующее поле, как NULL в psg, удовлетворяет только "ne"
+	if strings.HasPrefix(f.Field, dto.CustomFieldPrefix) {
+		value, ok := customValue(r, f.Field)
+		if !ok {
+			return f.Op == dto.OpNe
+		}
+		return matchValue(value, f)
+	}
+
+	// Пустая дата, как NULL в psg, не удовлетворяет ни одному сравнению, кроме "ne"
+	if dto.DateFilterFields[f.Field] && reflect.ValueOf(r).Field(recordFieldIndex[f.Field]).String() == "" {
+		return f.Op == dto.OpNe
+	}
+
+	// Условие на часть адреса - для каждого адреса записи
+	if _, ok := dto.AddressFilterFields[f.Field]; ok {
+		for _, a := range r.Addresses {
+			if f.Op == dto.OpNe && compare(a.Part(f.Field), f.Value, f.IgnoreCase) == 0 {
+				return false
+			}
+			if f.Op != dto.OpNe && matchValue(a.Part(f.Field), f) {
+				return true
+			}
+		}
+		return f.Op == dto.OpNe
+	}
+
+	return matchValue(reflect.ValueOf(r).Field(recordFieldIndex[f.Field]).Interface(), f)
+}
+
+// matchValue проверяет, что значение поля value удовлетворяет сравнению f (листу дерева условий).
+func matchValue(value any, f *dto.Filter) bool {
+	switch f.Op {
+	case dto.OpEq:
+		return compare(value, f.Value, f.IgnoreCase) == 0
+	case dto.OpNe:
+		return compare(value, f.Value, f.IgnoreCase) != 0
+	case dto.OpGt:
+		return compare(value, f.Value, f.IgnoreCase) > 0
+	case dto.OpGte:
+		return compare(value, f.Value, f.IgnoreCase) >= 0
+	case dto.OpLt:
+		return compare(value, f.Value, f.IgnoreCase) < 0
+	case dto.OpLte:
+		return compare(value, f.Value, f.IgnoreCase) <= 0
+	case dto.OpIn:
+		for _, v := range f.Values {
+			if compare(value, v, f.IgnoreCase) == 0 {
+				return true
+			}
+		}
+		return false
+	case dto.OpPrefix, dto.OpContains:
+		s, sub := value.(string), f.Value.(string)
+		if f.IgnoreCase {
+			s, sub = strings.ToLower(s), strings.ToLower(sub)
+		}
+		if f.Op == dto.OpPrefix {
+			return strings.HasPrefix(s, sub)
+		}
+		return strings.Contains(s, sub)
+	}
+
+	return false
+}
+
+// compare сравнивает значение поля записи со значением из условия (после dto.Filter.Validate
+// их типы совпадают: string, int64 или time.Time).
+func compare(value, cond any, ignoreCase bool) int {
+	switch v := value.(type) {
+	case string:
+		c := cond.(string)
+		if ignoreCase {
+			v, c = strings.ToLower(v), strings.ToLower(c)
+		}
+		return cmp.Compare(v, c)
+	case int64:
+		return cmp.Compare(v, cond.(int64))
+	case time.Time:
+		return v.Compare(cond.(time.Time))
+	}
+	return -1
+}
+
+// sortKey возвращает значение поля сортировки записи (для сортировки по id - пустую строку,
+// для частей адреса - часть основного адреса).
+func sortKey(r dto.Record, field string) string {
+	switch field {
+	case "id":
+		return ""
+	case "city", "postal_index":
+		return r.PrimaryAddressPart(field)
+	}
+	return reflect.ValueOf(r).Field(recordFieldIndex[field]).String()
+}
+
+// compareByKey сравнивает записи по ключу (поле сортировки, id), как ORDER BY в psg.SelectRecord.
+func compareByKey(a, b dto.Record, field string) int {
+	if c := cmp.Compare(sortKey(a, field), sortKey(b, field)); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// sortRecords упорядочивает записи по ключу (field, id).
+func sortRecords(records []dto.Record, field string, desc bool) {
+	slices.SortFunc(records, func(a, b dto.Record) int {
+		if desc {
+			return compareByKey(b, a, field)
+		}
+		return compareByKey(a, b, field)
+	})
+}
+
+// afterCursor проверяет, что запись r в порядке сортировки находится после ключа cursor.
+func afterCursor(r dto.Record, field string, desc bool, cursor dto.Cursor) bool {
+	c := cmp.Compare(sortKey(r, field), cursor.Value)
+	if c == 0 {
+		c = cmp.Compare(r.ID, cursor.ID)
+	}
+	if desc {
+		return c < 0
+	}
+	return c > 0
+}
+
+// sortByRank упорядочивает записи по убыванию релевантности и по id, как сортировка dto.SortRelevance в psg.
+func sortByRank(records []dto.Record, ranks map[int64]float64) {
+	slices.SortFunc(records, func(a, b dto.Record) int {
+		if c := cmp.Compare(ranks[b.ID], ranks[a.ID]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
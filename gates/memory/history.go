@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"cmp"
+	"context"
+	"slices"
+	"time"
+)
+
+// History возвращает страницу истории изменений, удовлетворяющих q, от новых изменений к старым.
+func (m *Memory) History(ctx context.Context, q dto.HistoryQuery) (page dto.HistoryPage, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err = q.Validate(); err != nil {
+		return page, err
+	}
+	beforeID, _ := q.BeforeID()
+
+	if err = ctxErr(ctx); err != nil {
+		return page, err
+	}
+
+	for i := len(m.history) - 1; i >= 0; i-- {
+		e := m.history[i]
+		switch {
+		case beforeID != 0 && e.ID >= beforeID,
+			e.ID <= q.AfterID,
+			e.ID < q.SyncFrom,
+			q.RecordID != 0 && e.RecordID != q.RecordID,
+			q.Actor != "" && e.Actor != q.Actor,
+			q.Action != "" && e.Action != q.Action,
+			!q.Since.IsZero() && e.ChangedAt.Before(q.Since),
+			!q.Until.IsZero() && !e.ChangedAt.Before(q.Until):
+			continue
+		}
+		if q.Limit > 0 && len(page.Entries) == q.Limit {
+			page.NextCursor = q.NextCursor(page.Entries[q.Limit-1])
+			break
+		}
+		page.Entries = append(page.Entries, e)
+	}
+
+	return page, nil
+}
+
+// SyncToken возвращает отметку синхронизации (см. psg.Psg.SyncToken). Изменения в памяти видны сразу,
+// поэтому отметка - идентификатор следующей записи истории.
+func (m *Memory) SyncToken(ctx context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := ctxErr(ctx); err != nil {
+		return 0, err
+	}
+	return m.nextHistoryID, nil
+}
+
+// trackUpdate выполняет изменение change записей ids (полей, тегов, дополнительных полей), обновляет время
+// их изменения и записывает изменения в историю с состоянием записей до и после. Если change сообщает,
+// что ничего не изменилось, история не пишется. Вызывающий должен удерживать m.mu.
+func (m *Memory) trackUpdate(ctx context.Context, ids []int64, change func() bool) {
+	before := make([]*dto.Record, 0, len(ids))
+	for _, id := range ids {
+		before = append(before, m.snapshot(id))
+	}
+	if !change() {
+		return
+	}
+	for i, id := range ids {
+		m.touch(id)
+		m.addHistory(ctx, dto.ActionUpdate, id, before[i], m.snapshot(id))
+	}
+}
+
+// addHistory добавляет в историю изменение action записи id с автором и запросом из контекста.
+// Время изменения совпадает со временем изменения записи after (для удаления - текущее время).
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) addHistory(ctx context.Context, action string, id int64, before, after *dto.Record) {
+	meta := dto.ChangeMetaFrom(ctx)
+	entry := dto.HistoryEntry{
+		ID:        m.nextHistoryID,
+		RecordID:  id,
+		Action:    action,
+		Before:    before,
+		After:     after,
+		ChangedAt: timestamp(),
+		Actor:     meta.Actor,
+		RequestID: meta.RequestID,
+	}
+	if after != nil {
+		entry.ChangedAt = after.UpdatedAt
+	}
+	m.nextHistoryID++
+	m.history = append(m.history, entry)
+}
+
+// snapshot возвращает копию записи id с тегами для истории изменений или nil, если записи нет.
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) snapshot(id int64) *dto.Record {
+	i := m.indexByID(id)
+	if i == -1 {
+		return nil
+	}
+	r := cloneRecord(m.withTags(m.records[i]))
+	return &r
+}
+
+// recordsAsOf возвращает записи (с тегами) в состоянии на момент t по истории изменений, включая удаленные
+// позже t, в порядке идентификаторов. В памяти история ведется с создания каждой записи, поэтому
+// состояние записи - результат ее последнего изменения не позже t. Вызывающий должен удерживать m.mu.
+func (m *Memory) recordsAsOf(t time.Time) []dto.Record {
+	states := map[int64]*dto.Record{}
+	for _, e := range m.history {
+		if e.ChangedAt.After(t) {
+			continue
+		}
+		states[e.RecordID] = e.After
+	}
+
+	records := make([]dto.Record, 0, len(states))
+	for _, r := range states {
+		// Изменение записи в корзине (например, удаление дополнительного поля) не возвращает ее
+		if r != nil && r.DeletedAt == nil {
+			records = append(records, *r)
+		}
+	}
+	slices.SortFunc(records, func(a, b dto.Record) int { return cmp.Compare(a.ID, b.ID) })
+	return records
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"log"
+)
+
+// ImportRecords сохраняет записи recs под одной блокировкой и возвращает ошибку каждой записи
+// (см. psg.Psg.ImportRecords): dto.ErrPhoneInUse, если номер записи занят другой записью хранилища
+// или более ранней записью recs. При dryRun записи только проверяются.
+func (m *Memory) ImportRecords(ctx context.Context, recs []dto.Record, dryRun bool) (errs []error, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) ImportRecords()")
+	if err != nil {
+		log.Println("(m *Memory) ImportRecords(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return nil, err
+	}
+
+	// Номера проверяются по множеству, а не через phonesInUse, чтобы импорт не зависел квадратично от размера хранилища
+	used := map[string]bool{}
+	for _, r := range m.records {
+		for _, p := range r.Phones {
+			used[p.Number] = true
+		}
+	}
+
+	errs = make([]error, len(recs))
+	for i, rec := range recs {
+		for _, p := range rec.Phones {
+			if used[p.Number] {
+				errs[i] = dto.ErrPhoneInUse
+			}
+		}
+		if errs[i] != nil {
+			continue
+		}
+		for _, p := range rec.Phones {
+			used[p.Number] = true
+		}
+	}
+
+	if !dryRun {
+		for i, rec := range recs {
+			if errs[i] == nil {
+				m.insertRecord(ctx, rec)
+			}
+		}
+	}
+
+	return errs, nil
+}
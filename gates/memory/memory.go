@@ -0,0 +1,511 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"sync"
+	"time"
+)
+
+// Memory хранит записи адресной книги в памяти процесса.
+// Поведение повторяет psg.Psg (в том числе ошибки), но не требует базы данных,
+// поэтому подходит для демонстраций и тестов обработчиков.
+// Безопасна для одновременного использования из нескольких горутин.
+type Memory struct {
+	mu      sync.RWMutex
+	records []dto.Record // Записи в порядке добавления
+	trash   []dto.Record // Записи в корзине (с DeletedAt и DeletedBy) в порядке удаления; их теги остаются в recordTags
+	nextID  int64        // Идентификатор для следующей записи (аналог SERIAL)
+
+	customFields map[string]dto.CustomFieldDef // Объявления дополнительных полей по именам
+
+	tags       map[int64]dto.Tag // Теги по идентификаторам (Count не хранится и считается при чтении)
+	recordTags map[int64][]int64 // Идентификаторы тегов записей по идентификаторам записей
+	nextTagID  int64             // Идентификатор для следующего тега
+
+	history       []dto.HistoryEntry // История изменений записей в порядке изменений
+	nextHistoryID int64              // Идентификатор для следующей записи истории
+
+	cardDAVNames map[int64]string // Имена ресурсов CardDAV записей, созданных клиентами CardDAV
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		nextID:       1,
+		customFields: map[string]dto.CustomFieldDef{},
+		tags:         map[int64]dto.Tag{},
+		recordTags:   map[int64][]int64{},
+		nextTagID:    1,
+
+		nextHistoryID: 1,
+
+		cardDAVNames: map[int64]string{},
+	}
+}
+
+// SaveRecord сохраняет запись и возвращает ее идентификатор. Если один из номеров телефона rec.Phones
+// уже принадлежит другой записи, возвращает ошибку dto.ErrPhoneInUse.
+func (m *Memory) SaveRecord(ctx context.Context, rec dto.Record) (id int64, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) SaveRecord()")
+	if err != nil {
+		log.Println("(m *Memory) SaveRecord(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return 0, err
+	}
+
+	id, err = m.saveRecord(ctx, rec)
+	if err != nil {
+		wErr.LogMsg(err.Error())
+	}
+	return id, err
+}
+
+// saveRecord сохраняет запись, как SaveRecord. Вызывающий должен удерживать m.mu.
+func (m *Memory) saveRecord(ctx context.Context, rec dto.Record) (int64, error) {
+	if m.phonesInUse(-1, rec.Phones) {
+		return 0, dto.ErrPhoneInUse
+	}
+	return m.insertRecord(ctx, rec), nil
+}
+
+// insertRecord добавляет запись rec с новым идентификатором и записывает создание в историю,
+// не проверяя номера телефона. Вызывающий должен удерживать m.mu.
+func (m *Memory) insertRecord(ctx context.Context, rec dto.Record) int64 {
+	rec.ID = m.nextID
+	rec.Tags = nil // Теги хранятся отдельно (m.recordTags), у новой записи их нет
+	rec.CreatedAt = timestamp()
+	rec.UpdatedAt = rec.CreatedAt
+	rec.Version = 1
+	m.nextID++
+	m.records = append(m.records, cloneRecord(rec))
+	after := cloneRecord(rec)
+	m.addHistory(ctx, dto.ActionCreate, rec.ID, nil, &after)
+
+	return rec.ID
+}
+
+// GetRecords возвращает страницу записей, удовлетворяющих условию q.Filter, в порядке q.Sort
+// (постраничная выборка по ключу работает так же, как в psg.Psg). При q.AsOf записи выбираются
+// в состоянии на этот момент по истории изменений (см. recordsAsOf).
+func (m *Memory) GetRecords(ctx context.Context, q dto.Query) (page dto.RecordsPage, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	q.CustomFields = dto.NewCustomFieldDefs(m.customFieldList())
+	if err = q.Validate(); err != nil {
+		return page, err
+	}
+	var cursor *dto.Cursor
+	if q.Cursor != "" {
+		c, err := q.DecodeCursor()
+		if err != nil {
+			return page, err
+		}
+		cursor = &c
+	}
+
+	if err = ctxErr(ctx); err != nil {
+		return page, err
+	}
+
+	records := m.records
+	switch {
+	case q.Trash:
+		records = m.trash
+	case !q.AsOf.IsZero():
+		records = m.recordsAsOf(q.AsOf)
+	}
+
+	var matched []dto.Record
+	ranks := map[int64]float64{}
+	words := dto.SearchWords(q.Search)
+	for _, r := range records {
+		if q.AsOf.IsZero() {
+			r = m.withTags(r)
+		}
+		if q.Filter != nil && !matchFilter(r, q.Filter) {
+			continue
+		}
+		if q.Search != "" {
+			rank, ok := searchRank(r, words, q.Search)
+			if !ok {
+				continue
+			}
+			ranks[r.ID] = rank
+		}
+		matched = append(matched, r)
+	}
+	if q.WithTotal {
+		total := int64(len(matched))
+		page.Total = &total
+	}
+
+	field, desc := q.SortField()
+	if field == dto.SortRelevance {
+		sortByRank(matched, ranks)
+		if cursor != nil {
+			matched = matched[min(cursor.Offset, len(matched)):]
+			cursor = nil
+		}
+	} else {
+		sortRecords(matched, field, desc)
+	}
+
+	for _, r := range matched {
+		if cursor != nil && !afterCursor(r, field, desc, *cursor) {
+			continue
+		}
+		if q.Limit > 0 && len(page.Records) == q.Limit {
+			page.NextCursor = q.NextCursor(page.Records[q.Limit-1], q.Limit)
+			break
+		}
+		page.Records = append(page.Records, cloneRecord(r))
+	}
+
+	return page, nil
+}
+
+// UpdateRecord применяет частичное обновление patch к записи, у которой есть номер phone
+// (основной номер не изменяется, patch.Phone игнорируется; patch.Phones заменяет все номера).
+// Если номер телефона не найден, возвращает ошибку dto.ErrPhoneNotFound,
+// если новый номер занят другой записью - dto.ErrPhoneInUse,
+// если patch не затрагивает ни одного поля - dto.ErrNothingToUpdate,
+// если patch.Version не 0 и не совпадает с версией записи - dto.ErrVersionMismatch.
+func (m *Memory) UpdateRecord(ctx context.Context, phone string, patch dto.RecordPatch) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) UpdateRecord()")
+	if err != nil {
+		log.Println("(m *Memory) UpdateRecord(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	if err = m.updateRecord(ctx, phone, patch); err != nil {
+		wErr.LogMsg(err.Error())
+	}
+	return err
+}
+
+// updateRecord изменяет запись с номером phone, как UpdateRecord. Вызывающий должен удерживать m.mu.
+func (m *Memory) updateRecord(ctx context.Context, phone string, patch dto.RecordPatch) error {
+	patch.Phone = dto.PatchString{}
+	if patch.IsEmpty() {
+		return dto.ErrNothingToUpdate
+	}
+
+	i := m.indexByPhone(phone)
+	if i == -1 {
+		return dto.ErrPhoneNotFound
+	}
+	if err := m.checkVersion(i, patch.Version); err != nil {
+		return err
+	}
+	if m.phonesInUse(i, patch.Phones.Value) {
+		return dto.ErrPhoneInUse
+	}
+
+	m.trackUpdate(ctx, []int64{m.records[i].ID}, func() bool {
+		patch.Apply(&m.records[i])
+		return true
+	})
+
+	return nil
+}
+
+// DeleteRecordByPhone перемещает в корзину запись, у которой есть номер телефона phone.
+// Если номер телефона не найден, возвращает ошибку dto.ErrPhoneNotFound,
+// если version не 0 и не совпадает с версией записи - dto.ErrVersionMismatch.
+func (m *Memory) DeleteRecordByPhone(ctx context.Context, phone string, version int64) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) DeleteRecordByPhone()")
+	if err != nil {
+		log.Println("(m *Memory) DeleteRecordByPhone(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	if err = m.deleteRecordByPhone(ctx, phone, version); err != nil {
+		wErr.LogMsg(err.Error())
+	}
+	return err
+}
+
+// deleteRecordByPhone перемещает в корзину запись с номером phone, как DeleteRecordByPhone.
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) deleteRecordByPhone(ctx context.Context, phone string, version int64) error {
+	i := m.indexByPhone(phone)
+	if i == -1 {
+		return dto.ErrPhoneNotFound
+	}
+	if err := m.checkVersion(i, version); err != nil {
+		return err
+	}
+
+	m.deleteRecord(ctx, i)
+
+	return nil
+}
+
+// ReplaceRecord заменяет все поля записи с идентификатором rec.ID, включая номера телефона.
+// Если записи нет, возвращает ошибку dto.ErrRecordNotFound, если один из новых номеров
+// занят другой записью - dto.ErrPhoneInUse, если rec.Version не 0 и не совпадает с версией записи -
+// dto.ErrVersionMismatch.
+func (m *Memory) ReplaceRecord(ctx context.Context, rec dto.Record) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) ReplaceRecord()")
+	if err != nil {
+		log.Println("(m *Memory) ReplaceRecord(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	i, err := m.indexForUpdate(rec.ID, rec.Version, rec.Phones)
+	if err != nil {
+		wErr.LogMsg(err.Error())
+		return err
+	}
+
+	rec.Tags = nil
+	rec.CreatedAt, rec.Version = m.records[i].CreatedAt, m.records[i].Version
+	m.trackUpdate(ctx, []int64{rec.ID}, func() bool {
+		m.records[i] = cloneRecord(rec)
+		return true
+	})
+
+	return nil
+}
+
+// UpdateRecordByID применяет частичное обновление patch к записи с идентификатором id, включая номера телефона.
+// Ошибки те же, что у ReplaceRecord, а при пустом patch - dto.ErrNothingToUpdate.
+func (m *Memory) UpdateRecordByID(ctx context.Context, id int64, patch dto.RecordPatch) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) UpdateRecordByID()")
+	if err != nil {
+		log.Println("(m *Memory) UpdateRecordByID(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	if err = m.updateRecordByID(ctx, id, patch); err != nil {
+		wErr.LogMsg(err.Error())
+	}
+	return err
+}
+
+// updateRecordByID изменяет запись id, как UpdateRecordByID. Вызывающий должен удерживать m.mu.
+func (m *Memory) updateRecordByID(ctx context.Context, id int64, patch dto.RecordPatch) error {
+	if patch.IsEmpty() {
+		return dto.ErrNothingToUpdate
+	}
+
+	phones := patch.Phones.Value
+	if patch.Phone.Set {
+		phones = []dto.Phone{{Number: patch.Phone.Value}}
+	}
+	i, err := m.indexForUpdate(id, patch.Version, phones)
+	if err != nil {
+		return err
+	}
+
+	m.trackUpdate(ctx, []int64{id}, func() bool {
+		m.records[i] = cloneRecord(m.records[i])
+		patch.Apply(&m.records[i])
+		return true
+	})
+
+	return nil
+}
+
+// DeleteRecordByID перемещает в корзину запись с идентификатором id.
+// Если записи нет, возвращает ошибку dto.ErrRecordNotFound,
+// если version не 0 и не совпадает с версией записи - dto.ErrVersionMismatch.
+func (m *Memory) DeleteRecordByID(ctx context.Context, id, version int64) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) DeleteRecordByID()")
+	if err != nil {
+		log.Println("(m *Memory) DeleteRecordByID(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	if err = m.deleteRecordByID(ctx, id, version); err != nil {
+		wErr.LogMsg(err.Error())
+	}
+	return err
+}
+
+// deleteRecordByID перемещает в корзину запись id, как DeleteRecordByID. Вызывающий должен удерживать m.mu.
+func (m *Memory) deleteRecordByID(ctx context.Context, id, version int64) error {
+	i := m.indexByID(id)
+	if i == -1 {
+		return dto.ErrRecordNotFound
+	}
+	if err := m.checkVersion(i, version); err != nil {
+		return err
+	}
+
+	m.deleteRecord(ctx, i)
+
+	return nil
+}
+
+// PhoneExists возвращает ошибку dto.ErrPhoneInUse, если номер телефона уже используется,
+// и nil, если номер не найден.
+func (m *Memory) PhoneExists(ctx context.Context, phone string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := ctxErr(ctx); err != nil {
+		return err
+	}
+
+	if m.indexByPhone(phone) != -1 {
+		return dto.ErrPhoneInUse
+	}
+	return nil
+}
+
+// timestamp возвращает текущее время в UTC с точностью до микросекунд, как timestamptz в psg.Psg.
+func timestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// touch отмечает изменение записи с идентификатором id, обновляя UpdatedAt и увеличивая версию.
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) touch(id int64) {
+	if i := m.indexByID(id); i != -1 {
+		m.records[i].UpdatedAt = timestamp()
+		m.records[i].Version++
+	}
+}
+
+// deleteRecord перемещает запись с индексом i в корзину (теги записи сохраняются до восстановления
+// или окончательного удаления) и записывает удаление в историю. Вызывающий должен удерживать m.mu.
+func (m *Memory) deleteRecord(ctx context.Context, i int) {
+	r := m.records[i]
+	before := m.snapshot(r.ID)
+	deletedAt := timestamp()
+	r.DeletedAt, r.DeletedBy = &deletedAt, dto.ChangeMetaFrom(ctx).Actor
+	r.Version++
+	m.records = append(m.records[:i], m.records[i+1:]...)
+	m.trash = append(m.trash, r)
+	m.addHistory(ctx, dto.ActionDelete, r.ID, before, nil)
+}
+
+// ctxErr возвращает ошибку, если контекст запроса уже отменен.
+// Истечение дедлайна, как и в psg.Psg, превращается в dto.ErrTimeout.
+func ctxErr(ctx context.Context) error {
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return dto.ErrTimeout
+	}
+	return err
+}
+
+// indexByPhone возвращает индекс записи, у которой есть номер phone, или -1.
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) indexByPhone(phone string) int {
+	for i := range m.records {
+		if m.records[i].HasPhone(phone) {
+			return i
+		}
+	}
+	return -1
+}
+
+// phonesInUse сообщает, что один из номеров phones принадлежит записи с индексом, отличным от own
+// (аналог ограничения address_book_phones_number_key). Вызывающий должен удерживать m.mu.
+func (m *Memory) phonesInUse(own int, phones []dto.Phone) bool {
+	for _, p := range phones {
+		if j := m.indexByPhone(p.Number); j != -1 && j != own {
+			return true
+		}
+	}
+	return false
+}
+
+// indexByID возвращает индекс записи с идентификатором id или -1.
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) indexByID(id int64) int {
+	for i := range m.records {
+		if m.records[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexForUpdate возвращает индекс записи id с версией version (0 - любой), которой можно присвоить номера phones.
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) indexForUpdate(id, version int64, phones []dto.Phone) (int, error) {
+	i := m.indexByID(id)
+	if i == -1 {
+		return -1, dto.ErrRecordNotFound
+	}
+	if err := m.checkVersion(i, version); err != nil {
+		return -1, err
+	}
+	if m.phonesInUse(i, phones) {
+		return -1, dto.ErrPhoneInUse
+	}
+	return i, nil
+}
+
+// checkVersion возвращает dto.ErrVersionMismatch, если version не 0 и не совпадает с версией записи
+// с индексом i (аналог checkVersionTx в psg.Psg). Вызывающий должен удерживать m.mu.
+func (m *Memory) checkVersion(i int, version int64) error {
+	if version != 0 && m.records[i].Version != version {
+		return dto.ErrVersionMismatch
+	}
+	return nil
+}
+
+// cloneRecord возвращает копию записи с собственными списками номеров, адресов, адресов почты, тегов, дополнительных полей
+// и временем удаления, чтобы изменения хранимой записи не затрагивали записи, отданные вызывающему.
+func cloneRecord(r dto.Record) dto.Record {
+	r.Phones = append([]dto.Phone(nil), r.Phones...)
+	r.Addresses = append([]dto.PostalAddress(nil), r.Addresses...)
+	r.Emails = append([]string(nil), r.Emails...)
+	r.Custom = maps.Clone(r.Custom)
+	r.Tags = append([]string(nil), r.Tags...)
+	if r.DeletedAt != nil {
+		deletedAt := *r.DeletedAt
+		r.DeletedAt = &deletedAt
+	}
+	return r
+}
@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"strings"
+)
+
+// similarityThreshold - минимальная похожесть строки поиска на текст записи
+// (как значение по умолчанию pg_trgm.word_similarity_threshold).
+const similarityThreshold = 0.6
+
+// searchRank проверяет, что запись r подходит под строку поиска, и возвращает ее релевантность.
+// Повторяет поиск psg (см. whereBuilder.searchCond) без морфологии: запись подходит, если каждое
+// слово поиска является префиксом одного из слов ФИО, адреса, организации, должности или заметок,
+// если триграммы строки поиска в основном встречаются в тексте записи или если строка поиска - начало
+// одного из адресов электронной почты. Релевантность - доля найденных слов плюс похожесть по триграммам.
+func searchRank(r dto.Record, words []string, search string) (rank float64, ok bool) {
+	text := dto.SearchWords(strings.Join([]string{r.LastName, r.Name, r.MiddleName, r.Address,
+		r.Organization, r.JobTitle, r.Notes}, " "))
+
+	found := 0
+	for _, w := range words {
+		for _, t := range text {
+			if strings.HasPrefix(t, w) {
+				found++
+				break
+			}
+		}
+	}
+	similarity := wordSimilarity(words, text)
+
+	if found < len(words) && similarity < similarityThreshold && !hasEmailPrefix(r, search) {
+		return 0, false
+	}
+	return float64(found)/float64(len(words)) + similarity, true
+}
+
+// wordSimilarity возвращает долю триграмм слов поиска, встречающихся в триграммах слов текста
+// (приближение функции word_similarity из pg_trgm).
+func wordSimilarity(words, text []string) float64 {
+	query := trigrams(words)
+	if len(query) == 0 {
+		return 0
+	}
+	target := trigrams(text)
+
+	common := 0
+	for t := range query {
+		if target[t] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(query))
+}
+
+// trigrams возвращает множество триграмм слов так же, как pg_trgm:
+// каждое слово дополняется двумя пробелами в начале и одним в конце.
+func trigrams(words []string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range words {
+		runes := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// hasEmailPrefix сообщает, что один из адресов электронной почты записи начинается со строки поиска
+// (без учета регистра).
+func hasEmailPrefix(r dto.Record, search string) bool {
+	search = strings.ToLower(search)
+	for _, e := range r.Emails {
+		if strings.HasPrefix(e, search) {
+			return true
+		}
+	}
+	return false
+}
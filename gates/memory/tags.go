@@ -0,0 +1,268 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"strings"
+)
+
+// Tags возвращает теги в порядке имен с количеством записей в каждом.
+func (m *Memory) Tags(ctx context.Context) ([]dto.Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := ctxErr(ctx); err != nil {
+		return nil, err
+	}
+
+	tags := make([]dto.Tag, 0, len(m.tags))
+	for _, t := range m.tags {
+		tags = append(tags, t)
+	}
+	for recordID, ids := range m.recordTags {
+		if m.indexByID(recordID) == -1 {
+			continue // Запись в корзине
+		}
+		for _, id := range ids {
+			i := slices.IndexFunc(tags, func(t dto.Tag) bool { return t.ID == id })
+			tags[i].Count++
+		}
+	}
+	slices.SortFunc(tags, compareTags)
+	return tags, nil
+}
+
+// CreateTag добавляет тег name и возвращает его. Если тег с таким именем (без учета регистра) уже есть,
+// возвращает ошибку dto.ErrTagExists.
+func (m *Memory) CreateTag(ctx context.Context, name string) (dto.Tag, error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) CreateTag()")
+	if err != nil {
+		log.Println("(m *Memory) CreateTag(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return dto.Tag{}, err
+	}
+
+	if m.tagNameInUse(0, name) {
+		wErr.LogMsg(dto.ErrTagExists.Error())
+		return dto.Tag{}, dto.ErrTagExists
+	}
+	tag := dto.Tag{ID: m.nextTagID, Name: name}
+	m.nextTagID++
+	m.tags[tag.ID] = tag
+
+	return tag, nil
+}
+
+// RenameTag переименовывает тег id. Если тега нет, возвращает ошибку dto.ErrTagNotFound,
+// если имя занято другим тегом - dto.ErrTagExists.
+func (m *Memory) RenameTag(ctx context.Context, id int64, name string) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) RenameTag()")
+	if err != nil {
+		log.Println("(m *Memory) RenameTag(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	tag, ok := m.tags[id]
+	switch {
+	case !ok:
+		err = dto.ErrTagNotFound
+	case m.tagNameInUse(id, name):
+		err = dto.ErrTagExists
+	}
+	if err != nil {
+		wErr.LogMsg(err.Error())
+		return err
+	}
+	m.trackUpdate(ctx, m.tagMembers(id), func() bool {
+		tag.Name = name
+		m.tags[id] = tag
+		return true
+	})
+
+	return nil
+}
+
+// DeleteTag удаляет тег id; записи остаются, теряя только этот тег.
+// Если тега нет, возвращает ошибку dto.ErrTagNotFound.
+func (m *Memory) DeleteTag(ctx context.Context, id int64) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) DeleteTag()")
+	if err != nil {
+		log.Println("(m *Memory) DeleteTag(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := m.tags[id]; !ok {
+		wErr.LogMsg(dto.ErrTagNotFound.Error())
+		return dto.ErrTagNotFound
+	}
+	members := m.tagMembers(id)
+	m.trackUpdate(ctx, members, func() bool {
+		delete(m.tags, id)
+		// Тег убирается и у записей в корзине
+		for recordID := range m.recordTags {
+			m.removeTag(recordID, id)
+		}
+		return true
+	})
+
+	return nil
+}
+
+// AttachTag добавляет запись recordID в тег tagID; повторное добавление не считается ошибкой.
+// Если записи нет, возвращает ошибку dto.ErrRecordNotFound, если тега нет - dto.ErrTagNotFound.
+func (m *Memory) AttachTag(ctx context.Context, recordID, tagID int64) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) AttachTag()")
+	if err != nil {
+		log.Println("(m *Memory) AttachTag(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	if err = m.checkTagMembership(recordID, tagID); err != nil {
+		wErr.LogMsg(err.Error())
+		return err
+	}
+	m.trackUpdate(ctx, []int64{recordID}, func() bool {
+		if slices.Contains(m.recordTags[recordID], tagID) {
+			return false
+		}
+		m.recordTags[recordID] = append(m.recordTags[recordID], tagID)
+		return true
+	})
+
+	return nil
+}
+
+// DetachTag убирает запись recordID из тега tagID; если запись не входит в тег, ничего не меняется.
+// Если записи нет, возвращает ошибку dto.ErrRecordNotFound, если тега нет - dto.ErrTagNotFound.
+func (m *Memory) DetachTag(ctx context.Context, recordID, tagID int64) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) DetachTag()")
+	if err != nil {
+		log.Println("(m *Memory) DetachTag(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	if err = m.checkTagMembership(recordID, tagID); err != nil {
+		wErr.LogMsg(err.Error())
+		return err
+	}
+	m.trackUpdate(ctx, []int64{recordID}, func() bool {
+		return m.removeTag(recordID, tagID)
+	})
+
+	return nil
+}
+
+// checkTagMembership проверяет, что запись recordID и тег tagID существуют. Вызывающий должен удерживать m.mu.
+func (m *Memory) checkTagMembership(recordID, tagID int64) error {
+	if m.indexByID(recordID) == -1 {
+		return dto.ErrRecordNotFound
+	}
+	if _, ok := m.tags[tagID]; !ok {
+		return dto.ErrTagNotFound
+	}
+	return nil
+}
+
+// removeTag убирает тег tagID у записи recordID и сообщает, был ли он у записи. Вызывающий должен удерживать m.mu.
+func (m *Memory) removeTag(recordID, tagID int64) bool {
+	ids := m.recordTags[recordID]
+	if !slices.Contains(ids, tagID) {
+		return false
+	}
+	ids = slices.DeleteFunc(ids, func(id int64) bool { return id == tagID })
+	if len(ids) == 0 {
+		delete(m.recordTags, recordID)
+	} else {
+		m.recordTags[recordID] = ids
+	}
+	return true
+}
+
+// tagMembers возвращает идентификаторы записей с тегом tagID (кроме записей в корзине) по возрастанию.
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) tagMembers(tagID int64) []int64 {
+	var ids []int64
+	for recordID, tagIDs := range m.recordTags {
+		if slices.Contains(tagIDs, tagID) && m.indexByID(recordID) != -1 {
+			ids = append(ids, recordID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// tagNameInUse сообщает, что имя name без учета регистра занято тегом, отличным от own
+// (аналог индекса address_book_tags_name_key). Вызывающий должен удерживать m.mu.
+func (m *Memory) tagNameInUse(own int64, name string) bool {
+	for _, t := range m.tags {
+		if t.ID != own && strings.EqualFold(t.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// withTags возвращает запись r с именами ее тегов в порядке имен (как loadTags в psg).
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) withTags(r dto.Record) dto.Record {
+	r.Tags = nil
+	ids := m.recordTags[r.ID]
+	if len(ids) == 0 {
+		return r
+	}
+	tags := make([]dto.Tag, 0, len(ids))
+	for _, id := range ids {
+		tags = append(tags, m.tags[id])
+	}
+	slices.SortFunc(tags, compareTags)
+	for _, t := range tags {
+		r.Tags = append(r.Tags, t.Name)
+	}
+	return r
+}
+
+// compareTags упорядочивает теги по имени без учета регистра, затем по идентификатору.
+func compareTags(a, b dto.Tag) int {
+	if c := cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"time"
+)
+
+// RestoreRecord восстанавливает запись id из корзины. Если один из ее номеров уже занят другой записью,
+// возвращает ошибку dto.ErrPhoneInUse, если записи нет в корзине - dto.ErrRecordNotFound.
+func (m *Memory) RestoreRecord(ctx context.Context, id int64) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) RestoreRecord()")
+	if err != nil {
+		log.Println("(m *Memory) RestoreRecord(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	i := m.indexInTrash(id)
+	if i == -1 {
+		wErr.LogMsg(dto.ErrRecordNotFound.Error())
+		return dto.ErrRecordNotFound
+	}
+	r := m.trash[i]
+	if m.phonesInUse(-1, r.Phones) {
+		wErr.LogMsg(dto.ErrPhoneInUse.Error())
+		return dto.ErrPhoneInUse
+	}
+
+	r.DeletedAt, r.DeletedBy = nil, ""
+	r.UpdatedAt = timestamp()
+	r.Version++
+	m.trash = append(m.trash[:i], m.trash[i+1:]...)
+	// Записи хранятся в порядке добавления, то есть по возрастанию id
+	j, _ := slices.BinarySearchFunc(m.records, id, func(r dto.Record, id int64) int { return cmp.Compare(r.ID, id) })
+	m.records = slices.Insert(m.records, j, r)
+	m.addHistory(ctx, dto.ActionRestore, id, nil, m.snapshot(id))
+
+	return nil
+}
+
+// PurgeRecord окончательно удаляет запись id из корзины вместе с ее тегами.
+// Если записи нет в корзине, возвращает ошибку dto.ErrRecordNotFound.
+func (m *Memory) PurgeRecord(ctx context.Context, id int64) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(m *Memory) PurgeRecord()")
+	if err != nil {
+		log.Println("(m *Memory) PurgeRecord(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return err
+	}
+
+	i := m.indexInTrash(id)
+	if i == -1 {
+		wErr.LogMsg(dto.ErrRecordNotFound.Error())
+		return dto.ErrRecordNotFound
+	}
+	m.purge(ctx, i)
+
+	return nil
+}
+
+// PurgeTrash окончательно удаляет записи, перемещенные в корзину раньше before, и возвращает их количество.
+func (m *Memory) PurgeTrash(ctx context.Context, before time.Time) (n int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = ctxErr(ctx); err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(m.trash); {
+		if !m.trash[i].DeletedAt.Before(before) {
+			i++
+			continue
+		}
+		m.purge(ctx, i)
+		n++
+	}
+
+	return n, nil
+}
+
+// purge окончательно удаляет запись с индексом i в корзине вместе с ее тегами и записывает удаление в историю.
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) purge(ctx context.Context, i int) {
+	r := cloneRecord(m.withTags(m.trash[i]))
+	delete(m.recordTags, r.ID)
+	m.trash = append(m.trash[:i], m.trash[i+1:]...)
+	m.addHistory(ctx, dto.ActionPurge, r.ID, &r, nil)
+}
+
+// indexInTrash возвращает индекс записи с идентификатором id в корзине или -1.
+// Вызывающий должен удерживать m.mu.
+func (m *Memory) indexInTrash(id int64) int {
+	return slices.IndexFunc(m.trash, func(r dto.Record) bool { return r.ID == id })
+}
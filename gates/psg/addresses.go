@@ -0,0 +1,89 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"context"
+	"github.com/jackc/pgx/v5"
+)
+
+// Структурированные адреса записей хранятся в таблице address_book_addresses (см. миграцию 0006)
+// в порядке id; первый адрес записи - основной.
+
+// addressColumns - столбцы address_book_addresses в порядке полей dto.PostalAddress.
+const addressColumns = "label, country, region, city, street, house, apartment, postal_index"
+
+// insertAddresses добавляет адреса addresses записи id одним запросом INSERT с сохранением порядка.
+func insertAddresses(ctx context.Context, tx pgx.Tx, id int64, addresses []dto.PostalAddress) error {
+	if len(addresses) == 0 {
+		return nil
+	}
+	columns := make([][]string, 8)
+	for _, a := range addresses {
+		for i, v := range []string{a.Label, a.Country, a.Region, a.City, a.Street, a.House, a.Apartment, a.PostalIndex} {
+			columns[i] = append(columns[i], v)
+		}
+	}
+
+	sqlCommand := `INSERT INTO address_book_addresses (record_id, ` + addressColumns + `)
+		SELECT $1::bigint, ` + addressColumns + ` FROM unnest($2::text[], $3::text[], $4::text[], $5::text[], $6::text[], $7::text[], $8::text[], $9::text[])
+		WITH ORDINALITY AS a(` + addressColumns + `, n) ORDER BY n`
+	_, err := tx.Exec(ctx, sqlCommand, id, columns[0], columns[1], columns[2], columns[3],
+		columns[4], columns[5], columns[6], columns[7])
+	return err
+}
+
+// replaceAddresses заменяет все адреса записи id на addresses.
+func replaceAddresses(ctx context.Context, tx pgx.Tx, id int64, addresses []dto.PostalAddress) error {
+	_, err := tx.Exec(ctx, `DELETE FROM address_book_addresses WHERE record_id=$1`, id)
+	if err != nil {
+		return err
+	}
+	return insertAddresses(ctx, tx, id, addresses)
+}
+
+// setPrimaryAddress заменяет части основного адреса записи id на части address, сохраняя метку
+// (добавляет адрес, если адресов нет).
+func setPrimaryAddress(ctx context.Context, tx pgx.Tx, id int64, address dto.PostalAddress) error {
+	sqlCommand := `UPDATE address_book_addresses
+		SET country=$1, region=$2, city=$3, street=$4, house=$5, apartment=$6, postal_index=$7
+		WHERE id = (SELECT min(id) FROM address_book_addresses WHERE record_id=$8)`
+	tag, err := tx.Exec(ctx, sqlCommand, address.Country, address.Region, address.City, address.Street,
+		address.House, address.Apartment, address.PostalIndex, id)
+	if err != nil || tag.RowsAffected() > 0 {
+		return err
+	}
+	return insertAddresses(ctx, tx, id, []dto.PostalAddress{address})
+}
+
+// loadAddresses загружает адреса записей records одним запросом и заполняет Addresses.
+func loadAddresses(ctx context.Context, q querier, records []dto.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(records))
+	ids := make([]int64, 0, len(records))
+	for i, r := range records {
+		index[r.ID] = i
+		ids = append(ids, r.ID)
+	}
+
+	sqlCommand := `SELECT record_id, ` + addressColumns + ` FROM address_book_addresses
+		WHERE record_id = ANY($1) ORDER BY record_id, id`
+	rows, err := q.Query(ctx, sqlCommand, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var a dto.PostalAddress
+		err = rows.Scan(&id, &a.Label, &a.Country, &a.Region, &a.City, &a.Street, &a.House, &a.Apartment, &a.PostalIndex)
+		if err != nil {
+			return err
+		}
+		r := &records[index[id]]
+		r.Addresses = append(r.Addresses, a)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,110 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Выборка записей на момент времени (dto.Query.AsOf) строится по истории изменений address_book_history
+// (см. миграцию 0011): в начале запроса SelectRecord добавляется WITH с одноименными CTE address_book,
+// address_book_phones, address_book_addresses, address_book_emails и address_book_tag_members, которые
+// заслоняют таблицы и содержат состояние записей на этот момент. Поэтому условия, поиск и сортировка
+// строятся так же, как для текущего состояния.
+//
+// Состояние записи на момент t:
+//   - если есть изменения не позже t - состояние после последнего из них (удаленная запись и запись,
+//     измененная в корзине, не выбираются);
+//   - иначе, если есть изменения позже t, - состояние до первого из них (записи, созданной позже t, еще нет);
+//   - если изменений нет (запись не менялась с появления истории) - текущее состояние, если запись создана не позже t.
+//
+// «Последнее» и «первое» определяются по идентификатору строки истории, а не по changed_at: changed_at - время
+// начала транзакции, и транзакция, начатая раньше, может изменить запись позже. Строка истории вставляется,
+// пока транзакция держит блокировку записи, поэтому для одной записи порядок идентификаторов совпадает
+// с порядком фиксации изменений.
+//
+// Состояние ищется отдельно для каждой записи, существовавшей на момент t (запись в address_book, созданная
+// не позже t, или окончательно удаленная позже t), по индексу address_book_history (record_id, id),
+// поэтому запрос не просматривает всю историю.
+
+// asOfColumn - столбец CTE address_book с состоянием записи из истории (NULL для записей без истории).
+const asOfColumn = "as_of_record"
+
+// asOfTables возвращает WITH с состоянием таблиц записей на момент asOf (см. выше).
+// Внутри CTE имя таблицы, совпадающее с именем самого CTE, обозначает таблицу.
+func (b *whereBuilder) asOfTables(asOf time.Time) string {
+	t := b.placeholder(asOf) + "::timestamptz"
+
+	// Части адреса перечисляются в одном порядке для записей из истории и из таблицы
+	fields := make([]string, 0, len(dto.AddressFilterFields))
+	for f := range dto.AddressFilterFields {
+		fields = append(fields, f)
+	}
+	slices.Sort(fields)
+	fromJSON := make([]string, 0, len(fields))
+	for _, f := range fields {
+		fromJSON = append(fromJSON, "coalesce(a ->> '"+f+"', '') AS "+f)
+	}
+
+	return `WITH as_of_ids AS (
+		SELECT id FROM address_book WHERE created_at <= ` + t + `
+		UNION
+		SELECT record_id FROM address_book_history WHERE action = '` + dto.ActionPurge + `' AND changed_at > ` + t + `
+	), as_of_history AS (
+		SELECT i.id AS record_id, CASE WHEN l.found THEN l.after ELSE f.before END AS rec,
+		       coalesce(l.found OR f.found, false) AS tracked
+		FROM as_of_ids i
+		LEFT JOIN LATERAL (
+			SELECT true AS found, after FROM address_book_history h
+			WHERE h.record_id = i.id AND h.changed_at <= ` + t + ` ORDER BY h.id DESC LIMIT 1
+		) l ON true
+		LEFT JOIN LATERAL (
+			SELECT true AS found, before FROM address_book_history h
+			WHERE h.record_id = i.id AND h.changed_at > ` + t + ` ORDER BY h.id LIMIT 1
+		) f ON true
+	), as_of_records AS (
+		SELECT rec, record_id FROM as_of_history
+		WHERE rec IS NOT NULL AND rec ->> 'deleted_at' IS NULL AND (rec ->> 'created_at')::timestamptz <= ` + t + `
+	), as_of_unchanged AS (
+		SELECT record_id AS id FROM as_of_history WHERE NOT tracked
+	), address_book_phones AS (
+		SELECT record_id, p ->> 'number' AS number FROM as_of_records, jsonb_array_elements(rec -> 'phones') AS p
+		UNION ALL
+		SELECT record_id, number FROM address_book_phones WHERE record_id IN (SELECT id FROM as_of_unchanged)
+	), address_book_emails AS (
+		SELECT record_id, email FROM as_of_records, jsonb_array_elements_text(rec -> 'emails') AS email
+		UNION ALL
+		SELECT record_id, email FROM address_book_emails WHERE record_id IN (SELECT id FROM as_of_unchanged)
+	), address_book_tag_members AS (
+		SELECT record_id, name FROM as_of_records, jsonb_array_elements_text(rec -> 'tags') AS name
+		UNION ALL
+		SELECT record_id, name FROM address_book_tag_members WHERE record_id IN (SELECT id FROM as_of_unchanged)
+	), address_book_addresses AS (
+		SELECT n AS id, record_id, ` + strings.Join(fromJSON, ", ") + `
+		FROM as_of_records, jsonb_array_elements(rec -> 'addresses') WITH ORDINALITY AS e(a, n)
+		UNION ALL
+		SELECT id, record_id, ` + strings.Join(fields, ", ") + `
+		FROM address_book_addresses WHERE record_id IN (SELECT id FROM as_of_unchanged)
+	), address_book AS (
+		SELECT s.*, to_tsvector('russian', s.search_text) AS search_vector FROM (
+			SELECT v.*, v.last_name || ' ' || v.name || ' ' || v.middle_name || ' ' || v.address || ' ' ||
+			            v.organization || ' ' || v.job_title || ' ' || v.notes AS search_text
+			FROM (
+				SELECT r.id, coalesce(r.name, '') AS name, coalesce(r.last_name, '') AS last_name,
+				       coalesce(r.middle_name, '') AS middle_name, coalesce(r.address, '') AS address, r.birthday,
+				       coalesce(r.organization, '') AS organization, coalesce(r.job_title, '') AS job_title,
+				       coalesce(r.notes, '') AS notes, coalesce(r.custom, '{}') AS custom, r.created_at, r.updated_at,
+				       NULL::timestamptz AS deleted_at, '' AS deleted_by, coalesce(r.version, 0) AS version,
+				       rec AS ` + asOfColumn + `
+				FROM as_of_records, jsonb_to_record(rec) AS r(id bigint, name text, last_name text, middle_name text,
+				     address text, birthday date, organization text, job_title text, notes text, custom jsonb,
+				     created_at timestamptz, updated_at timestamptz, version bigint)
+				UNION ALL
+				SELECT id, name, last_name, middle_name, address, birthday, organization, job_title, notes, custom,
+				       created_at, updated_at, deleted_at, deleted_by, version, NULL
+				FROM address_book WHERE id IN (SELECT id FROM as_of_unchanged)
+			) v
+		) s
+	) `
+}
@@ -0,0 +1,119 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"log"
+)
+
+// errBatchRollback откатывает транзакцию атомарного пакета после ошибки операции.
+var errBatchRollback = errors.New("batch rolled back")
+
+// Batch выполняет операции batch.Ops по порядку в одной транзакции и возвращает результат каждой операции.
+// Операции выполняются так же, как SaveRecord, UpdateRecord (UpdateRecordByID) и DeleteRecordByPhone
+// (DeleteRecordByID), и возвращают те же ошибки. Атомарный пакет после первой ошибки операции откатывается,
+// ее результат содержит ошибку, а результаты остальных операций - dto.ErrBatchRolledBack. Иначе каждая
+// операция выполняется в своей точке сохранения (SAVEPOINT): ошибка отменяет только ее.
+// Ошибка Batch (например, истечение дедлайна) означает, что не выполнена ни одна операция.
+//
+// Пример использования:
+//
+//	batch := dto.BatchOps{Atomic: true, Ops: []dto.BatchOp{
+//	    {Action: dto.ActionCreate, Record: rec},
+//	    {Action: dto.ActionDelete, Phone: "81234567890"},
+//	}}
+//	outcomes, err := psg.Batch(ctx, batch)
+//	if err == nil && outcomes[1].Err != nil {
+//	    fmt.Println(outcomes[1].Err.Error())
+//	}
+func (p *Psg) Batch(ctx context.Context, batch dto.BatchOps) (outcomes []dto.BatchOutcome, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) Batch()")
+	if err != nil {
+		log.Println("(p *Psg) Batch(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Batch)
+	defer cancel()
+
+	outcomes = make([]dto.BatchOutcome, len(batch.Ops))
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		for i, op := range batch.Ops {
+			var id int64
+			var err error
+			if batch.Atomic {
+				id, err = batchOpTx(ctx, tx, op)
+			} else {
+				err = pgx.BeginFunc(ctx, tx, func(sp pgx.Tx) (err error) {
+					id, err = batchOpTx(ctx, sp, op)
+					return err
+				})
+			}
+			if err == nil {
+				outcomes[i].ID = id
+				continue
+			}
+
+			opErr, ok := batchOpErr(err)
+			if !ok {
+				return err
+			}
+			wErr.LogMsg(fmt.Sprintf("operation %d (%s): %s", i, op.Action, opErr.Error()))
+			outcomes[i] = dto.BatchOutcome{ID: id, Err: opErr}
+			if batch.Atomic {
+				dto.Rollback(outcomes, i)
+				return errBatchRollback
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBatchRollback) {
+		wErr.Specify(err, "pgx.BeginFunc()").LogError()
+		return nil, wrapTimeout(err)
+	}
+
+	return outcomes, nil
+}
+
+// batchOpTx выполняет операцию пакета op в транзакции tx и возвращает идентификатор записи.
+func batchOpTx(ctx context.Context, tx pgx.Tx, op dto.BatchOp) (id int64, err error) {
+	if op.Action == dto.ActionCreate {
+		return saveRecordTx(ctx, tx, op.Record)
+	}
+
+	id = op.ID
+	if id == 0 {
+		// Как в UpdateRecord, основной номер записи, найденной по номеру, не изменяется
+		op.Patch.Phone = dto.PatchString{}
+		if id, err = recordIDByPhoneTx(ctx, tx, op.Phone); err != nil {
+			return 0, err
+		}
+	}
+
+	switch op.Action {
+	case dto.ActionUpdate:
+		if op.Patch.IsEmpty() {
+			return id, dto.ErrNothingToUpdate
+		}
+		return id, updateRecordTx(ctx, tx, id, op.Patch)
+	case dto.ActionDelete:
+		return id, deleteRecordTx(ctx, tx, id, op.Version)
+	}
+	return id, fmt.Errorf("unknown batch action %q", op.Action)
+}
+
+// batchOpErr преобразует ошибку операции пакета в ошибку dto (как checkUpdateErr). ok == false для ошибок,
+// после которых пакет нельзя продолжить (истечение дедлайна, потеря соединения и т.п.).
+func batchOpErr(err error) (opErr error, ok bool) {
+	switch {
+	case isUniqueViolation(err, phoneUniqueConstraint):
+		return dto.ErrPhoneInUse, true
+	case errors.Is(err, dto.ErrRecordNotFound), errors.Is(err, dto.ErrPhoneNotFound),
+		errors.Is(err, dto.ErrVersionMismatch), errors.Is(err, dto.ErrNothingToUpdate):
+		return err, true
+	}
+	return err, false
+}
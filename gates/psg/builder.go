@@ -0,0 +1,256 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"strings"
+)
+
+// recordColumns - столбцы address_book в порядке сканирования в dto.Record (см. scanRecord).
+// Дата рождения возвращается строкой в формате dto.DateLayout (пустой, если не указана),
+// время создания, изменения и удаления (NULL вне корзины) - как timestamptz (см. scanRecord), последним - версия записи.
+// Номера телефонов, адреса и адреса электронной почты хранятся в отдельных таблицах и загружаются отдельно
+// (см. loadPhones, loadAddresses, loadEmails), как и имена тегов (loadTags).
+const recordColumns = "id, name, last_name, middle_name, address, " +
+	"coalesce(to_char(birthday, 'YYYY-MM-DD'), ''), organization, job_title, notes, custom, created_at, updated_at, deleted_at, deleted_by, version"
+
+// whereBuilder строит условие WHERE по дереву dto.Filter.
+// Значения передаются только через параметры $1, $2, ..., а имена столбцов берутся
+// из белого списка dto.FilterFields (теги sql.field) и экранируются как идентификаторы.
+// Имена дополнительных полей ("custom.key") должны быть объявлены в custom и тоже передаются параметрами.
+type whereBuilder struct {
+	values []any
+	custom dto.CustomFieldDefs
+}
+
+// placeholder добавляет значение в список параметров и возвращает его обозначение ($N).
+func (b *whereBuilder) placeholder(value any) string {
+	b.values = append(b.values, value)
+	return fmt.Sprintf("$%d", len(b.values))
+}
+
+// filterConds возвращает условия WHERE для filter (пустой список, если filter == nil).
+func (b *whereBuilder) filterConds(filter *dto.Filter) ([]string, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	cond, err := b.build(filter)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"(" + cond + ")"}, nil
+}
+
+// build возвращает SQL-условие для f. Условие f должно быть проверено методом Validate.
+func (b *whereBuilder) build(f *dto.Filter) (string, error) {
+	switch {
+	case len(f.And) > 0:
+		return b.group(f.And, " AND ")
+	case len(f.Or) > 0:
+		return b.group(f.Or, " OR ")
+	case f.Not != nil:
+		cond, err := b.build(f.Not)
+		if err != nil {
+			return "", err
+		}
+		return "NOT (" + cond + ")", nil
+	case f.Field == "phone":
+		return b.compareChild(f, "address_book_phones", "number")
+	case f.Field == dto.EmailFilterField:
+		return b.compareChild(f, "address_book_emails", "email")
+	case f.Field == dto.TagFilterField:
+		return b.compareChild(f, "address_book_tag_members", "name")
+	case dto.AddressFilterFields[f.Field] != 0:
+		return b.compareChild(f, "address_book_addresses", f.Field)
+	case strings.HasPrefix(f.Field, dto.CustomFieldPrefix):
+		return b.compareCustom(f)
+	default:
+		return b.compare(f)
+	}
+}
+
+// compareChild строит условие на строки дочерней таблицы table записи (номера телефона, адреса, теги):
+// сравнению f столбца column должна удовлетворять хотя бы одна строка, а для "ne" - ни одна строка
+// не должна быть равна значению.
+func (b *whereBuilder) compareChild(f *dto.Filter, table, column string) (string, error) {
+	leaf := *f
+	exists := "EXISTS"
+	if leaf.Op == dto.OpNe {
+		leaf.Op, exists = dto.OpEq, "NOT EXISTS"
+	}
+	leaf.Field = column
+	cond, err := b.compareColumn(&leaf)
+	if err != nil {
+		return "", err
+	}
+	return exists + " (SELECT 1 FROM " + table + " WHERE " + table + ".record_id = address_book.id AND " + cond + ")", nil
+}
+
+// sortExpr возвращает SQL-выражение поля сортировки field. Части адреса берутся из основного адреса записи
+// (пустая строка, если адресов нет), чтобы ключ страницы (значение, id) всегда был определен. Строки
+// сравниваются побайтно (COLLATE "C"), как в memory.Memory, а не по правилам локали базы данных: так порядок
+// записей и токены продолжения одинаковы в обоих хранилищах (см. миграцию 0017).
+func sortExpr(field string) string {
+	column := pgx.Identifier{field}.Sanitize()
+	switch _, address := dto.AddressFilterFields[field]; {
+	case field == "id":
+		return column
+	case address:
+		return "coalesce((SELECT " + column + " FROM address_book_addresses" +
+			" WHERE address_book_addresses.record_id = address_book.id ORDER BY address_book_addresses.id LIMIT 1), '') COLLATE \"C\""
+	}
+	return column + ` COLLATE "C"`
+}
+
+func (b *whereBuilder) group(filters []dto.Filter, op string) (string, error) {
+	conds := make([]string, 0, len(filters))
+	for i := range filters {
+		cond, err := b.build(&filters[i])
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, "("+cond+")")
+	}
+	return strings.Join(conds, op), nil
+}
+
+// compare строит сравнение одного поля. При IgnoreCase строковые значения сравниваются через lower() и ILIKE.
+func (b *whereBuilder) compare(f *dto.Filter) (string, error) {
+	if _, ok := dto.FilterFields[f.Field]; !ok {
+		return "", fmt.Errorf("unknown filter field: %q", f.Field)
+	}
+	return b.compareColumn(f)
+}
+
+// compareCustom строит сравнение дополнительного поля: значение извлекается из custom (->>) и приводится
+// к типу объявления. Точное сравнение записывается как вхождение объекта (@>), чтобы использовать GIN-индекс.
+// Записи без поля удовлетворяют только условию "ne", как и записи без даты рождения.
+func (b *whereBuilder) compareCustom(f *dto.Filter) (string, error) {
+	def, ok := b.custom.Lookup(f.Field)
+	if !ok {
+		return "", fmt.Errorf("unknown filter field: %q", f.Field)
+	}
+	if f.Op == dto.OpEq && !f.IgnoreCase {
+		return "custom @> " + b.placeholder(map[string]any{def.Key: f.Value}) + "::jsonb", nil
+	}
+
+	expr := "(custom ->> " + b.placeholder(def.Key) + "::text)"
+	switch def.Type {
+	case dto.CustomInt:
+		expr += "::bigint"
+	case dto.CustomDate:
+		expr += "::date"
+	}
+	return b.compareExpr(f, expr)
+}
+
+// compareColumn строит сравнение столбца f.Field без проверки по белому списку dto.FilterFields.
+// Имя столбца должно быть задано кодом, а не взято из запроса.
+func (b *whereBuilder) compareColumn(f *dto.Filter) (string, error) {
+	return b.compareExpr(f, pgx.Identifier{f.Field}.Sanitize())
+}
+
+// compareExpr строит сравнение SQL-выражения column со значением условия f.
+func (b *whereBuilder) compareExpr(f *dto.Filter, column string) (string, error) {
+	expr := column
+
+	_, isString := f.Value.(string)
+	if f.Op == dto.OpIn && len(f.Values) > 0 {
+		_, isString = f.Values[0].(string)
+	}
+	ignoreCase := f.IgnoreCase && isString
+	value := func(v any) string {
+		if ignoreCase {
+			return "lower(" + b.placeholder(v) + ")"
+		}
+		return b.placeholder(v)
+	}
+	if ignoreCase {
+		column = "lower(" + column + ")"
+	}
+
+	switch f.Op {
+	case dto.OpEq:
+		return column + " = " + value(f.Value), nil
+	case dto.OpNe:
+		return column + " IS DISTINCT FROM " + value(f.Value), nil
+	case dto.OpGt:
+		return column + " > " + value(f.Value), nil
+	case dto.OpGte:
+		return column + " >= " + value(f.Value), nil
+	case dto.OpLt:
+		return column + " < " + value(f.Value), nil
+	case dto.OpLte:
+		return column + " <= " + value(f.Value), nil
+	case dto.OpIn:
+		values := b.arrayPlaceholder(f)
+		if ignoreCase {
+			return column + " = ANY (SELECT lower(v) FROM unnest(" + values + ") v)", nil
+		}
+		return column + " = ANY (" + values + ")", nil
+	case dto.OpPrefix, dto.OpContains:
+		pattern := escapeLike(f.Value.(string)) + "%"
+		if f.Op == dto.OpContains {
+			pattern = "%" + pattern
+		}
+		// Для ILIKE lower() не нужен: сравнение уже без учета регистра
+		like := " LIKE "
+		if ignoreCase {
+			column = expr
+			like = " ILIKE "
+		}
+		return column + like + b.placeholder(pattern) + ` ESCAPE '\'`, nil
+	default:
+		return "", fmt.Errorf("unknown filter operation: %q", f.Op)
+	}
+}
+
+// arrayPlaceholder добавляет значения f.Values одним параметром-массивом и возвращает его обозначение:
+// число параметров запроса ограничено 65535, а списки идентификаторов бывают длиннее. Строки передаются
+// массивом text[], а для дат (в том числе дополнительных полей) приводятся к date[]: pgx не переводит строки
+// в двоичный формат дат.
+func (b *whereBuilder) arrayPlaceholder(f *dto.Filter) string {
+	if _, isString := f.Values[0].(string); !isString {
+		return b.placeholder(f.Values)
+	}
+	values := make([]string, len(f.Values))
+	for i, v := range f.Values {
+		values[i], _ = v.(string)
+	}
+	isDate := dto.DateFilterFields[f.Field]
+	if def, ok := b.custom.Lookup(f.Field); ok {
+		isDate = def.Type == dto.CustomDate
+	}
+	if isDate {
+		return b.placeholder(values) + "::text[]::date[]"
+	}
+	return b.placeholder(values) + "::text[]"
+}
+
+// escapeLike экранирует специальные символы шаблона LIKE, чтобы значение сравнивалось буквально.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// searchCond строит условие поиска по строке search и выражение релевантности для сортировки.
+// Запись подходит, если ее полнотекстовый вектор (конфигурация russian) содержит все слова
+// поиска как префиксы лексем, если строка поиска похожа на часть текста записи
+// по триграммам (оператор <% расширения pg_trgm, порог pg_trgm.word_similarity_threshold)
+// или если с нее начинается один из адресов электронной почты записи.
+// Столбцы search_vector и search_text создает миграция 0004 (состав полей - миграция 0007).
+func (b *whereBuilder) searchCond(search string) (cond, rank string) {
+	words := dto.SearchWords(search)
+	for i := range words {
+		words[i] += ":*"
+	}
+	tsQuery := "to_tsquery('russian', " + b.placeholder(strings.Join(words, " & ")) + ")"
+	text := b.placeholder(search)
+	email := b.placeholder(escapeLike(strings.ToLower(search)) + "%")
+
+	cond = "(search_vector @@ " + tsQuery + " OR " + text + " <% search_text" +
+		" OR EXISTS (SELECT 1 FROM address_book_emails WHERE address_book_emails.record_id = address_book.id" +
+		" AND email LIKE " + email + ` ESCAPE '\'))`
+	rank = "ts_rank(search_vector, " + tsQuery + ") + word_similarity(" + text + ", search_text)"
+	return cond, rank
+}
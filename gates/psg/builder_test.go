@@ -0,0 +1,310 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWhereBuilder(t *testing.T) {
+	custom := dto.NewCustomFieldDefs([]dto.CustomFieldDef{
+		{Key: "floor", Type: dto.CustomInt},
+		{Key: "hired", Type: dto.CustomDate},
+	})
+
+	tests := []struct {
+		name       string
+		filter     dto.Filter
+		wantSQL    string
+		wantValues []any
+		wantErr    string
+	}{
+		{
+			name:       "eq",
+			filter:     dto.Filter{Field: "name", Op: dto.OpEq, Value: "Иван"},
+			wantSQL:    `"name" = $1`,
+			wantValues: []any{"Иван"},
+		},
+		{
+			name: "and with id range",
+			filter: dto.Filter{And: []dto.Filter{
+				{Field: "last_name", Op: dto.OpPrefix, Value: "Ив"},
+				{Field: "id", Op: dto.OpGte, Value: int64(10)},
+				{Field: "id", Op: dto.OpLte, Value: int64(20)},
+			}},
+			wantSQL:    `("last_name" LIKE $1 ESCAPE '\') AND ("id" >= $2) AND ("id" <= $3)`,
+			wantValues: []any{"Ив%", int64(10), int64(20)},
+		},
+		{
+			name: "or with ignore case",
+			filter: dto.Filter{Or: []dto.Filter{
+				{Field: "name", Op: dto.OpEq, Value: "Иван", IgnoreCase: true},
+				{Field: "id", Op: dto.OpGt, Value: int64(5), IgnoreCase: true},
+			}},
+			wantSQL:    `(lower("name") = lower($1)) OR ("id" > $2)`,
+			wantValues: []any{"Иван", int64(5)},
+		},
+		{
+			name:       "not",
+			filter:     dto.Filter{Not: &dto.Filter{Field: "id", Op: dto.OpLt, Value: int64(3)}},
+			wantSQL:    `NOT ("id" < $1)`,
+			wantValues: []any{int64(3)},
+		},
+		{
+			name: "nested groups number placeholders in order",
+			filter: dto.Filter{And: []dto.Filter{
+				{Or: []dto.Filter{
+					{Field: "city", Op: dto.OpEq, Value: "Москва"},
+					{Field: "city", Op: dto.OpEq, Value: "Казань"},
+				}},
+				{Not: &dto.Filter{Field: "organization", Op: dto.OpNe, Value: ""}},
+			}},
+			wantSQL: `((EXISTS (SELECT 1 FROM address_book_addresses WHERE address_book_addresses.record_id = address_book.id AND "city" = $1))` +
+				` OR (EXISTS (SELECT 1 FROM address_book_addresses WHERE address_book_addresses.record_id = address_book.id AND "city" = $2)))` +
+				` AND (NOT ("organization" IS DISTINCT FROM $3))`,
+			wantValues: []any{"Москва", "Казань", ""},
+		},
+		{
+			name:       "in ids",
+			filter:     dto.Filter{Field: "id", Op: dto.OpIn, Values: []any{int64(1), int64(2), int64(3)}},
+			wantSQL:    `"id" = ANY ($1)`,
+			wantValues: []any{[]any{int64(1), int64(2), int64(3)}},
+		},
+		{
+			name:       "in strings ignore case",
+			filter:     dto.Filter{Field: "name", Op: dto.OpIn, Values: []any{"Иван", "Петр"}, IgnoreCase: true},
+			wantSQL:    `lower("name") = ANY (SELECT lower(v) FROM unnest($1::text[]) v)`,
+			wantValues: []any{[]string{"Иван", "Петр"}},
+		},
+		{
+			name:       "in dates",
+			filter:     dto.Filter{Field: "birthday", Op: dto.OpIn, Values: []any{"1990-01-02", "1991-03-04"}},
+			wantSQL:    `"birthday" = ANY ($1::text[]::date[])`,
+			wantValues: []any{[]string{"1990-01-02", "1991-03-04"}},
+		},
+		{
+			name:       "in phones",
+			filter:     dto.Filter{Field: "phone", Op: dto.OpIn, Values: []any{"89995554422"}},
+			wantSQL:    `EXISTS (SELECT 1 FROM address_book_phones WHERE address_book_phones.record_id = address_book.id AND "number" = ANY ($1::text[]))`,
+			wantValues: []any{[]string{"89995554422"}},
+		},
+		{
+			name:       "prefix escapes like patterns",
+			filter:     dto.Filter{Field: "notes", Op: dto.OpPrefix, Value: `50%_off\`},
+			wantSQL:    `"notes" LIKE $1 ESCAPE '\'`,
+			wantValues: []any{`50\%\_off\\%`},
+		},
+		{
+			name:       "contains ignore case",
+			filter:     dto.Filter{Field: "name", Op: dto.OpContains, Value: "a_b", IgnoreCase: true},
+			wantSQL:    `"name" ILIKE $1 ESCAPE '\'`,
+			wantValues: []any{`%a\_b%`},
+		},
+		{
+			name:       "ne on child rows",
+			filter:     dto.Filter{Field: "email", Op: dto.OpNe, Value: "a@example.com"},
+			wantSQL:    `NOT EXISTS (SELECT 1 FROM address_book_emails WHERE address_book_emails.record_id = address_book.id AND "email" = $1)`,
+			wantValues: []any{"a@example.com"},
+		},
+		{
+			name:       "custom eq",
+			filter:     dto.Filter{Field: "custom.floor", Op: dto.OpEq, Value: int64(3)},
+			wantSQL:    `custom @> $1::jsonb`,
+			wantValues: []any{map[string]any{"floor": int64(3)}},
+		},
+		{
+			name:       "custom range",
+			filter:     dto.Filter{Field: "custom.floor", Op: dto.OpGte, Value: int64(2)},
+			wantSQL:    `(custom ->> $1::text)::bigint >= $2`,
+			wantValues: []any{"floor", int64(2)},
+		},
+		{
+			name:       "custom dates in",
+			filter:     dto.Filter{Field: "custom.hired", Op: dto.OpIn, Values: []any{"2020-01-01"}},
+			wantSQL:    `(custom ->> $1::text)::date = ANY ($2::text[]::date[])`,
+			wantValues: []any{"hired", []string{"2020-01-01"}},
+		},
+		{
+			name:    "field outside whitelist",
+			filter:  dto.Filter{Field: `name" OR 1=1 --`, Op: dto.OpEq, Value: "x"},
+			wantErr: "unknown filter field",
+		},
+		{
+			name:    "column without sql.field tag",
+			filter:  dto.Filter{Field: "deleted_by", Op: dto.OpEq, Value: "x"},
+			wantErr: "unknown filter field",
+		},
+		{
+			name:    "undeclared custom field",
+			filter:  dto.Filter{Field: "custom.room", Op: dto.OpEq, Value: "x"},
+			wantErr: "unknown filter field",
+		},
+		{
+			name: "field outside whitelist in group",
+			filter: dto.Filter{Or: []dto.Filter{
+				{Field: "name", Op: dto.OpEq, Value: "x"},
+				{Field: "password", Op: dto.OpEq, Value: "x"},
+			}},
+			wantErr: "unknown filter field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &whereBuilder{custom: custom}
+			got, err := b.build(&tt.filter)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("build() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("build() error = %v", err)
+			}
+			if got != tt.wantSQL {
+				t.Errorf("build() =\n%s\nwant\n%s", got, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(b.values, tt.wantValues) {
+				t.Errorf("values = %#v, want %#v", b.values, tt.wantValues)
+			}
+		})
+	}
+}
+
+func TestSelectRecord(t *testing.T) {
+	const from = "SELECT " + recordColumns + " FROM address_book WHERE deleted_at IS NULL"
+
+	tests := []struct {
+		name       string
+		q          dto.Query
+		wantSQL    string
+		wantValues []any
+		wantErr    bool
+	}{
+		{
+			name:    "all records",
+			q:       dto.Query{},
+			wantSQL: from + " ORDER BY id ASC",
+		},
+		{
+			name: "filter, sort and limit",
+			q: dto.Query{Filter: &dto.Filter{Or: []dto.Filter{
+				{Field: "name", Op: dto.OpPrefix, Value: "Jo", IgnoreCase: true},
+				{Field: "id", Op: dto.OpIn, Values: []any{float64(1), "2"}},
+			}}, Sort: "-last_name", Limit: 10},
+			wantSQL:    from + ` AND (("name" ILIKE $1 ESCAPE '\') OR ("id" = ANY ($2))) ORDER BY "last_name" COLLATE "C" DESC, id DESC LIMIT 11`,
+			wantValues: []any{"Jo%", []any{int64(1), int64(2)}},
+		},
+		{
+			name: "id range from json numbers",
+			q: dto.Query{Filter: &dto.Filter{And: []dto.Filter{
+				{Field: "id", Op: dto.OpGte, Value: float64(10)},
+				{Field: "id", Op: dto.OpLt, Value: float64(20)},
+			}}},
+			wantSQL:    from + ` AND (("id" >= $1) AND ("id" < $2)) ORDER BY id ASC`,
+			wantValues: []any{int64(10), int64(20)},
+		},
+		{
+			name: "cursor placeholders follow filter placeholders",
+			q: dto.Query{
+				Filter: &dto.Filter{Field: "last_name", Op: dto.OpEq, Value: "Петров"},
+				Sort:   "name",
+				Limit:  2,
+				Cursor: (&dto.Query{Filter: &dto.Filter{Field: "last_name", Op: dto.OpEq, Value: "Петров"}, Sort: "name"}).
+					NextCursor(dto.Record{ID: 7, Name: "Иван"}, 2),
+			},
+			wantSQL: from + ` AND ("last_name" = $1) AND ("name" COLLATE "C", id) > ($2, $3)` +
+				` ORDER BY "name" COLLATE "C" ASC, id ASC LIMIT 3`,
+			wantValues: []any{"Петров", "Иван", int64(7)},
+		},
+		{
+			name: "sort by primary address part",
+			q:    dto.Query{Sort: "-city", Limit: 5},
+			wantSQL: from + ` ORDER BY coalesce((SELECT "city" FROM address_book_addresses WHERE address_book_addresses.record_id = address_book.id` +
+				` ORDER BY address_book_addresses.id LIMIT 1), '') COLLATE "C" DESC, id DESC LIMIT 6`,
+		},
+		{
+			name: "cursor issued for another filter",
+			q: dto.Query{
+				Filter: &dto.Filter{Field: "last_name", Op: dto.OpEq, Value: "Петров"},
+				Sort:   "name",
+				Cursor: (&dto.Query{Sort: "name"}).NextCursor(dto.Record{ID: 7, Name: "Иван"}, 2),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "field outside whitelist",
+			q:       dto.Query{Filter: &dto.Filter{Field: "version", Op: dto.OpEq, Value: float64(1)}},
+			wantErr: true,
+		},
+		{
+			name:    "sql in field name",
+			q:       dto.Query{Filter: &dto.Filter{Field: `id"; DROP TABLE address_book; --`, Op: dto.OpEq, Value: float64(1)}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, values, err := (&Psg{}).SelectRecord(tt.q)
+			if tt.wantErr {
+				var vErr *dto.ValidationError
+				if !errors.As(err, &vErr) {
+					t.Fatalf("SelectRecord() error = %v, want *dto.ValidationError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SelectRecord() error = %v", err)
+			}
+			if got != tt.wantSQL {
+				t.Errorf("SelectRecord() =\n%s\nwant\n%s", got, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(values, tt.wantValues) {
+				t.Errorf("values = %#v, want %#v", values, tt.wantValues)
+			}
+		})
+	}
+}
+
+func TestSelectRecordAsOf(t *testing.T) {
+	asOf := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	got, values, err := (&Psg{}).SelectRecord(dto.Query{AsOf: asOf, Filter: &dto.Filter{Field: "name", Op: dto.OpEq, Value: "Иван"}})
+	if err != nil {
+		t.Fatalf("SelectRecord() error = %v", err)
+	}
+	// Состояние каждой записи ищется по индексу (record_id, id), а не сортировкой всей истории
+	for _, want := range []string{
+		"WITH as_of_ids AS (",
+		"WHERE h.record_id = i.id AND h.changed_at <= $1::timestamptz ORDER BY h.id DESC LIMIT 1",
+		"WHERE h.record_id = i.id AND h.changed_at > $1::timestamptz ORDER BY h.id LIMIT 1",
+		`WHERE deleted_at IS NULL AND ("name" = $2) ORDER BY id ASC`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SelectRecord() =\n%s\nwant to contain\n%s", got, want)
+		}
+	}
+	if strings.Contains(got, "DISTINCT ON") {
+		t.Errorf("SelectRecord() sorts the whole history:\n%s", got)
+	}
+	if !reflect.DeepEqual(values, []any{asOf, "Иван"}) {
+		t.Errorf("values = %#v", values)
+	}
+}
+
+func TestSelectHistorySyncFrom(t *testing.T) {
+	got, values := selectHistory(dto.HistoryQuery{SyncFrom: 42, RecordID: 7, Limit: 100})
+	// Изменения выбираются по номеру фиксации транзакции, а не по идентификатору истории
+	want := "SELECT id, record_id, action, before, after, changed_at, actor, request_id FROM address_book_history" +
+		" WHERE txid IN (SELECT txid FROM address_book_sync_tx WHERE seq >= $1) AND record_id = $2 ORDER BY id DESC LIMIT 101"
+	if got != want {
+		t.Errorf("selectHistory() =\n%s\nwant\n%s", got, want)
+	}
+	if !reflect.DeepEqual(values, []any{int64(42), int64(7)}) {
+		t.Errorf("values = %#v", values)
+	}
+}
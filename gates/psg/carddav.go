@@ -0,0 +1,97 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"github.com/jackc/pgx/v5"
+	"log"
+)
+
+// Имена ресурсов CardDAV, выбранные клиентами, хранятся в таблице address_book_carddav_names (см. миграцию 0014).
+
+const cardDAVNameUniqueConstraint = "address_book_carddav_names_name_key"
+
+// CardDAVNames возвращает имена ресурсов CardDAV записей, созданных клиентами CardDAV (см. SaveCardDAVRecord),
+// по идентификаторам записей, в том числе записей в корзине и окончательно удаленных (для сообщений об удалении).
+//
+// Пример использования:
+//
+//	names, err := psg.CardDAVNames(ctx)
+//	if err != nil {
+//	    fmt.Println(err.Error())
+//	}
+//	fmt.Println(names[1])
+func (p *Psg) CardDAVNames(ctx context.Context) (names map[int64]string, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) CardDAVNames()")
+	if err != nil {
+		log.Println("(p *Psg) CardDAVNames(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Get)
+	defer cancel()
+
+	rows, err := p.conn.Query(ctx, `SELECT record_id, name FROM address_book_carddav_names`)
+	if err != nil {
+		wErr.Specify(err, "p.conn.Query()").LogError()
+		return nil, wrapTimeout(err)
+	}
+	names = map[int64]string{}
+	var id int64
+	var name string
+	_, err = pgx.ForEachRow(rows, []any{&id, &name}, func() error {
+		names[id] = name
+		return nil
+	})
+	if err != nil {
+		wErr.Specify(err, "pgx.ForEachRow()").LogError()
+		return nil, wrapTimeout(err)
+	}
+	return names, nil
+}
+
+// SaveCardDAVRecord сохраняет запись, как SaveRecord, вместе с именем ресурса CardDAV name в одной транзакции
+// и возвращает идентификатор новой записи. Имя удаленной записи (в корзине или окончательно) передается новой записи;
+// если имя занято другой записью, возвращает ошибку dto.ErrCardDAVNameInUse, если занят номер - dto.ErrPhoneInUse.
+//
+// Пример использования:
+//
+//	id, err := psg.SaveCardDAVRecord(ctx, rec, "6d1f0e2a-3b4c-4d5e-8f90-a1b2c3d4e5f6.vcf")
+//	if err != nil {
+//	    fmt.Println(err.Error())
+//	}
+func (p *Psg) SaveCardDAVRecord(ctx context.Context, rec dto.Record, name string) (id int64, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) SaveCardDAVRecord()")
+	if err != nil {
+		log.Println("(p *Psg) SaveCardDAVRecord(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Save)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM address_book_carddav_names n WHERE n.name = $1 AND NOT EXISTS
+			(SELECT 1 FROM address_book r WHERE r.id = n.record_id AND r.deleted_at IS NULL)`, name)
+		if err != nil {
+			return err
+		}
+		if id, err = saveRecordTx(ctx, tx, rec); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO address_book_carddav_names (record_id, name) VALUES ($1, $2)`, id, name)
+		return err
+	})
+	switch {
+	case isUniqueViolation(err, phoneUniqueConstraint):
+		wErr.LogMsg(dto.ErrPhoneInUse.Error())
+		return 0, dto.ErrPhoneInUse
+	case isUniqueViolation(err, cardDAVNameUniqueConstraint):
+		wErr.LogMsg(dto.ErrCardDAVNameInUse.Error())
+		return 0, dto.ErrCardDAVNameInUse
+	case err != nil:
+		wErr.Specify(err, "pgx.BeginFunc()").LogError()
+		return 0, wrapTimeout(err)
+	}
+
+	return id, nil
+}
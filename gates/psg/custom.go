@@ -0,0 +1,191 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"log"
+)
+
+// Объявления дополнительных полей хранятся в таблице custom_field_definitions, значения - в столбце
+// address_book.custom (JSONB-объект, см. миграцию 0008). Значения проверяются по объявлениям до записи.
+
+const customFieldPkeyConstraint = "custom_field_definitions_pkey"
+
+// CustomFields возвращает объявления дополнительных полей в порядке имен.
+func (p *Psg) CustomFields(ctx context.Context) (defs []dto.CustomFieldDef, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) CustomFields()")
+	if err != nil {
+		log.Println("(p *Psg) CustomFields(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Get)
+	defer cancel()
+
+	defs, err = p.customFieldDefs(ctx)
+	if err != nil {
+		wErr.Specify(err, "p.customFieldDefs(ctx)").LogError()
+		return nil, wrapTimeout(err)
+	}
+	return defs, nil
+}
+
+// CreateCustomField добавляет объявление дополнительного поля def.
+// Если поле с таким именем уже объявлено, возвращает ошибку dto.ErrCustomFieldExists.
+func (p *Psg) CreateCustomField(ctx context.Context, def dto.CustomFieldDef) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) CreateCustomField()")
+	if err != nil {
+		log.Println("(p *Psg) CreateCustomField(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Save)
+	defer cancel()
+
+	sqlCommand := `INSERT INTO custom_field_definitions (key, type, required, enum_values, label) VALUES ($1, $2, $3, $4, $5)`
+	_, err = p.conn.Exec(ctx, sqlCommand, def.Key, def.Type, def.Required, enumValues(def), def.Label)
+	if isUniqueViolation(err, customFieldPkeyConstraint) {
+		wErr.LogMsg(dto.ErrCustomFieldExists.Error())
+		return dto.ErrCustomFieldExists
+	}
+	if err != nil {
+		wErr.Specify(err, "p.conn.Exec()").LogError()
+		return wrapTimeout(err)
+	}
+	return nil
+}
+
+// UpdateCustomField заменяет объявление дополнительного поля def.Key. Тип поля изменить нельзя
+// (значения в записях уже проверены по старому типу), такая попытка возвращает *dto.ValidationError.
+// Новые ограничения (обязательность, допустимые значения) проверяются для записей при их следующем изменении.
+// Если поле не объявлено, возвращает ошибку dto.ErrCustomFieldNotFound.
+func (p *Psg) UpdateCustomField(ctx context.Context, def dto.CustomFieldDef) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) UpdateCustomField()")
+	if err != nil {
+		log.Println("(p *Psg) UpdateCustomField(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Update)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		var fieldType string
+		err := tx.QueryRow(ctx, `SELECT type FROM custom_field_definitions WHERE key=$1 FOR UPDATE`, def.Key).Scan(&fieldType)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return dto.ErrCustomFieldNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if fieldType != def.Type {
+			return &dto.ValidationError{Msg: fmt.Sprintf("type of custom field %q cannot be changed", def.Key)}
+		}
+
+		sqlCommand := `UPDATE custom_field_definitions SET required=$1, enum_values=$2, label=$3 WHERE key=$4`
+		_, err = tx.Exec(ctx, sqlCommand, def.Required, enumValues(def), def.Label, def.Key)
+		return err
+	})
+	return checkCustomFieldErr(wErr, err)
+}
+
+// DeleteCustomField удаляет объявление дополнительного поля key и значения этого поля во всех записях
+// в одной транзакции (изменения записей попадают в историю). Если поле не объявлено, возвращает ошибку dto.ErrCustomFieldNotFound.
+func (p *Psg) DeleteCustomField(ctx context.Context, key string) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) DeleteCustomField()")
+	if err != nil {
+		log.Println("(p *Psg) DeleteCustomField(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Delete)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `SELECT id FROM address_book WHERE custom ? $1::text ORDER BY id`, key)
+		if err != nil {
+			return err
+		}
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+		if err != nil {
+			return err
+		}
+		// Значение удаляется и у записей в корзине, чтобы восстановленная запись не содержала необъявленное поле
+		return trackChangeTx(ctx, tx, ids, recordsWithTrashTx, func() error {
+			tag, err := tx.Exec(ctx, `DELETE FROM custom_field_definitions WHERE key=$1`, key)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				return dto.ErrCustomFieldNotFound
+			}
+			_, err = tx.Exec(ctx, `UPDATE address_book SET custom = custom - $1::text, updated_at = now() WHERE custom ? $1::text`, key)
+			return err
+		})
+	})
+	return checkCustomFieldErr(wErr, err)
+}
+
+// customFieldDefs загружает объявления дополнительных полей.
+func (p *Psg) customFieldDefs(ctx context.Context) ([]dto.CustomFieldDef, error) {
+	rows, err := p.conn.Query(ctx, `SELECT key, type, required, enum_values, label FROM custom_field_definitions ORDER BY key`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(r pgx.CollectableRow) (d dto.CustomFieldDef, err error) {
+		err = r.Scan(&d.Key, &d.Type, &d.Required, &d.Values, &d.Label)
+		if len(d.Values) == 0 {
+			d.Values = nil
+		}
+		return d, err
+	})
+}
+
+// checkCustomFieldErr преобразует ошибку транзакции изменения объявления поля и записывает ее в журнал.
+func checkCustomFieldErr(wErr *pkg.WrappedError, err error) error {
+	var validationErr *dto.ValidationError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, dto.ErrCustomFieldNotFound), errors.As(err, &validationErr):
+		wErr.LogMsg(err.Error())
+		return err
+	}
+	wErr.Specify(err, "pgx.BeginFunc()").LogError()
+	return wrapTimeout(err)
+}
+
+// enumValues возвращает допустимые значения поля для столбца enum_values (пустой массив вместо NULL).
+func enumValues(def dto.CustomFieldDef) []string {
+	if def.Values == nil {
+		return []string{}
+	}
+	return def.Values
+}
+
+// customJSON возвращает значения дополнительных полей для столбца custom (пустой объект вместо NULL).
+func customJSON(custom map[string]any) map[string]any {
+	if custom == nil {
+		return map[string]any{}
+	}
+	return custom
+}
+
+// customPatchClause возвращает выражение нового значения столбца custom по изменению patch (JSON Merge Patch):
+// заданные поля объединяются с текущими (||), поля со значением null удаляются (-).
+// При patch.Null все текущие поля удаляются.
+func customPatchClause(patch dto.PatchCustom, placeholder func(any) string) string {
+	set := map[string]any{}
+	removed := []string{}
+	for k, v := range patch.Value {
+		if v == nil {
+			removed = append(removed, k)
+		} else {
+			set[k] = v
+		}
+	}
+	if patch.Null {
+		return placeholder(set) + "::jsonb"
+	}
+	return "(custom || " + placeholder(set) + "::jsonb) - " + placeholder(removed) + "::text[]"
+}
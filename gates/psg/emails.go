@@ -0,0 +1,68 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"context"
+	"github.com/jackc/pgx/v5"
+)
+
+// Адреса электронной почты записей хранятся в таблице address_book_emails (см. миграцию 0007) в порядке id.
+
+// insertEmails добавляет адреса emails записи id одним запросом INSERT с сохранением порядка.
+func insertEmails(ctx context.Context, tx pgx.Tx, id int64, emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	sqlCommand := `INSERT INTO address_book_emails (record_id, email)
+		SELECT $1::bigint, email FROM unnest($2::text[]) WITH ORDINALITY AS e(email, n) ORDER BY n`
+	_, err := tx.Exec(ctx, sqlCommand, id, emails)
+	return err
+}
+
+// replaceEmails заменяет все адреса электронной почты записи id на emails.
+func replaceEmails(ctx context.Context, tx pgx.Tx, id int64, emails []string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM address_book_emails WHERE record_id=$1`, id)
+	if err != nil {
+		return err
+	}
+	return insertEmails(ctx, tx, id, emails)
+}
+
+// loadEmails загружает адреса электронной почты записей records одним запросом и заполняет Emails.
+func loadEmails(ctx context.Context, q querier, records []dto.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(records))
+	ids := make([]int64, 0, len(records))
+	for i, r := range records {
+		index[r.ID] = i
+		ids = append(ids, r.ID)
+	}
+
+	sqlCommand := `SELECT record_id, email FROM address_book_emails WHERE record_id = ANY($1) ORDER BY record_id, id`
+	rows, err := q.Query(ctx, sqlCommand, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var email string
+		if err = rows.Scan(&id, &email); err != nil {
+			return err
+		}
+		r := &records[index[id]]
+		r.Emails = append(r.Emails, email)
+	}
+	return rows.Err()
+}
+
+// nullDate возвращает дату для параметра запроса: пустая строка записывается как NULL.
+func nullDate(date string) any {
+	if date == "" {
+		return nil
+	}
+	return date
+}
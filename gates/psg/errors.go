@@ -0,0 +1,37 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"context"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+)
+
+const (
+	uniqueViolationCode = "23505" // SQLSTATE unique_violation
+	queryCanceledCode   = "57014" // SQLSTATE query_canceled (в том числе по statement_timeout)
+
+	phoneUniqueConstraint = "address_book_phones_number_key"
+)
+
+// isUniqueViolation проверяет, что err - нарушение ограничения уникальности constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == uniqueViolationCode && pgErr.ConstraintName == constraint
+}
+
+// wrapTimeout заменяет ошибки истечения дедлайна и statement_timeout на dto.ErrTimeout.
+// Остальные ошибки возвращаются без изменений.
+func wrapTimeout(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return dto.ErrTimeout
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == queryCanceledCode {
+		return dto.ErrTimeout
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log"
+)
+
+// exportFetchSize - количество записей, которое ExportRecords читает из курсора за один раз.
+const exportFetchSize = 1000
+
+// ExportRecords передает функции fn по одной все записи, удовлетворяющие условиям q (как GetRecords, но без
+// постраничной выборки: q.Limit, q.Cursor и q.WithTotal не учитываются), в порядке q.Sort. Записи читаются
+// из серверного курсора порциями по exportFetchSize вместе с номерами, адресами, почтой и тегами,
+// поэтому расход памяти не зависит от количества записей. Выборка выполняется в одной транзакции только
+// для чтения (REPEATABLE READ) и видит записи на момент ее начала. Ошибка fn прерывает выгрузку и возвращается.
+//
+// Пример использования:
+//
+//	q := dto.Query{Filter: dto.RecordFilter(dto.Record{LastName: "Doe"}), Sort: "name"}
+//	err := psg.ExportRecords(ctx, q, func(r dto.Record) error {
+//	    return encoder.Encode(r)
+//	})
+//	if err != nil {
+//	    fmt.Println(err.Error())
+//	}
+func (p *Psg) ExportRecords(ctx context.Context, q dto.Query, fn func(dto.Record) error) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) ExportRecords()")
+	if err != nil {
+		log.Println("(p *Psg) ExportRecords(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Export)
+	defer cancel()
+
+	q.Limit, q.Cursor, q.WithTotal = 0, "", false
+	if q.Filter != nil && q.Filter.HasCustomFields() {
+		defs, err := p.customFieldDefs(ctx)
+		if err != nil {
+			wErr.Specify(err, "p.customFieldDefs(ctx)").LogError()
+			return wrapTimeout(err)
+		}
+		q.CustomFields = dto.NewCustomFieldDefs(defs)
+	}
+	sqlCommand, values, err := p.SelectRecord(q)
+	if err != nil {
+		wErr.Specify(err, "p.SelectRecord(q)").LogError()
+		return err
+	}
+
+	txOptions := pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}
+	err = pgx.BeginTxFunc(ctx, p.conn, txOptions, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "DECLARE export_records NO SCROLL CURSOR FOR "+sqlCommand, values...)
+		if err != nil {
+			return err
+		}
+		for {
+			records, err := fetchRecordsTx(ctx, tx, !q.AsOf.IsZero())
+			if err != nil {
+				return err
+			}
+			for _, r := range records {
+				if err = fn(r); err != nil {
+					return err
+				}
+			}
+			if len(records) < exportFetchSize {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		wErr.Specify(err, "pgx.BeginTxFunc()").LogError()
+		return wrapTimeout(err)
+	}
+
+	return nil
+}
+
+// fetchRecordsTx читает из курсора export_records следующие exportFetchSize записей и загружает их номера,
+// адреса, почту и теги. При asOf записи, восстановленные из истории (см. GetRecords), заменяют текущие.
+func fetchRecordsTx(ctx context.Context, tx pgx.Tx, asOf bool) ([]dto.Record, error) {
+	rows, err := tx.Query(ctx, fmt.Sprintf("FETCH %d FROM export_records", exportFetchSize))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []dto.Record
+	restored := map[int]dto.Record{}
+	for rows.Next() {
+		var r dto.Record
+		var snapshot *dto.Record
+		if asOf {
+			err = scanRecord(rows, &r, &snapshot)
+		} else {
+			err = scanRecord(rows, &r)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if snapshot != nil {
+			restored[len(records)] = *snapshot
+		}
+		records = append(records, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, load := range []func(context.Context, querier, []dto.Record) error{loadPhones, loadAddresses, loadEmails, loadTags} {
+		if err = load(ctx, tx, records); err != nil {
+			return nil, err
+		}
+	}
+	for i, r := range restored {
+		records[i] = r
+	}
+	return records, nil
+}
@@ -0,0 +1,269 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"log"
+	"strings"
+)
+
+// История изменений записей хранится в таблице address_book_history (см. миграцию 0011).
+// Каждое изменение записи пишет строку истории в своей транзакции, поэтому изменение
+// и его история фиксируются или откатываются вместе.
+
+// errUnchanged возвращается изменением в trackUpdateTx, если записи не изменились: история тогда не пишется.
+var errUnchanged = errors.New("records unchanged")
+
+// querier - источник строк для загрузки частей записей: пул соединений или транзакция.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// History возвращает страницу истории изменений, удовлетворяющих q, от новых изменений к старым.
+// Если q.Limit задан и изменений больше, в результате возвращается токен следующей страницы NextCursor.
+//
+// Пример использования:
+//
+//	page, err := psg.History(ctx, dto.HistoryQuery{RecordID: 1, Limit: 20})
+//	if err != nil {
+//	    fmt.Println(err.Error())
+//	}
+//	for _, e := range page.Entries {
+//	    fmt.Println(e.ChangedAt, e.Actor, e.Action)
+//	}
+func (p *Psg) History(ctx context.Context, q dto.HistoryQuery) (page dto.HistoryPage, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) History()")
+	if err != nil {
+		log.Println("(p *Psg) History(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Get)
+	defer cancel()
+
+	err = q.Validate()
+	if err != nil {
+		wErr.Specify(err, "q.Validate()").LogError()
+		return page, err
+	}
+
+	sqlCommand, values := selectHistory(q)
+	rows, err := p.conn.Query(ctx, sqlCommand, values...)
+	if err == nil {
+		page.Entries, err = pgx.CollectRows(rows, func(r pgx.CollectableRow) (e dto.HistoryEntry, err error) {
+			err = r.Scan(&e.ID, &e.RecordID, &e.Action, &e.Before, &e.After, &e.ChangedAt, &e.Actor, &e.RequestID)
+			e.ChangedAt = e.ChangedAt.UTC()
+			return e, err
+		})
+	}
+	if err != nil {
+		wErr.Specify(err, "p.conn.Query()").LogError()
+		return page, wrapTimeout(err)
+	}
+
+	// selectHistory запрашивает на одну запись больше лимита, чтобы узнать, есть ли следующая страница
+	if q.Limit > 0 && len(page.Entries) > q.Limit {
+		page.Entries = page.Entries[:q.Limit]
+		page.NextCursor = q.NextCursor(page.Entries[q.Limit-1])
+	}
+
+	return page, nil
+}
+
+// SyncToken возвращает отметку синхронизации: следующий номер фиксации транзакций, записывающих историю
+// (см. миграцию 0016). Номера выдаются в порядке фиксации и вместе с ней, поэтому изменения, которых не видно
+// на момент вызова, получат номер не меньше возвращенного, и выборка истории с dto.HistoryQuery.SyncFrom
+// не пропускает изменения, зафиксированные позже изменений с большим идентификатором. Отметка меняется
+// только при изменениях записей. Изменения, зафиксированные между вызовом и чтением записей, могут попасть
+// в выборку повторно.
+//
+// Пример использования:
+//
+//	token, err := psg.SyncToken(ctx)
+//	if err != nil {
+//	    fmt.Println(err.Error())
+//	}
+//	page, err := psg.History(ctx, dto.HistoryQuery{SyncFrom: token, Limit: 100})
+func (p *Psg) SyncToken(ctx context.Context) (token int64, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) SyncToken()")
+	if err != nil {
+		log.Println("(p *Psg) SyncToken(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Get)
+	defer cancel()
+
+	sqlCommand := `SELECT seq + 1 FROM address_book_sync`
+	if err = p.conn.QueryRow(ctx, sqlCommand).Scan(&token); err != nil {
+		wErr.Specify(err, "p.conn.QueryRow()").LogError()
+		return 0, wrapTimeout(err)
+	}
+	return token, nil
+}
+
+// selectHistory строит SQL-запрос выборки истории изменений по параметрам q (проверенным методом Validate).
+func selectHistory(q dto.HistoryQuery) (string, []any) {
+	var conds []string
+	var values []any
+	add := func(cond string, value any) {
+		values = append(values, value)
+		conds = append(conds, fmt.Sprintf(cond, len(values)))
+	}
+
+	if beforeID, _ := q.BeforeID(); beforeID != 0 {
+		add("id < $%d", beforeID)
+	}
+	if q.AfterID != 0 {
+		add("id > $%d", q.AfterID)
+	}
+	if q.SyncFrom != 0 {
+		add("txid IN (SELECT txid FROM address_book_sync_tx WHERE seq >= $%d)", q.SyncFrom)
+	}
+	if q.RecordID != 0 {
+		add("record_id = $%d", q.RecordID)
+	}
+	if q.Actor != "" {
+		add("actor = $%d", q.Actor)
+	}
+	if q.Action != "" {
+		add("action = $%d", q.Action)
+	}
+	if !q.Since.IsZero() {
+		add("changed_at >= $%d", q.Since)
+	}
+	if !q.Until.IsZero() {
+		add("changed_at < $%d", q.Until)
+	}
+
+	sqlCommand := "SELECT id, record_id, action, before, after, changed_at, actor, request_id FROM address_book_history"
+	if len(conds) > 0 {
+		sqlCommand += " WHERE " + strings.Join(conds, " AND ")
+	}
+	sqlCommand += " ORDER BY id DESC"
+	if q.Limit > 0 {
+		sqlCommand += fmt.Sprintf(" LIMIT %d", q.Limit+1)
+	}
+	return sqlCommand, values
+}
+
+// recordTx загружает запись id со всеми номерами, адресами, почтой и тегами в транзакции tx
+// и блокирует ее до конца транзакции. Если записи нет, возвращает dto.ErrRecordNotFound.
+func recordTx(ctx context.Context, tx pgx.Tx, id int64) (dto.Record, error) {
+	records, err := recordsTx(ctx, tx, []int64{id})
+	if err != nil {
+		return dto.Record{}, err
+	}
+	if len(records) == 0 {
+		return dto.Record{}, dto.ErrRecordNotFound
+	}
+	return records[0], nil
+}
+
+// recordsTx загружает записи ids (в порядке id) так же, как recordTx. Отсутствующие записи и записи
+// в корзине пропускаются.
+func recordsTx(ctx context.Context, tx pgx.Tx, ids []int64) ([]dto.Record, error) {
+	return selectRecordsTx(ctx, tx, "id = ANY($1) AND deleted_at IS NULL", ids)
+}
+
+// recordsWithTrashTx загружает записи ids так же, как recordsTx, включая записи в корзине.
+func recordsWithTrashTx(ctx context.Context, tx pgx.Tx, ids []int64) ([]dto.Record, error) {
+	return selectRecordsTx(ctx, tx, "id = ANY($1)", ids)
+}
+
+// selectRecordsTx загружает записи, удовлетворяющие условию where с параметрами args, в порядке id
+// со всеми номерами, адресами, почтой и тегами и блокирует их до конца транзакции tx.
+func selectRecordsTx(ctx context.Context, tx pgx.Tx, where string, args ...any) ([]dto.Record, error) {
+	sqlCommand := "SELECT " + recordColumns + " FROM address_book WHERE " + where + " ORDER BY id FOR UPDATE"
+	rows, err := tx.Query(ctx, sqlCommand, args...)
+	if err != nil {
+		return nil, err
+	}
+	records, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (rec dto.Record, err error) {
+		err = scanRecord(r, &rec)
+		return rec, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, load := range []func(context.Context, querier, []dto.Record) error{loadPhones, loadAddresses, loadEmails, loadTags} {
+		if err = load(ctx, tx, records); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// trackUpdateTx выполняет изменение change записей ids в транзакции tx, увеличивает их версии и записывает
+// в историю их состояние до и после изменения. Записи блокируются до изменения.
+// Если одной из записей нет, возвращает dto.ErrRecordNotFound, не выполняя change.
+// Если change возвращает errUnchanged, история не пишется и возвращается nil.
+func trackUpdateTx(ctx context.Context, tx pgx.Tx, ids []int64, change func() error) error {
+	return trackChangeTx(ctx, tx, ids, recordsTx, change)
+}
+
+// trackChangeTx - trackUpdateTx, загружающий записи функцией load (recordsTx или recordsWithTrashTx).
+func trackChangeTx(ctx context.Context, tx pgx.Tx, ids []int64, load func(context.Context, pgx.Tx, []int64) ([]dto.Record, error),
+	change func() error) error {
+	before, err := load(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+	if len(before) < len(ids) {
+		return dto.ErrRecordNotFound
+	}
+	if err = change(); err != nil {
+		if errors.Is(err, errUnchanged) {
+			return nil
+		}
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE address_book SET version=version+1 WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return err
+	}
+	after, err := load(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range before {
+		if i < len(after) && after[i].ID == before[i].ID {
+			err = insertHistory(ctx, tx, dto.ActionUpdate, before[i].ID, &before[i], &after[i])
+		} else {
+			err = dto.ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertHistory добавляет в историю изменение action записи id с автором и запросом из контекста
+// (см. dto.WithChangeMeta). Время изменения - время начала транзакции, как и updated_at записи.
+func insertHistory(ctx context.Context, tx pgx.Tx, action string, id int64, before, after *dto.Record) error {
+	meta := dto.ChangeMetaFrom(ctx)
+	sqlCommand := `INSERT INTO address_book_history (record_id, action, before, after, actor, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := tx.Exec(ctx, sqlCommand, id, action, before, after, meta.Actor, meta.RequestID)
+	return err
+}
+
+// insertHistoryBefore записывает в историю одним запросом действие action над записями records в транзакции tx:
+// records - их состояние до действия, состояния после нет (как при окончательном удалении).
+func insertHistoryBefore(ctx context.Context, tx pgx.Tx, action string, records []dto.Record) error {
+	before, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	meta := dto.ChangeMetaFrom(ctx)
+	sqlCommand := `INSERT INTO address_book_history (record_id, action, before, actor, request_id)
+		SELECT (r->>'id')::bigint, $1, r, $2, $3 FROM jsonb_array_elements($4::jsonb) WITH ORDINALITY AS t(r, n) ORDER BY n`
+	_, err = tx.Exec(ctx, sqlCommand, action, meta.Actor, meta.RequestID, string(before))
+	return err
+}
@@ -0,0 +1,197 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"time"
+)
+
+// ImportRecords сохраняет записи recs в одной транзакции через COPY (pgx.CopyFrom) и возвращает ошибку
+// каждой записи: dto.ErrPhoneInUse, если ее номер занят действующей записью или более ранней записью recs.
+// Такие записи пропускаются, остальные сохраняются вместе с адресами, почтой, номерами и записями истории
+// создания (с автором и запросом из dto.ChangeMetaFrom(ctx)), как при SaveRecord. На время импорта таблица
+// номеров блокируется от изменений (чтение не блокируется), поэтому номера не могут занять параллельно.
+// При dryRun записи только проверяются в транзакции только для чтения, без блокировки таблицы номеров.
+// Ошибка ImportRecords означает, что не сохранена ни одна запись.
+//
+// Пример использования:
+//
+//	errs, err := psg.ImportRecords(ctx, recs, false)
+//	if err != nil {
+//	    fmt.Println(err.Error())
+//	}
+//	for i, recErr := range errs {
+//	    if recErr != nil {
+//	        fmt.Println(i, recErr.Error())
+//	    }
+//	}
+func (p *Psg) ImportRecords(ctx context.Context, recs []dto.Record, dryRun bool) (errs []error, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) ImportRecords()")
+	if err != nil {
+		log.Println("(p *Psg) ImportRecords(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Import)
+	defer cancel()
+
+	if dryRun {
+		txOptions := pgx.TxOptions{AccessMode: pgx.ReadOnly}
+		err = pgx.BeginTxFunc(ctx, p.conn, txOptions, func(tx pgx.Tx) error {
+			errs, err = importConflictsTx(ctx, tx, recs)
+			return err
+		})
+		if err != nil {
+			wErr.Specify(err, "pgx.BeginTxFunc()").LogError()
+			return nil, wrapTimeout(err)
+		}
+		return errs, nil
+	}
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `LOCK TABLE address_book_phones IN SHARE ROW EXCLUSIVE MODE`)
+		if err != nil {
+			return err
+		}
+		if errs, err = importConflictsTx(ctx, tx, recs); err != nil {
+			return err
+		}
+
+		valid := make([]dto.Record, 0, len(recs))
+		for i := range recs {
+			if errs[i] == nil {
+				valid = append(valid, recs[i])
+			}
+		}
+		return copyRecordsTx(ctx, tx, valid)
+	})
+	if err != nil {
+		wErr.Specify(err, "pgx.BeginFunc()").LogError()
+		return nil, wrapTimeout(err)
+	}
+
+	return errs, nil
+}
+
+// importConflictsTx возвращает для каждой записи recs dto.ErrPhoneInUse, если один из ее номеров
+// занят действующей записью или более ранней записью recs, иначе nil.
+func importConflictsTx(ctx context.Context, tx pgx.Tx, recs []dto.Record) ([]error, error) {
+	var numbers []string
+	for _, rec := range recs {
+		for _, p := range rec.Phones {
+			numbers = append(numbers, p.Number)
+		}
+	}
+	rows, err := tx.Query(ctx, `SELECT number FROM address_book_phones WHERE number = ANY($1) AND NOT deleted`, numbers)
+	if err != nil {
+		return nil, err
+	}
+	inUse, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool, len(inUse))
+	for _, number := range inUse {
+		used[number] = true
+	}
+	errs := make([]error, len(recs))
+	for i, rec := range recs {
+		for _, p := range rec.Phones {
+			if used[p.Number] {
+				errs[i] = dto.ErrPhoneInUse
+			}
+		}
+		if errs[i] != nil {
+			continue
+		}
+		for _, p := range rec.Phones {
+			used[p.Number] = true
+		}
+	}
+	return errs, nil
+}
+
+// copyRecordsTx сохраняет записи recs в транзакции tx: идентификаторы записей берутся из последовательности
+// address_book одним запросом, после чего записи, их адреса, почта и номера загружаются через COPY.
+// Сохраненные записи перечитываются (со значениями, которые установила база: временем, версией,
+// идентификаторами адресов), и их история создания тоже загружается через COPY. Время создания записей
+// и истории - время начала транзакции, как при SaveRecord.
+func copyRecordsTx(ctx context.Context, tx pgx.Tx, recs []dto.Record) error {
+	if len(recs) == 0 {
+		return nil
+	}
+
+	var now time.Time
+	if err := tx.QueryRow(ctx, `SELECT now()`).Scan(&now); err != nil {
+		return err
+	}
+	rows, err := tx.Query(ctx, `SELECT nextval(pg_get_serial_sequence('address_book', 'id')) FROM generate_series(1, $1)`, len(recs))
+	if err != nil {
+		return err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return err
+	}
+
+	var records, addresses, emails, phones [][]any
+	for i := range recs {
+		rec := recs[i]
+		rec.ID = ids[i]
+
+		var birthday any
+		if rec.Birthday != "" {
+			if birthday, err = time.Parse(dto.DateLayout, rec.Birthday); err != nil {
+				return err
+			}
+		}
+		records = append(records, []any{rec.ID, rec.Name, rec.LastName, rec.MiddleName, rec.Address,
+			birthday, rec.Organization, rec.JobTitle, rec.Notes, customJSON(rec.Custom), now, now})
+		for _, a := range rec.Addresses {
+			addresses = append(addresses, []any{rec.ID, a.Label, a.Country, a.Region, a.City, a.Street, a.House, a.Apartment, a.PostalIndex})
+		}
+		for _, email := range rec.Emails {
+			emails = append(emails, []any{rec.ID, email})
+		}
+		for _, p := range rec.Phones {
+			phones = append(phones, []any{rec.ID, p.Number, p.Type, p.Primary})
+		}
+	}
+
+	for _, c := range []struct {
+		table   string
+		columns []string
+		rows    [][]any
+	}{
+		{"address_book", []string{"id", "name", "last_name", "middle_name", "address", "birthday",
+			"organization", "job_title", "notes", "custom", "created_at", "updated_at"}, records},
+		{"address_book_addresses", []string{"record_id", "label", "country", "region", "city", "street",
+			"house", "apartment", "postal_index"}, addresses},
+		{"address_book_emails", []string{"record_id", "email"}, emails},
+		{"address_book_phones", []string{"record_id", "number", "type", "is_primary"}, phones},
+	} {
+		if len(c.rows) == 0 {
+			continue
+		}
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{c.table}, c.columns, pgx.CopyFromRows(c.rows))
+		if err != nil {
+			return err
+		}
+	}
+
+	stored, err := recordsTx(ctx, tx, ids)
+	if err != nil {
+		return err
+	}
+	history := make([][]any, 0, len(stored))
+	meta := dto.ChangeMetaFrom(ctx)
+	for i := range stored {
+		history = append(history, []any{stored[i].ID, dto.ActionCreate, &stored[i], now, meta.Actor, meta.RequestID})
+	}
+	columns := []string{"record_id", "action", "after", "changed_at", "actor", "request_id"}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"address_book_history"}, columns, pgx.CopyFromRows(history))
+	return err
+}
@@ -0,0 +1,247 @@
+package psg
+
+import (
+	"addressBookServer/pkg"
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Миграции схемы базы данных встраиваются в бинарный файл.
+// Каждая миграция - пара файлов migrations/NNNN_описание.up.sql и migrations/NNNN_описание.down.sql,
+// где NNNN - номер версии. Миграции применяются по возрастанию версии и откатываются по убыванию.
+// Любое изменение схемы добавляется только новой миграцией, уже выпущенные файлы не редактируются.
+// Перенос данных, который нельзя выразить на SQL, регистрируется в dataMigrations (migrate_data.go).
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+const (
+	migrationsDir = "migrations"
+
+	// migrationsLockKey - ключ pg_advisory_lock, под которым выполняются миграции,
+	// чтобы несколько одновременно запущенных экземпляров сервера не применяли их параллельно.
+	migrationsLockKey = 4201620240101
+)
+
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations читает встроенные миграции и возвращает их отсортированными по версии.
+func loadMigrations() (migrations []migration, err error) {
+	entries, err := fs.ReadDir(migrationsFS, migrationsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file: %s", fileName)
+		}
+
+		name := strings.TrimSuffix(fileName, "."+direction+".sql")
+		versionStr, _, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in file name: %s", fileName)
+		}
+
+		body, err := migrationsFS.ReadFile(path.Join(migrationsDir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		}
+		if m.name != name {
+			return nil, fmt.Errorf("migration version %d has different names: %s, %s", version, m.name, name)
+		}
+		if direction == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %s must have both up and down files", m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	return migrations, nil
+}
+
+// MigrateUp применяет все еще не примененные миграции.
+// Вызывается из NewPsg при старте, но может быть вызвана и отдельно.
+func (p *Psg) MigrateUp(ctx context.Context) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) MigrateUp()")
+	if err != nil {
+		log.Println("(p *Psg) MigrateUp(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		wErr.Specify(err, "loadMigrations()").LogError()
+		return err
+	}
+
+	err = p.withMigrationLock(ctx, func(conn *pgxpool.Conn, applied map[int64]bool) error {
+		for _, m := range migrations {
+			if applied[m.version] {
+				continue
+			}
+			err := applyMigration(ctx, conn, m.up, dataMigrations[m.version],
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name)
+			if err != nil {
+				return errors.Wrapf(err, "migration %s up", m.name)
+			}
+			wErr.LogMsg("migration applied: " + m.name)
+		}
+		return nil
+	})
+	if err != nil {
+		wErr.Specify(err, "p.withMigrationLock()").LogError()
+		return err
+	}
+
+	return nil
+}
+
+// MigrateDown откатывает steps последних примененных миграций.
+func (p *Psg) MigrateDown(ctx context.Context, steps int) error {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) MigrateDown()")
+	if err != nil {
+		log.Println("(p *Psg) MigrateDown(): NewWrappedErrorWithFile()", err)
+	}
+	defer wErr.Close()
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		wErr.Specify(err, "loadMigrations()").LogError()
+		return err
+	}
+
+	err = p.withMigrationLock(ctx, func(conn *pgxpool.Conn, applied map[int64]bool) error {
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if !applied[m.version] {
+				continue
+			}
+			err := applyMigration(ctx, conn, m.down, nil,
+				`DELETE FROM schema_migrations WHERE version = $1`, m.version)
+			if err != nil {
+				return errors.Wrapf(err, "migration %s down", m.name)
+			}
+			wErr.LogMsg("migration rolled back: " + m.name)
+			steps--
+		}
+		return nil
+	})
+	if err != nil {
+		wErr.Specify(err, "p.withMigrationLock()").LogError()
+		return err
+	}
+
+	return nil
+}
+
+// withMigrationLock захватывает соединение и advisory lock, создает при необходимости
+// таблицу schema_migrations и вызывает fn со списком уже примененных версий.
+func (p *Psg) withMigrationLock(ctx context.Context, fn func(conn *pgxpool.Conn, applied map[int64]bool) error) error {
+	conn, err := p.conn.Acquire(ctx)
+	if err != nil {
+		return errors.Wrap(err, "p.conn.Acquire()")
+	}
+	defer conn.Release()
+
+	// Ни ожидание блокировки, ни перенос данных в миграциях не должны прерываться по statement_timeout
+	_, err = conn.Exec(ctx, `SET statement_timeout = 0`)
+	if err != nil {
+		return errors.Wrap(err, "disable statement_timeout")
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), `RESET statement_timeout`)
+	}()
+
+	// Блокировка уровня сессии, поэтому все миграции выполняются через одно соединение conn
+	_, err = conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, int64(migrationsLockKey))
+	if err != nil {
+		return errors.Wrap(err, "pg_advisory_lock")
+	}
+	defer func() {
+		_, _ = conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, int64(migrationsLockKey))
+	}()
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+	if err != nil {
+		return errors.Wrap(err, "create schema_migrations")
+	}
+
+	rows, err := conn.Query(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return errors.Wrap(err, "select schema_migrations")
+	}
+	applied, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return errors.Wrap(err, "scan schema_migrations")
+	}
+
+	appliedSet := make(map[int64]bool, len(applied))
+	for _, v := range applied {
+		appliedSet[v] = true
+	}
+
+	return fn(conn, appliedSet)
+}
+
+// applyMigration выполняет sqlMigration, перенос данных dataMigration (если не nil)
+// и запрос учета версии bookkeeping в одной транзакции.
+func applyMigration(ctx context.Context, conn *pgxpool.Conn, sqlMigration string,
+	dataMigration func(ctx context.Context, tx pgx.Tx) error, bookkeeping string, args ...any) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, sqlMigration); err != nil {
+			return err
+		}
+		if dataMigration != nil {
+			if err := dataMigration(ctx, tx); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(ctx, bookkeeping, args...)
+		return err
+	})
+}
@@ -0,0 +1,93 @@
+package psg
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Копия разбора адресов pkg.ParseAddress на момент миграции 6 для backfillAddresses. Разбор в pkg
+// будет меняться вместе с приложением, а миграция должна раскладывать адреса одинаково на любой базе,
+// когда бы ее ни применили, поэтому эта копия не изменяется.
+
+// addressV6 - составные части адреса, как в address_book_addresses версии 6.
+type addressV6 struct {
+	country, region, city, street, house, apartment, postalIndex string
+}
+
+var (
+	addressV6IndexRe     = regexp.MustCompile(`^\d{6}$`)
+	addressV6CountryRe   = regexp.MustCompile(`(?i)^(россия|российская федерация|рф)$`)
+	addressV6RegionRe    = regexp.MustCompile(`(?i)(^|\s)(обл\.?|область|край|респ\.?|республика|автономный округ|ао)(\s|$)`)
+	addressV6CityRe      = regexp.MustCompile(`(?i)^(?:г\.\s*|г\s+|город\s+)(.+)$`)
+	addressV6StreetRe    = regexp.MustCompile(`(?i)^(ул\.?|улица|пр-т|просп\.?|проспект|пер\.?|переулок|ш\.?|шоссе|б-р|бульвар|наб\.?|набережная|пл\.?|площадь|проезд|пр-д|туп\.?|тупик|аллея|мкр\.?|микрорайон)(?:\s+|\.)(.+)$`)
+	addressV6StreetEndRe = regexp.MustCompile(`(?i)^(.+?)\s+(ул\.?|улица|пр-т|просп\.?|проспект|пер\.?|переулок|ш\.?|шоссе|б-р|бульвар|наб\.?|набережная|пл\.?|площадь|проезд|пр-д|туп\.?|тупик|аллея)$`)
+	addressV6HouseRe     = regexp.MustCompile(`(?i)^(?:д\.\s*|д\s+|дом\s+)(.+)$`)
+	addressV6BuildingRe  = regexp.MustCompile(`(?i)^(?:корп\.\s*|корпус\s+|к\.\s*)(.+)$`)
+	addressV6StructureRe = regexp.MustCompile(`(?i)^(?:стр\.\s*|строение\s+)(.+)$`)
+	addressV6FlatRe      = regexp.MustCompile(`(?i)^(?:кв\.\s*|кв\s+|квартира\s+|оф\.\s*|офис\s+|пом\.\s*|помещение\s+)(.+)$`)
+	addressV6NumberRe    = regexp.MustCompile(`^\d+[а-яА-Яa-zA-Z]?([/-]\d+[а-яА-Я]?)?$`)
+	addressV6TailRe      = regexp.MustCompile(`^(.+?)\s+(\d+[а-яА-Яa-zA-Z]?([/-]\d+)?)$`)
+	addressV6SplitRe     = regexp.MustCompile(`(?i)\s+(д\.|дом\s|корп\.|корпус\s|стр\.|строение\s|кв\.|квартира\s|оф\.|офис\s)`)
+)
+
+// parseAddressV6 разбирает адрес в свободной форме так же, как pkg.ParseAddress версии 6.
+func parseAddressV6(address string) (parsed addressV6) {
+	address = addressV6SplitRe.ReplaceAllString(address, ", $1")
+
+	for _, part := range strings.Split(address, ",") {
+		part = strings.Join(strings.Fields(part), " ")
+		if part == "" {
+			continue
+		}
+
+		if m := addressV6HouseRe.FindStringSubmatch(part); m != nil {
+			parsed.house = m[1]
+			continue
+		}
+		if m := addressV6BuildingRe.FindStringSubmatch(part); m != nil {
+			parsed.house = strings.TrimSpace(parsed.house + " к. " + m[1])
+			continue
+		}
+		if m := addressV6StructureRe.FindStringSubmatch(part); m != nil {
+			parsed.house = strings.TrimSpace(parsed.house + " стр. " + m[1])
+			continue
+		}
+		if m := addressV6FlatRe.FindStringSubmatch(part); m != nil {
+			parsed.apartment = m[1]
+			continue
+		}
+
+		switch {
+		case addressV6IndexRe.MatchString(part):
+			parsed.postalIndex = part
+		case addressV6CountryRe.MatchString(part):
+			parsed.country = part
+		case addressV6CityRe.MatchString(part):
+			parsed.city = addressV6CityRe.FindStringSubmatch(part)[1]
+		case addressV6RegionRe.MatchString(part):
+			parsed.region = part
+		case addressV6StreetRe.MatchString(part), addressV6StreetEndRe.MatchString(part):
+			parsed.street = part
+			splitHouseV6(&parsed)
+		case addressV6NumberRe.MatchString(part) && parsed.street != "" && parsed.house == "":
+			parsed.house = part
+		case parsed.city == "" && parsed.street == "":
+			parsed.city = part
+		case parsed.street == "":
+			parsed.street = part
+			splitHouseV6(&parsed)
+		}
+	}
+
+	return parsed
+}
+
+// splitHouseV6 отделяет номер дома в конце улицы, если дом еще не указан (как pkg.splitHouse версии 6).
+func splitHouseV6(parsed *addressV6) {
+	if parsed.house != "" {
+		return
+	}
+	if m := addressV6TailRe.FindStringSubmatch(parsed.street); m != nil {
+		parsed.street, parsed.house = m[1], m[2]
+	}
+}
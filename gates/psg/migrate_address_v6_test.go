@@ -0,0 +1,28 @@
+package psg
+
+import "testing"
+
+// Ожидаемые значения зафиксированы вместе с миграцией 6 и не меняются вслед за pkg.ParseAddress.
+func TestParseAddressV6(t *testing.T) {
+	tests := []struct {
+		address string
+		want    addressV6
+	}{
+		{
+			"123456, Россия, Московская обл., г. Москва, ул. Ленина, д. 5, корп. 2, кв. 10",
+			addressV6{postalIndex: "123456", country: "Россия", region: "Московская обл.", city: "Москва",
+				street: "ул. Ленина", house: "5 к. 2", apartment: "10"},
+		},
+		{"Москва, Ленина 5", addressV6{city: "Москва", street: "Ленина", house: "5"}},
+		{"ул. Ленина д. 5 кв. 10", addressV6{street: "ул. Ленина", house: "5", apartment: "10"}},
+		{"г Казань, Баумана ул., 3, стр. 1", addressV6{city: "Казань", street: "Баумана ул.", house: "3 стр. 1"}},
+		{"Somewhere in Canada (unknown)", addressV6{city: "Somewhere in Canada (unknown)"}},
+		{" , ", addressV6{}},
+	}
+
+	for _, tt := range tests {
+		if got := parseAddressV6(tt.address); got != tt.want {
+			t.Errorf("parseAddressV6(%q) =\n%+v\nwant\n%+v", tt.address, got, tt.want)
+		}
+	}
+}
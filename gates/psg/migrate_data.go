@@ -0,0 +1,49 @@
+package psg
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+)
+
+// dataMigrations - переносы данных, которые нельзя выразить на SQL. Функция выполняется
+// после up-файла миграции с той же версией и в той же транзакции; при откате вызывается только down-файл.
+var dataMigrations = map[int64]func(ctx context.Context, tx pgx.Tx) error{
+	6: backfillAddresses,
+}
+
+// backfillAddresses разбирает адреса в свободной форме существующих записей (parseAddressV6)
+// и сохраняет их как основной структурированный адрес. SQL и разбор адресов записаны здесь, а не берутся
+// у приложения (insertAddresses, pkg.ParseAddress): миграция должна выполнять одно и то же на любой базе,
+// поэтому они соответствуют версии 6 и не меняются вместе с приложением.
+func backfillAddresses(ctx context.Context, tx pgx.Tx) error {
+	rows, err := tx.Query(ctx, `SELECT id, address FROM address_book WHERE address <> '' ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	type row struct {
+		id      int64
+		address string
+	}
+	records, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (rec row, err error) {
+		err = r.Scan(&rec.id, &rec.address)
+		return rec, err
+	})
+	if err != nil {
+		return err
+	}
+
+	const sqlCommand = `INSERT INTO address_book_addresses
+		(record_id, country, region, city, street, house, apartment, postal_index)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	for _, rec := range records {
+		a := parseAddressV6(rec.address)
+		if a == (addressV6{}) {
+			continue
+		}
+		_, err = tx.Exec(ctx, sqlCommand, rec.id, a.country, a.region, a.city, a.street, a.house, a.apartment, a.postalIndex)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+-- IF NOT EXISTS: таблица могла быть создана вручную до появления миграций
+CREATE TABLE IF NOT EXISTS address_book (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255),
+    last_name VARCHAR(255),
+    middle_name VARCHAR(255),
+    address VARCHAR(255),
+    phone VARCHAR(20)
+);
@@ -0,0 +1 @@
+ALTER TABLE address_book DROP CONSTRAINT IF EXISTS address_book_phone_key;
@@ -0,0 +1,3 @@
+-- Уникальность номера телефона гарантируется базой данных, а не проверкой перед вставкой.
+-- Если в таблице уже есть дубликаты, миграция завершится ошибкой: их нужно устранить вручную.
+ALTER TABLE address_book ADD CONSTRAINT address_book_phone_key UNIQUE (phone);
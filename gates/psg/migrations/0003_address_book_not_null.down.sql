@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS address_book_name_id_idx;
+DROP INDEX IF EXISTS address_book_last_name_id_idx;
+
+ALTER TABLE address_book
+    ALTER COLUMN name DROP NOT NULL,
+    ALTER COLUMN name DROP DEFAULT,
+    ALTER COLUMN last_name DROP NOT NULL,
+    ALTER COLUMN last_name DROP DEFAULT,
+    ALTER COLUMN middle_name DROP NOT NULL,
+    ALTER COLUMN middle_name DROP DEFAULT,
+    ALTER COLUMN address DROP NOT NULL,
+    ALTER COLUMN address DROP DEFAULT,
+    ALTER COLUMN phone DROP NOT NULL;
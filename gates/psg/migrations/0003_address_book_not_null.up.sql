@@ -0,0 +1,20 @@
+-- Сортировка и постраничная выборка по ключу (поле, id) не работают с NULL,
+-- поэтому пустые значения хранятся как пустые строки.
+UPDATE address_book SET name = '' WHERE name IS NULL;
+UPDATE address_book SET last_name = '' WHERE last_name IS NULL;
+UPDATE address_book SET middle_name = '' WHERE middle_name IS NULL;
+UPDATE address_book SET address = '' WHERE address IS NULL;
+
+ALTER TABLE address_book
+    ALTER COLUMN name SET DEFAULT '',
+    ALTER COLUMN name SET NOT NULL,
+    ALTER COLUMN last_name SET DEFAULT '',
+    ALTER COLUMN last_name SET NOT NULL,
+    ALTER COLUMN middle_name SET DEFAULT '',
+    ALTER COLUMN middle_name SET NOT NULL,
+    ALTER COLUMN address SET DEFAULT '',
+    ALTER COLUMN address SET NOT NULL,
+    ALTER COLUMN phone SET NOT NULL;
+
+CREATE INDEX address_book_last_name_id_idx ON address_book (last_name, id);
+CREATE INDEX address_book_name_id_idx ON address_book (name, id);
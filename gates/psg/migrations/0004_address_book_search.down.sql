@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS address_book_search_text_trgm_idx;
+DROP INDEX IF EXISTS address_book_search_vector_idx;
+
+ALTER TABLE address_book
+    DROP COLUMN IF EXISTS search_vector,
+    DROP COLUMN IF EXISTS search_text;
@@ -0,0 +1,11 @@
+-- Полнотекстовый (конфигурация russian) и нечеткий (триграммы) поиск по ФИО и адресу
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE address_book
+    ADD COLUMN search_text TEXT
+        GENERATED ALWAYS AS (last_name || ' ' || name || ' ' || middle_name || ' ' || address) STORED,
+    ADD COLUMN search_vector TSVECTOR
+        GENERATED ALWAYS AS (to_tsvector('russian', last_name || ' ' || name || ' ' || middle_name || ' ' || address)) STORED;
+
+CREATE INDEX address_book_search_vector_idx ON address_book USING gin (search_vector);
+CREATE INDEX address_book_search_text_trgm_idx ON address_book USING gin (search_text gin_trgm_ops);
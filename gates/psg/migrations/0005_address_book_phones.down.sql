@@ -0,0 +1,11 @@
+-- В address_book возвращается только основной номер, дополнительные номера теряются.
+ALTER TABLE address_book ADD COLUMN phone TEXT NOT NULL DEFAULT '';
+
+UPDATE address_book b SET phone = p.number
+FROM address_book_phones p
+WHERE p.record_id = b.id AND p.is_primary;
+
+ALTER TABLE address_book ALTER COLUMN phone DROP DEFAULT;
+ALTER TABLE address_book ADD CONSTRAINT address_book_phone_key UNIQUE (phone);
+
+DROP TABLE address_book_phones;
@@ -0,0 +1,19 @@
+-- Номера телефонов переносятся в отдельную таблицу: у записи может быть несколько номеров
+-- с типами, один из них основной. Номера по-прежнему уникальны среди всех записей.
+CREATE TABLE address_book_phones (
+    id         BIGSERIAL PRIMARY KEY,
+    record_id  BIGINT  NOT NULL REFERENCES address_book (id) ON DELETE CASCADE,
+    number     TEXT    NOT NULL,
+    type       TEXT    NOT NULL DEFAULT 'other',
+    is_primary BOOLEAN NOT NULL DEFAULT false,
+    CONSTRAINT address_book_phones_number_key UNIQUE (number),
+    CONSTRAINT address_book_phones_type_check CHECK (type IN ('mobile', 'work', 'home', 'fax', 'other'))
+);
+
+CREATE INDEX address_book_phones_record_id_idx ON address_book_phones (record_id);
+CREATE UNIQUE INDEX address_book_phones_primary_idx ON address_book_phones (record_id) WHERE is_primary;
+
+INSERT INTO address_book_phones (record_id, number, type, is_primary)
+SELECT id, phone, 'other', true FROM address_book WHERE phone <> '';
+
+ALTER TABLE address_book DROP COLUMN phone;
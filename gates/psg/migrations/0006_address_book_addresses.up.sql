@@ -0,0 +1,19 @@
+-- Структурированные адреса записи: у записи может быть несколько адресов с метками, первый (по id) основной.
+-- Столбец address_book.address остается адресом в свободной форме (основной адрес одной строкой).
+-- Существующие адреса разбираются на части при применении миграции (см. backfillAddresses в migrate_data.go).
+CREATE TABLE address_book_addresses (
+    id           BIGSERIAL PRIMARY KEY,
+    record_id    BIGINT NOT NULL REFERENCES address_book (id) ON DELETE CASCADE,
+    label        TEXT   NOT NULL DEFAULT '',
+    country      TEXT   NOT NULL DEFAULT '',
+    region       TEXT   NOT NULL DEFAULT '',
+    city         TEXT   NOT NULL DEFAULT '',
+    street       TEXT   NOT NULL DEFAULT '',
+    house        TEXT   NOT NULL DEFAULT '',
+    apartment    TEXT   NOT NULL DEFAULT '',
+    postal_index TEXT   NOT NULL DEFAULT ''
+);
+
+CREATE INDEX address_book_addresses_record_id_idx ON address_book_addresses (record_id, id);
+CREATE INDEX address_book_addresses_city_idx ON address_book_addresses (city);
+CREATE INDEX address_book_addresses_postal_index_idx ON address_book_addresses (postal_index);
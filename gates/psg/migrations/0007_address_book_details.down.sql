@@ -0,0 +1,23 @@
+DROP TABLE IF EXISTS address_book_emails;
+
+DROP INDEX IF EXISTS address_book_search_text_trgm_idx;
+DROP INDEX IF EXISTS address_book_search_vector_idx;
+
+ALTER TABLE address_book
+    DROP COLUMN IF EXISTS search_text,
+    DROP COLUMN IF EXISTS search_vector;
+
+ALTER TABLE address_book
+    DROP COLUMN IF EXISTS birthday,
+    DROP COLUMN IF EXISTS organization,
+    DROP COLUMN IF EXISTS job_title,
+    DROP COLUMN IF EXISTS notes;
+
+ALTER TABLE address_book
+    ADD COLUMN search_text TEXT
+        GENERATED ALWAYS AS (last_name || ' ' || name || ' ' || middle_name || ' ' || address) STORED,
+    ADD COLUMN search_vector TSVECTOR
+        GENERATED ALWAYS AS (to_tsvector('russian', last_name || ' ' || name || ' ' || middle_name || ' ' || address)) STORED;
+
+CREATE INDEX address_book_search_vector_idx ON address_book USING gin (search_vector);
+CREATE INDEX address_book_search_text_trgm_idx ON address_book USING gin (search_text gin_trgm_ops);
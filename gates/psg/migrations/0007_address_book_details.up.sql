@@ -0,0 +1,39 @@
+-- Дополнительные поля записи. Дата рождения может отсутствовать (NULL), остальные поля, как и ФИО,
+-- хранят пустые значения как пустые строки.
+ALTER TABLE address_book
+    ADD COLUMN birthday     DATE,
+    ADD COLUMN organization TEXT NOT NULL DEFAULT '',
+    ADD COLUMN job_title    TEXT NOT NULL DEFAULT '',
+    ADD COLUMN notes        TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX address_book_birthday_idx ON address_book (birthday);
+
+-- Адреса электронной почты записи (в нижнем регистре) в порядке id
+CREATE TABLE address_book_emails (
+    id        BIGSERIAL PRIMARY KEY,
+    record_id BIGINT NOT NULL REFERENCES address_book (id) ON DELETE CASCADE,
+    email     TEXT   NOT NULL,
+    CONSTRAINT address_book_emails_record_id_email_key UNIQUE (record_id, email)
+);
+
+-- text_pattern_ops: индекс подходит и для точного сравнения, и для поиска по началу адреса (LIKE 'prefix%')
+CREATE INDEX address_book_emails_email_idx ON address_book_emails (email text_pattern_ops);
+
+-- Поиск (см. миграцию 0004) охватывает и новые текстовые поля
+DROP INDEX address_book_search_text_trgm_idx;
+DROP INDEX address_book_search_vector_idx;
+
+ALTER TABLE address_book
+    DROP COLUMN search_text,
+    DROP COLUMN search_vector;
+
+ALTER TABLE address_book
+    ADD COLUMN search_text TEXT
+        GENERATED ALWAYS AS (last_name || ' ' || name || ' ' || middle_name || ' ' || address || ' ' ||
+                             organization || ' ' || job_title || ' ' || notes) STORED,
+    ADD COLUMN search_vector TSVECTOR
+        GENERATED ALWAYS AS (to_tsvector('russian', last_name || ' ' || name || ' ' || middle_name || ' ' || address || ' ' ||
+                                                    organization || ' ' || job_title || ' ' || notes)) STORED;
+
+CREATE INDEX address_book_search_vector_idx ON address_book USING gin (search_vector);
+CREATE INDEX address_book_search_text_trgm_idx ON address_book USING gin (search_text gin_trgm_ops);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS address_book_custom_idx;
+
+ALTER TABLE address_book DROP COLUMN IF EXISTS custom;
+
+DROP TABLE IF EXISTS custom_field_definitions;
@@ -0,0 +1,18 @@
+-- Дополнительные поля записей, объявляемые администратором развертывания.
+-- Значения хранятся в address_book.custom и проверяются сервером по объявлениям при записи.
+CREATE TABLE custom_field_definitions (
+    key         TEXT    PRIMARY KEY,
+    type        TEXT    NOT NULL,
+    required    BOOLEAN NOT NULL DEFAULT false,
+    enum_values TEXT[]  NOT NULL DEFAULT '{}',
+    label       TEXT    NOT NULL DEFAULT '',
+    CONSTRAINT custom_field_definitions_key_check CHECK (key ~ '^[a-z][a-z0-9_]{0,62}$'),
+    CONSTRAINT custom_field_definitions_type_check CHECK (type IN ('string', 'int', 'date', 'enum'))
+);
+
+ALTER TABLE address_book
+    ADD COLUMN custom JSONB NOT NULL DEFAULT '{}',
+    ADD CONSTRAINT address_book_custom_check CHECK (jsonb_typeof(custom) = 'object');
+
+-- Индекс для условий на наличие и точное значение полей (custom @> '{"key": value}', custom ? 'key')
+CREATE INDEX address_book_custom_idx ON address_book USING gin (custom);
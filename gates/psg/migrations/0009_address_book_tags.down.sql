@@ -0,0 +1,5 @@
+DROP VIEW IF EXISTS address_book_tag_members;
+
+DROP TABLE IF EXISTS address_book_record_tags;
+
+DROP TABLE IF EXISTS address_book_tags;
@@ -0,0 +1,23 @@
+-- Теги (группы) записей. Имена уникальны без учета регистра.
+CREATE TABLE address_book_tags (
+    id   BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    CONSTRAINT address_book_tags_name_check CHECK (name <> '')
+);
+
+CREATE UNIQUE INDEX address_book_tags_name_key ON address_book_tags (lower(name));
+
+-- Принадлежность записей тегам (многие ко многим). Связи удаляются вместе с записью или тегом.
+CREATE TABLE address_book_record_tags (
+    record_id BIGINT NOT NULL REFERENCES address_book (id) ON DELETE CASCADE,
+    tag_id    BIGINT NOT NULL REFERENCES address_book_tags (id) ON DELETE CASCADE,
+    PRIMARY KEY (record_id, tag_id)
+);
+
+CREATE INDEX address_book_record_tags_tag_id_idx ON address_book_record_tags (tag_id);
+
+-- Имена тегов записей: по представлению строятся условия на поле tag (как на дочерние таблицы телефонов и адресов)
+CREATE VIEW address_book_tag_members AS
+SELECT rt.record_id, t.id AS tag_id, t.name
+FROM address_book_record_tags rt
+         JOIN address_book_tags t ON t.id = rt.tag_id;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS address_book_created_at_idx;
+DROP INDEX IF EXISTS address_book_updated_at_idx;
+
+ALTER TABLE address_book
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,10 @@
+-- Время создания и последнего изменения записи. Значения устанавливает сервер (psg.Psg):
+-- created_at - при вставке, updated_at - при каждом изменении записи, ее номеров, адресов, почты и тегов.
+-- Существующие записи получают время применения миграции.
+ALTER TABLE address_book
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Для выборок "измененные после" (синхронизация) и "созданные до"
+CREATE INDEX address_book_updated_at_idx ON address_book (updated_at);
+CREATE INDEX address_book_created_at_idx ON address_book (created_at);
@@ -0,0 +1,19 @@
+-- История изменений записей: состояние записи до и после изменения (JSON, как в ответах API), время,
+-- автор и идентификатор запроса. Строки пишутся psg.Psg в той же транзакции, что и изменение.
+-- Внешнего ключа на address_book нет: история удаленных записей сохраняется.
+CREATE TABLE address_book_history (
+    id         BIGSERIAL   PRIMARY KEY,
+    record_id  BIGINT      NOT NULL,
+    action     TEXT        NOT NULL,
+    before     JSONB,
+    after      JSONB,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor      TEXT        NOT NULL DEFAULT '',
+    request_id TEXT        NOT NULL DEFAULT '',
+    CONSTRAINT address_book_history_action_check CHECK (action IN ('create', 'update', 'delete'))
+);
+
+-- История записи и лента изменений по автору и времени (от новых к старым)
+CREATE INDEX address_book_history_record_id_idx ON address_book_history (record_id, id);
+CREATE INDEX address_book_history_actor_idx ON address_book_history (actor, id);
+CREATE INDEX address_book_history_changed_at_idx ON address_book_history (changed_at);
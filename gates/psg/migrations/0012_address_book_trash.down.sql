@@ -0,0 +1,17 @@
+-- Записи из корзины удаляются окончательно, как при удалении до появления корзины
+DELETE FROM address_book WHERE deleted_at IS NOT NULL;
+
+DELETE FROM address_book_history WHERE action IN ('restore', 'purge');
+ALTER TABLE address_book_history
+    DROP CONSTRAINT IF EXISTS address_book_history_action_check,
+    ADD CONSTRAINT address_book_history_action_check CHECK (action IN ('create', 'update', 'delete'));
+
+DROP INDEX IF EXISTS address_book_phones_number_key;
+ALTER TABLE address_book_phones
+    DROP COLUMN IF EXISTS deleted,
+    ADD CONSTRAINT address_book_phones_number_key UNIQUE (number);
+
+DROP INDEX IF EXISTS address_book_deleted_at_idx;
+ALTER TABLE address_book
+    DROP COLUMN IF EXISTS deleted_by,
+    DROP COLUMN IF EXISTS deleted_at;
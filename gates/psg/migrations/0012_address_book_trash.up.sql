@@ -0,0 +1,20 @@
+-- Корзина: удаление записи помечает ее временем удаления deleted_at и автором deleted_by, а не удаляет строку.
+-- Записи в корзине не выбираются обычными запросами, их можно восстановить или удалить окончательно.
+ALTER TABLE address_book
+    ADD COLUMN deleted_at TIMESTAMPTZ,
+    ADD COLUMN deleted_by TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX address_book_deleted_at_idx ON address_book (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Номера записей в корзине не занимают номер: уникальность проверяется только среди номеров действующих записей
+-- (имя индекса совпадает с прежним ограничением, по нему распознается занятый номер).
+ALTER TABLE address_book_phones
+    ADD COLUMN deleted BOOLEAN NOT NULL DEFAULT false,
+    DROP CONSTRAINT address_book_phones_number_key;
+
+CREATE UNIQUE INDEX address_book_phones_number_key ON address_book_phones (number) WHERE NOT deleted;
+
+-- Восстановление из корзины и окончательное удаление записываются в историю изменений
+ALTER TABLE address_book_history
+    DROP CONSTRAINT address_book_history_action_check,
+    ADD CONSTRAINT address_book_history_action_check CHECK (action IN ('create', 'update', 'delete', 'restore', 'purge'));
@@ -0,0 +1,2 @@
+ALTER TABLE address_book
+    DROP COLUMN IF EXISTS version;
@@ -0,0 +1,5 @@
+-- Версия записи для оптимистичной блокировки: растет на 1 при каждом изменении записи, ее номеров,
+-- адресов, почты и тегов, при удалении в корзину и восстановлении. Клиент передает версию,
+-- которую он прочитал, и изменение отклоняется, если запись успели изменить.
+ALTER TABLE address_book
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
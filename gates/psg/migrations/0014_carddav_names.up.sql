@@ -0,0 +1,9 @@
+-- Имена ресурсов CardDAV ({name}.vcf), выбранные клиентами CardDAV при создании контакта.
+-- Остальные записи доступны по имени abs-record-{id}.vcf, поэтому строки есть только у записей,
+-- созданных через CardDAV. Внешнего ключа на address_book нет: имя окончательно удаленной записи сохраняется,
+-- чтобы сообщить клиентам об удалении ресурса (sync-collection). Имя удаленной записи может занять новый контакт.
+CREATE TABLE address_book_carddav_names (
+    record_id BIGINT PRIMARY KEY,
+    name      TEXT   NOT NULL,
+    CONSTRAINT address_book_carddav_names_name_key UNIQUE (name)
+);
@@ -0,0 +1 @@
+ALTER TABLE address_book_history DROP COLUMN IF EXISTS txid;
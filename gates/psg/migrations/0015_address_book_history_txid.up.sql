@@ -0,0 +1,6 @@
+-- Транзакция, записавшая строку истории (pg_current_xact_id), для sync-token CardDAV (см. Psg.SyncToken):
+-- идентификаторы истории выдаются до фиксации транзакций, поэтому по ним нельзя узнать, какие изменения
+-- клиент уже видел. Существующим строкам достается транзакция миграции, и клиенты получат их еще раз.
+ALTER TABLE address_book_history ADD COLUMN txid BIGINT NOT NULL DEFAULT pg_current_xact_id()::text::bigint;
+
+CREATE INDEX address_book_history_txid_idx ON address_book_history (txid);
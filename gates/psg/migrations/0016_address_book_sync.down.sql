@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS address_book_history_sync ON address_book_history;
+DROP FUNCTION IF EXISTS address_book_sync_commit();
+DROP TABLE IF EXISTS address_book_sync_tx;
+DROP TABLE IF EXISTS address_book_sync;
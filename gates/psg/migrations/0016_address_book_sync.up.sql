@@ -0,0 +1,45 @@
+-- Порядок фиксации изменений для sync-token CardDAV (см. Psg.SyncToken). Идентификаторы истории и номера
+-- транзакций (txid) выдаются до фиксации, а pg_snapshot_xmin меняется от любых транзакций кластера, поэтому
+-- каждая транзакция, записавшая историю, при фиксации получает следующий номер из address_book_sync.
+-- Номер выдается отложенным триггером в самом конце транзакции: блокировка строки address_book_sync
+-- упорядочивает фиксации, но держится только до фиксации и не ждет блокировок записей.
+CREATE TABLE address_book_sync (
+    id  BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id), -- В таблице одна строка
+    seq BIGINT NOT NULL                              -- Номер последней зафиксированной транзакции
+);
+
+CREATE TABLE address_book_sync_tx (
+    txid BIGINT PRIMARY KEY, -- Транзакция (address_book_history.txid)
+    seq  BIGINT NOT NULL     -- Номер фиксации транзакции
+);
+
+CREATE INDEX address_book_sync_tx_seq_idx ON address_book_sync_tx (seq);
+
+-- Существующая история считается полученной клиентами: им выдается новый формат токена, и старые токены
+-- приводят к полной синхронизации
+INSERT INTO address_book_sync (seq) VALUES (0);
+INSERT INTO address_book_sync_tx (txid, seq) SELECT DISTINCT txid, 0 FROM address_book_history;
+
+CREATE FUNCTION address_book_sync_commit() RETURNS trigger
+    LANGUAGE plpgsql AS
+$$
+DECLARE
+    next_seq BIGINT;
+BEGIN
+    -- Триггер срабатывает для каждой строки истории транзакции, а номер ей нужен один
+    IF current_setting('abs.sync_txid', true) = NEW.txid::text THEN
+        RETURN NULL;
+    END IF;
+    PERFORM set_config('abs.sync_txid', NEW.txid::text, true);
+
+    UPDATE address_book_sync SET seq = seq + 1 RETURNING seq INTO next_seq;
+    INSERT INTO address_book_sync_tx (txid, seq) VALUES (NEW.txid, next_seq);
+    RETURN NULL;
+END
+$$;
+
+CREATE CONSTRAINT TRIGGER address_book_history_sync
+    AFTER INSERT ON address_book_history
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+EXECUTE FUNCTION address_book_sync_commit();
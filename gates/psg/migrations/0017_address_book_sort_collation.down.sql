@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS address_book_name_id_idx;
+DROP INDEX IF EXISTS address_book_last_name_id_idx;
+
+CREATE INDEX address_book_last_name_id_idx ON address_book (last_name, id);
+CREATE INDEX address_book_name_id_idx ON address_book (name, id);
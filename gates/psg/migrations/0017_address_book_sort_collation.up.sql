@@ -0,0 +1,8 @@
+-- Сортировка записей сравнивает строки побайтно (COLLATE "C"), как хранилище в памяти, а не по правилам
+-- локали базы данных (см. sortExpr). Индексы для постраничной выборки по (name, id) и (last_name, id)
+-- пересоздаются с тем же правилом сравнения, иначе ORDER BY и условие токена продолжения их не используют.
+DROP INDEX IF EXISTS address_book_name_id_idx;
+DROP INDEX IF EXISTS address_book_last_name_id_idx;
+
+CREATE INDEX address_book_name_id_idx ON address_book (name COLLATE "C", id);
+CREATE INDEX address_book_last_name_id_idx ON address_book (last_name COLLATE "C", id);
@@ -0,0 +1,88 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"context"
+	"github.com/jackc/pgx/v5"
+)
+
+// Номера телефонов записей хранятся в таблице address_book_phones (см. миграцию 0005):
+// уникальность номеров среди всех записей гарантирует ограничение address_book_phones_number_key,
+// единственность основного номера записи - уникальный индекс address_book_phones_primary_idx.
+
+// insertPhones добавляет номера phones записи id одним запросом INSERT.
+func insertPhones(ctx context.Context, tx pgx.Tx, id int64, phones []dto.Phone) error {
+	numbers := make([]string, 0, len(phones))
+	types := make([]string, 0, len(phones))
+	primary := make([]bool, 0, len(phones))
+	for _, p := range phones {
+		numbers = append(numbers, p.Number)
+		types = append(types, p.Type)
+		primary = append(primary, p.Primary)
+	}
+
+	sqlCommand := `INSERT INTO address_book_phones (record_id, number, type, is_primary)
+		SELECT $1::bigint, * FROM unnest($2::text[], $3::text[], $4::boolean[])`
+	_, err := tx.Exec(ctx, sqlCommand, id, numbers, types, primary)
+	return err
+}
+
+// replacePhones заменяет все номера записи id на phones.
+// Старые номера удаляются до вставки новых, поэтому номера можно переиспользовать.
+func replacePhones(ctx context.Context, tx pgx.Tx, id int64, phones []dto.Phone) error {
+	_, err := tx.Exec(ctx, `DELETE FROM address_book_phones WHERE record_id=$1`, id)
+	if err != nil {
+		return err
+	}
+	return insertPhones(ctx, tx, id, phones)
+}
+
+// setPrimaryPhone заменяет основной номер записи id на number (добавляет его, если основного номера нет).
+// Если number уже есть среди дополнительных номеров записи, он удаляется из них.
+func setPrimaryPhone(ctx context.Context, tx pgx.Tx, id int64, number string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM address_book_phones WHERE record_id=$1 AND number=$2 AND NOT is_primary`, id, number)
+	if err != nil {
+		return err
+	}
+	tag, err := tx.Exec(ctx, `UPDATE address_book_phones SET number=$1 WHERE record_id=$2 AND is_primary`, number, id)
+	if err != nil || tag.RowsAffected() > 0 {
+		return err
+	}
+	return insertPhones(ctx, tx, id, []dto.Phone{{Number: number, Type: dto.PhoneOther, Primary: true}})
+}
+
+// loadPhones загружает номера телефонов записей records одним запросом
+// и заполняет Phones (основной номер первым) и Phone.
+func loadPhones(ctx context.Context, q querier, records []dto.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(records))
+	ids := make([]int64, 0, len(records))
+	for i, r := range records {
+		index[r.ID] = i
+		ids = append(ids, r.ID)
+	}
+
+	sqlCommand := `SELECT record_id, number, type, is_primary FROM address_book_phones
+		WHERE record_id = ANY($1) ORDER BY record_id, is_primary DESC, id`
+	rows, err := q.Query(ctx, sqlCommand, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var phone dto.Phone
+		if err = rows.Scan(&id, &phone.Number, &phone.Type, &phone.Primary); err != nil {
+			return err
+		}
+		r := &records[index[id]]
+		r.Phones = append(r.Phones, phone)
+		if phone.Primary {
+			r.Phone = phone.Number
+		}
+	}
+	return rows.Err()
+}
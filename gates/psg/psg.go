@@ -5,32 +5,78 @@ import (
 	"context"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"net/url"
+	"strconv"
+	"time"
 )
 
 type Psg struct {
-	conn *pgxpool.Pool
+	conn     *pgxpool.Pool
+	timeouts Timeouts
 }
 
-func NewPsg(dburl string, login, pass string) (psg *Psg, err error) {
+// Timeouts задает ограничения времени выполнения запросов к базе данных.
+// Нулевое значение означает отсутствие ограничения.
+// Дедлайны операций накладываются на контекст запроса клиента: отмена запроса
+// или истечение дедлайна прерывает выполнение SQL.
+type Timeouts struct {
+	Statement time.Duration // statement_timeout на стороне сервера для всех соединений пула
+	Save      time.Duration // Дедлайн SaveRecord
+	Get       time.Duration // Дедлайн GetRecords и PhoneExists
+	Update    time.Duration // Дедлайн UpdateRecord
+	Delete    time.Duration // Дедлайн DeleteRecordByPhone
+	Batch     time.Duration // Дедлайн Batch (всего пакета операций)
+	Import    time.Duration // Дедлайн ImportRecords (всего импорта)
+	Export    time.Duration // Дедлайн ExportRecords (всей выгрузки)
+}
+
+// NewPsg подключается к базе данных и применяет непримененные миграции схемы (см. MigrateUp).
+func NewPsg(dburl string, login, pass string, timeouts Timeouts) (psg *Psg, err error) {
 	wErr := pkg.NewWrappedError("NewPsg()")
 
-	psg = &Psg{}
-	psg.conn, err = parseConnectionString(dburl, login, pass)
+	psg, err = NewPsgWithoutMigrations(dburl, login, pass, timeouts)
+	if err != nil {
+		wErr.Specify(err, "NewPsgWithoutMigrations(dburl, login, pass, timeouts)").LogError()
+		return nil, err
+	}
+
+	err = psg.MigrateUp(context.Background())
+	if err != nil {
+		wErr.Specify(err, "psg.MigrateUp(context.Background())").LogError()
+		psg.Close()
+		return nil, err
+	}
+
+	return psg, nil
+}
+
+// NewPsgWithoutMigrations подключается к базе данных, не трогая схему.
+// Используется, когда миграциями нужно управлять вручную (MigrateUp, MigrateDown).
+func NewPsgWithoutMigrations(dburl string, login, pass string, timeouts Timeouts) (psg *Psg, err error) {
+	wErr := pkg.NewWrappedError("NewPsgWithoutMigrations()")
+
+	psg = &Psg{timeouts: timeouts}
+	psg.conn, err = parseConnectionString(dburl, login, pass, timeouts.Statement)
 	if err != nil {
-		wErr.Specify(err, "parseConnectionString(dburl, login, pass)").LogError()
+		wErr.Specify(err, "parseConnectionString(dburl, login, pass, timeouts.Statement)").LogError()
 		return nil, err
 	}
 
 	err = psg.conn.Ping(context.Background())
 	if err != nil {
 		wErr.Specify(err, "psg.conn.Ping(context.Background())").LogError()
+		psg.Close()
 		return nil, err
 	}
 
 	return psg, nil
 }
 
-func parseConnectionString(dburl, user, password string) (db *pgxpool.Pool, err error) {
+// Close закрывает все соединения с базой данных.
+func (p *Psg) Close() {
+	p.conn.Close()
+}
+
+func parseConnectionString(dburl, user, password string, statementTimeout time.Duration) (db *pgxpool.Pool, err error) {
 	wErr := pkg.NewWrappedError("parseConnectionString()")
 
 	var u *url.URL
@@ -40,10 +86,27 @@ func parseConnectionString(dburl, user, password string) (db *pgxpool.Pool, err
 	}
 	u.User = url.UserPassword(user, password)
 
-	db, err = pgxpool.New(context.Background(), u.String())
+	config, err := pgxpool.ParseConfig(u.String())
+	if err != nil {
+		wErr.Specify(err, "pgxpool.ParseConfig(u.String())").LogError()
+		return nil, err
+	}
+	if statementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(statementTimeout.Milliseconds(), 10)
+	}
+
+	db, err = pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
-		wErr.Specify(err, "pgxpool.New(context.Background(), u.String())").LogError()
+		wErr.Specify(err, "pgxpool.NewWithConfig(context.Background(), config)").LogError()
 		return nil, err
 	}
 	return db, nil
 }
+
+// withTimeout ограничивает ctx дедлайном timeout, если он задан.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -7,28 +7,18 @@ import (
 	"fmt"
 	"github.com/jackc/pgx/v5"
 	"github.com/pkg/errors"
-	"html/template"
 	"log"
-	"reflect"
-	"strconv"
 	"strings"
 )
 
-/*
-CREATE TABLE address_book (
-    id SERIAL PRIMARY KEY,
-    name VARCHAR(255),
-    last_name VARCHAR(255),
-    middle_name VARCHAR(255),
-    address VARCHAR(255),
-    phone VARCHAR(20)
-);
-*/
-
-// SaveRecord сохраняет запись в таблицу address_book. Перед сохранением
-// проверяет уникальность номера телефона. Если номер телефона уже существует
-// в базе данных, возвращает ошибку "phone number already in use". В случае
-// успешного сохранения возвращает nil.
+// Схема таблицы address_book описана миграциями в каталоге migrations (см. migrate.go).
+
+// SaveRecord сохраняет запись в таблицу address_book, ее адреса rec.Addresses в таблицу address_book_addresses,
+// адреса электронной почты rec.Emails в таблицу address_book_emails и номера телефона rec.Phones
+// в таблицу address_book_phones в одной транзакции вместе с записью истории изменений (см. History).
+// Уникальность номеров телефона проверяет ограничение address_book_phones_number_key:
+// если один из номеров уже существует в базе данных, возвращает ошибку dto.ErrPhoneInUse.
+// В случае успешного сохранения возвращает идентификатор новой записи и nil.
 //
 // Пример использования:
 //
@@ -37,358 +27,679 @@ CREATE TABLE address_book (
 //	    LastName:   "Doe",
 //	    MiddleName: "Smith",
 //	    Address:    "123 Main St",
-//	    Phone:      "+71234567890",
+//	    Phones:     []dto.Phone{{Number: "81234567890", Type: dto.PhoneMobile, Primary: true}},
 //	}
-//	err := psg.SaveRecord(rec)
+//	id, err := psg.SaveRecord(ctx, rec)
 //	if err != nil {
 //	    fmt.Println(err.Error())
 //	}
-func (p *Psg) SaveRecord(rec dto.Record) error {
+func (p *Psg) SaveRecord(ctx context.Context, rec dto.Record) (id int64, err error) {
 	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) SaveRecord()")
 	if err != nil {
 		log.Println("(p *Psg) SaveRecord(): NewWrappedErrorWithFile()", err)
 	}
 
-	err = p.PhoneExists(rec.Phone)
-	if err != nil {
-		wErr.Specify(err, "p.PhoneExists(rec.Phone)").LogError()
+	ctx, cancel := withTimeout(ctx, p.timeouts.Save)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		id, err = saveRecordTx(ctx, tx, rec)
 		return err
+	})
+	if isUniqueViolation(err, phoneUniqueConstraint) {
+		wErr.LogMsg(dto.ErrPhoneInUse.Error())
+		return 0, dto.ErrPhoneInUse
 	}
-
-	sqlCommand := `INSERT INTO address_book (name, last_name, middle_name, address, phone) VALUES ($1, $2, $3, $4, $5)`
-	_, err = p.conn.Exec(context.Background(), sqlCommand, rec.Name, rec.LastName, rec.MiddleName, rec.Address, rec.Phone)
 	if err != nil {
-		wErr.Specify(err, "p.conn.Exec()").LogError()
-		return err
+		wErr.Specify(err, "pgx.BeginFunc()").LogError()
+		return 0, wrapTimeout(err)
 	}
 
-	return nil
+	return id, nil
 }
 
-// GetRecords возвращает список записей из таблицы address_book, удовлетворяющих
-// условиям выборки, определенным в переданной структуре rec. В случае успешного
-// выполнения запроса возвращает список записей и nil ошибки. В случае возникновения
-// ошибки при выполнении запроса или сканирования результатов, возвращает пустой
-// список и ошибку.
+// saveRecordTx сохраняет запись rec с ее адресами, почтой и номерами в транзакции tx (см. SaveRecord)
+// и записывает создание в историю. Возвращает идентификатор новой записи.
+func saveRecordTx(ctx context.Context, tx pgx.Tx, rec dto.Record) (id int64, err error) {
+	sqlCommand := `INSERT INTO address_book (name, last_name, middle_name, address, birthday, organization, job_title, notes, custom)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
+	err = tx.QueryRow(ctx, sqlCommand, rec.Name, rec.LastName, rec.MiddleName, rec.Address,
+		nullDate(rec.Birthday), rec.Organization, rec.JobTitle, rec.Notes, customJSON(rec.Custom)).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if err = insertAddresses(ctx, tx, id, rec.Addresses); err != nil {
+		return 0, err
+	}
+	if err = insertEmails(ctx, tx, id, rec.Emails); err != nil {
+		return 0, err
+	}
+	if err = insertPhones(ctx, tx, id, rec.Phones); err != nil {
+		return 0, err
+	}
+	after, err := recordTx(ctx, tx, id)
+	if err != nil {
+		return 0, err
+	}
+	return id, insertHistory(ctx, tx, dto.ActionCreate, id, nil, &after)
+}
+
+// GetRecords возвращает страницу записей из таблицы address_book, удовлетворяющих
+// условиям выборки q (см. SelectRecord), в порядке q.Sort. Если q.Limit задан и записей больше,
+// в результате возвращается токен следующей страницы NextCursor. При q.WithTotal дополнительно
+// считается общее количество записей, удовлетворяющих q.Filter. При q.AsOf записи возвращаются
+// в состоянии на этот момент, включая удаленные позже. В случае возникновения
+// ошибки при выполнении запроса или сканирования результатов, возвращает ошибку.
 //
 // Пример использования:
 //
-//	rec := dto.Record{Name: "John", Phone: "+71234567890"}
-//	records, err := psg.GetRecords(rec)
+//	q := dto.Query{Filter: dto.RecordFilter(dto.Record{Name: "John"}), Sort: "-last_name", Limit: 50}
+//	page, err := psg.GetRecords(ctx, q)
 //	if err != nil {
 //	    fmt.Println(err.Error())
 //	}
-func (p *Psg) GetRecords(rec dto.Record) (result []dto.Record, err error) {
+//	q.Cursor = page.NextCursor // следующая страница
+func (p *Psg) GetRecords(ctx context.Context, q dto.Query) (page dto.RecordsPage, err error) {
 	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) GetRecords()")
 	if err != nil {
-		log.Println("(p *Psg) SaveRecord(): NewWrappedErrorWithFile()", err)
+		log.Println("(p *Psg) GetRecords(): NewWrappedErrorWithFile()", err)
 	}
 
-	sqlCommand, values, err := p.SelectRecord(rec)
+	ctx, cancel := withTimeout(ctx, p.timeouts.Get)
+	defer cancel()
+
+	// Объявления дополнительных полей нужны только для условий на них
+	if q.Filter != nil && q.Filter.HasCustomFields() {
+		defs, err := p.customFieldDefs(ctx)
+		if err != nil {
+			wErr.Specify(err, "p.customFieldDefs(ctx)").LogError()
+			return page, wrapTimeout(err)
+		}
+		q.CustomFields = dto.NewCustomFieldDefs(defs)
+	}
+
+	err = q.Validate()
 	if err != nil {
-		wErr.Specify(err, "p.SelectRecord(rec)").LogError()
-		return result, err
+		wErr.Specify(err, "q.Validate()").LogError()
+		return page, err
 	}
 
-	rows, err := p.conn.Query(context.Background(), sqlCommand, values...)
+	sqlCommand, values, err := p.SelectRecord(q)
+	if err != nil {
+		wErr.Specify(err, "p.SelectRecord(q)").LogError()
+		return page, err
+	}
+
+	rows, err := p.conn.Query(ctx, sqlCommand, values...)
 	if err != nil {
 		wErr.Specify(err, "p.conn.Query()").LogError()
-		return result, err
+		return page, wrapTimeout(err)
 	}
 	defer rows.Close()
 
+	// Записи на момент q.AsOf, восстановленные из истории, по позициям на странице.
+	// Они уже содержат номера, адреса, почту и теги и заменяют загруженные ниже текущие значения.
+	restored := map[int]dto.Record{}
 	for rows.Next() {
 		var r dto.Record
-		err = rows.Scan(&r.ID, &r.Name, &r.LastName, &r.MiddleName, &r.Address, &r.Phone)
+		var snapshot *dto.Record
+		if q.AsOf.IsZero() {
+			err = scanRecord(rows, &r)
+		} else {
+			err = scanRecord(rows, &r, &snapshot)
+		}
 		if err != nil {
-			wErr.Specify(err, "rows.Scan(&r.ID, &r.Name, &r.LastName, &r.MiddleName, &r.Address, &r.Phone)").LogError()
-			return result, err
+			wErr.Specify(err, "scanRecord(rows, &r)").LogError()
+			return page, err
+		}
+		if snapshot != nil {
+			restored[len(page.Records)] = *snapshot
 		}
-		result = append(result, r)
+		page.Records = append(page.Records, r)
 	}
 
 	err = rows.Err()
 	if err != nil {
 		wErr.Specify(err, "rows.Err()").LogError()
-		return result, err
+		return page, wrapTimeout(err)
+	}
+
+	// SelectRecord запрашивает на одну запись больше лимита, чтобы узнать, есть ли следующая страница
+	if q.Limit > 0 && len(page.Records) > q.Limit {
+		page.Records = page.Records[:q.Limit]
+		page.NextCursor = q.NextCursor(page.Records[q.Limit-1], q.Limit)
+	}
+
+	err = loadPhones(ctx, p.conn, page.Records)
+	if err != nil {
+		wErr.Specify(err, "loadPhones(ctx, p.conn, page.Records)").LogError()
+		return page, wrapTimeout(err)
+	}
+	err = loadAddresses(ctx, p.conn, page.Records)
+	if err != nil {
+		wErr.Specify(err, "loadAddresses(ctx, p.conn, page.Records)").LogError()
+		return page, wrapTimeout(err)
+	}
+	err = loadEmails(ctx, p.conn, page.Records)
+	if err != nil {
+		wErr.Specify(err, "loadEmails(ctx, p.conn, page.Records)").LogError()
+		return page, wrapTimeout(err)
+	}
+	err = loadTags(ctx, p.conn, page.Records)
+	if err != nil {
+		wErr.Specify(err, "loadTags(ctx, p.conn, page.Records)").LogError()
+		return page, wrapTimeout(err)
+	}
+	for i, r := range restored {
+		if i < len(page.Records) {
+			page.Records[i] = r
+		}
+	}
+
+	if q.WithTotal {
+		sqlCommand, values, err = selectCount(q)
+		if err != nil {
+			wErr.Specify(err, "selectCount(q)").LogError()
+			return page, err
+		}
+		var total int64
+		err = p.conn.QueryRow(ctx, sqlCommand, values...).Scan(&total)
+		if err != nil {
+			wErr.Specify(err, "p.conn.QueryRow().Scan(&total)").LogError()
+			return page, wrapTimeout(err)
+		}
+		page.Total = &total
 	}
 
-	return result, nil
+	return page, nil
 }
 
-// UpdateRecord обновляет запись в таблице address_book на основе переданной структуры rec.
-// В случае успешного выполнения обновления возвращает nil ошибки. Если номер телефона не найден,
-// возвращает ошибку "phone number not found". В случае возникновения ошибки при выполнении запроса,
-// возвращает соответствующую ошибку.
+// UpdateRecord применяет частичное обновление patch к записи, у которой есть номер телефона phone
+// (основной или дополнительный), по правилам JSON Merge Patch: отсутствующие поля не изменяются,
+// очищенные (null) становятся пустыми строками. Основной номер этим методом не изменяется
+// (см. UpdateRecordByID), patch.Phone игнорируется, а patch.Phones заменяет все номера записи.
+// Обновление выполняется в одной транзакции. В случае успешного выполнения обновления возвращает nil ошибки.
+// Если номер телефона не найден, возвращает ошибку dto.ErrPhoneNotFound, если новый номер занят
+// другой записью - dto.ErrPhoneInUse. Если patch не затрагивает ни одного поля, возвращает dto.ErrNothingToUpdate.
+// Если задана ожидаемая версия patch.Version, а версия записи другая (запись уже изменили), возвращает
+// dto.ErrVersionMismatch и не изменяет запись.
+// В случае возникновения ошибки при выполнении запроса, возвращает соответствующую ошибку.
 //
 // Пример использования:
 //
-//	rec := dto.Record{Phone: "+71234567890", Name: "John", Address: "123 Main St."}
-//	err := psg.UpdateRecord(rec)
+//	var patch dto.RecordPatch
+//	_ = json.Unmarshal([]byte(`{"name": "John", "middle_name": null}`), &patch)
+//	err := psg.UpdateRecord(ctx, "81234567890", patch)
 //	if err != nil {
 //	    fmt.Println(err.Error())
 //	}
-func (p *Psg) UpdateRecord(rec dto.Record) (err error) {
+func (p *Psg) UpdateRecord(ctx context.Context, phone string, patch dto.RecordPatch) (err error) {
 	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) UpdateRecord()")
 	if err != nil {
 		log.Println("(p *Psg) UpdateRecord(): NewWrappedErrorWithFile()", err)
 	}
 
-	err = p.PhoneExists(rec.Phone)
-	if err == nil {
-		err = errors.New("phone number not found")
-		wErr.LogMsg(err.Error())
-		return err
-	}
-
-	fields := []string{}
-	values := []any{}
-	index := 1
-
-	if rec.Name != "" {
-		fields = append(fields, fmt.Sprintf("name=$%d", index))
-		values = append(values, rec.Name)
-		index++
-	}
-	if rec.LastName != "" {
-		fields = append(fields, fmt.Sprintf("last_name=$%d", index))
-		values = append(values, rec.LastName)
-		index++
-	}
-	if rec.MiddleName != "" {
-		fields = append(fields, fmt.Sprintf("middle_name=$%d", index))
-		values = append(values, rec.MiddleName)
-		index++
-	}
-	if rec.Address != "" {
-		fields = append(fields, fmt.Sprintf("address=$%d", index))
-		values = append(values, rec.Address)
-		index++
+	patch.Phone = dto.PatchString{}
+	if patch.IsEmpty() {
+		wErr.LogMsg(dto.ErrNothingToUpdate.Error())
+		return dto.ErrNothingToUpdate
 	}
 
-	values = append(values, rec.Phone)
+	ctx, cancel := withTimeout(ctx, p.timeouts.Update)
+	defer cancel()
 
-	sqlCommand := fmt.Sprintf(`UPDATE address_book SET %s WHERE phone=$%d`, strings.Join(fields, ", "), index)
-	_, err = p.conn.Exec(context.Background(), sqlCommand, values...)
-	if err != nil {
-		wErr.Specify(err, "p.conn.Exec()").LogError()
-		return err
-	}
-
-	return nil
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		id, err := recordIDByPhoneTx(ctx, tx, phone)
+		if err != nil {
+			return err
+		}
+		return updateRecordTx(ctx, tx, id, patch)
+	})
+	return checkUpdateErr(wErr, err)
 }
 
-// DeleteRecordByPhone удаляет запись из таблицы address_book по номеру телефона.
+// DeleteRecordByPhone перемещает в корзину запись, у которой есть номер телефона phone (основной или дополнительный),
+// в одной транзакции с записью истории изменений; номера записи в корзине освобождаются для других записей.
 // В случае успешного выполнения удаления возвращает nil ошибки. Если номер телефона не найден,
-// возвращает ошибку "phone number not found". В случае возникновения ошибки при выполнении запроса,
+// возвращает ошибку dto.ErrPhoneNotFound. Если version не 0 и не совпадает с версией записи,
+// возвращает dto.ErrVersionMismatch. В случае возникновения ошибки при выполнении запроса,
 // возвращает соответствующую ошибку.
 //
 // Пример использования:
 //
-//	err := psg.DeleteRecordByPhone("+71234567890")
+//	err := psg.DeleteRecordByPhone(ctx, "+71234567890", 0)
 //	if err != nil {
 //	    fmt.Println(err.Error())
 //	}
-func (p *Psg) DeleteRecordByPhone(phone string) (err error) {
+func (p *Psg) DeleteRecordByPhone(ctx context.Context, phone string, version int64) (err error) {
 	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) DeleteRecordByPhone()")
 	if err != nil {
 		log.Println("(p *Psg) DeleteRecordByPhone(): NewWrappedErrorWithFile()", err)
 	}
 
-	err = p.PhoneExists(phone)
-	if err == nil {
-		err = errors.New("phone number not found")
-		wErr.LogMsg(err.Error())
-		return err
+	ctx, cancel := withTimeout(ctx, p.timeouts.Delete)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		id, err := recordIDByPhoneTx(ctx, tx, phone)
+		if err != nil {
+			return err
+		}
+		return deleteRecordTx(ctx, tx, id, version)
+	})
+	return checkUpdateErr(wErr, err)
+}
+
+// recordIDByPhoneTx возвращает идентификатор действующей записи, у которой есть номер phone (основной
+// или дополнительный), или dto.ErrPhoneNotFound.
+func recordIDByPhoneTx(ctx context.Context, tx pgx.Tx, phone string) (id int64, err error) {
+	err = tx.QueryRow(ctx, `SELECT record_id FROM address_book_phones WHERE number=$1 AND NOT deleted`, phone).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, dto.ErrPhoneNotFound
 	}
+	return id, err
+}
 
-	sqlCommand := `DELETE FROM address_book WHERE phone=$1`
-	_, err = p.conn.Exec(context.Background(), sqlCommand, phone)
+// ReplaceRecord заменяет все поля записи с идентификатором rec.ID, включая все адреса, адреса электронной почты
+// и номера телефона, в одной транзакции вместе с записью истории изменений. Пустые поля rec записываются как пустые строки. Если записи нет, возвращает ошибку
+// dto.ErrRecordNotFound, если один из новых номеров занят другой записью - dto.ErrPhoneInUse.
+// rec.Version, если не 0, - ожидаемая версия записи (см. UpdateRecord).
+//
+// Пример использования:
+//
+//	rec := dto.Record{ID: 1, Version: 3, Name: "John", LastName: "Doe", Address: "123 Main St.",
+//	    Phones: []dto.Phone{{Number: "81234567890", Type: dto.PhoneMobile, Primary: true}}}
+//	err := psg.ReplaceRecord(ctx, rec)
+func (p *Psg) ReplaceRecord(ctx context.Context, rec dto.Record) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) ReplaceRecord()")
 	if err != nil {
-		wErr.Specify(err, "p.conn.Exec()").LogError()
-		return err
+		log.Println("(p *Psg) ReplaceRecord(): NewWrappedErrorWithFile()", err)
 	}
 
-	return nil
+	ctx, cancel := withTimeout(ctx, p.timeouts.Update)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		return trackUpdateTx(ctx, tx, []int64{rec.ID}, func() error {
+			if err := checkVersionTx(ctx, tx, rec.ID, rec.Version); err != nil {
+				return err
+			}
+			sqlCommand := `UPDATE address_book SET name=$1, last_name=$2, middle_name=$3, address=$4,
+				birthday=$5, organization=$6, job_title=$7, notes=$8, custom=$9, updated_at=now() WHERE id=$10`
+			_, err := tx.Exec(ctx, sqlCommand, rec.Name, rec.LastName, rec.MiddleName, rec.Address,
+				nullDate(rec.Birthday), rec.Organization, rec.JobTitle, rec.Notes, customJSON(rec.Custom), rec.ID)
+			if err != nil {
+				return err
+			}
+			if err = replaceAddresses(ctx, tx, rec.ID, rec.Addresses); err != nil {
+				return err
+			}
+			if err = replaceEmails(ctx, tx, rec.ID, rec.Emails); err != nil {
+				return err
+			}
+			return replacePhones(ctx, tx, rec.ID, rec.Phones)
+		})
+	})
+	return checkUpdateErr(wErr, err)
 }
 
-// SelectRecord выполняет SQL-запрос для выборки записей из таблицы address_book
-// на основе переданной структуры r, содержащей поля для условий выборки.
-// Возвращает сгенерированный SQL-запрос, значения для передачи в запрос (values)
-// и ошибку в случае возникновения проблем.
-//
-// Условия выборки строятся на основе полей структуры r, и каждое поле
-// используется в качестве отдельного условия. Условия объединяются
-// операторами AND, а значения подставляются через параметры $1, $2, и т.д.
+// UpdateRecordByID применяет частичное обновление patch к записи с идентификатором id в одной транзакции.
+// Правила те же, что у UpdateRecord, но patch.Phone заменяет основной номер записи.
+// Если записи нет, возвращает ошибку dto.ErrRecordNotFound, если новый номер телефона занят
+// другой записью - dto.ErrPhoneInUse.
 //
 // Пример использования:
 //
-//	r := dto.Record{ID: 1, Name: "John"}
-//	query, values, err := psg.SelectRecord(r)
-//
-// Полученный query:
-//
-//	SELECT
-//	    id, name, last_name, middle_name, address, phone
-//	FROM
-//	    address_book
-//	WHERE
-//	    id = $1 AND name = $2;
-//
-// Полученные значения values:
-//
-//	[]any{1, "John"}
-func (p *Psg) SelectRecord(r dto.Record) (resQuery string, values []any, err error) {
-	// Случай отсутствия условий выборки (возвращаем все записи)
-	if r.Name == "" && r.LastName == "" && r.MiddleName == "" && r.Address == "" && r.Phone == "" {
-		resQuery = "SELECT id, name, last_name, middle_name, address, phone FROM address_book;"
-		return resQuery, values, nil
+//	patch := dto.RecordPatch{Phone: dto.PatchString{Set: true, Value: "81234567890"}}
+//	err := psg.UpdateRecordByID(ctx, 1, patch)
+func (p *Psg) UpdateRecordByID(ctx context.Context, id int64, patch dto.RecordPatch) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) UpdateRecordByID()")
+	if err != nil {
+		log.Println("(p *Psg) UpdateRecordByID(): NewWrappedErrorWithFile()", err)
+	}
+
+	if patch.IsEmpty() {
+		wErr.LogMsg(dto.ErrNothingToUpdate.Error())
+		return dto.ErrNothingToUpdate
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Update)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		return updateRecordTx(ctx, tx, id, patch)
+	})
+	return checkUpdateErr(wErr, err)
+}
+
+// updateRecordTx применяет patch к записи id в транзакции tx (см. applyPatchTx) и записывает изменение
+// в историю. Если записи нет, возвращает dto.ErrRecordNotFound, если ее версия не patch.Version - dto.ErrVersionMismatch.
+func updateRecordTx(ctx context.Context, tx pgx.Tx, id int64, patch dto.RecordPatch) error {
+	return trackUpdateTx(ctx, tx, []int64{id}, func() error {
+		if err := checkVersionTx(ctx, tx, id, patch.Version); err != nil {
+			return err
+		}
+		return applyPatchTx(ctx, tx, id, patch)
+	})
+}
+
+// checkVersionTx сравнивает версию записи id, заблокированной в транзакции tx, с ожидаемой версией version
+// и возвращает dto.ErrVersionMismatch, если они не совпадают. Версия 0 не проверяется.
+func checkVersionTx(ctx context.Context, tx pgx.Tx, id, version int64) error {
+	if version == 0 {
+		return nil
+	}
+	var current int64
+	err := tx.QueryRow(ctx, `SELECT version FROM address_book WHERE id=$1`, id).Scan(&current)
+	if err != nil {
+		return err
 	}
+	if current != version {
+		return dto.ErrVersionMismatch
+	}
+	return nil
+}
 
-	sqlFields, values, err := structToFieldsValues(r, "sql.field")
+// applyPatchTx записывает изменения patch записи id: поля address_book и время изменения,
+// затем адреса, адреса электронной почты и номера телефона.
+func applyPatchTx(ctx context.Context, tx pgx.Tx, id int64, patch dto.RecordPatch) error {
+	fields, values := updateSetClause(patch)
+	fields = append(fields, "updated_at=now()")
+	values = append(values, id)
+	sqlCommand := fmt.Sprintf(`UPDATE address_book SET %s WHERE id=$%d`, strings.Join(fields, ", "), len(values))
+	_, err := tx.Exec(ctx, sqlCommand, values...)
 	if err != nil {
-		return "", nil, err
+		return err
 	}
 
-	var conds []dto.Cond
+	switch {
+	case patch.Addresses.Set:
+		err = replaceAddresses(ctx, tx, id, patch.Addresses.Value)
+	case patch.PrimaryAddress != nil:
+		err = setPrimaryAddress(ctx, tx, id, *patch.PrimaryAddress)
+	}
+	if err != nil {
+		return err
+	}
 
-	for i := range sqlFields {
-		if i == 0 {
-			conds = append(conds, dto.Cond{
-				Lop:    "",
-				PgxInd: "$" + strconv.Itoa(i+1),
-				Field:  sqlFields[i],
-				Value:  values[i],
-			})
-			continue
+	if patch.Emails.Set {
+		if err = replaceEmails(ctx, tx, id, patch.Emails.Value); err != nil {
+			return err
 		}
-		conds = append(conds, dto.Cond{
-			Lop:    "AND",
-			PgxInd: "$" + strconv.Itoa(i+1),
-			Field:  sqlFields[i],
-			Value:  values[i],
-		})
 	}
 
-	query := `
-	SELECT 
-		id, name, last_name, middle_name, address, phone
-	FROM
-	    address_book
-	WHERE
-		{{range .}} {{.Lop}} {{.Field}} = {{.PgxInd}}{{end}}
-;
-`
-	tmpl, err := template.New("").Parse(query)
+	switch {
+	case patch.Phones.Set:
+		return replacePhones(ctx, tx, id, patch.Phones.Value)
+	case patch.Phone.Set:
+		return setPrimaryPhone(ctx, tx, id, patch.Phone.Value)
+	}
+	return nil
+}
+
+// checkUpdateErr преобразует ошибку транзакции обновления записи в ошибки dto и записывает ее в журнал.
+func checkUpdateErr(wErr *pkg.WrappedError, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case isUniqueViolation(err, phoneUniqueConstraint):
+		wErr.LogMsg(dto.ErrPhoneInUse.Error())
+		return dto.ErrPhoneInUse
+	case errors.Is(err, dto.ErrRecordNotFound), errors.Is(err, dto.ErrPhoneNotFound), errors.Is(err, dto.ErrVersionMismatch):
+		wErr.LogMsg(err.Error())
+		return err
+	}
+	wErr.Specify(err, "pgx.BeginFunc()").LogError()
+	return wrapTimeout(err)
+}
+
+// DeleteRecordByID перемещает в корзину запись с идентификатором id в одной транзакции с записью истории изменений
+// (см. DeleteRecordByPhone).
+// Если записи нет, возвращает ошибку dto.ErrRecordNotFound, если version не 0 и не совпадает
+// с версией записи - dto.ErrVersionMismatch.
+//
+// Пример использования:
+//
+//	err := psg.DeleteRecordByID(ctx, 1, 3)
+func (p *Psg) DeleteRecordByID(ctx context.Context, id, version int64) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) DeleteRecordByID()")
 	if err != nil {
-		return
+		log.Println("(p *Psg) DeleteRecordByID(): NewWrappedErrorWithFile()", err)
 	}
 
-	var sb strings.Builder
-	err = tmpl.Execute(&sb, conds)
+	ctx, cancel := withTimeout(ctx, p.timeouts.Delete)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		return deleteRecordTx(ctx, tx, id, version)
+	})
+	return checkUpdateErr(wErr, err)
+}
+
+// deleteRecordTx перемещает запись id в корзину в транзакции tx (см. trash.go) и записывает в историю
+// ее состояние до удаления. Автор удаления берется из dto.ChangeMetaFrom(ctx).
+// Если записи нет (или она уже в корзине), возвращает dto.ErrRecordNotFound,
+// если version не 0 и не совпадает с версией записи - dto.ErrVersionMismatch.
+func deleteRecordTx(ctx context.Context, tx pgx.Tx, id, version int64) error {
+	before, err := recordTx(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if version != 0 && before.Version != version {
+		return dto.ErrVersionMismatch
+	}
+	sqlCommand := `UPDATE address_book SET deleted_at=now(), deleted_by=$2, version=version+1 WHERE id=$1`
+	_, err = tx.Exec(ctx, sqlCommand, id, dto.ChangeMetaFrom(ctx).Actor)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `UPDATE address_book_phones SET deleted=true WHERE record_id=$1`, id)
 	if err != nil {
-		return
+		return err
+	}
+	return insertHistory(ctx, tx, dto.ActionDelete, id, &before, nil)
+}
+
+// updateSetClause строит список присваиваний "поле=$N" для полей address_book, присутствующих в patch,
+// и соответствующие им значения. Очищенные поля записываются как пустые строки, а не NULL,
+// чтобы их можно было сканировать в string (кроме даты рождения, которая очищается в NULL).
+// Дополнительные поля объединяются с текущими значениями (см. customPatchClause).
+// Номера телефона, адреса и адреса электронной почты хранятся отдельно и сюда не входят.
+func updateSetClause(patch dto.RecordPatch) (fields []string, values []any) {
+	add := func(field string, value dto.PatchString) {
+		if !value.Set {
+			return
+		}
+		values = append(values, value.Value)
+		if field == "birthday" {
+			values[len(values)-1] = nullDate(value.Value)
+		}
+		fields = append(fields, fmt.Sprintf("%s=$%d", field, len(values)))
+	}
+
+	add("name", patch.Name)
+	add("last_name", patch.LastName)
+	add("middle_name", patch.MiddleName)
+	add("address", patch.Address)
+	add("birthday", patch.Birthday)
+	add("organization", patch.Organization)
+	add("job_title", patch.JobTitle)
+	add("notes", patch.Notes)
+	if patch.Custom.Set {
+		expr := customPatchClause(patch.Custom, func(v any) string {
+			values = append(values, v)
+			return fmt.Sprintf("$%d", len(values))
+		})
+		fields = append(fields, "custom="+expr)
 	}
-	resQuery = sb.String()
-	return
+
+	return fields, values
 }
 
-// structToFieldsValues преобразует структуру s в список имен полей и их значений,
-// учитывая тег tag для определения имени поля в SQL-запросе.
+// SelectRecord строит SQL-запрос для выборки страницы записей из таблицы address_book
+// по параметрам q. Возвращает сгенерированный SQL-запрос, значения для передачи
+// в запрос (values) и ошибку в случае возникновения проблем.
 //
-// Возвращает два слайса: sqlFields содержит имена полей для использования в SQL-запросе,
-// values содержит значения соответствующих полей. Если поле имеет значение по умолчанию
-// для своего типа (например, 0 для числовых типов, "" для строк и т.д.), оно будет
-// пропущено и не включено в результирующие срезы.
+// Условие WHERE строится по дереву q.Filter (см. dto.Filter и whereBuilder): поддерживаются
+// группы AND/OR, NOT, списки IN (одним параметром-массивом, = ANY), поиск по префиксу и подстроке, сравнение без учета регистра
+// и диапазоны, в том числе для дополнительных полей, объявленных в q.CustomFields. Значения подставляются только через параметры $1, $2, и т.д.
+// Если задан q.Search, добавляется условие полнотекстового и нечеткого поиска (см. whereBuilder.searchCond).
+//
+// Записи упорядочиваются по полю q.Sort и id (ключ страницы). Если задан q.Cursor, выбираются
+// записи после ключа из токена (keyset pagination), а при q.Limit запрашивается q.Limit+1 запись,
+// чтобы определить наличие следующей страницы. При сортировке dto.SortRelevance записи
+// упорядочиваются по убыванию релевантности, а следующая страница выбирается по смещению из токена.
+// Если задан q.AsOf, записи выбираются в состоянии на этот момент по истории изменений (см. asOfTables),
+// а последним столбцом возвращается состояние записи из истории.
 //
 // Пример использования:
 //
-//	type MyStruct struct {
-//	    Field1 int    `sql.field:"column1"`
-//	    Field2 string `sql.field:"column2"`
-//	    Field3 bool   `sql.field:"-"`
-//	}
-//	s := MyStruct{Field1: 42, Field2: "value", Field3: true}
-//	sqlFields, values, err := structToFieldsValues(s, "sql.field")
+//	q := dto.Query{Filter: &dto.Filter{Or: []dto.Filter{
+//	    {Field: "name", Op: dto.OpPrefix, Value: "Jo", IgnoreCase: true},
+//	    {Field: "id", Op: dto.OpIn, Values: []any{int64(1), int64(2)}},
+//	}}, Sort: "-last_name", Limit: 10}
+//	query, values, err := psg.SelectRecord(q)
+//
+// Полученный query:
+//
+//	SELECT ... FROM address_book WHERE deleted_at IS NULL AND (("name" ILIKE $1 ESCAPE '\') OR ("id" = ANY ($2)))
+//	ORDER BY "last_name" COLLATE "C" DESC, id DESC LIMIT 11
+//
+// (вместо ... - столбцы recordColumns).
 //
-// Полученные sqlFields:
+// Полученные значения values:
+//
+//	[]any{"Jo%", []any{1, 2}}
 //
-//	[]string{"column1", "column2"}
+// Для q := dto.Query{Search: "Иван Петр", Limit: 10} полученный query:
 //
-// Полученные values:
+//	SELECT ... FROM address_book
+//	WHERE deleted_at IS NULL AND (search_vector @@ to_tsquery('russian', $1) OR $2 <% search_text
+//	    OR EXISTS (SELECT 1 FROM address_book_emails WHERE address_book_emails.record_id = address_book.id AND email LIKE $3 ESCAPE '\'))
+//	ORDER BY ts_rank(search_vector, to_tsquery('russian', $1)) + word_similarity($2, search_text) DESC, id ASC LIMIT 11
 //
-//	[]any{42, "value"}
+// и values:
 //
-// В случае, если s не является структурой, возвращается ошибка "s must be a struct".
-func structToFieldsValues(s any, tag string) (sqlFields []string, values []any, err error) {
-	rv := reflect.ValueOf(s)
-	if rv.Kind() == reflect.Ptr {
-		rv = rv.Elem()
+//	[]any{"иван:* & петр:*", "Иван Петр", "иван петр%"}
+func (p *Psg) SelectRecord(q dto.Query) (resQuery string, values []any, err error) {
+	err = q.Validate()
+	if err != nil {
+		return "", nil, err
 	}
 
-	if rv.Kind() != reflect.Struct {
-		return nil, nil, errors.New("s must be a struct")
+	b := &whereBuilder{custom: q.CustomFields}
+	with, columns := "", recordColumns
+	if !q.AsOf.IsZero() {
+		with, columns = b.asOfTables(q.AsOf), recordColumns+", "+asOfColumn
+	}
+	conds, err := b.filterConds(q.Filter)
+	if err != nil {
+		return "", nil, err
+	}
+	conds = append([]string{trashCond(q.Trash)}, conds...)
+	var rank string
+	if q.Search != "" {
+		var cond string
+		cond, rank = b.searchCond(q.Search)
+		conds = append(conds, cond)
 	}
 
-	for i := 0; i < rv.NumField(); i++ {
-		field := rv.Type().Field(i)
-		tg := strings.TrimSpace(field.Tag.Get(tag))
-		if tg == "" || tg == "-" {
-			continue
-		}
-		tgs := strings.Split(tg, ",")
-		tg = tgs[0]
-
-		fv := rv.Field(i)
-		isZero := false
-		switch fv.Kind() {
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			isZero = fv.Int() == 0
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			isZero = fv.Uint() == 0
-		case reflect.Float32, reflect.Float64:
-			isZero = fv.Float() == 0
-		case reflect.Complex64, reflect.Complex128:
-			isZero = fv.Complex() == complex(0, 0)
-		case reflect.Bool:
-			isZero = !fv.Bool()
-		case reflect.String:
-			isZero = fv.String() == ""
-		case reflect.Array, reflect.Slice:
-			isZero = fv.Len() == 0
+	var cursor dto.Cursor
+	if q.Cursor != "" {
+		cursor, err = q.DecodeCursor()
+		if err != nil {
+			return "", nil, err
 		}
+	}
 
-		if isZero {
-			continue
+	field, desc := q.SortField()
+	column := sortExpr(field)
+	direction, compare := "ASC", ">"
+	if desc {
+		direction, compare = "DESC", "<"
+	}
+
+	// Условие "после ключа последней записи предыдущей страницы"
+	if q.Cursor != "" && field != dto.SortRelevance {
+		if field == "id" {
+			conds = append(conds, "id "+compare+" "+b.placeholder(cursor.ID))
+		} else {
+			conds = append(conds, fmt.Sprintf("(%s, id) %s (%s, %s)",
+				column, compare, b.placeholder(cursor.Value), b.placeholder(cursor.ID)))
 		}
+	}
 
-		sqlFields = append(sqlFields, tg)
-		values = append(values, fv.Interface())
+	resQuery = with + "SELECT " + columns + " FROM address_book"
+	if len(conds) > 0 {
+		resQuery += " WHERE " + strings.Join(conds, " AND ")
+	}
+	switch field {
+	case "id":
+		resQuery += " ORDER BY id " + direction
+	case dto.SortRelevance:
+		resQuery += " ORDER BY " + rank + " DESC, id ASC"
+	default:
+		resQuery += fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+	}
+	if q.Limit > 0 {
+		resQuery += fmt.Sprintf(" LIMIT %d", q.Limit+1)
+	}
+	if cursor.Offset > 0 {
+		resQuery += fmt.Sprintf(" OFFSET %d", cursor.Offset)
+	}
+
+	return resQuery, b.values, nil
+}
+
+// selectCount строит SQL-запрос количества записей, удовлетворяющих q.Filter и q.Search (без учета страницы).
+func selectCount(q dto.Query) (resQuery string, values []any, err error) {
+	b := &whereBuilder{custom: q.CustomFields}
+	with := ""
+	if !q.AsOf.IsZero() {
+		with = b.asOfTables(q.AsOf)
+	}
+	conds, err := b.filterConds(q.Filter)
+	if err != nil {
+		return "", nil, err
+	}
+	conds = append([]string{trashCond(q.Trash)}, conds...)
+	if q.Search != "" {
+		cond, _ := b.searchCond(q.Search)
+		conds = append(conds, cond)
 	}
 
-	return
+	resQuery = with + "SELECT count(*) FROM address_book"
+	if len(conds) > 0 {
+		resQuery += " WHERE " + strings.Join(conds, " AND ")
+	}
+	return resQuery, b.values, nil
 }
 
-// PhoneExists проверяет наличие номера телефона в таблице address_book.
-// Возвращает ошибку "phone number already in use", если номер телефона уже используется.
+// PhoneExists проверяет наличие номера телефона (основного или дополнительного) в таблице address_book_phones.
+// Возвращает ошибку dto.ErrPhoneInUse, если номер телефона уже используется.
 // Возвращает nil, если номер телефона не найден.
+// Проверка носит справочный характер: SaveRecord, UpdateRecord и DeleteRecordByPhone
+// не используют ее, уникальность гарантирует ограничение address_book_phones_number_key.
 //
 // Пример использования:
 //
-//		err := psg.PhoneExists("+71234567890")
-//		if err != nil {
-//		    fmt.Println(err.Error()) // "phone number already in use"
-//		} else {
-//	     fmt.Println("phone number not found")
-//		}
-func (p *Psg) PhoneExists(phone string) error {
+//	err := psg.PhoneExists(ctx, "+71234567890")
+//	if err != nil {
+//	    fmt.Println(err.Error()) // "phone number already in use"
+//	} else {
+//	    fmt.Println("phone number not found")
+//	}
+func (p *Psg) PhoneExists(ctx context.Context, phone string) error {
 	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) PhoneExists()")
 	if err != nil {
 		log.Println("(p *Psg) PhoneExists(): NewWrappedErrorWithFile()", err)
 	}
 
-	sqlCommand := `SELECT phone FROM address_book WHERE phone = $1`
-	row := p.conn.QueryRow(context.Background(), sqlCommand, phone)
+	sqlCommand := `SELECT number FROM address_book_phones WHERE number = $1 AND NOT deleted`
+	ctx, cancel := withTimeout(ctx, p.timeouts.Get)
+	defer cancel()
+
+	row := p.conn.QueryRow(ctx, sqlCommand, phone)
 
 	var existingPhone string
 	err = row.Scan(&existingPhone)
@@ -397,13 +708,22 @@ func (p *Psg) PhoneExists(phone string) error {
 			return nil
 		}
 		wErr.Specify(err, "row.Scan(&existingPhone)").LogError()
-		return err
-	}
-	if existingPhone == phone {
-		err = errors.New("phone number already in use")
-		wErr.LogMsg(err.Error())
-		return err
+		return wrapTimeout(err)
 	}
 
-	return errors.New("phone number already in use")
+	wErr.LogMsg(dto.ErrPhoneInUse.Error())
+	return dto.ErrPhoneInUse
+}
+
+// scanRecord сканирует столбцы recordColumns строки row в r, а следующие за ними столбцы - в extra.
+// Время возвращается в UTC.
+func scanRecord(row pgx.CollectableRow, r *dto.Record, extra ...any) error {
+	dest := []any{&r.ID, &r.Name, &r.LastName, &r.MiddleName, &r.Address,
+		&r.Birthday, &r.Organization, &r.JobTitle, &r.Notes, &r.Custom, &r.CreatedAt, &r.UpdatedAt, &r.DeletedAt, &r.DeletedBy, &r.Version}
+	err := row.Scan(append(dest, extra...)...)
+	r.CreatedAt, r.UpdatedAt = r.CreatedAt.UTC(), r.UpdatedAt.UTC()
+	if r.DeletedAt != nil {
+		*r.DeletedAt = r.DeletedAt.UTC()
+	}
+	return err
 }
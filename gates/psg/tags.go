@@ -0,0 +1,319 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"log"
+)
+
+// Теги хранятся в таблице address_book_tags, принадлежность записей тегам - в address_book_record_tags
+// (см. миграцию 0009). Имена тегов записей загружаются через представление address_book_tag_members.
+// Изменение тегов записи, как и других ее полей, записывается в историю изменений (см. trackUpdateTx).
+
+const tagNameUniqueConstraint = "address_book_tags_name_key"
+
+// Tags возвращает теги в порядке имен с количеством записей в каждом.
+/*
+Пример использования:
+
+	tags, err := p.Tags(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range tags {
+		fmt.Println(t.ID, t.Name, t.Count)
+	}
+*/
+func (p *Psg) Tags(ctx context.Context) (tags []dto.Tag, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) Tags()")
+	if err != nil {
+		log.Println("(p *Psg) Tags(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Get)
+	defer cancel()
+
+	sqlCommand := `SELECT t.id, t.name, count(r.id) FROM address_book_tags t
+		LEFT JOIN address_book_record_tags rt ON rt.tag_id = t.id
+		LEFT JOIN address_book r ON r.id = rt.record_id AND r.deleted_at IS NULL
+		GROUP BY t.id ORDER BY lower(t.name), t.id`
+	rows, err := p.conn.Query(ctx, sqlCommand)
+	if err == nil {
+		tags, err = pgx.CollectRows(rows, func(r pgx.CollectableRow) (t dto.Tag, err error) {
+			err = r.Scan(&t.ID, &t.Name, &t.Count)
+			return t, err
+		})
+	}
+	if err != nil {
+		wErr.Specify(err, "p.conn.Query()").LogError()
+		return nil, wrapTimeout(err)
+	}
+	return tags, nil
+}
+
+// CreateTag добавляет тег name и возвращает его. Если тег с таким именем (без учета регистра) уже есть,
+// возвращает ошибку dto.ErrTagExists.
+/*
+Пример использования:
+
+	tag, err := p.CreateTag(ctx, "Бухгалтерия")
+	if errors.Is(err, dto.ErrTagExists) {
+		// тег уже есть
+	}
+*/
+func (p *Psg) CreateTag(ctx context.Context, name string) (tag dto.Tag, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) CreateTag()")
+	if err != nil {
+		log.Println("(p *Psg) CreateTag(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Save)
+	defer cancel()
+
+	tag.Name = name
+	err = p.conn.QueryRow(ctx, `INSERT INTO address_book_tags (name) VALUES ($1) RETURNING id`, name).Scan(&tag.ID)
+	if isUniqueViolation(err, tagNameUniqueConstraint) {
+		wErr.LogMsg(dto.ErrTagExists.Error())
+		return dto.Tag{}, dto.ErrTagExists
+	}
+	if err != nil {
+		wErr.Specify(err, "p.conn.QueryRow().Scan(&tag.ID)").LogError()
+		return dto.Tag{}, wrapTimeout(err)
+	}
+	return tag, nil
+}
+
+// RenameTag переименовывает тег id и обновляет время изменения его записей. Если тега нет, возвращает ошибку dto.ErrTagNotFound,
+// если имя занято другим тегом - dto.ErrTagExists.
+func (p *Psg) RenameTag(ctx context.Context, id int64, name string) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) RenameTag()")
+	if err != nil {
+		log.Println("(p *Psg) RenameTag(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Update)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		ids, err := lockTagMembers(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return trackUpdateTx(ctx, tx, ids, func() error {
+			tag, err := tx.Exec(ctx, `UPDATE address_book_tags SET name=$1 WHERE id=$2`, name, id)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				return dto.ErrTagNotFound
+			}
+			return touchTagMembers(ctx, tx, id)
+		})
+	})
+	return checkTagErr(wErr, err)
+}
+
+// DeleteTag удаляет тег id; записи остаются, теряя только этот тег (время их изменения обновляется).
+// Если тега нет, возвращает ошибку dto.ErrTagNotFound.
+func (p *Psg) DeleteTag(ctx context.Context, id int64) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) DeleteTag()")
+	if err != nil {
+		log.Println("(p *Psg) DeleteTag(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Delete)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		ids, err := lockTagMembers(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return trackUpdateTx(ctx, tx, ids, func() error {
+			// Время изменения записей обновляется до каскадного удаления связей с тегом
+			if err := touchTagMembers(ctx, tx, id); err != nil {
+				return err
+			}
+			tag, err := tx.Exec(ctx, `DELETE FROM address_book_tags WHERE id=$1`, id)
+			if err == nil && tag.RowsAffected() == 0 {
+				return dto.ErrTagNotFound
+			}
+			return err
+		})
+	})
+	return checkTagErr(wErr, err)
+}
+
+// AttachTag добавляет запись recordID в тег tagID; повторное добавление не считается ошибкой.
+// Если записи нет, возвращает ошибку dto.ErrRecordNotFound, если тега нет - dto.ErrTagNotFound.
+/*
+Пример использования:
+
+	err := p.AttachTag(ctx, recordID, tag.ID)
+	if errors.Is(err, dto.ErrTagNotFound) {
+		// тег удален
+	}
+*/
+func (p *Psg) AttachTag(ctx context.Context, recordID, tagID int64) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) AttachTag()")
+	if err != nil {
+		log.Println("(p *Psg) AttachTag(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Update)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		err := lockTagMembership(ctx, tx, recordID, tagID)
+		if err != nil {
+			return err
+		}
+		return trackUpdateTx(ctx, tx, []int64{recordID}, func() error {
+			sqlCommand := `INSERT INTO address_book_record_tags (record_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+			tag, err := tx.Exec(ctx, sqlCommand, recordID, tagID)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				return errUnchanged
+			}
+			return touchRecord(ctx, tx, recordID)
+		})
+	})
+	return checkTagErr(wErr, err)
+}
+
+// DetachTag убирает запись recordID из тега tagID; если запись не входит в тег, ничего не меняется.
+// Если записи нет, возвращает ошибку dto.ErrRecordNotFound, если тега нет - dto.ErrTagNotFound.
+func (p *Psg) DetachTag(ctx context.Context, recordID, tagID int64) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) DetachTag()")
+	if err != nil {
+		log.Println("(p *Psg) DetachTag(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Update)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		err := lockTagMembership(ctx, tx, recordID, tagID)
+		if err != nil {
+			return err
+		}
+		return trackUpdateTx(ctx, tx, []int64{recordID}, func() error {
+			tag, err := tx.Exec(ctx, `DELETE FROM address_book_record_tags WHERE record_id=$1 AND tag_id=$2`, recordID, tagID)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				return errUnchanged
+			}
+			return touchRecord(ctx, tx, recordID)
+		})
+	})
+	return checkTagErr(wErr, err)
+}
+
+// lockTagMembership проверяет, что запись (не в корзине) и тег существуют, и блокирует их от удаления до конца транзакции.
+func lockTagMembership(ctx context.Context, tx pgx.Tx, recordID, tagID int64) error {
+	var id int64
+	err := tx.QueryRow(ctx, `SELECT id FROM address_book WHERE id=$1 AND deleted_at IS NULL FOR KEY SHARE`, recordID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return dto.ErrRecordNotFound
+	}
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(ctx, `SELECT id FROM address_book_tags WHERE id=$1 FOR KEY SHARE`, tagID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return dto.ErrTagNotFound
+	}
+	return err
+}
+
+// touchRecord обновляет время изменения записи id (при изменении ее тегов).
+func touchRecord(ctx context.Context, tx pgx.Tx, id int64) error {
+	_, err := tx.Exec(ctx, `UPDATE address_book SET updated_at=now() WHERE id=$1`, id)
+	return err
+}
+
+// lockTagMembers блокирует тег tagID до конца транзакции и возвращает идентификаторы его записей
+// (кроме записей в корзине) по возрастанию. Тег блокируется до выборки записей: AttachTag и DetachTag
+// блокируют тег совместно (FOR KEY SHARE), поэтому они ждут конца транзакции, а начатые раньше
+// завершаются до выборки, и ни одна запись тега не меняется без истории. Если тега нет, возвращает dto.ErrTagNotFound.
+func lockTagMembers(ctx context.Context, tx pgx.Tx, tagID int64) ([]int64, error) {
+	var id int64
+	err := tx.QueryRow(ctx, `SELECT id FROM address_book_tags WHERE id=$1 FOR UPDATE`, tagID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, dto.ErrTagNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sqlCommand := `SELECT rt.record_id FROM address_book_record_tags rt
+		JOIN address_book r ON r.id = rt.record_id AND r.deleted_at IS NULL
+		WHERE rt.tag_id=$1 ORDER BY rt.record_id`
+	rows, err := tx.Query(ctx, sqlCommand, tagID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+// touchTagMembers обновляет время изменения всех записей с тегом tagID (при переименовании и удалении тега).
+func touchTagMembers(ctx context.Context, tx pgx.Tx, tagID int64) error {
+	sqlCommand := `UPDATE address_book SET updated_at=now()
+		WHERE id IN (SELECT record_id FROM address_book_record_tags WHERE tag_id=$1)`
+	_, err := tx.Exec(ctx, sqlCommand, tagID)
+	return err
+}
+
+// checkTagErr преобразует ошибку изменения тега или принадлежности к нему и записывает ее в журнал.
+func checkTagErr(wErr *pkg.WrappedError, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case isUniqueViolation(err, tagNameUniqueConstraint):
+		wErr.LogMsg(dto.ErrTagExists.Error())
+		return dto.ErrTagExists
+	case errors.Is(err, dto.ErrTagNotFound), errors.Is(err, dto.ErrRecordNotFound):
+		wErr.LogMsg(err.Error())
+		return err
+	}
+	wErr.Specify(err, "p.conn.Exec()").LogError()
+	return wrapTimeout(err)
+}
+
+// loadTags загружает имена тегов записей records одним запросом и заполняет Tags (по алфавиту).
+func loadTags(ctx context.Context, q querier, records []dto.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(records))
+	ids := make([]int64, 0, len(records))
+	for i, r := range records {
+		index[r.ID] = i
+		ids = append(ids, r.ID)
+	}
+
+	sqlCommand := `SELECT record_id, name FROM address_book_tag_members WHERE record_id = ANY($1) ORDER BY record_id, lower(name)`
+	rows, err := q.Query(ctx, sqlCommand, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var name string
+		if err = rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		r := &records[index[id]]
+		r.Tags = append(r.Tags, name)
+	}
+	return rows.Err()
+}
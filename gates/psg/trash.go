@@ -0,0 +1,156 @@
+package psg
+
+import (
+	"addressBookServer/models/dto"
+	"addressBookServer/pkg"
+	"context"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"time"
+)
+
+// Удаленные записи остаются в таблице address_book с временем удаления deleted_at и автором deleted_by
+// (корзина, см. миграцию 0012), а их номера в address_book_phones помечаются deleted и не занимают номер.
+// Обычные запросы выбирают только записи с deleted_at IS NULL; записи в корзине выбираются при dto.Query.Trash.
+
+// trashCond возвращает условие выборки записей в корзине (trash) или действующих записей.
+func trashCond(trash bool) string {
+	if trash {
+		return "deleted_at IS NOT NULL"
+	}
+	return "deleted_at IS NULL"
+}
+
+// RestoreRecord восстанавливает запись id из корзины в одной транзакции с записью истории изменений.
+// Номера записи снова проверяются на уникальность: если один из них уже занят другой записью,
+// возвращает ошибку dto.ErrPhoneInUse. Если записи нет в корзине, возвращает dto.ErrRecordNotFound.
+//
+// Пример использования:
+//
+//	err := psg.RestoreRecord(ctx, 1)
+//	if errors.Is(err, dto.ErrPhoneInUse) {
+//	    fmt.Println("номер записи уже используется")
+//	}
+func (p *Psg) RestoreRecord(ctx context.Context, id int64) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) RestoreRecord()")
+	if err != nil {
+		log.Println("(p *Psg) RestoreRecord(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Update)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		sqlCommand := `UPDATE address_book SET deleted_at=NULL, deleted_by='', updated_at=now(), version=version+1
+			WHERE id=$1 AND deleted_at IS NOT NULL`
+		tag, err := tx.Exec(ctx, sqlCommand, id)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return dto.ErrRecordNotFound
+		}
+		// Занятый номер нарушает уникальный индекс address_book_phones_number_key
+		_, err = tx.Exec(ctx, `UPDATE address_book_phones SET deleted=false WHERE record_id=$1`, id)
+		if err != nil {
+			return err
+		}
+		after, err := recordTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		return insertHistory(ctx, tx, dto.ActionRestore, id, nil, &after)
+	})
+	return checkUpdateErr(wErr, err)
+}
+
+// PurgeRecord окончательно удаляет запись id из корзины вместе с номерами, адресами, почтой и тегами
+// в одной транзакции с записью истории изменений. Если записи нет в корзине, возвращает dto.ErrRecordNotFound.
+//
+// Пример использования:
+//
+//	err := psg.PurgeRecord(ctx, 1)
+func (p *Psg) PurgeRecord(ctx context.Context, id int64) (err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) PurgeRecord()")
+	if err != nil {
+		log.Println("(p *Psg) PurgeRecord(): NewWrappedErrorWithFile()", err)
+	}
+
+	ctx, cancel := withTimeout(ctx, p.timeouts.Delete)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		n, err := purgeTx(ctx, tx, "id = $1", id)
+		if err == nil && n == 0 {
+			return dto.ErrRecordNotFound
+		}
+		return err
+	})
+	return checkUpdateErr(wErr, err)
+}
+
+// purgeTrashChunk - наибольшее количество записей, удаляемых PurgeTrash в одной транзакции.
+const purgeTrashChunk = 500
+
+// PurgeTrash окончательно удаляет записи, перемещенные в корзину раньше before, и возвращает их количество.
+// Вызывается периодически фоновой задачей сервера (см. флаг -trash-retention). Записи удаляются частями
+// по purgeTrashChunk в отдельных транзакциях (ограничение времени - на каждую часть), чтобы не держать
+// блокировки на всей корзине. Записи, заблокированные другими транзакциями (например, восстанавливаемые),
+// пропускаются до следующего запуска. При ошибке возвращается и количество уже удаленных записей.
+//
+// Пример использования:
+//
+//	n, err := psg.PurgeTrash(ctx, time.Now().Add(-30*24*time.Hour))
+func (p *Psg) PurgeTrash(ctx context.Context, before time.Time) (n int64, err error) {
+	wErr, err := pkg.NewWrappedErrorWithFile("(p *Psg) PurgeTrash()")
+	if err != nil {
+		log.Println("(p *Psg) PurgeTrash(): NewWrappedErrorWithFile()", err)
+	}
+
+	for {
+		chunk, err := p.purgeTrashChunk(ctx, before)
+		n += chunk
+		if err != nil {
+			wErr.Specify(err, "p.purgeTrashChunk(ctx, before)").LogError()
+			return n, wrapTimeout(err)
+		}
+		if chunk < purgeTrashChunk {
+			return n, nil
+		}
+	}
+}
+
+// purgeTrashChunk окончательно удаляет в одной транзакции до purgeTrashChunk записей (по возрастанию id),
+// перемещенных в корзину раньше before, пропуская заблокированные. Возвращает количество удаленных записей.
+func (p *Psg) purgeTrashChunk(ctx context.Context, before time.Time) (n int64, err error) {
+	ctx, cancel := withTimeout(ctx, p.timeouts.Delete)
+	defer cancel()
+
+	err = pgx.BeginFunc(ctx, p.conn, func(tx pgx.Tx) error {
+		where := `id IN (SELECT id FROM address_book WHERE deleted_at < $1 ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED)`
+		n, err = purgeTx(ctx, tx, where, before, purgeTrashChunk)
+		return err
+	})
+	return n, err
+}
+
+// purgeTx окончательно удаляет записи, удовлетворяющие условию where (только из корзины), в транзакции tx
+// и записывает в историю их состояние в корзине одним запросом. Возвращает количество удаленных записей.
+func purgeTx(ctx context.Context, tx pgx.Tx, where string, args ...any) (int64, error) {
+	records, err := selectRecordsTx(ctx, tx, where+" AND deleted_at IS NOT NULL", args...)
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+	if err = insertHistoryBefore(ctx, tx, dto.ActionPurge, records); err != nil {
+		return 0, err
+	}
+	ids := make([]int64, 0, len(records))
+	for _, rec := range records {
+		ids = append(ids, rec.ID)
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM address_book WHERE id = ANY($1)`, ids)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
@@ -5,6 +5,7 @@ go 1.21.0
 require (
 	github.com/jackc/pgx/v5 v5.5.1
 	github.com/pkg/errors v0.9.1
+	golang.org/x/text v0.9.0
 )
 
 require (
@@ -13,5 +14,4 @@ require (
 	github.com/jackc/puddle/v2 v2.2.1 // indirect
 	golang.org/x/crypto v0.9.0 // indirect
 	golang.org/x/sync v0.1.0 // indirect
-	golang.org/x/text v0.9.0 // indirect
 )
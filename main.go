@@ -2,20 +2,74 @@ package main
 
 import (
 	"addressBookServer/controllers/addressBookService"
+	"addressBookServer/gates/memory"
 	"addressBookServer/gates/psg"
+	"addressBookServer/models/dto"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
+)
+
+const (
+	dbURL  = "postgres://127.0.0.1:5432/postgres"
+	dbUser = "postgres"
+	dbPass = "qwerty"
 )
 
 func main() {
-	p, err := psg.NewPsg("postgres://127.0.0.1:5432/postgres", "postgres", "qwerty")
+	storage := flag.String("storage", "psg", "хранилище записей: psg (PostgreSQL) или memory (в памяти, без базы данных)")
+	var timeouts psg.Timeouts
+	flag.DurationVar(&timeouts.Statement, "statement-timeout", 10*time.Second, "statement_timeout PostgreSQL для всех запросов (0 - без ограничения)")
+	flag.DurationVar(&timeouts.Save, "save-timeout", 3*time.Second, "дедлайн сохранения записи (0 - без ограничения)")
+	flag.DurationVar(&timeouts.Get, "get-timeout", 5*time.Second, "дедлайн получения записей (0 - без ограничения)")
+	flag.DurationVar(&timeouts.Update, "update-timeout", 3*time.Second, "дедлайн обновления записи (0 - без ограничения)")
+	flag.DurationVar(&timeouts.Delete, "delete-timeout", 3*time.Second, "дедлайн удаления записи (0 - без ограничения)")
+	flag.DurationVar(&timeouts.Batch, "batch-timeout", 30*time.Second, "дедлайн пакета операций /batch (0 - без ограничения)")
+	flag.DurationVar(&timeouts.Import, "import-timeout", 5*time.Minute, "дедлайн импорта записей из файла (0 - без ограничения)")
+	flag.DurationVar(&timeouts.Export, "export-timeout", 30*time.Minute, "дедлайн выгрузки записей /export (0 - без ограничения)")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "срок хранения записей в корзине, после которого они удаляются окончательно (0 - не удалять)")
+	trashPurgeInterval := flag.Duration("trash-purge-interval", time.Hour, "период проверки корзины на записи старше trash-retention")
+	migrate := flag.String("migrate", "", "выполнить миграции схемы и завершиться: up (применить все) или down (откатить последнюю)")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Использование: %s [флаги] [import [флаги импорта] файл.csv|файл.vcf]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *migrate != "" {
+		runMigrations(*migrate)
+		return
+	}
+
+	db, closeDB, err := openStorage(*storage, timeouts)
 	if err != nil {
-		log.Println("psg.NewPsg(): ", err)
+		log.Println(err)
+		return
 	}
+	defer closeDB()
 
-	abs := addressBookService.NewAddressBookService(":8080", p)
+	if flag.Arg(0) == "import" {
+		if !runImport(db, flag.Args()[1:]) {
+			closeDB()
+			os.Exit(1)
+		}
+		return
+	}
+
+	abs := addressBookService.NewAddressBookService(":8080", db)
+	if *trashRetention > 0 {
+		go abs.PurgeTrash(*trashRetention, *trashPurgeInterval)
+	}
 
 	signalCh := make(chan os.Signal, 1)     // канал для получения сигнала
 	signal.Notify(signalCh, syscall.SIGINT) // привязываем его к сигналу SIGINT
@@ -26,3 +80,110 @@ func main() {
 
 	abs.Start()
 }
+
+// openStorage открывает хранилище записей storage (psg или memory) и возвращает функцию его закрытия.
+func openStorage(storage string, timeouts psg.Timeouts) (db addressBookService.Storage, closeDB func(), err error) {
+	switch storage {
+	case "psg":
+		p, err := psg.NewPsg(dbURL, dbUser, dbPass, timeouts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("psg.NewPsg(): %w", err)
+		}
+		return p, p.Close, nil
+	case "memory":
+		return memory.NewMemory(), func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown storage: %s", storage)
+}
+
+// runImport выполняет подкоманду import: загружает записи из файла CSV или vCard в хранилище db
+// (см. addressBookService.ImportCSV и addressBookService.ImportVCard) и выводит отчет в формате JSON.
+// Возвращает false, если импорт не выполнен или часть строк отклонена.
+//
+//	addressBookServer import -encoding=windows-1251 -delimiter=";" -map "Телефон:phone" -map "Фамилия:last_name" contacts.csv
+//	addressBookServer import contacts.vcf
+func runImport(db addressBookService.Storage, args []string) bool {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "формат файла: csv или vcard (по умолчанию vcard для файлов .vcf, иначе csv)")
+	delimiter := flags.String("delimiter", ",", "разделитель полей: один символ или tab")
+	encoding := flags.String("encoding", "utf-8", "кодировка файла: utf-8 или windows-1251")
+	var mapping mappingFlag
+	flags.Var(&mapping, "map", "сопоставление столбца полю записи вида Заголовок:поле (можно повторять, поле - исключает столбец)")
+	dryRun := flags.Bool("dry-run", false, "только проверить файл, не сохраняя записи")
+	actor := flags.String("actor", "import", "автор изменений в истории")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Println("import: file name is required")
+		return false
+	}
+
+	opts, err := addressBookService.NewImportOptions(*delimiter, *encoding, mapping, *dryRun)
+	if err != nil {
+		log.Println("import:", err)
+		return false
+	}
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Println("import:", err)
+		return false
+	}
+	defer file.Close()
+
+	ctx := dto.WithChangeMeta(context.Background(), dto.ChangeMeta{Actor: *actor, RequestID: "import " + filepath.Base(file.Name())})
+	var report dto.ImportReport
+	switch {
+	case *format == "vcard", *format == "" && strings.EqualFold(filepath.Ext(file.Name()), ".vcf"):
+		report, err = addressBookService.ImportVCard(ctx, db, file, *dryRun)
+	case *format == "csv", *format == "":
+		report, err = addressBookService.ImportCSV(ctx, db, file, opts)
+	default:
+		err = errors.New("format must be csv or vcard")
+	}
+	if err != nil {
+		log.Println("import:", err)
+		return false
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		log.Println("import:", err)
+		return false
+	}
+	return len(report.Rejected) == 0
+}
+
+// mappingFlag - повторяемый флаг сопоставления столбцов подкоманды import.
+type mappingFlag []string
+
+func (m *mappingFlag) String() string {
+	return strings.Join(*m, ", ")
+}
+
+func (m *mappingFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// runMigrations применяет (up) или откатывает на одну версию (down) миграции схемы PostgreSQL.
+func runMigrations(direction string) {
+	p, err := psg.NewPsgWithoutMigrations(dbURL, dbUser, dbPass, psg.Timeouts{})
+	if err != nil {
+		log.Println("psg.NewPsgWithoutMigrations(): ", err)
+		return
+	}
+	defer p.Close()
+
+	switch direction {
+	case "up":
+		err = p.MigrateUp(context.Background())
+	case "down":
+		err = p.MigrateDown(context.Background(), 1)
+	default:
+		log.Println("unknown migration direction:", direction)
+		return
+	}
+	if err != nil {
+		log.Println("migrate", direction+":", err)
+	}
+}
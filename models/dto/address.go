@@ -0,0 +1,104 @@
+package dto
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// PostalAddress - структурированный почтовый адрес записи. Адресов у записи может быть несколько,
+// первый из них основной. Теги sql.field задают имена столбцов таблицы address_book_addresses
+// и одновременно имена полей в условиях Filter (условию удовлетворяет любой из адресов записи).
+type PostalAddress struct {
+	Label       string `json:"label,omitempty" sql.field:"label"` // Метка адреса (например, "дом", "работа")
+	Country     string `json:"country,omitempty" sql.field:"country"`
+	Region      string `json:"region,omitempty" sql.field:"region"`
+	City        string `json:"city,omitempty" sql.field:"city"`
+	Street      string `json:"street,omitempty" sql.field:"street"`
+	House       string `json:"house,omitempty" sql.field:"house"`
+	Apartment   string `json:"apartment,omitempty" sql.field:"apartment"`
+	PostalIndex string `json:"postal_index,omitempty" sql.field:"postal_index"`
+}
+
+// AddressFilterFields - поля PostalAddress, доступные в условиях Filter, и их типы.
+var AddressFilterFields = sqlFieldKinds(PostalAddress{}, "sql.field")
+
+// IsEmpty сообщает, что в адресе не заполнено ни одной составной части (метка не учитывается).
+func (a PostalAddress) IsEmpty() bool {
+	a.Label = ""
+	return a == PostalAddress{}
+}
+
+// String возвращает адрес одной строкой в порядке, принятом в России:
+// "123456, Россия, Московская обл., г. Москва, ул. Ленина, д. 5, кв. 10".
+func (a PostalAddress) String() string {
+	var parts []string
+	add := func(prefix, value string) {
+		if value != "" {
+			parts = append(parts, prefix+value)
+		}
+	}
+
+	add("", a.PostalIndex)
+	add("", a.Country)
+	add("", a.Region)
+	add("г. ", a.City)
+	add("", a.Street)
+	add("д. ", a.House)
+	add("кв. ", a.Apartment)
+
+	return strings.Join(parts, ", ")
+}
+
+// PatchAddresses - список адресов в частичном обновлении. Как и PatchPhones, заменяет все адреса записи.
+type PatchAddresses struct {
+	Set   bool
+	Value []PostalAddress
+}
+
+// UnmarshalJSON вызывается только для присутствующего в запросе поля, в том числе равного null.
+func (p *PatchAddresses) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	return json.Unmarshal(data, &p.Value)
+}
+
+// SetPrimaryAddress заменяет составные части основного (первого) адреса записи на части address,
+// сохраняя его метку. Если адресов нет, address становится единственным адресом.
+func (r *Record) SetPrimaryAddress(address PostalAddress) {
+	if len(r.Addresses) == 0 {
+		r.Addresses = []PostalAddress{address}
+		return
+	}
+	address.Label = r.Addresses[0].Label
+	r.Addresses[0] = address
+}
+
+// Part возвращает составную часть адреса по имени field из тега sql.field ("" для неизвестного имени).
+func (a PostalAddress) Part(field string) string {
+	switch field {
+	case "label":
+		return a.Label
+	case "country":
+		return a.Country
+	case "region":
+		return a.Region
+	case "city":
+		return a.City
+	case "street":
+		return a.Street
+	case "house":
+		return a.House
+	case "apartment":
+		return a.Apartment
+	case "postal_index":
+		return a.PostalIndex
+	}
+	return ""
+}
+
+// PrimaryAddressPart возвращает составную часть field основного адреса записи или "", если адресов нет.
+func (r *Record) PrimaryAddressPart(field string) string {
+	if len(r.Addresses) == 0 {
+		return ""
+	}
+	return r.Addresses[0].Part(field)
+}
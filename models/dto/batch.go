@@ -0,0 +1,40 @@
+package dto
+
+import "errors"
+
+// ErrBatchRolledBack - результат операции атомарного пакета, отмененной из-за ошибки другой операции.
+var ErrBatchRolledBack = errors.New("rolled back")
+
+// BatchOp - операция пакетного изменения записей (см. BatchOps). Данные уже проверены и нормализованы.
+type BatchOp struct {
+	Action  string      // ActionCreate, ActionUpdate или ActionDelete
+	Record  Record      // Новая запись (ActionCreate)
+	ID      int64       // Идентификатор изменяемой или удаляемой записи; 0 - запись ищется по Phone
+	Phone   string      // Номер изменяемой или удаляемой записи (если ID не указан)
+	Patch   RecordPatch // Частичное обновление (ActionUpdate); по Phone основной номер не изменяется, как в UpdateRecord
+	Version int64       // Ожидаемая версия удаляемой записи (ActionDelete, 0 - не проверяется)
+}
+
+// BatchOps - пакет операций, которые хранилище выполняет по порядку в одной транзакции.
+// Атомарный пакет (Atomic) выполняется целиком или не выполняется совсем: после первой ошибки
+// транзакция откатывается. Иначе каждая операция выполняется отдельно (в PostgreSQL - в точке сохранения),
+// ошибка операции отменяет только ее, а остальные фиксируются.
+type BatchOps struct {
+	Ops    []BatchOp
+	Atomic bool
+}
+
+// BatchOutcome - результат операции пакета.
+type BatchOutcome struct {
+	ID  int64 // Идентификатор созданной, измененной или удаленной записи
+	Err error // Ошибка операции (такая же, как у одиночного метода хранилища) или ErrBatchRolledBack
+}
+
+// Rollback отмечает результаты атомарного пакета после ошибки операции failed: остальные операции отменены.
+func Rollback(outcomes []BatchOutcome, failed int) {
+	for i := range outcomes {
+		if i != failed {
+			outcomes[i] = BatchOutcome{Err: ErrBatchRolledBack}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package dto
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Типы значений дополнительных полей
+const (
+	CustomString = "string" // Строка
+	CustomInt    = "int"    // Целое число
+	CustomDate   = "date"   // Дата в формате DateLayout
+	CustomEnum   = "enum"   // Одно из значений CustomFieldDef.Values
+)
+
+// CustomFieldTypes - допустимые типы дополнительных полей.
+var CustomFieldTypes = map[string]bool{CustomString: true, CustomInt: true, CustomDate: true, CustomEnum: true}
+
+// CustomFieldPrefix - префикс имени дополнительного поля в условиях Filter: "custom.department".
+const CustomFieldPrefix = "custom."
+
+// customKeyRe - допустимые имена дополнительных полей (совпадает с ограничением в таблице custom_field_definitions).
+var customKeyRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// CustomFieldDef - объявление дополнительного поля записи (номер клиента, отдел, номер пропуска и т.п.).
+// Набор объявлений задается администратором развертывания; значения полей хранятся в Record.Custom
+// и проверяются по объявлениям при создании и изменении записи (см. ValidateCustom).
+type CustomFieldDef struct {
+	Key      string   `json:"key"`              // Имя поля: латинские строчные буквы, цифры и "_"
+	Type     string   `json:"type"`             // Тип значения: CustomString, CustomInt, CustomDate или CustomEnum
+	Required bool     `json:"required"`         // Поле обязательно для каждой записи
+	Values   []string `json:"values,omitempty"` // Допустимые значения (только для CustomEnum)
+	Label    string   `json:"label,omitempty"`  // Название поля для отображения
+}
+
+// Validate проверяет объявление поля. Ошибки возвращаются как *ValidationError.
+func (d *CustomFieldDef) Validate() error {
+	d.Label = strings.TrimSpace(d.Label)
+	switch {
+	case !customKeyRe.MatchString(d.Key):
+		return &ValidationError{Msg: "custom field key must match " + customKeyRe.String()}
+	case !CustomFieldTypes[d.Type]:
+		return &ValidationError{Msg: fmt.Sprintf("unknown custom field type %q", d.Type)}
+	case d.Type == CustomEnum && len(d.Values) == 0:
+		return &ValidationError{Msg: "enum custom field requires non-empty values"}
+	case d.Type != CustomEnum && len(d.Values) > 0:
+		return &ValidationError{Msg: "values are allowed only for enum custom fields"}
+	}
+	for i, v := range d.Values {
+		if v == "" || slices.Contains(d.Values[:i], v) {
+			return &ValidationError{Msg: "enum values must be unique and non-empty"}
+		}
+	}
+	return nil
+}
+
+// Kind возвращает тип значения поля в условиях Filter.
+func (d CustomFieldDef) Kind() reflect.Kind {
+	if d.Type == CustomInt {
+		return reflect.Int64
+	}
+	return reflect.String
+}
+
+// CustomFieldDefs - объявления дополнительных полей по именам.
+type CustomFieldDefs map[string]CustomFieldDef
+
+// NewCustomFieldDefs строит набор объявлений по списку.
+func NewCustomFieldDefs(defs []CustomFieldDef) CustomFieldDefs {
+	set := make(CustomFieldDefs, len(defs))
+	for _, d := range defs {
+		set[d.Key] = d
+	}
+	return set
+}
+
+// Lookup возвращает объявление дополнительного поля по имени поля в условии Filter ("custom.key").
+func (defs CustomFieldDefs) Lookup(field string) (CustomFieldDef, bool) {
+	key, ok := strings.CutPrefix(field, CustomFieldPrefix)
+	if !ok {
+		return CustomFieldDef{}, false
+	}
+	d, ok := defs[key]
+	return d, ok
+}
+
+// ValidateCustom проверяет значения дополнительных полей values по объявлениям defs и приводит их к типам полей
+// (целые числа - int64, даты и значения перечислений - строки). Неизвестные поля не допускаются.
+// При requireAll все обязательные поля должны быть заполнены (создание и полная замена записи);
+// иначе values - изменение по правилам JSON Merge Patch: значение nil удаляет поле, и удалить обязательное поле нельзя.
+func (defs CustomFieldDefs) ValidateCustom(values map[string]any, requireAll bool) error {
+	for key, value := range values {
+		d, ok := defs[key]
+		if !ok {
+			return &ValidationError{Msg: fmt.Sprintf("unknown custom field %q", key)}
+		}
+		if value == nil {
+			if requireAll || d.Required {
+				return &ValidationError{Msg: fmt.Sprintf("custom field %q cannot be empty", key)}
+			}
+			continue
+		}
+		normalized, err := d.coerce(value)
+		if err != nil {
+			return err
+		}
+		values[key] = normalized
+	}
+	if !requireAll {
+		return nil
+	}
+	for key, d := range defs {
+		if _, ok := values[key]; d.Required && !ok {
+			return &ValidationError{Msg: fmt.Sprintf("custom field %q is required", key)}
+		}
+	}
+	return nil
+}
+
+// ValidatePatch проверяет изменение дополнительных полей записи (см. ValidateCustom):
+// удалить все поля можно, только если среди объявленных нет обязательных.
+func (defs CustomFieldDefs) ValidatePatch(p *PatchCustom) error {
+	if p.Null {
+		for key, d := range defs {
+			if d.Required {
+				return &ValidationError{Msg: fmt.Sprintf("custom field %q cannot be empty", key)}
+			}
+		}
+	}
+	return defs.ValidateCustom(p.Value, false)
+}
+
+// coerce приводит значение поля из JSON к типу объявления.
+func (d CustomFieldDef) coerce(value any) (any, error) {
+	wrongType := &ValidationError{Msg: fmt.Sprintf("custom field %q must be of type %s", d.Key, d.Type)}
+
+	switch d.Type {
+	case CustomInt:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			if v != math.Trunc(v) || math.Abs(v) > math.MaxInt64 {
+				return nil, wrongType
+			}
+			return int64(v), nil
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, wrongType
+			}
+			return n, nil
+		}
+		return nil, wrongType
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return nil, wrongType
+	}
+	switch d.Type {
+	case CustomDate:
+		if _, err := time.Parse(DateLayout, s); err != nil {
+			return nil, wrongType
+		}
+	case CustomEnum:
+		if !slices.Contains(d.Values, s) {
+			return nil, &ValidationError{Msg: fmt.Sprintf("custom field %q must be one of %s", d.Key, strings.Join(d.Values, ", "))}
+		}
+	}
+	return s, nil
+}
+
+// PatchCustom - изменение дополнительных полей по правилам JSON Merge Patch: поля объекта Value
+// заменяют значения записи, поля со значением null удаляются, а null вместо объекта (Null) удаляет все поля.
+type PatchCustom struct {
+	Set   bool
+	Null  bool
+	Value map[string]any
+}
+
+// UnmarshalJSON вызывается только для присутствующего в запросе поля, в том числе равного null.
+func (p *PatchCustom) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	p.Null = string(data) == "null"
+	return json.Unmarshal(data, &p.Value)
+}
+
+// Apply применяет изменение к дополнительным полям custom и возвращает результат.
+func (p PatchCustom) Apply(custom map[string]any) map[string]any {
+	if !p.Set {
+		return custom
+	}
+	result := map[string]any{}
+	if !p.Null {
+		for k, v := range custom {
+			result[k] = v
+		}
+	}
+	for k, v := range p.Value {
+		if v == nil {
+			delete(result, k)
+		} else {
+			result[k] = v
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
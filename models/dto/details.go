@@ -0,0 +1,44 @@
+package dto
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// DateLayout - формат дат записи (дата рождения) в JSON и в условиях Filter.
+const DateLayout = time.DateOnly
+
+// EmailFilterField - имя поля адреса электронной почты в условиях Filter:
+// условию удовлетворяет любой из адресов записи (как для phone).
+const EmailFilterField = "email"
+
+// EmailFilterFields - поле адреса электронной почты в условиях Filter и его тип.
+var EmailFilterFields = map[string]reflect.Kind{EmailFilterField: reflect.String}
+
+// DateFilterFields - поля записи с датами (тег sql.field с параметром date). Значения в условиях Filter
+// указываются в формате DateLayout, операции prefix и contains и сравнение без учета регистра не поддерживаются.
+var DateFilterFields = sqlFieldsWithOption(Record{}, "sql.field", "date")
+
+// HasEmail сообщает, что email - один из адресов электронной почты записи.
+func (r *Record) HasEmail(email string) bool {
+	for _, e := range r.Emails {
+		if e == email {
+			return true
+		}
+	}
+	return false
+}
+
+// PatchEmails - список адресов электронной почты в частичном обновлении. Как и PatchPhones,
+// заменяет все адреса записи; null или пустой список удаляет их.
+type PatchEmails struct {
+	Set   bool
+	Value []string
+}
+
+// UnmarshalJSON вызывается только для присутствующего в запросе поля, в том числе равного null.
+func (p *PatchEmails) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	return json.Unmarshal(data, &p.Value)
+}
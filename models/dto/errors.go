@@ -0,0 +1,32 @@
+package dto
+
+import "errors"
+
+// Ошибки хранилища, общие для всех реализаций (PostgreSQL, память).
+// Сравнивать следует через errors.Is, сообщения совпадают с теми, что уходят клиенту.
+var (
+	ErrPhoneInUse    = errors.New("phone number already in use")
+	ErrPhoneNotFound = errors.New("phone number not found")
+	ErrTimeout       = errors.New("request timeout")
+
+	ErrRecordNotFound  = errors.New("record not found")
+	ErrNothingToUpdate = errors.New("nothing to update")
+	ErrVersionMismatch = errors.New("record version mismatch")
+
+	ErrCustomFieldNotFound = errors.New("custom field not found")
+	ErrCustomFieldExists   = errors.New("custom field already exists")
+
+	ErrTagNotFound = errors.New("tag not found")
+	ErrTagExists   = errors.New("tag already exists")
+
+	ErrCardDAVNameInUse = errors.New("resource name already in use")
+)
+
+// ValidationError - ошибка в данных запроса клиента (отсутствуют обязательные поля, неверный номер и т.п.).
+type ValidationError struct {
+	Msg string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Msg
+}
@@ -0,0 +1,339 @@
+package dto
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Операции сравнения в условии Filter
+const (
+	OpEq       = "eq"       // Равно
+	OpNe       = "ne"       // Не равно
+	OpIn       = "in"       // Равно одному из Values
+	OpPrefix   = "prefix"   // Начинается с (только строковые поля)
+	OpContains = "contains" // Содержит подстроку (только строковые поля)
+	OpGt       = "gt"       // Больше
+	OpGte      = "gte"      // Больше или равно
+	OpLt       = "lt"       // Меньше
+	OpLte      = "lte"      // Меньше или равно
+)
+
+// Filter - условие выборки записей. Узел дерева условий содержит ровно одно из:
+//   - And - все вложенные условия выполняются;
+//   - Or - выполняется хотя бы одно вложенное условие;
+//   - Not - вложенное условие не выполняется;
+//   - Field и Op - сравнение поля записи (имя из тега sql.field) со значением Value (или Values для "in").
+//
+// IgnoreCase включает сравнение строк без учета регистра.
+//
+// Пример: фамилия начинается с "ив" без учета регистра и идентификатор от 10 до 20
+//
+//	{"and": [
+//	    {"field": "last_name", "op": "prefix", "value": "ив", "ignore_case": true},
+//	    {"field": "id", "op": "gte", "value": 10},
+//	    {"field": "id", "op": "lte", "value": 20}
+//	]}
+type Filter struct {
+	And        []Filter `json:"and,omitempty"`
+	Or         []Filter `json:"or,omitempty"`
+	Not        *Filter  `json:"not,omitempty"`
+	Field      string   `json:"field,omitempty"`
+	Op         string   `json:"op,omitempty"`
+	Value      any      `json:"value,omitempty"`
+	Values     []any    `json:"values,omitempty"`
+	IgnoreCase bool     `json:"ignore_case,omitempty"`
+}
+
+// FilterFields - поля записи, доступные в условиях Filter, и их типы.
+// Строится по тегам sql.field структур Record и PostalAddress (и полям email и tag), поэтому является единственным
+// допустимым источником имен столбцов при построении SQL.
+var FilterFields = mergeFieldKinds(sqlFieldKinds(Record{}, "sql.field"), AddressFilterFields, EmailFilterFields,
+	map[string]reflect.Kind{TagFilterField: reflect.String})
+
+// RecordFilter строит условие "все непустые поля rec равны соответствующим полям записи".
+// Возвращает nil, если в rec нет непустых полей.
+func RecordFilter(rec Record) *Filter {
+	var conds []Filter
+	add := func(field string, value any) {
+		conds = append(conds, Filter{Field: field, Op: OpEq, Value: value})
+	}
+
+	if rec.ID != 0 {
+		add("id", rec.ID)
+	}
+	if rec.Name != "" {
+		add("name", rec.Name)
+	}
+	if rec.LastName != "" {
+		add("last_name", rec.LastName)
+	}
+	if rec.MiddleName != "" {
+		add("middle_name", rec.MiddleName)
+	}
+	if rec.Address != "" {
+		add("address", rec.Address)
+	}
+	if rec.Phone != "" {
+		add("phone", rec.Phone)
+	}
+	for _, email := range rec.Emails {
+		add(EmailFilterField, email)
+	}
+	if rec.Birthday != "" {
+		add("birthday", rec.Birthday)
+	}
+	if rec.Organization != "" {
+		add("organization", rec.Organization)
+	}
+	if rec.JobTitle != "" {
+		add("job_title", rec.JobTitle)
+	}
+	if rec.Notes != "" {
+		add("notes", rec.Notes)
+	}
+	for key, value := range rec.Custom {
+		add(CustomFieldPrefix+key, value)
+	}
+	for _, tag := range rec.Tags {
+		add(TagFilterField, tag)
+	}
+
+	if len(conds) == 0 {
+		return nil
+	}
+	return &Filter{And: conds}
+}
+
+// AndFilters объединяет условия через AND, пропуская nil. Возвращает nil, если условий нет.
+func AndFilters(filters ...*Filter) *Filter {
+	var conds []Filter
+	for _, f := range filters {
+		if f != nil {
+			conds = append(conds, *f)
+		}
+	}
+
+	switch len(conds) {
+	case 0:
+		return nil
+	case 1:
+		return &conds[0]
+	default:
+		return &Filter{And: conds}
+	}
+}
+
+// Validate проверяет структуру условия, имена полей и операции и приводит значения
+// к типам полей (например, числа JSON к int64 для id). Ошибки возвращаются как *ValidationError.
+// Условия на дополнительные поля ("custom.key") проверяются методом ValidateCustom.
+func (f *Filter) Validate() error {
+	return f.ValidateCustom(nil)
+}
+
+// ValidateCustom проверяет условие так же, как Validate, дополнительно разрешая поля "custom.key",
+// объявленные в custom. Дополнительное поле типа CustomDate сравнивается как дата, CustomInt - как число.
+func (f *Filter) ValidateCustom(custom CustomFieldDefs) error {
+	kinds := 0
+	if len(f.And) > 0 {
+		kinds++
+	}
+	if len(f.Or) > 0 {
+		kinds++
+	}
+	if f.Not != nil {
+		kinds++
+	}
+	if f.Field != "" || f.Op != "" {
+		kinds++
+	}
+	if kinds != 1 {
+		return &ValidationError{Msg: "filter must contain exactly one of: and, or, not, field/op"}
+	}
+
+	for i := range f.And {
+		if err := f.And[i].ValidateCustom(custom); err != nil {
+			return err
+		}
+	}
+	for i := range f.Or {
+		if err := f.Or[i].ValidateCustom(custom); err != nil {
+			return err
+		}
+	}
+	if f.Not != nil {
+		return f.Not.ValidateCustom(custom)
+	}
+	if f.Field == "" && f.Op == "" {
+		return nil
+	}
+
+	kind, ok := FilterFields[f.Field]
+	isDate := DateFilterFields[f.Field]
+	if def, isCustom := custom.Lookup(f.Field); isCustom {
+		kind, ok, isDate = def.Kind(), true, def.Type == CustomDate
+	}
+	if !ok {
+		return &ValidationError{Msg: fmt.Sprintf("filter: unknown field %q", f.Field)}
+	}
+
+	// Даты и время сравниваются как даты, а не как строки
+	if isDate || TimestampFilterFields[f.Field] {
+		f.IgnoreCase = false
+	}
+
+	var err error
+	switch f.Op {
+	case OpEq, OpNe, OpGt, OpGte, OpLt, OpLte:
+		f.Value, err = coerceFilterValue(f.Field, kind, f.Value)
+	case OpPrefix, OpContains:
+		if kind != reflect.String || isDate {
+			return &ValidationError{Msg: fmt.Sprintf("filter: operation %q is not supported for field %q", f.Op, f.Field)}
+		}
+		f.Value, err = coerceFilterValue(f.Field, kind, f.Value)
+	case OpIn:
+		if len(f.Values) == 0 {
+			return &ValidationError{Msg: fmt.Sprintf("filter: operation %q requires non-empty values", f.Op)}
+		}
+		for i := range f.Values {
+			f.Values[i], err = coerceFilterValue(f.Field, kind, f.Values[i])
+			if err != nil {
+				break
+			}
+		}
+	default:
+		return &ValidationError{Msg: fmt.Sprintf("filter: unknown operation %q", f.Op)}
+	}
+	if err != nil || !isDate {
+		return err
+	}
+
+	for _, v := range append([]any{f.Value}, f.Values...) {
+		if s, ok := v.(string); ok {
+			if _, err = time.Parse(DateLayout, s); err != nil {
+				return &ValidationError{Msg: fmt.Sprintf("filter: field %q requires a date in format %s", f.Field, DateLayout)}
+			}
+		}
+	}
+	return nil
+}
+
+// HasCustomFields сообщает, что в условии есть сравнение дополнительного поля ("custom.key").
+func (f *Filter) HasCustomFields() (found bool) {
+	f.Walk(func(leaf *Filter) {
+		found = found || strings.HasPrefix(leaf.Field, CustomFieldPrefix)
+	})
+	return found
+}
+
+// Walk вызывает fn для каждого условия сравнения поля (листа дерева).
+func (f *Filter) Walk(fn func(leaf *Filter)) {
+	for i := range f.And {
+		f.And[i].Walk(fn)
+	}
+	for i := range f.Or {
+		f.Or[i].Walk(fn)
+	}
+	if f.Not != nil {
+		f.Not.Walk(fn)
+	}
+	if f.Field != "" {
+		fn(f)
+	}
+}
+
+// coerceFilterValue приводит значение из JSON к типу поля kind (для полей со временем - к time.Time).
+func coerceFilterValue(field string, kind reflect.Kind, value any) (any, error) {
+	wrongType := &ValidationError{Msg: fmt.Sprintf("filter: wrong value type for field %q", field)}
+
+	switch kind {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return nil, wrongType
+		}
+		return s, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case int:
+			return int64(v), nil
+		case float64:
+			if v != math.Trunc(v) || math.Abs(v) > math.MaxInt64 {
+				return nil, wrongType
+			}
+			return int64(v), nil
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, wrongType
+			}
+			return n, nil
+		}
+	case reflect.Struct: // time.Time (TimestampFilterFields)
+		switch v := value.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			t, err := ParseTimestamp(v)
+			if err != nil {
+				return nil, &ValidationError{Msg: fmt.Sprintf("filter: field %q requires a time in RFC 3339 format", field)}
+			}
+			return t, nil
+		}
+	}
+
+	return nil, wrongType
+}
+
+// sqlFieldKinds возвращает имена полей структуры s из тега tag и их типы.
+// Поля без тега или с тегом "-" пропускаются.
+func sqlFieldKinds(s any, tag string) map[string]reflect.Kind {
+	fields := map[string]reflect.Kind{}
+
+	rt := reflect.TypeOf(s)
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tg := strings.TrimSpace(field.Tag.Get(tag))
+		if tg == "" || tg == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tg, ",")
+		fields[name] = field.Type.Kind()
+	}
+
+	return fields
+}
+
+// sqlFieldsWithOption возвращает имена полей структуры s из тега tag, в которых после имени
+// указан параметр option (например, `sql.field:"birthday,date"`).
+func sqlFieldsWithOption(s any, tag, option string) map[string]bool {
+	fields := map[string]bool{}
+
+	rt := reflect.TypeOf(s)
+	for i := 0; i < rt.NumField(); i++ {
+		name, options, _ := strings.Cut(strings.TrimSpace(rt.Field(i).Tag.Get(tag)), ",")
+		for _, opt := range strings.Split(options, ",") {
+			if opt == option && name != "" && name != "-" {
+				fields[name] = true
+			}
+		}
+	}
+
+	return fields
+}
+
+// mergeFieldKinds объединяет наборы полей в новый набор.
+func mergeFieldKinds(sets ...map[string]reflect.Kind) map[string]reflect.Kind {
+	fields := map[string]reflect.Kind{}
+	for _, set := range sets {
+		for name, kind := range set {
+			fields[name] = kind
+		}
+	}
+	return fields
+}
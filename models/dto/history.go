@@ -0,0 +1,106 @@
+package dto
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Действия с записью в истории изменений
+const (
+	ActionCreate  = "create"  // Запись создана (Before пуст)
+	ActionUpdate  = "update"  // Запись изменена
+	ActionDelete  = "delete"  // Запись удалена в корзину (After пуст)
+	ActionRestore = "restore" // Запись восстановлена из корзины (Before пуст)
+	ActionPurge   = "purge"   // Запись удалена из корзины окончательно (Before - запись в корзине, After пуст)
+)
+
+// HistoryActions - допустимые действия в истории изменений.
+var HistoryActions = map[string]bool{ActionCreate: true, ActionUpdate: true, ActionDelete: true, ActionRestore: true, ActionPurge: true}
+
+// HistoryEntry - изменение записи: состояние до и после, время, автор и идентификатор запроса.
+// Записи истории создаются хранилищем в той же транзакции, что и само изменение.
+type HistoryEntry struct {
+	ID        int64     `json:"id"`
+	RecordID  int64     `json:"record_id"`
+	Action    string    `json:"action"`     // ActionCreate, ActionUpdate, ActionDelete, ActionRestore или ActionPurge
+	Before    *Record   `json:"before"`     // Запись до изменения (nil при создании)
+	After     *Record   `json:"after"`      // Запись после изменения (nil при удалении)
+	ChangedAt time.Time `json:"changed_at"` // Время изменения
+	Actor     string    `json:"actor"`      // Автор изменения со слов клиента (см. ChangeMeta)
+	RequestID string    `json:"request_id"` // Идентификатор запроса, в котором сделано изменение
+}
+
+// HistoryQuery - параметры выборки истории изменений. Записи истории возвращаются от новых к старым.
+type HistoryQuery struct {
+	RecordID int64     // Только изменения записи RecordID, 0 - всех записей
+	Actor    string    // Только изменения автора Actor, "" - всех авторов
+	Action   string    // Только действия Action, "" - все действия
+	Since    time.Time // Изменения не раньше Since (нулевое значение - без ограничения)
+	Until    time.Time // Изменения раньше Until (нулевое значение - без ограничения)
+	AfterID  int64     // Только записи истории с идентификатором больше AfterID (0 - без ограничения)
+	SyncFrom int64     // Только изменения с отметкой синхронизации не меньше SyncFrom (отметку выдает SyncToken хранилища), 0 - все
+	Limit    int       // Максимальное количество записей истории, 0 - без ограничения
+	Cursor   string    // Токен продолжения из HistoryPage.NextCursor, "" - с начала
+}
+
+// HistoryPage - результат выборки истории изменений.
+type HistoryPage struct {
+	Entries    []HistoryEntry
+	NextCursor string // Токен следующей страницы, "" - страниц больше нет
+}
+
+// Validate проверяет параметры выборки истории. Ошибки возвращаются как *ValidationError.
+func (q *HistoryQuery) Validate() error {
+	switch {
+	case q.Limit < 0 || q.Limit > MaxLimit:
+		return &ValidationError{Msg: fmt.Sprintf("limit must be between 0 and %d", MaxLimit)}
+	case q.Action != "" && !HistoryActions[q.Action]:
+		return &ValidationError{Msg: fmt.Sprintf("unknown history action %q", q.Action)}
+	case q.AfterID < 0:
+		return &ValidationError{Msg: "after id must not be negative"}
+	case q.SyncFrom < 0:
+		return &ValidationError{Msg: "sync token must not be negative"}
+	}
+	_, err := q.BeforeID()
+	return err
+}
+
+// BeforeID возвращает идентификатор записи истории из токена продолжения: следующая страница содержит
+// записи истории с меньшими идентификаторами. Без токена возвращает 0.
+func (q *HistoryQuery) BeforeID() (int64, error) {
+	if q.Cursor == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(q.Cursor, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, &ValidationError{Msg: "invalid cursor"}
+	}
+	return id, nil
+}
+
+// NextCursor возвращает токен страницы, следующей за последней записью истории last.
+func (q *HistoryQuery) NextCursor(last HistoryEntry) string {
+	return strconv.FormatInt(last.ID, 10)
+}
+
+// ChangeMeta - автор и идентификатор запроса, которые хранилище записывает в историю изменений.
+// Автор берется из запроса без проверки (сервер не аутентифицирует клиентов).
+type ChangeMeta struct {
+	Actor     string
+	RequestID string
+}
+
+type changeMetaKey struct{}
+
+// WithChangeMeta возвращает контекст, в котором изменения записываются в историю с автором и запросом meta.
+func WithChangeMeta(ctx context.Context, meta ChangeMeta) context.Context {
+	return context.WithValue(ctx, changeMetaKey{}, meta)
+}
+
+// ChangeMetaFrom возвращает автора и идентификатор запроса из контекста (пустые, если не заданы).
+func ChangeMetaFrom(ctx context.Context) ChangeMeta {
+	meta, _ := ctx.Value(changeMetaKey{}).(ChangeMeta)
+	return meta
+}
@@ -0,0 +1,18 @@
+package dto
+
+// ImportReport - результат импорта записей из файла: сколько строк прочитано, сколько записей загружено
+// (в пробном режиме - прошло бы загрузку) и какие строки отклонены с причинами.
+type ImportReport struct {
+	DryRun         bool           `json:"dry_run"`                   // Пробный импорт: записи проверены, но не сохранены
+	Total          int            `json:"total"`                     // Количество строк с данными (без заголовка) или карточек vCard
+	Imported       int            `json:"imported"`                  // Количество загруженных записей
+	Rejected       []ImportReject `json:"rejected"`                  // Отклоненные строки в порядке файла
+	IgnoredColumns []string       `json:"ignored_columns,omitempty"` // Столбцы файла, не сопоставленные ни одному полю
+}
+
+// ImportReject - строка файла (карточка vCard), не прошедшая проверку или не сохраненная хранилищем.
+type ImportReject struct {
+	Line   int    `json:"line"`           // Номер строки в файле (с 1, заголовок CSV - строка 1; для vCard - строка BEGIN:VCARD)
+	Name   string `json:"name,omitempty"` // Имя контакта (для карточек vCard)
+	Reason string `json:"reason"`         // Причина отклонения
+}
@@ -0,0 +1,115 @@
+package dto
+
+import "encoding/json"
+
+// PatchString - строковое поле частичного обновления по правилам JSON Merge Patch (RFC 7396):
+//   - поле отсутствует в запросе (Set == false) - значение не изменяется;
+//   - поле равно null (Set && Null) - значение очищается;
+//   - иначе полю присваивается Value.
+type PatchString struct {
+	Set   bool
+	Null  bool
+	Value string
+}
+
+// UnmarshalJSON вызывается только для присутствующих в запросе полей, в том числе равных null.
+func (p *PatchString) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	if string(data) == "null" {
+		p.Null = true
+		p.Value = ""
+		return nil
+	}
+	p.Null = false
+	return json.Unmarshal(data, &p.Value)
+}
+
+// Cleared сообщает, что поле нужно очистить (null).
+func (p PatchString) Cleared() bool {
+	return p.Set && p.Null
+}
+
+// Empty сообщает, что поле получает пустое значение: очищается или ему присваивается пустая строка.
+func (p PatchString) Empty() bool {
+	return p.Set && p.Value == ""
+}
+
+// RecordPatch - частичное обновление записи (тело application/merge-patch+json).
+type RecordPatch struct {
+	Name       PatchString    `json:"name"`
+	LastName   PatchString    `json:"last_name"`
+	MiddleName PatchString    `json:"middle_name"`
+	Address    PatchString    `json:"address"`
+	Phone      PatchString    `json:"phone"`     // Новый основной номер
+	Phones     PatchPhones    `json:"phones"`    // Новый список номеров (заменяет все номера)
+	Addresses  PatchAddresses `json:"addresses"` // Новый список адресов (заменяет все адреса)
+
+	Emails       PatchEmails `json:"emails"`   // Новый список адресов электронной почты (заменяет все адреса)
+	Birthday     PatchString `json:"birthday"` // Дата рождения в формате DateLayout
+	Organization PatchString `json:"organization"`
+	JobTitle     PatchString `json:"job_title"`
+	Notes        PatchString `json:"notes"`
+	Custom       PatchCustom `json:"custom"` // Изменение дополнительных полей (объединяется с текущими значениями)
+
+	// Version - ожидаемая версия записи (см. Record.Version); 0 - версия не проверяется.
+	Version int64 `json:"version"`
+
+	// PrimaryAddress - разобранный Address, которым заменяется основной адрес
+	// (заполняется при проверке обновления, если Addresses не указан).
+	PrimaryAddress *PostalAddress `json:"-"`
+}
+
+// IsEmpty сообщает, что обновление не затрагивает ни одного поля.
+func (p RecordPatch) IsEmpty() bool {
+	return !p.Name.Set && !p.LastName.Set && !p.MiddleName.Set && !p.Address.Set && !p.Phone.Set && !p.Phones.Set &&
+		!p.Addresses.Set && !p.Emails.Set && !p.Birthday.Set && !p.Organization.Set && !p.JobTitle.Set && !p.Notes.Set &&
+		!p.Custom.Set
+}
+
+// SkipEmptyStrings убирает из обновления строковые поля, равные пустой строке. В API v1 пустая строка
+// означает «без изменений» (как до перехода на JSON Merge Patch), а очищает поле только null.
+func (p *RecordPatch) SkipEmptyStrings() {
+	for _, field := range []*PatchString{&p.Name, &p.LastName, &p.MiddleName, &p.Address, &p.Phone,
+		&p.Birthday, &p.Organization, &p.JobTitle, &p.Notes} {
+		if field.Set && !field.Null && field.Value == "" {
+			*field = PatchString{}
+		}
+	}
+}
+
+// Apply применяет обновление к записи rec. Очищенные поля становятся пустыми строками.
+// Phones заменяет все номера записи, Phone - только основной номер;
+// Addresses заменяет все адреса, PrimaryAddress - только основной адрес, Emails - все адреса электронной почты.
+func (p RecordPatch) Apply(rec *Record) {
+	apply := func(field PatchString, value *string) {
+		if field.Set {
+			*value = field.Value
+		}
+	}
+
+	apply(p.Name, &rec.Name)
+	apply(p.LastName, &rec.LastName)
+	apply(p.MiddleName, &rec.MiddleName)
+	apply(p.Address, &rec.Address)
+	apply(p.Birthday, &rec.Birthday)
+	apply(p.Organization, &rec.Organization)
+	apply(p.JobTitle, &rec.JobTitle)
+	apply(p.Notes, &rec.Notes)
+	if p.Emails.Set {
+		rec.Emails = append([]string(nil), p.Emails.Value...)
+	}
+	rec.Custom = p.Custom.Apply(rec.Custom)
+	if p.Phones.Set {
+		rec.Phones = append([]Phone(nil), p.Phones.Value...)
+		rec.Phone = PrimaryPhone(rec.Phones)
+	}
+	if p.Phone.Set {
+		rec.SetPrimaryPhone(p.Phone.Value)
+	}
+	if p.Addresses.Set {
+		rec.Addresses = append([]PostalAddress(nil), p.Addresses.Value...)
+	}
+	if p.PrimaryAddress != nil {
+		rec.SetPrimaryAddress(*p.PrimaryAddress)
+	}
+}
@@ -0,0 +1,78 @@
+package dto
+
+import "encoding/json"
+
+// Типы номеров телефона
+const (
+	PhoneMobile = "mobile" // Мобильный
+	PhoneWork   = "work"   // Рабочий
+	PhoneHome   = "home"   // Домашний
+	PhoneFax    = "fax"    // Факс
+	PhoneOther  = "other"  // Другой (по умолчанию)
+)
+
+// PhoneTypes - допустимые типы номеров телефона.
+var PhoneTypes = map[string]bool{PhoneMobile: true, PhoneWork: true, PhoneHome: true, PhoneFax: true, PhoneOther: true}
+
+// Phone - номер телефона записи. Номера уникальны среди всех записей,
+// у каждой записи ровно один основной номер (Primary).
+type Phone struct {
+	Number  string `json:"number"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// PrimaryPhone возвращает основной номер из phones ("" если основного номера нет).
+func PrimaryPhone(phones []Phone) string {
+	for _, p := range phones {
+		if p.Primary {
+			return p.Number
+		}
+	}
+	return ""
+}
+
+// SetPrimaryPhone заменяет основной номер записи на number (добавляет его, если основного номера нет)
+// и обновляет поле Phone. Если number был дополнительным номером записи, он удаляется из дополнительных.
+func (r *Record) SetPrimaryPhone(number string) {
+	r.Phone = number
+	phones := r.Phones[:0]
+	found := false
+	for _, p := range r.Phones {
+		switch {
+		case p.Primary:
+			p.Number = number
+			found = true
+		case p.Number == number:
+			continue
+		}
+		phones = append(phones, p)
+	}
+	r.Phones = phones
+	if !found {
+		r.Phones = append(r.Phones, Phone{Number: number, Type: PhoneOther, Primary: true})
+	}
+}
+
+// HasPhone сообщает, что number - один из номеров записи.
+func (r *Record) HasPhone(number string) bool {
+	for _, p := range r.Phones {
+		if p.Number == number {
+			return true
+		}
+	}
+	return false
+}
+
+// PatchPhones - список номеров в частичном обновлении. По правилам JSON Merge Patch
+// массив заменяется целиком: если поле присутствует (Set), все номера записи заменяются на Value.
+type PatchPhones struct {
+	Set   bool
+	Value []Phone
+}
+
+// UnmarshalJSON вызывается только для присутствующего в запросе поля, в том числе равного null.
+func (p *PatchPhones) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	return json.Unmarshal(data, &p.Value)
+}
@@ -0,0 +1,174 @@
+package dto
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	SortDefault   = "id"        // Сортировка по умолчанию
+	SortRelevance = "relevance" // Сортировка по релевантности поиска Query.Search (по умолчанию при поиске)
+	MaxLimit      = 1000        // Максимальное количество записей на странице
+)
+
+// SortFields - поля, по которым можно сортировать записи.
+// city и postal_index - части основного адреса записи (записи без адресов идут как с пустыми значениями).
+var SortFields = map[string]bool{"id": true, "name": true, "last_name": true, "city": true, "postal_index": true}
+
+// Query - параметры выборки записей из хранилища.
+type Query struct {
+	Filter    *Filter // Условие выборки, nil - все записи
+	Search    string  // Строка поиска по ФИО и адресу (полнотекстовый и нечеткий поиск), "" - без поиска
+	Sort      string  // Поле сортировки (id, name, last_name, city, postal_index), с префиксом "-" - по убыванию, или SortRelevance; "" - SortDefault
+	Limit     int     // Максимальное количество записей, 0 - без ограничения
+	Cursor    string  // Токен продолжения из RecordsPage.NextCursor, "" - с начала
+	WithTotal bool    // Посчитать общее количество записей, удовлетворяющих Filter
+
+	// Trash - выбрать записи из корзины (удаленные) вместо действующих.
+	Trash bool
+
+	// AsOf - момент времени, на который выбираются записи (по истории изменений, включая удаленные позже записи);
+	// нулевое значение - текущее состояние.
+	AsOf time.Time
+
+	// CustomFields - объявления дополнительных полей, доступных в Filter. Заполняется хранилищем.
+	CustomFields CustomFieldDefs
+}
+
+// RecordsPage - результат выборки записей.
+type RecordsPage struct {
+	Records    []Record
+	NextCursor string // Токен следующей страницы, "" - страниц больше нет
+	Total      *int64 // Общее количество записей (если запрошено Query.WithTotal)
+}
+
+// Cursor - содержимое токена продолжения: ключ последней записи страницы (keyset pagination).
+// Релевантность не хранится в записи, поэтому при сортировке SortRelevance токен содержит
+// смещение следующей страницы. Токен действует только для тех же сортировки, поиска и условий выборки,
+// для которых получен. Для клиента токен непрозрачен.
+type Cursor struct {
+	Sort   string `json:"s"`           // Сортировка, для которой получен токен
+	Search string `json:"q,omitempty"` // Строка поиска, для которой получен токен
+	Filter string `json:"f"`           // Отпечаток условий выборки, для которых получен токен (см. Query.conditionsKey)
+	Value  string `json:"v,omitempty"` // Значение поля сортировки последней записи (кроме сортировки по id)
+	ID     int64  `json:"id"`          // Идентификатор последней записи
+	Offset int    `json:"o,omitempty"` // Смещение следующей страницы (только для SortRelevance)
+}
+
+// Validate проверяет параметры выборки, подставляет сортировку по умолчанию (при поиске - SortRelevance)
+// и проверяет условие Filter. Ошибки возвращаются как *ValidationError.
+func (q *Query) Validate() error {
+	q.Search = strings.TrimSpace(q.Search)
+	if q.Search != "" && len(SearchWords(q.Search)) == 0 {
+		return &ValidationError{Msg: "search query must contain letters or digits"}
+	}
+	if q.Sort == "" {
+		q.Sort = SortDefault
+		if q.Search != "" {
+			q.Sort = SortRelevance
+		}
+	}
+	switch field, desc := q.SortField(); {
+	case field == SortRelevance && (desc || q.Search == ""):
+		return &ValidationError{Msg: "sort by relevance requires a search query and ascending order"}
+	case field != SortRelevance && !SortFields[field]:
+		return &ValidationError{Msg: fmt.Sprintf("unknown sort field %q", field)}
+	}
+	if q.Trash && !q.AsOf.IsZero() {
+		return &ValidationError{Msg: "as_of cannot be used with trash"}
+	}
+	if q.Limit < 0 || q.Limit > MaxLimit {
+		return &ValidationError{Msg: fmt.Sprintf("limit must be between 0 and %d", MaxLimit)}
+	}
+	if q.Filter != nil {
+		if err := q.Filter.ValidateCustom(q.CustomFields); err != nil {
+			return err
+		}
+	}
+	// Токен проверяется после условия: отпечаток считается по проверенным (нормализованным) значениям
+	if q.Cursor != "" {
+		if _, err := q.DecodeCursor(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SortField возвращает поле сортировки и признак сортировки по убыванию.
+func (q *Query) SortField() (field string, desc bool) {
+	sort := q.Sort
+	if sort == "" {
+		sort = SortDefault
+	}
+	if strings.HasPrefix(sort, "-") {
+		return sort[1:], true
+	}
+	return sort, false
+}
+
+// DecodeCursor разбирает токен продолжения. Токен должен быть получен для той же сортировки, строки поиска
+// и условий выборки.
+func (q *Query) DecodeCursor() (cursor Cursor, err error) {
+	invalid := &ValidationError{Msg: "invalid cursor"}
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return cursor, invalid
+	}
+	if err = json.Unmarshal(data, &cursor); err != nil {
+		return cursor, invalid
+	}
+	if cursor.Sort != q.Sort {
+		return cursor, &ValidationError{Msg: "cursor was issued for another sort order"}
+	}
+	if cursor.Search != q.Search {
+		return cursor, &ValidationError{Msg: "cursor was issued for another search query"}
+	}
+	if cursor.Filter != q.conditionsKey() {
+		return cursor, &ValidationError{Msg: "cursor was issued for another filter"}
+	}
+	return cursor, nil
+}
+
+// conditionsKey возвращает отпечаток условий выборки (Filter, Trash, AsOf) для токена продолжения:
+// ключ последней записи, полученный для других условий, пропустил бы или повторил записи.
+func (q *Query) conditionsKey() string {
+	conditions := struct {
+		Filter *Filter `json:"f,omitempty"`
+		Trash  bool    `json:"t,omitempty"`
+		AsOf   string  `json:"a,omitempty"`
+	}{Filter: q.Filter, Trash: q.Trash}
+	if !q.AsOf.IsZero() {
+		conditions.AsOf = q.AsOf.UTC().Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(conditions)
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// NextCursor возвращает токен продолжения после записи last, последней из count записей текущей страницы.
+func (q *Query) NextCursor(last Record, count int) string {
+	cursor := Cursor{Sort: q.Sort, Search: q.Search, Filter: q.conditionsKey(), ID: last.ID}
+	field, _ := q.SortField()
+	switch field {
+	case "name":
+		cursor.Value = last.Name
+	case "last_name":
+		cursor.Value = last.LastName
+	case "city", "postal_index":
+		cursor.Value = last.PrimaryAddressPart(field)
+	case SortRelevance:
+		cursor.Offset = count
+		if q.Cursor != "" {
+			current, _ := q.DecodeCursor()
+			cursor.Offset += current.Offset
+		}
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
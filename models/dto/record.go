@@ -1,10 +1,26 @@
 package dto
 
+import "time"
+
 type Record struct {
-	ID         int64  `json:"-" sql.field:"id"`
-	Name       string `json:"name,omitempty" sql.field:"name"`
-	LastName   string `json:"last_name,omitempty" sql.field:"last_name"`
-	MiddleName string `json:"middle_name,omitempty" sql.field:"middle_name"`
-	Address    string `json:"address,omitempty" sql.field:"address"`
-	Phone      string `json:"phone,omitempty" sql.field:"phone"`
+	ID           int64           `json:"id,omitempty" sql.field:"id"`
+	Name         string          `json:"name,omitempty" sql.field:"name"`
+	LastName     string          `json:"last_name,omitempty" sql.field:"last_name"`
+	MiddleName   string          `json:"middle_name,omitempty" sql.field:"middle_name"`
+	Address      string          `json:"address,omitempty" sql.field:"address"`
+	Phone        string          `json:"phone,omitempty" sql.field:"phone"`            // Основной номер; в условиях выборки - любой из номеров записи
+	Phones       []Phone         `json:"phones,omitempty"`                             // Все номера записи, включая основной
+	Addresses    []PostalAddress `json:"addresses,omitempty"`                          // Структурированные адреса, первый - основной
+	Emails       []string        `json:"emails,omitempty"`                             // Адреса электронной почты (в нижнем регистре)
+	Birthday     string          `json:"birthday,omitempty" sql.field:"birthday,date"` // Дата рождения в формате DateLayout
+	Organization string          `json:"organization,omitempty" sql.field:"organization"`
+	JobTitle     string          `json:"job_title,omitempty" sql.field:"job_title"`
+	Notes        string          `json:"notes,omitempty" sql.field:"notes"`           // Заметки в свободной форме
+	Custom       map[string]any  `json:"custom,omitempty"`                            // Дополнительные поля (см. CustomFieldDef)
+	Tags         []string        `json:"tags,omitempty"`                              // Имена тегов записи по алфавиту (только для чтения)
+	CreatedAt    time.Time       `json:"created_at" sql.field:"created_at,timestamp"` // Время создания (устанавливает хранилище)
+	UpdatedAt    time.Time       `json:"updated_at" sql.field:"updated_at,timestamp"` // Время последнего изменения (устанавливает хранилище)
+	DeletedAt    *time.Time      `json:"deleted_at,omitempty"`                        // Время удаления (только для записей в корзине)
+	DeletedBy    string          `json:"deleted_by,omitempty"`                        // Автор удаления (только для записей в корзине)
+	Version      int64           `json:"version,omitempty"`                           // Версия записи, растет при каждом изменении (в запросе - ожидаемая версия)
 }
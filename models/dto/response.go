@@ -3,9 +3,11 @@ package dto
 import "encoding/json"
 
 type Response struct {
-	Result string          `json:"result"`
-	Data   json.RawMessage `json:"data"`
-	Error  string          `json:"error"`
+	Result     string          `json:"result"`
+	Data       json.RawMessage `json:"data"`
+	Error      string          `json:"error"`
+	NextCursor string          `json:"next_cursor,omitempty"` // Токен следующей страницы (постраничная выборка)
+	Total      *int64          `json:"total,omitempty"`       // Общее количество записей (если запрошено)
 }
 
 func (r *Response) Update(result string, data json.RawMessage, error string) {
@@ -13,3 +15,9 @@ func (r *Response) Update(result string, data json.RawMessage, error string) {
 	r.Data = data
 	r.Error = error
 }
+
+// ErrorResponse - тело ответа с ошибкой в REST API v2.
+type ErrorResponse struct {
+	Error   string  `json:"error"`
+	Current *Record `json:"current,omitempty"` // Текущее состояние записи при несовпадении версий
+}
@@ -0,0 +1,16 @@
+package dto
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SearchWords разбивает строку поиска Query.Search на слова в нижнем регистре.
+// Словами считаются последовательности букв и цифр, остальные символы - разделители.
+// Буква "ё" заменяется на "е", так как в записях ее часто не пишут.
+func SearchWords(search string) []string {
+	search = strings.ReplaceAll(strings.ToLower(search), "ё", "е")
+	return strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
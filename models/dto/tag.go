@@ -0,0 +1,45 @@
+package dto
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// TagFilterField - имя поля тега в условиях Filter: условию удовлетворяет любой из тегов записи (как для phone).
+const TagFilterField = "tag"
+
+// maxTagLength - максимальная длина имени тега в символах.
+const maxTagLength = 100
+
+// Tag - тег (группа) записей: отдел, проект и т.п. Запись может входить в несколько тегов,
+// в тег - любое количество записей. Имена тегов уникальны без учета регистра.
+type Tag struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"` // Количество записей с тегом
+}
+
+// NormalizeTagName убирает лишние пробелы в имени тега и проверяет его длину.
+// Ошибки возвращаются как *ValidationError.
+func NormalizeTagName(name string) (string, error) {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		return "", &ValidationError{Msg: "tag name cannot be empty"}
+	}
+	if utf8.RuneCountInString(name) > maxTagLength {
+		return "", &ValidationError{Msg: "tag name is too long"}
+	}
+	return name, nil
+}
+
+// AnyTagFilter строит условие "у записи есть хотя бы один из тегов tags". Возвращает nil, если tags пуст.
+func AnyTagFilter(tags []string) *Filter {
+	if len(tags) == 0 {
+		return nil
+	}
+	values := make([]any, 0, len(tags))
+	for _, t := range tags {
+		values = append(values, t)
+	}
+	return &Filter{Field: TagFilterField, Op: OpIn, Values: values}
+}
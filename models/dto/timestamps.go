@@ -0,0 +1,65 @@
+package dto
+
+import (
+	"time"
+)
+
+// TimestampFilterFields - поля записи со временем (тег sql.field с параметром timestamp): created_at и updated_at.
+// Значения в условиях Filter указываются в формате RFC 3339 или DateLayout (полночь UTC),
+// операции prefix и contains не поддерживаются.
+var TimestampFilterFields = sqlFieldsWithOption(Record{}, "sql.field", "timestamp")
+
+// ParseTimestamp разбирает время в формате RFC 3339 (доли секунды необязательны) или дату в формате DateLayout.
+func ParseTimestamp(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	if d, dateErr := time.Parse(DateLayout, s); dateErr == nil {
+		return d, nil
+	}
+	return time.Time{}, err
+}
+
+// ParseAsOf разбирает момент времени выборки as_of (см. Query.AsOf) в формате ParseTimestamp.
+// Пустая строка означает текущее состояние (нулевое время). Ошибка возвращается как *ValidationError.
+func ParseAsOf(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := ParseTimestamp(s)
+	if err != nil {
+		return time.Time{}, &ValidationError{Msg: "as_of must be a RFC 3339 timestamp or a date"}
+	}
+	return t, nil
+}
+
+// TimeRange - условия на время создания и изменения записей в запросах выборки. Границы не включаются:
+// updated_after возвращает записи, измененные строго позже указанного времени.
+type TimeRange struct {
+	CreatedAfter  string `json:"created_after,omitempty"`
+	CreatedBefore string `json:"created_before,omitempty"`
+	UpdatedAfter  string `json:"updated_after,omitempty"`
+	UpdatedBefore string `json:"updated_before,omitempty"`
+}
+
+// Filter строит условие по заданным границам. Возвращает nil, если границ нет.
+// Формат времени проверяется методом Filter.Validate.
+func (t TimeRange) Filter() *Filter {
+	var conds []Filter
+	add := func(field, op, value string) {
+		if value != "" {
+			conds = append(conds, Filter{Field: field, Op: op, Value: value})
+		}
+	}
+
+	add("created_at", OpGt, t.CreatedAfter)
+	add("created_at", OpLt, t.CreatedBefore)
+	add("updated_at", OpGt, t.UpdatedAfter)
+	add("updated_at", OpLt, t.UpdatedBefore)
+
+	if len(conds) == 0 {
+		return nil
+	}
+	return &Filter{And: conds}
+}
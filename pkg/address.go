@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"addressBookServer/models/dto"
+	"regexp"
+	"strings"
+)
+
+// Шаблоны составных частей адреса. Сокращения пишутся с точкой или без нее, регистр не учитывается.
+var (
+	addressIndexRe     = regexp.MustCompile(`^\d{6}$`)
+	addressCountryRe   = regexp.MustCompile(`(?i)^(россия|российская федерация|рф)$`)
+	addressRegionRe    = regexp.MustCompile(`(?i)(^|\s)(обл\.?|область|край|респ\.?|республика|автономный округ|ао)(\s|$)`)
+	addressCityRe      = regexp.MustCompile(`(?i)^(?:г\.\s*|г\s+|город\s+)(.+)$`)
+	addressStreetRe    = regexp.MustCompile(`(?i)^(ул\.?|улица|пр-т|просп\.?|проспект|пер\.?|переулок|ш\.?|шоссе|б-р|бульвар|наб\.?|набережная|пл\.?|площадь|проезд|пр-д|туп\.?|тупик|аллея|мкр\.?|микрорайон)(?:\s+|\.)(.+)$`)
+	addressStreetEndRe = regexp.MustCompile(`(?i)^(.+?)\s+(ул\.?|улица|пр-т|просп\.?|проспект|пер\.?|переулок|ш\.?|шоссе|б-р|бульвар|наб\.?|набережная|пл\.?|площадь|проезд|пр-д|туп\.?|тупик|аллея)$`)
+	addressHouseRe     = regexp.MustCompile(`(?i)^(?:д\.\s*|д\s+|дом\s+)(.+)$`)
+	addressBuildingRe  = regexp.MustCompile(`(?i)^(?:корп\.\s*|корпус\s+|к\.\s*)(.+)$`)
+	addressStructureRe = regexp.MustCompile(`(?i)^(?:стр\.\s*|строение\s+)(.+)$`)
+	addressFlatRe      = regexp.MustCompile(`(?i)^(?:кв\.\s*|кв\s+|квартира\s+|оф\.\s*|офис\s+|пом\.\s*|помещение\s+)(.+)$`)
+	addressNumberRe    = regexp.MustCompile(`^\d+[а-яА-Яa-zA-Z]?([/-]\d+[а-яА-Я]?)?$`)
+	addressTailRe      = regexp.MustCompile(`^(.+?)\s+(\d+[а-яА-Яa-zA-Z]?([/-]\d+)?)$`)
+
+	// addressSplitRe находит начало дома, корпуса, строения или квартиры внутри части адреса без запятой:
+	// "ул. Ленина д. 5 кв. 10" -> "ул. Ленина, д. 5, кв. 10"
+	addressSplitRe = regexp.MustCompile(`(?i)\s+(д\.|дом\s|корп\.|корпус\s|стр\.|строение\s|кв\.|квартира\s|оф\.|офис\s)`)
+)
+
+// ParseAddress разбирает адрес в свободной форме на составные части.
+// Рассчитан на распространенную запись российских адресов через запятую:
+//
+//	"123456, Россия, Московская обл., г. Москва, ул. Ленина, д. 5, корп. 2, кв. 10"
+//	"г. Москва, ул. Ленина, д. 5, кв. 10"
+//	"Москва, Ленина 5"
+//
+// Части распознаются по сокращениям (г., ул., пр-т, д., корп., стр., кв. и т.д.), шестизначный номер
+// считается почтовым индексом. Части без сокращений считаются по порядку городом и улицей
+// (номер в конце улицы - домом). Нераспознанные части пропускаются, поэтому исходную строку
+// стоит сохранять вместе с результатом.
+func ParseAddress(address string) (parsed dto.PostalAddress) {
+	address = addressSplitRe.ReplaceAllString(address, ", $1")
+
+	for _, part := range strings.Split(address, ",") {
+		part = strings.Join(strings.Fields(part), " ")
+		if part == "" {
+			continue
+		}
+
+		if m := addressHouseRe.FindStringSubmatch(part); m != nil {
+			parsed.House = m[1]
+			continue
+		}
+		if m := addressBuildingRe.FindStringSubmatch(part); m != nil {
+			parsed.House = strings.TrimSpace(parsed.House + " к. " + m[1])
+			continue
+		}
+		if m := addressStructureRe.FindStringSubmatch(part); m != nil {
+			parsed.House = strings.TrimSpace(parsed.House + " стр. " + m[1])
+			continue
+		}
+		if m := addressFlatRe.FindStringSubmatch(part); m != nil {
+			parsed.Apartment = m[1]
+			continue
+		}
+
+		switch {
+		case addressIndexRe.MatchString(part):
+			parsed.PostalIndex = part
+		case addressCountryRe.MatchString(part):
+			parsed.Country = part
+		case addressCityRe.MatchString(part):
+			parsed.City = addressCityRe.FindStringSubmatch(part)[1]
+		case addressRegionRe.MatchString(part):
+			parsed.Region = part
+		case addressStreetRe.MatchString(part), addressStreetEndRe.MatchString(part):
+			parsed.Street = part
+			splitHouse(&parsed)
+		case addressNumberRe.MatchString(part) && parsed.Street != "" && parsed.House == "":
+			parsed.House = part
+		case parsed.City == "" && parsed.Street == "":
+			parsed.City = part
+		case parsed.Street == "":
+			parsed.Street = part
+			splitHouse(&parsed)
+		}
+	}
+
+	return parsed
+}
+
+// splitHouse отделяет номер дома в конце улицы ("Ленина 5" - улица "Ленина", дом "5"), если дом еще не указан.
+func splitHouse(parsed *dto.PostalAddress) {
+	if parsed.House != "" {
+		return
+	}
+	if m := addressTailRe.FindStringSubmatch(parsed.Street); m != nil {
+		parsed.Street, parsed.House = m[1], m[2]
+	}
+}
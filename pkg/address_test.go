@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"addressBookServer/models/dto"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    dto.PostalAddress
+	}{
+		{
+			"123456, Россия, Московская обл., г. Москва, ул. Ленина, д. 5, корп. 2, кв. 10",
+			dto.PostalAddress{PostalIndex: "123456", Country: "Россия", Region: "Московская обл.", City: "Москва",
+				Street: "ул. Ленина", House: "5 к. 2", Apartment: "10"},
+		},
+		{
+			"г. Москва, ул. Ленина, д. 5, кв. 10",
+			dto.PostalAddress{City: "Москва", Street: "ул. Ленина", House: "5", Apartment: "10"},
+		},
+		{"Москва, Ленина 5", dto.PostalAddress{City: "Москва", Street: "Ленина", House: "5"}},
+		{"ул. Ленина д. 5 кв. 10", dto.PostalAddress{Street: "ул. Ленина", House: "5", Apartment: "10"}},
+		{"г Казань, Баумана ул., 3, стр. 1", dto.PostalAddress{City: "Казань", Street: "Баумана ул.", House: "3 стр. 1"}},
+		{"Республика Татарстан, город Казань, проспект Победы, дом 12а, офис 3",
+			dto.PostalAddress{Region: "Республика Татарстан", City: "Казань", Street: "проспект Победы", House: "12а", Apartment: "3"}},
+		{"РФ, Тверь, мкр. Южный 4/1", dto.PostalAddress{Country: "РФ", City: "Тверь", Street: "мкр. Южный", House: "4/1"}},
+		{"  г.  Москва ,, ул.  Ленина  ", dto.PostalAddress{City: "Москва", Street: "ул. Ленина"}},
+		{"Somewhere in Canada (unknown)", dto.PostalAddress{City: "Somewhere in Canada (unknown)"}},
+		{"", dto.PostalAddress{}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseAddress(tt.address); got != tt.want {
+			t.Errorf("ParseAddress(%q) =\n%+v\nwant\n%+v", tt.address, got, tt.want)
+		}
+	}
+}
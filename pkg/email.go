@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+const maxEmailLength = 254
+
+// NormalizeEmail проверяет адрес электронной почты и приводит его к нижнему регистру.
+// В случае некорректных данных возвращает "" и error.
+// Некорректными считаются адреса:
+//   - длиннее maxEmailLength символов
+//   - не соответствующие RFC 5322 (net/mail) или с отображаемым именем ("Иван <ivan@example.com>")
+//   - без точки в имени домена
+func NormalizeEmail(email string) (normalizedEmail string, err error) {
+	wErr := NewWrappedError("NormalizeEmail()")
+
+	email = strings.TrimSpace(email)
+	if len(email) > maxEmailLength {
+		err = errors.New(fmt.Sprintf("email too long (max %d characters): %s", maxEmailLength, email))
+		wErr.Specify(err, "len(email) > maxEmailLength").LogError()
+		return "", err
+	}
+
+	parsed, err := mail.ParseAddress(email)
+	if err != nil || parsed.Name != "" || parsed.Address != email {
+		err = errors.New("invalid email: " + email)
+		wErr.Specify(err, "mail.ParseAddress(email)").LogError()
+		return "", err
+	}
+
+	_, domain, _ := strings.Cut(email, "@")
+	if !strings.Contains(strings.Trim(domain, "."), ".") {
+		err = errors.New("invalid email domain: " + email)
+		wErr.Specify(err, "strings.Contains(domain, \".\")").LogError()
+		return "", err
+	}
+
+	return strings.ToLower(email), nil
+}
@@ -0,0 +1,344 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	vcardMaxLine   = 10 << 20 // Наибольшая длина строки файла vCard (фотографии в base64 бывают без переносов)
+	vcardFoldWidth = 75       // Длина строки vCard в байтах, после которой строка переносится
+)
+
+// VCard - карточка контакта в формате vCard (RFC 2426, RFC 6350; разбираются и карточки vCard 2.1):
+// свойства в порядке файла, без BEGIN и END.
+type VCard struct {
+	Line  int // Номер строки BEGIN:VCARD в файле (с 1)
+	Props []VCardProp
+	Err   error // Ошибка разбора карточки (свойство без двоеточия, нет END:VCARD); свойства до ошибки сохраняются
+}
+
+// VCardProp - свойство карточки vCard: NAME;PARAM=value:value.
+type VCardProp struct {
+	Group  string              // Группа свойства (item1 в item1.TEL), "" - без группы
+	Name   string              // Имя свойства в верхнем регистре
+	Params map[string][]string // Параметры: имена в верхнем регистре, значения без кавычек
+	Value  string              // Значение с экранированием vCard (см. Text, Components, List)
+}
+
+// Get возвращает первое свойство name (в верхнем регистре) или nil.
+func (c *VCard) Get(name string) *VCardProp {
+	for i := range c.Props {
+		if c.Props[i].Name == name {
+			return &c.Props[i]
+		}
+	}
+	return nil
+}
+
+// All возвращает все свойства name (в верхнем регистре) в порядке карточки.
+func (c *VCard) All(name string) (props []VCardProp) {
+	for _, p := range c.Props {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Add добавляет свойство name со значением value (уже экранированным, см. VCardText и VCardComponents)
+// и параметрами params вида "TYPE=cell,pref", "PREF=1" (значения через запятую - список).
+func (c *VCard) Add(name, value string, params ...string) {
+	prop := VCardProp{Name: name, Value: value}
+	for _, p := range params {
+		key, v, _ := strings.Cut(p, "=")
+		if prop.Params == nil {
+			prop.Params = map[string][]string{}
+		}
+		prop.Params[key] = append(prop.Params[key], strings.Split(v, ",")...)
+	}
+	c.Props = append(c.Props, prop)
+}
+
+// Text возвращает текстовое значение свойства без экранирования.
+func (p VCardProp) Text() string {
+	return unescapeVCard(p.Value)
+}
+
+// Components возвращает части составного значения (N, ADR, ORG), разделенные ";", без экранирования.
+func (p VCardProp) Components() []string {
+	return splitVCard(p.Value, ';')
+}
+
+// List возвращает значения списка (CATEGORIES), разделенные ",", без экранирования.
+func (p VCardProp) List() []string {
+	return splitVCard(p.Value, ',')
+}
+
+// Types возвращает значения параметра TYPE в нижнем регистре, включая параметры vCard 2.1 без имени (TEL;CELL).
+func (p VCardProp) Types() (types []string) {
+	for _, t := range p.Params["TYPE"] {
+		for _, v := range strings.Split(t, ",") {
+			types = append(types, strings.ToLower(strings.TrimSpace(v)))
+		}
+	}
+	return types
+}
+
+// Preferred сообщает, что свойство отмечено как предпочтительное: TYPE=pref (vCard 2.1, 3.0) или PREF=1 (vCard 4.0).
+func (p VCardProp) Preferred() bool {
+	return slices.Contains(p.Types(), "pref") || slices.Contains(p.Params["PREF"], "1")
+}
+
+// ParseVCards разбирает файл vCard r с одной или несколькими карточками (например, выгрузку контактов
+// Android или iOS). Продолженные строки (RFC 6350, 3.2) объединяются, значения в quoted-printable (vCard 2.1)
+// декодируются. Ошибка разбора карточки записывается в VCard.Err, и разбор продолжается со следующей карточки;
+// строки вне карточек пропускаются. Возвращает ошибку только при ошибке чтения r.
+func ParseVCards(r io.Reader) (cards []VCard, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), vcardMaxLine)
+
+	var card *VCard
+	var line string // Текущая логическая строка
+	var start, n int
+	flush := func() {
+		if line == "" {
+			return
+		}
+		logical, at := line, start
+		line = ""
+
+		name, value, _ := strings.Cut(logical, ":")
+		switch upper := strings.ToUpper(strings.TrimSpace(name)); {
+		case upper == "BEGIN" && strings.EqualFold(strings.TrimSpace(value), "VCARD"):
+			if card != nil && card.Err == nil {
+				card.Err = fmt.Errorf("line %d: END:VCARD is missing", at)
+			}
+			if card != nil {
+				cards = append(cards, *card)
+			}
+			card = &VCard{Line: at}
+		case card == nil:
+		case upper == "END":
+			cards = append(cards, *card)
+			card = nil
+		case card.Err != nil:
+		default:
+			prop, err := parseVCardLine(logical)
+			if err != nil {
+				card.Err = fmt.Errorf("line %d: %w", at, err)
+				return
+			}
+			card.Props = append(card.Props, prop)
+		}
+	}
+
+	for scanner.Scan() {
+		n++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line != "" && (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")):
+			line += text[1:]
+		case line != "" && strings.HasSuffix(line, "=") && isQuotedPrintable(line):
+			// Мягкий перенос строки quoted-printable: продолжение начинается без пробела
+			line += "\n" + text
+		default:
+			flush()
+			line, start = text, n
+		}
+	}
+	flush()
+	if err = scanner.Err(); err != nil {
+		return cards, err
+	}
+	if card != nil {
+		if card.Err == nil {
+			card.Err = errors.New("END:VCARD is missing")
+		}
+		cards = append(cards, *card)
+	}
+	return cards, nil
+}
+
+// isQuotedPrintable сообщает, что значение свойства строки line закодировано в quoted-printable.
+func isQuotedPrintable(line string) bool {
+	name, _, _ := strings.Cut(line, ":")
+	return strings.Contains(strings.ToUpper(name), "QUOTED-PRINTABLE")
+}
+
+// parseVCardLine разбирает логическую строку свойства: [группа.]ИМЯ[;параметры]:значение.
+func parseVCardLine(line string) (prop VCardProp, err error) {
+	colon := -1
+	quoted := false
+	for i := 0; i < len(line) && colon == -1; i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				colon = i
+			}
+		}
+	}
+	if colon == -1 {
+		return prop, errors.New("property value is missing")
+	}
+
+	params := splitParams(line[:colon])
+	prop.Name = strings.ToUpper(params[0])
+	if group, name, ok := strings.Cut(prop.Name, "."); ok {
+		prop.Group, prop.Name = strings.ToLower(group), name
+	}
+	if prop.Name == "" {
+		return prop, errors.New("property name is missing")
+	}
+	for _, p := range params[1:] {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok {
+			key, value = "TYPE", p // Параметр vCard 2.1 без имени: TEL;CELL;PREF
+		}
+		key = strings.ToUpper(strings.TrimSpace(key))
+		if prop.Params == nil {
+			prop.Params = map[string][]string{}
+		}
+		prop.Params[key] = append(prop.Params[key], strings.Trim(value, `"`))
+	}
+
+	prop.Value = line[colon+1:]
+	if encoding := prop.Params["ENCODING"]; len(encoding) > 0 && strings.EqualFold(encoding[0], "QUOTED-PRINTABLE") {
+		value := strings.ReplaceAll(prop.Value, "=\n", "")
+		decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(value)))
+		if err != nil {
+			return prop, fmt.Errorf("quoted-printable: %w", err)
+		}
+		prop.Value = string(decoded)
+		delete(prop.Params, "ENCODING")
+	}
+	if !utf8.ValidString(prop.Value) {
+		return prop, fmt.Errorf("%s value is not valid UTF-8", prop.Name)
+	}
+	return prop, nil
+}
+
+// splitParams разделяет имя свойства и параметры по ";" вне кавычек.
+func splitParams(s string) (parts []string) {
+	quoted := false
+	begin := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				parts = append(parts, s[begin:i])
+				begin = i + 1
+			}
+		}
+	}
+	return append(parts, s[begin:])
+}
+
+// splitVCard разделяет значение по неэкранированному разделителю sep и снимает экранирование с частей.
+func splitVCard(value string, sep byte) (parts []string) {
+	begin := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, unescapeVCard(value[begin:i]))
+			begin = i + 1
+		}
+	}
+	return append(parts, unescapeVCard(value[begin:]))
+}
+
+// unescapeVCard снимает экранирование текстового значения: \n и \N - перевод строки, \, \; \\ - сами символы.
+func unescapeVCard(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
+
+// VCardText экранирует текстовое значение свойства vCard.
+func VCardText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\r\n", `\n`, "\n", `\n`, ",", `\,`, ";", `\;`).Replace(s)
+}
+
+// VCardComponents экранирует части и соединяет их в составное значение (N, ADR, ORG).
+func VCardComponents(parts ...string) string {
+	for i := range parts {
+		parts[i] = VCardText(parts[i])
+	}
+	return strings.Join(parts, ";")
+}
+
+// WriteVCard записывает карточку c в w: BEGIN:VCARD, свойства с параметрами в порядке имен и END:VCARD.
+// Строки длиннее 75 байт переносятся (без разрыва символов UTF-8), строки разделяются CRLF.
+func WriteVCard(w io.Writer, c VCard) error {
+	var buf bytes.Buffer
+	buf.WriteString("BEGIN:VCARD\r\n")
+	for _, p := range c.Props {
+		var line strings.Builder
+		if p.Group != "" {
+			line.WriteString(p.Group + ".")
+		}
+		line.WriteString(p.Name)
+		keys := make([]string, 0, len(p.Params))
+		for k := range p.Params {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			values := slices.Clone(p.Params[k])
+			for i, v := range values {
+				if strings.ContainsAny(v, ":;,") {
+					values[i] = `"` + v + `"`
+				}
+			}
+			line.WriteString(";" + k + "=" + strings.Join(values, ","))
+		}
+		line.WriteString(":" + p.Value)
+		foldVCardLine(&buf, line.String())
+	}
+	buf.WriteString("END:VCARD\r\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// foldVCardLine записывает строку line в buf, перенося ее через каждые vcardFoldWidth байт.
+func foldVCardLine(buf *bytes.Buffer, line string) {
+	width := vcardFoldWidth
+	for len(line) > width {
+		cut := width
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		width = vcardFoldWidth - 1 // Пробел в начале продолжения входит в длину строки
+	}
+	buf.WriteString(line + "\r\n")
+}